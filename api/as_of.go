@@ -0,0 +1,50 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/uptrace/bun"
+)
+
+// AsOfSelector resolves the optional asOf query parameter (commit id or RFC3339 timestamp)
+// such that all reads in the wrapped handler see the model as it was at that commit
+type AsOfSelector struct {
+	Db      bun.IDB
+	Timeout time.Duration
+}
+
+func (a *AsOfSelector) Apply(h http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			asOf := r.URL.Query().Get("asOf")
+			if asOf == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), a.Timeout)
+			commitId, err := repository.ResolveAsOf(ctx, a.Db, asOf)
+			cancel()
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Could not resolve asOf", "asOf", asOf, "error", err)
+				http.Error(w, "Could not resolve asOf: "+err.Error(), asOfErrorStatus(err))
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(repository.WithAsOf(r.Context(), commitId)))
+		})
+}
+
+func asOfErrorStatus(err error) int {
+	if errors.Is(err, repository.ErrUnknownCommit) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, repository.ErrInvalidAsOf) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/pkg"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceAsOf(t *testing.T) {
+	store := setupStore(t)
+	ctx := context.Background()
+
+	mrid := uuid.New()
+	for _, voltage := range []float64{132.0, 220.0} {
+		bv := models.BaseVoltage{NominalVoltage: voltage}
+		bv.Mrid = mrid
+		entity := models.Entity{Mrid: mrid, EntityType: "BaseVoltage"}
+		err := pkg.InsertAll(ctx, store.db, models.Commit{Message: "bv"}, slices.Values([]any{&entity, &bv}), pkg.NoOpOnInsert)
+		require.NoError(t, err)
+	}
+
+	selector := AsOfSelector{Db: store.db, Timeout: time.Second}
+	mux := http.NewServeMux()
+	mux.Handle("GET /resource/{mrid}", selector.Apply(http.HandlerFunc(store.Resource)))
+
+	for _, test := range []struct {
+		query   string
+		code    int
+		voltage float64
+	}{
+		{query: "", code: http.StatusOK, voltage: 220.0},
+		{query: "?asOf=1", code: http.StatusOK, voltage: 132.0},
+		{query: "?asOf=2", code: http.StatusOK, voltage: 220.0},
+		{query: "?asOf=" + time.Now().Add(time.Hour).UTC().Format(time.RFC3339), code: http.StatusOK, voltage: 220.0},
+		{query: "?asOf=last-quarter", code: http.StatusBadRequest},
+		{query: "?asOf=99", code: http.StatusNotFound},
+		{query: "?asOf=2001-01-01T00:00:00Z", code: http.StatusNotFound},
+	} {
+		t.Run(test.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest("GET", "/resource/"+mrid.String()+test.query, nil))
+			require.Equal(t, test.code, rec.Code, rec.Body.String())
+			if test.code != http.StatusOK {
+				return
+			}
+
+			var item struct {
+				Data models.BaseVoltage `json:"data"`
+			}
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&item))
+			require.Equal(t, test.voltage, item.Data.NominalVoltage)
+		})
+	}
+
+	t.Run("as of from context", func(t *testing.T) {
+		asOfCtx := repository.WithAsOf(ctx, 1)
+		resource, err := store.GetResource(asOfCtx, mrid.String())
+		require.NoError(t, err)
+		require.Equal(t, 132.0, resource.(*models.BaseVoltage).NominalVoltage)
+	})
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/pkg"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/uptrace/bun"
+)
+
+var refNameExpr = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+type BranchRequest struct {
+	Name string `json:"name"`
+}
+
+type BranchEndpoint struct {
+	Repo    repository.BranchRepository
+	Timeout time.Duration
+}
+
+func (b *BranchEndpoint) Create(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1024)
+	var request BranchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to decode branch request", "error", err)
+		http.Error(w, "Failed to decode branch request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateBranchName(request.Name); err != nil {
+		slog.ErrorContext(r.Context(), "Invalid branch name", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), b.Timeout)
+	defer cancel()
+
+	if _, err := b.Repo.Get(ctx, request.Name); err == nil {
+		http.Error(w, fmt.Sprintf("Branch %s already exists", request.Name), http.StatusConflict)
+		return
+	}
+
+	branch := models.Branch{
+		Name:      request.Name,
+		Author:    UserFromCtx(r.Context()),
+		CreatedAt: time.Now(),
+	}
+	if err := b.Repo.Create(ctx, &branch); err != nil {
+		slog.ErrorContext(ctx, "Failed to create branch", "error", err)
+		http.Error(w, "Failed to create branch: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(branch)
+}
+
+func (b *BranchEndpoint) List(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), b.Timeout)
+	defer cancel()
+
+	branches, err := b.Repo.List(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list branches", "error", err)
+		http.Error(w, "Failed to list branches: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(branches)
+}
+
+type BranchMergeEndpoint struct {
+	Repo    repository.BranchRepository
+	Db      *bun.DB
+	Timeout time.Duration
+}
+
+// ServeHTTP merges a branch into main. Conflicts are reported with status 409 and nothing is
+// written. With dry-run=true the merge result is reported without writing anything.
+func (b *BranchMergeEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	dryRun := r.URL.Query().Get("dry-run") == "true"
+
+	ctx, cancel := context.WithTimeout(r.Context(), b.Timeout)
+	defer cancel()
+
+	branch, err := b.Repo.Get(ctx, name)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not find branch", "branch", name, "error", err)
+		http.Error(w, fmt.Sprintf("Could not find branch %s", name), http.StatusNotFound)
+		return
+	}
+
+	inserter := repository.BunInserter{Db: b.Db}
+	result, err := pkg.MergeBranch(ctx, b.Db, &inserter, branch, UserFromCtx(r.Context()), dryRun)
+	var conflict *pkg.ConflictError
+	if errors.As(err, &conflict) {
+		slog.InfoContext(ctx, "Rejected merge as main changed while it was prepared", "branch", name, "error", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(conflict)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to merge branch", "branch", name, "error", err)
+		http.Error(w, "Failed to merge branch: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(result.Conflicts) > 0 && !dryRun {
+		w.WriteHeader(http.StatusConflict)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func validateBranchName(name string) error {
+	if name == models.MainBranch {
+		return fmt.Errorf("Branch %s is reserved", models.MainBranch)
+	}
+	if !refNameExpr.MatchString(name) {
+		return fmt.Errorf("Branch name '%s' must match %s", name, refNameExpr.String())
+	}
+	return nil
+}
+
+// BranchSelector resolves the optional branch query parameter and makes all reads
+// and writes of the wrapped handler go to that branch
+type BranchSelector struct {
+	Repo    repository.BranchRepository
+	Timeout time.Duration
+}
+
+func (b *BranchSelector) Apply(h http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			branch := r.URL.Query().Get("branch")
+			if branch == "" || branch == models.MainBranch {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), b.Timeout)
+			_, err := b.Repo.Get(ctx, branch)
+			cancel()
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Could not find branch", "branch", branch, "error", err)
+				http.Error(w, fmt.Sprintf("Could not find branch %s", branch), http.StatusNotFound)
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(repository.WithBranch(r.Context(), branch)))
+		})
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/pkg"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBranchEndpointCreate(t *testing.T) {
+	repo := repository.InMemBranchRepository{}
+	endpoint := BranchEndpoint{Repo: &repo, Timeout: time.Second}
+
+	for _, test := range []struct {
+		desc string
+		body string
+		code int
+	}{
+		{desc: "created", body: `{"name": "project-1"}`, code: http.StatusCreated},
+		{desc: "already exists", body: `{"name": "project-1"}`, code: http.StatusConflict},
+		{desc: "main is reserved", body: `{"name": "main"}`, code: http.StatusBadRequest},
+		{desc: "invalid name", body: `{"name": "my branch"}`, code: http.StatusBadRequest},
+		{desc: "invalid json", body: `not json`, code: http.StatusBadRequest},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/branches", bytes.NewBufferString(test.body))
+			endpoint.Create(rec, req)
+			require.Equal(t, test.code, rec.Code, rec.Body.String())
+		})
+	}
+
+	require.Equal(t, 1, len(repo.Items))
+	require.Equal(t, defaultUser, repo.Items[0].Author)
+
+	t.Run("repo error", func(t *testing.T) {
+		repo.Err = errors.New("something went wrong")
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/branches", bytes.NewBufferString(`{"name": "project-2"}`))
+		endpoint.Create(rec, req)
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+}
+
+func TestBranchEndpointList(t *testing.T) {
+	repo := repository.InMemBranchRepository{Items: []models.Branch{{Name: "a"}, {Name: "b"}}}
+	endpoint := BranchEndpoint{Repo: &repo, Timeout: time.Second}
+
+	rec := httptest.NewRecorder()
+	endpoint.List(rec, httptest.NewRequest("GET", "/branches", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var branches []models.Branch
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&branches))
+	require.Equal(t, 2, len(branches))
+
+	repo.Err = errors.New("something went wrong")
+	rec = httptest.NewRecorder()
+	endpoint.List(rec, httptest.NewRequest("GET", "/branches", nil))
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+func TestBranchSelector(t *testing.T) {
+	repo := repository.InMemBranchRepository{Items: []models.Branch{{Name: "project"}}}
+	selector := BranchSelector{Repo: &repo, Timeout: time.Second}
+
+	var seen string
+	handler := selector.Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = repository.BranchFromCtx(r.Context())
+	}))
+
+	for _, test := range []struct {
+		url    string
+		code   int
+		branch string
+	}{
+		{url: "/resource", code: http.StatusOK, branch: models.MainBranch},
+		{url: "/resource?branch=main", code: http.StatusOK, branch: models.MainBranch},
+		{url: "/resource?branch=project", code: http.StatusOK, branch: "project"},
+		{url: "/resource?branch=unknown", code: http.StatusNotFound, branch: ""},
+	} {
+		t.Run(test.url, func(t *testing.T) {
+			seen = ""
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", test.url, nil))
+			require.Equal(t, test.code, rec.Code)
+			require.Equal(t, test.branch, seen)
+		})
+	}
+}
+
+func TestReadsResolveBranchOverMain(t *testing.T) {
+	store := setupStore(t)
+	ctx := context.Background()
+	branchRepo := repository.BunBranchRepository{Db: store.db}
+	bvRepo := repository.BunReadRepository[models.BaseVoltage]{Db: store.db, UseLatestView: true}
+
+	mrid := uuid.New()
+	insert := func(ctx context.Context, voltage float64) {
+		bv := models.BaseVoltage{NominalVoltage: voltage}
+		bv.Mrid = mrid
+		entity := models.Entity{Mrid: mrid, EntityType: "BaseVoltage"}
+		err := pkg.InsertAll(ctx, store.db, models.Commit{Message: "bv"}, pkg.Chain(slices.Values([]any{&entity, &bv})), pkg.NoOpOnInsert)
+		require.NoError(t, err)
+	}
+
+	insert(ctx, 132.0)
+	require.NoError(t, branchRepo.Create(ctx, &models.Branch{Name: "project"}))
+
+	branchCtx := repository.WithBranch(ctx, "project")
+	insert(branchCtx, 300.0)
+	insert(ctx, 220.0)
+
+	onMain, err := bvRepo.GetByMrid(ctx, mrid.String())
+	require.NoError(t, err)
+	require.Equal(t, 220.0, onMain.NominalVoltage)
+
+	onBranch, err := bvRepo.GetByMrid(branchCtx, mrid.String())
+	require.NoError(t, err)
+	require.Equal(t, 300.0, onBranch.NominalVoltage)
+
+	var commits []models.Commit
+	require.NoError(t, store.db.NewSelect().Model(&commits).Order("id").Scan(ctx))
+	branches := make([]string, len(commits))
+	for i, commit := range commits {
+		branches[i] = commit.Branch
+	}
+	require.Equal(t, []string{"main", "project", "main"}, branches)
+
+	resource, err := store.GetResource(branchCtx, mrid.String())
+	require.NoError(t, err)
+	require.Equal(t, 300.0, resource.(*models.BaseVoltage).NominalVoltage)
+
+	var vertices []ConnectionVertex
+	query := repository.ScopeLatestViews(branchCtx, store.db, repository.MustGetQuery("connection.sql"))
+	require.NoError(t, store.db.NewRaw(query, mrid).Scan(ctx, &vertices))
+}
+
+func TestBranchMergeEndpoint(t *testing.T) {
+	store := setupStore(t)
+	ctx := context.Background()
+	branchRepo := repository.BunBranchRepository{Db: store.db}
+	endpoint := BranchMergeEndpoint{Repo: &branchRepo, Db: store.db, Timeout: time.Second}
+
+	mrid := uuid.New()
+	insert := func(ctx context.Context, voltage float64) {
+		bv := models.BaseVoltage{NominalVoltage: voltage}
+		bv.Mrid = mrid
+		err := pkg.InsertAll(ctx, store.db, models.Commit{Message: "bv"}, slices.Values([]any{&bv}), pkg.NoOpOnInsert)
+		require.NoError(t, err)
+	}
+	insert(ctx, 132.0)
+	require.NoError(t, branchRepo.Create(ctx, &models.Branch{Name: "project"}))
+	insert(repository.WithBranch(ctx, "project"), 220.0)
+	insert(ctx, 300.0)
+
+	merge := func(url string) (*httptest.ResponseRecorder, pkg.MergeResult) {
+		mux := http.NewServeMux()
+		mux.Handle("POST /branches/{name}/merge", &endpoint)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("POST", url, nil))
+
+		var result pkg.MergeResult
+		if rec.Code != http.StatusNotFound {
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+		}
+		return rec, result
+	}
+
+	rec, _ := merge("/branches/unknown/merge")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec, result := merge("/branches/project/merge?dry-run=true")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.True(t, result.DryRun)
+	require.Equal(t, 1, len(result.Conflicts))
+
+	rec, result = merge("/branches/project/merge")
+	require.Equal(t, http.StatusConflict, rec.Code)
+	require.Equal(t, "nominal_voltage", result.Conflicts[0].Field)
+	require.Zero(t, result.CommitId)
+}
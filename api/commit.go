@@ -16,48 +16,24 @@ import (
 )
 
 type CommitEndpoint struct {
-	Db      repository.Inserter
-	timeout time.Duration
+	Db       repository.Inserter
+	Versions repository.VersionReader
+	timeout  time.Duration
 }
 
 func (c *CommitEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, 4096)
 	ctx := r.Context()
 
-	var (
-		content       []byte
-		modelMetaData ModelMetaData
-		model         any
-	)
-
-	failedCallNum, err := pkg.ReturnOnFirstError(
-		func() error {
-			var ierr error
-			content, ierr = io.ReadAll(r.Body)
-			return ierr
-		},
-		func() error {
-			return json.Unmarshal(content, &modelMetaData)
-		},
-		func() error {
-			var ierr error
-			model, ierr = pkg.FormInputFieldsForType(modelMetaData.CimType)
-			return ierr
-		},
-		func() error {
-			var rawJson map[string]any
-			jsonErr := json.Unmarshal(content, &rawJson)
-			unsetFields := pkg.UnsetFields(rawJson, model)
-			errUnsetCheck := CheckUnsetFieldsCommit(unsetFields)
-			return errors.Join(jsonErr, errUnsetCheck)
-		},
-		func() error {
-			return json.Unmarshal(content, model)
-		},
-	)
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to read body", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
+	modelMetaData, model, err := parseObjectPayload(ctx, content)
 	if err != nil {
-		slog.ErrorContext(ctx, "Failed to parse response", "error", err, "failedCall", failedCallNum)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -84,17 +60,35 @@ func (c *CommitEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	commit := models.Commit{
-		Branch:    "main",
+		Branch:    repository.BranchFromCtx(ctx),
 		Message:   modelMetaData.CommitMessage,
 		Author:    UserFromCtx(r.Context()),
 		CreatedAt: time.Now(),
 	}
 
+	// The base commit is checked in the transaction of the insert, such that two edits based on the
+	// same version can not both be stored
+	checkBaseCommit := func(ctx context.Context, inserter repository.Inserter) error {
+		versions := repository.TxVersionReader(inserter, c.Versions)
+		if versions == nil {
+			return nil
+		}
+		return pkg.CheckBaseCommit(ctx, versions, model, modelMetaData.Mrid, modelMetaData.BaseCommitId)
+	}
+
 	itemIter := func(yield func(v any) bool) {
 		pkg.YieldMany(yield, &gridModel, &entity, model)
 	}
-	err = pkg.InsertAllInserter(ctx, c.Db, commit, itemIter, pkg.NoOpOnInsert)
-
+	err = pkg.InsertAllWithHooks(ctx, c.Db, commit, itemIter, pkg.NoOpOnInsert, pkg.TxHooks{Before: checkBaseCommit})
+
+	var conflict *pkg.ConflictError
+	if errors.As(err, &conflict) {
+		slog.InfoContext(ctx, "Rejected commit based on an outdated version", "mrid", modelMetaData.Mrid, "baseCommitId", conflict.BaseCommitId, "headCommitId", conflict.HeadCommitId)
+		w.Header().Set(pkg.ContentType, pkg.ContentTypeJSON)
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(conflict)
+		return
+	}
 	if err != nil {
 		slog.ErrorContext(ctx, "Could not insert data", "error", err)
 		http.Error(w, "Could not insert data: "+err.Error(), http.StatusInternalServerError)
@@ -109,6 +103,40 @@ func (c *CommitEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Successfully updated object %s", modelMetaData.Mrid)
 }
 
+// parseObjectPayload decodes the payload sent by the entity editor into its meta data and the edited object
+func parseObjectPayload(ctx context.Context, content []byte) (ModelMetaData, any, error) {
+	var (
+		modelMetaData ModelMetaData
+		model         any
+	)
+
+	failedCallNum, err := pkg.ReturnOnFirstError(
+		func() error {
+			return json.Unmarshal(content, &modelMetaData)
+		},
+		func() error {
+			var ierr error
+			model, ierr = pkg.FormInputFieldsForType(modelMetaData.CimType)
+			return ierr
+		},
+		func() error {
+			var rawJson map[string]any
+			jsonErr := json.Unmarshal(content, &rawJson)
+			unsetFields := pkg.UnsetFields(rawJson, model)
+			errUnsetCheck := CheckUnsetFieldsCommit(unsetFields)
+			return errors.Join(jsonErr, errUnsetCheck)
+		},
+		func() error {
+			return json.Unmarshal(content, model)
+		},
+	)
+
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to parse response", "error", err, "failedCall", failedCallNum)
+	}
+	return modelMetaData, model, err
+}
+
 func CheckUnsetFieldsCommit(unset []string) error {
 	allowedUnset := map[string]struct{}{
 		"Id":        {},
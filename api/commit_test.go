@@ -2,16 +2,21 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"com.github/davidkleiven/tripleworks/models"
 	"com.github/davidkleiven/tripleworks/pkg"
 	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 )
 
@@ -87,3 +92,76 @@ func TestCommit(t *testing.T) {
 		require.Contains(t, rec.Body.String(), "not insert data")
 	})
 }
+
+func TestCommitStaleBaseCommit(t *testing.T) {
+	mrid := uuid.MustParse("530dfa65-3158-4bdc-845f-3483a24374b9")
+	version := func(commitId int, voltage float64) *models.BaseVoltage {
+		bv := models.BaseVoltage{NominalVoltage: voltage}
+		bv.Mrid = mrid
+		bv.CommitId = commitId
+		return &bv
+	}
+
+	var inserter repository.InMemInserter
+	store := CommitEndpoint{
+		Db:       &inserter,
+		Versions: &repository.InMemVersionReader{Items: []any{version(1, 132.0), version(2, 220.0)}},
+	}
+
+	commit := func(baseCommitId int) *httptest.ResponseRecorder {
+		data := fmt.Sprintf(`{"mrid": "%s", "name": "Base voltage", "cim_type": "BaseVoltage", "checksum": "00",
+		"modelId": 0, "modelName": "national", "energy_ident_code_eic": "EIC", "description": "Desc", "short_name": "name",
+		"baseCommitId": %d, "nominal_voltage": 400.0, "deleted": false}`, mrid, baseCommitId)
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/commit", bytes.NewBufferString(data))
+		req.Header.Set("Content-Type", "application/json")
+		store.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("based on newest version", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, commit(2).Code)
+	})
+
+	t.Run("based on outdated version", func(t *testing.T) {
+		rec := commit(1)
+		require.Equal(t, http.StatusConflict, rec.Code)
+
+		var conflict pkg.ConflictError
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &conflict))
+		require.Equal(t, int64(2), conflict.HeadCommitId)
+		require.Equal(t, []pkg.FieldChange{{Field: "nominal_voltage", Old: 132.0, New: 220.0}}, conflict.Changes)
+	})
+}
+
+func TestCommitChecksBaseCommitInTransaction(t *testing.T) {
+	store := setupStore(t)
+	ctx := context.Background()
+	endpoint := CommitEndpoint{Db: &repository.BunInserter{Db: store.db}, timeout: time.Minute}
+
+	var bv models.BaseVoltage
+	bv.Mrid, bv.Name, bv.NominalVoltage = uuid.New(), "Base voltage", 132.0
+	entity := pkg.MakeEntity(&bv, 0)
+	require.NoError(t, pkg.InsertAll(ctx, store.db, models.Commit{}, slices.Values([]any{&entity, &bv}), pkg.NoOpOnInsert))
+
+	commit := func(voltage float64) *httptest.ResponseRecorder {
+		data := fmt.Sprintf(`{"mrid": "%s", "name": "Base voltage", "cim_type": "BaseVoltage", "checksum": "00",
+		"modelId": 0, "modelName": "national", "energy_ident_code_eic": "EIC", "description": "Desc", "short_name": "name",
+		"baseCommitId": %d, "nominal_voltage": %f, "deleted": false}`, bv.Mrid, bv.CommitId, voltage)
+		rec := httptest.NewRecorder()
+		endpoint.ServeHTTP(rec, httptest.NewRequest("POST", "/commit", bytes.NewBufferString(data)))
+		return rec
+	}
+
+	require.Equal(t, http.StatusOK, commit(220.0).Code)
+
+	numCommits, err := store.db.NewSelect().Model((*models.Commit)(nil)).Count(ctx)
+	require.NoError(t, err)
+
+	rec := commit(400.0)
+	require.Equal(t, http.StatusConflict, rec.Code, rec.Body.String())
+
+	after, err := store.db.NewSelect().Model((*models.Commit)(nil)).Count(ctx)
+	require.NoError(t, err)
+	require.Equal(t, numCommits, after)
+}
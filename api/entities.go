@@ -5,12 +5,16 @@ import (
 	"bytes"
 	"cmp"
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"io"
 	"iter"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"reflect"
 	"regexp"
 	"slices"
@@ -110,9 +114,10 @@ func (e *EntityStore) GetEntityForKind(w http.ResponseWriter, r *http.Request) {
 	}
 	result.LogNotfound(ctx)
 
+	modelId := pkg.ModelOf(ctx)
 	var items []models.VersionedObject
 	for _, finder := range result.finders {
-		newItems, err := finder(ctx, e.db, 0)
+		newItems, err := finder(ctx, e.db, modelId)
 		if err != nil {
 			slog.ErrorContext(ctx, "Failed to find all items of type: %v", "error", err)
 		}
@@ -157,7 +162,8 @@ func (e *EntityStore) EntityList(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
 	defer cancel()
 
-	items, err := finder(ctx, e.db, 0)
+	modelId := pkg.ModelOf(ctx)
+	items, err := finder(ctx, e.db, modelId)
 	if err != nil {
 		slog.ErrorContext(ctx, "Could not retrieve items", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -178,7 +184,7 @@ func (e *EntityStore) SubstationDiagram(w http.ResponseWriter, r *http.Request)
 	)
 	failedNo, err := pkg.ReturnOnFirstError(
 		func() error {
-			return e.db.NewSelect().Model(&substation).Where("mrid = ?", substationMrid).OrderBy("CommitId", bun.OrderDesc).Limit(1).Scan(ctx)
+			return e.db.NewSelect().Model(&substation).Apply(repository.OnBranch(ctx)).Where("mrid = ?", substationMrid).OrderBy("CommitId", bun.OrderDesc).Limit(1).Scan(ctx)
 		},
 		func() error {
 			var ierr error
@@ -218,8 +224,7 @@ func (e *EntityStore) GetResource(ctx context.Context, mrid string) (any, error)
 	}
 
 	bunName := e.db.Table(reflect.TypeOf(resource).Elem()).Name
-	table := fmt.Sprintf("v_%s_latest", bunName)
-	err = e.db.NewSelect().Table(table).Where("mrid = ?", mrid).Limit(1).Scan(ctx, resource)
+	err = repository.SelectLatest(ctx, e.db, bunName).Where("mrid = ?", mrid).Limit(1).Scan(ctx, resource)
 	if err != nil {
 		return resource, fmt.Errorf("Failed to collect data for editing resource: %w", err)
 	}
@@ -242,6 +247,57 @@ func (e *EntityStore) EditComponentForm(w http.ResponseWriter, r *http.Request)
 	w.Header().Set("HX-Trigger", string(hxTriggerPayloadBytes))
 
 	pkg.FormInputFields(w, resource)
+	if mridGetter, ok := resource.(models.MridGetter); ok {
+		link := pkg.HistoryLink(r.Context(), mridGetter.GetMrid().String())
+		fmt.Fprintf(w, "<a class=\"button is-small is-text\" href=\"%s\" target=\"_blank\">History</a>\n", html.EscapeString(link))
+	}
+}
+
+func (e *EntityStore) History(w http.ResponseWriter, r *http.Request) {
+	mrid := r.PathValue("mrid")
+	ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
+	defer cancel()
+
+	history, err := pkg.History(ctx, e.db, mrid)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Resource %s does not exist", mrid), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to fetch history", "mrid", mrid, "error", err)
+		http.Error(w, "Failed to fetch history: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch accept := r.Header.Get("Accept"); {
+	case strings.Contains(accept, pkg.ContentTypeJSON):
+		w.Header().Set(pkg.ContentType, pkg.ContentTypeJSON)
+		json.NewEncoder(w).Encode(history)
+	default:
+		w.Header().Set(pkg.ContentType, pkg.ContentTypeHTML)
+		if err := pkg.WriteHistory(w, history); err != nil {
+			slog.ErrorContext(ctx, "Failed to render history", "error", err)
+		}
+	}
+}
+
+func (e *EntityStore) Blame(w http.ResponseWriter, r *http.Request) {
+	mrid := r.PathValue("mrid")
+	ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
+	defer cancel()
+
+	history, err := pkg.History(ctx, e.db, mrid)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Resource %s does not exist", mrid), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to fetch history", "mrid", mrid, "error", err)
+		http.Error(w, "Failed to fetch history: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(pkg.ContentType, pkg.ContentTypeJSON)
+	json.NewEncoder(w).Encode(history.Blame)
 }
 
 func (e *EntityStore) Resource(w http.ResponseWriter, r *http.Request) {
@@ -269,7 +325,8 @@ func (e *EntityStore) Export(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
 	defer cancel()
 
-	items, err := pkg.LatestOfAllItems(ctx, e.db, 0)
+	modelId := pkg.ModelOf(ctx)
+	items, err := pkg.LatestOfAllItems(ctx, e.db, modelId)
 	if err != nil {
 		slog.ErrorContext(ctx, "Could not fetch all items", "error", err)
 		http.Error(w, "Could not fetch items: "+err.Error(), http.StatusInternalServerError)
@@ -288,22 +345,114 @@ func (e *EntityStore) Export(w http.ResponseWriter, r *http.Request) {
 	pkg.Export(w, itemIterator)
 }
 
+type lightRecord interface {
+	CimItems(modelId int) iter.Seq[any]
+}
+
+// lightValidator is implemented by light records with values that JSON decoding can not check
+type lightValidator interface {
+	Validate() error
+}
+
+func newLightRecord(kind string) (lightRecord, error) {
+	var (
+		substations  = "substations"
+		generators   = "generators"
+		loads        = "loads"
+		lines        = "lines"
+		transformers = "transformers"
+		shunts       = "shunts"
+		switches     = "switches"
+		hvdc         = "hvdc"
+	)
+
+	switch kind {
+	case substations:
+		return &pkg.SubstationLight{}, nil
+	case generators:
+		return &pkg.GeneratorLight{}, nil
+	case loads:
+		return &pkg.LoadLight{}, nil
+	case lines:
+		return &pkg.LineLight{}, nil
+	case transformers:
+		return &pkg.TransformerLight{}, nil
+	case shunts:
+		return &pkg.ShuntLight{}, nil
+	case switches:
+		return &pkg.SwitchLight{}, nil
+	case hvdc:
+		return &pkg.HvdcLight{}, nil
+	}
+	return nil, fmt.Errorf("Unknown type %s", kind)
+}
+
+// lightItems expands one line of a simple upload into CIM objects
+func lightItems(kind string, line []byte, modelId int) (iter.Seq[any], error) {
+	record, err := newLightRecord(kind)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(line, record); err != nil {
+		return nil, err
+	}
+	if validator, ok := record.(lightValidator); ok {
+		if err := validator.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return record.CimItems(modelId), nil
+}
+
+type lineScanner interface {
+	Scan() bool
+	Bytes() []byte
+	Err() error
+}
+
+// uploadScanner returns a scanner over the JSON records of an upload. CSV bodies are converted row by row,
+// with column aliases given as alias=column:field query parameters.
+func uploadScanner(r *http.Request, kind string) (lineScanner, error) {
+	if !strings.HasPrefix(r.Header.Get(pkg.ContentType), pkg.ContentTypeCSV) {
+		return bufio.NewScanner(r.Body), nil
+	}
+
+	record, err := newLightRecord(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := make(map[string]string)
+	for _, alias := range r.URL.Query()["alias"] {
+		column, field, ok := strings.Cut(alias, ":")
+		if !ok {
+			return nil, fmt.Errorf("Invalid alias %s, expected column:field", alias)
+		}
+		aliases[column] = field
+	}
+	return pkg.NewCsvScanner(r.Body, record, aliases)
+}
+
+// SimpleUpload creates the objects described by a JSON lines or CSV upload of the kind in the path.
+// Nothing is written unless commit=true, and commits with references to objects that exist nowhere are
+// refused unless allow-dangling=true. A dry run responds with the report of dangling references, with the
+// objects that would be written attached as N-Triples.
 func (e *EntityStore) SimpleUpload(w http.ResponseWriter, r *http.Request) {
 	hundredMb := int64(100 << 20)
 	kind := r.PathValue("kind")
 	doCommit := r.URL.Query().Get("commit")
-	modelId := intOrDefault(r.URL.Query().Get("model-id"), 0)
+	upsert := r.URL.Query().Get("mode") == "upsert"
+	selectedModel, _ := repository.ModelFromCtx(r.Context())
+	modelId := intOrDefault(r.URL.Query().Get("model-id"), selectedModel)
+
+	if r.URL.Query().Get("stream") == "true" {
+		e.streamUpload(w, r, kind, modelId)
+		return
+	}
 
 	r.Body = http.MaxBytesReader(w, r.Body, hundredMb)
 	defer r.Body.Close()
 
-	var (
-		substations = "substations"
-		generators  = "generators"
-		loads       = "loads"
-		lines       = "lines"
-	)
-
 	ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
 	defer cancel()
 
@@ -319,49 +468,67 @@ func (e *EntityStore) SimpleUpload(w http.ResponseWriter, r *http.Request) {
 		existingSet[mrid] = struct{}{}
 	}
 
-	scanner := bufio.NewScanner(r.Body)
+	// Existing objects are skipped unless they are to be updated
+	onlyNew := func(items iter.Seq[any]) iter.Seq[any] {
+		if upsert {
+			return items
+		}
+		return pkg.OnlyNewItems(existingSet, items)
+	}
+
+	scanner, err := uploadScanner(r, kind)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not read upload", "kind", kind, "error", err)
+		http.Error(w, "Could not read upload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	num := 0
 	itemIterators := []iter.Seq[any]{}
+	lines := [][]any{}
 	var rawBytes bytes.Buffer
 	for scanner.Scan() {
 		num++
 		line := scanner.Bytes()
 
-		var (
-			err          error
-			itemIterator iter.Seq[any]
-		)
-		_, err = rawBytes.Write(line)
-		switch kind {
-		case substations:
-			var substation pkg.SubstationLight
-			err = json.Unmarshal(line, &substation)
-			itemIterator = pkg.OnlyNewItems(existingSet, substation.CimItems(modelId))
-		case generators:
-			var generator pkg.GeneratorLight
-			err = json.Unmarshal(line, &generator)
-			itemIterator = pkg.OnlyNewItems(existingSet, generator.CimItems(modelId))
-		case loads:
-			var load pkg.LoadLight
-			err = json.Unmarshal(line, &load)
-			itemIterator = pkg.OnlyNewItems(existingSet, load.CimItems(modelId))
-		case lines:
-			var acline pkg.LineLight
-			err = json.Unmarshal(line, &acline)
-			itemIterator = pkg.OnlyNewItems(existingSet, acline.CimItems(modelId))
-		default:
-			err = fmt.Errorf("Unknown type %s", kind)
-		}
-
+		rawBytes.Write(line)
+		itemIterator, err := lightItems(kind, line, modelId)
 		if err != nil {
 			slog.ErrorContext(ctx, "Could not unmarshal line", "kind", kind, "lineNo", num, "error", err)
 			http.Error(w, "Could not unmarshal line: "+err.Error(), http.StatusBadRequest)
 			return
 		}
-		itemIterators = append(itemIterators, itemIterator)
+		items := slices.Collect(itemIterator)
+		lines = append(lines, items)
+		itemIterators = append(itemIterators, onlyNew(slices.Values(items)))
+	}
+	if err := scanner.Err(); err != nil {
+		slog.ErrorContext(ctx, "Could not read upload", "kind", kind, "lineNo", num+1, "error", err)
+		http.Error(w, "Could not read upload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	references, err := pkg.DanglingReferences(ctx, e.db, modelId, lines)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not resolve references", "error", err)
+		http.Error(w, "Could not resolve references: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Objects pointing to mrids that exist nowhere are only written when explicitly allowed
+	allowDangling := r.URL.Query().Get("allow-dangling") == "true"
+	if doCommit == "true" && len(references.Dangling) > 0 && !allowDangling {
+		w.Header().Set(pkg.ContentType, pkg.ContentTypeJSON)
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(references)
+		return
 	}
 
 	rawData := models.SimpleUpload{Data: rawBytes.Bytes()}
+	if upsert {
+		e.upsertUpload(ctx, w, r, modelId, slices.Collect(pkg.Chain(itemIterators...)), &rawData, num, kind)
+		return
+	}
 	rawDataIter := func(yield func(v any) bool) {
 		yield(&rawData)
 	}
@@ -383,26 +550,606 @@ func (e *EntityStore) SimpleUpload(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	} else {
-		writer := writeNTriplesCallback(w)
+		var triples bytes.Buffer
+		writer := writeNTriplesCallback(&triples)
 		for item := range itemIterator {
 			writer(item)
 		}
+		w.Header().Set(pkg.ContentType, pkg.ContentTypeJSON)
+		json.NewEncoder(w).Encode(UploadPreview{ReferenceReport: references, Triples: triples.String()})
+	}
+}
+
+// UploadPreview is the response to a dry run of a simple upload
+type UploadPreview struct {
+	pkg.ReferenceReport
+
+	// Triples are the objects the upload would write, as N-Triples
+	Triples string `json:"triples"`
+}
+
+// UploadProgress is streamed back after every batch of a streamed upload. Lines is the number of
+// lines that have been handled, such that an interrupted upload can be resumed at the next line.
+type UploadProgress struct {
+	Lines    int    `json:"lines"`
+	Objects  int    `json:"objects"`
+	CommitId int64  `json:"commit_id,omitempty"`
+	Done     bool   `json:"done,omitempty"`
+	Error    string `json:"error,omitempty"`
+
+	// Dangling lists the references of the batch to objects that exist nowhere
+	Dangling []pkg.DanglingReference `json:"dangling,omitempty"`
+}
+
+// streamUpload reads a simple upload line by line and commits every batch-size lines in a commit of
+// its own, such that files of any size can be imported with bounded memory. The progress is written
+// as one json line per batch. Existing objects are skipped, so an interrupted upload is resumed by
+// uploading the same file again, optionally with start-line to skip the lines already committed.
+// Nothing is written unless commit=true. Every batch is checked for dangling references against the
+// lines before it and the database, and a commit stops at the first batch with dangling references
+// unless allow-dangling=true.
+func (e *EntityStore) streamUpload(w http.ResponseWriter, r *http.Request, kind string, modelId int) {
+	query := r.URL.Query()
+	batchSize := intOrDefault(query.Get("batch-size"), 1000)
+	startLine := intOrDefault(query.Get("start-line"), 1)
+	doCommit := query.Get("commit") == "true"
+	allowDangling := query.Get("allow-dangling") == "true"
+	if batchSize < 1 {
+		http.Error(w, "batch-size must be positive", http.StatusBadRequest)
+		return
+	}
+	if query.Get("mode") == "upsert" {
+		http.Error(w, "Streamed uploads can not be combined with upsert", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	existingCtx, cancel := context.WithTimeout(r.Context(), e.timeout)
+	existing, err := pkg.ExistingMrids(existingCtx, e.db, modelId)
+	cancel()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Could not get existing mrids", "error", err)
+		http.Error(w, "Could not get existing mrids: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	existingSet := pkg.Set(existing...)
+
+	w.Header().Set(pkg.ContentType, pkg.ContentTypeNDJSON)
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	var progress UploadProgress
+	report := func() {
+		enc.Encode(progress)
+		if flusher != nil {
+			flusher.Flush()
+		}
+		progress.Dangling = nil
+	}
+
+	var (
+		batch     []iter.Seq[any]
+		lines     [][]any
+		rawBytes  bytes.Buffer
+		firstLine int
+		checker   = pkg.NewReferenceChecker(e.db, modelId)
+	)
+	onInsert := func(v any) error {
+		if mridGetter, ok := v.(models.MridGetter); ok {
+			// Objects shared between lines, such as base voltages, are only written once
+			existingSet[mridGetter.GetMrid()] = struct{}{}
+			progress.Objects++
+		}
+		if versioned, ok := v.(models.VersionedIdentifiedObject); ok {
+			progress.CommitId = int64(versioned.GetCommitId())
+		}
+		return nil
+	}
+	flush := func(lastLine int) error {
+		defer func() {
+			batch = batch[:0]
+			lines = lines[:0]
+			rawBytes.Reset()
+		}()
+
+		ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
+		defer cancel()
+		references, err := checker.Check(ctx, firstLine, lines)
+		if err != nil {
+			return err
+		}
+		progress.Dangling = references.Dangling
+		if doCommit && len(references.Dangling) > 0 && !allowDangling {
+			return fmt.Errorf("Lines %d-%d have %d dangling references", firstLine, lastLine, len(references.Dangling))
+		}
+
+		items := pkg.Chain(batch...)
+		if !doCommit {
+			for item := range items {
+				onInsert(item)
+			}
+			return nil
+		}
+
+		rawData := models.SimpleUpload{Data: rawBytes.Bytes()}
+		commit := models.Commit{
+			Message: fmt.Sprintf("Add %s from lines %d-%d", kind, firstLine, lastLine),
+			Author:  UserFromCtx(r.Context()),
+		}
+		return pkg.InsertAll(ctx, e.db, commit, pkg.Chain(items, pkg.SliceToAnySeq([]any{&rawData})), onInsert)
+	}
+
+	scanner, err := uploadScanner(r, kind)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Could not read upload", "kind", kind, "error", err)
+		http.Error(w, "Could not read upload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	num := 0
+	for scanner.Scan() {
+		num++
+		if num < startLine {
+			progress.Lines = num
+			continue
+		}
+
+		line := scanner.Bytes()
+		itemIterator, err := lightItems(kind, line, modelId)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Could not unmarshal line", "kind", kind, "lineNo", num, "error", err)
+			progress.Error = fmt.Sprintf("Could not unmarshal line %d: %s", num, err)
+			report()
+			return
+		}
+		if len(batch) == 0 {
+			firstLine = num
+		}
+		rawBytes.Write(line)
+		items := slices.Collect(itemIterator)
+		lines = append(lines, items)
+		batch = append(batch, pkg.OnlyNewItems(existingSet, slices.Values(items)))
+
+		if len(batch) == batchSize {
+			if err := flush(num); err != nil {
+				slog.ErrorContext(r.Context(), "Could not insert new items", "lineNo", num, "error", err)
+				progress.Error = "Could not insert new items: " + err.Error()
+				report()
+				return
+			}
+			progress.Lines = num
+			report()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		slog.ErrorContext(r.Context(), "Could not read upload", "error", err)
+		progress.Error = "Could not read upload: " + err.Error()
+		report()
+		return
+	}
+
+	if len(batch) > 0 {
+		if err := flush(num); err != nil {
+			slog.ErrorContext(r.Context(), "Could not insert new items", "lineNo", num, "error", err)
+			progress.Error = "Could not insert new items: " + err.Error()
+			report()
+			return
+		}
+	}
+	progress.Lines = num
+	progress.Done = true
+	report()
+}
+
+// upsertUpload writes new versions of the uploaded objects that changed and creates the missing ones.
+// The response reports created, updated and unchanged objects per class. Nothing is written unless commit=true.
+func (e *EntityStore) upsertUpload(ctx context.Context, w http.ResponseWriter, r *http.Request, modelId int, items []any, rawData *models.SimpleUpload, num int, kind string) {
+	changed, report, err := pkg.UpsertItems(ctx, e.db, modelId, items)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not compare with existing items", "error", err)
+		http.Error(w, "Could not compare with existing items: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(pkg.ContentType, pkg.ContentTypeJSON)
+	if r.URL.Query().Get("commit") != "true" || len(changed) == 0 {
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	commit := models.Commit{
+		Message: fmt.Sprintf("Upsert %d %s", num, kind),
+		Author:  UserFromCtx(r.Context()),
+	}
+	onInsert := func(v any) error {
+		if versioned, ok := v.(models.VersionedIdentifiedObject); ok {
+			report.CommitId = int64(versioned.GetCommitId())
+		}
+		return nil
+	}
+	if err := pkg.InsertAll(ctx, e.db, commit, slices.Values(append(changed, rawData)), onInsert); err != nil {
+		slog.ErrorContext(ctx, "Could not insert new items", "error", err)
+		http.Error(w, "Could not insert new items: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// cgmesDocuments returns the RDF/XML documents of a request. Several profiles of the same model
+// can be uploaded together as files in a multipart form, otherwise the body is one document.
+func cgmesDocuments(r *http.Request) ([]io.Reader, func(), error) {
+	if !strings.HasPrefix(r.Header.Get(pkg.ContentType), "multipart/form-data") {
+		return []io.Reader{r.Body}, func() {}, nil
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return nil, func() {}, err
+	}
+
+	var (
+		documents []io.Reader
+		files     []io.Closer
+	)
+	closeAll := func() {
+		for _, file := range files {
+			file.Close()
+		}
 	}
+	for _, headers := range r.MultipartForm.File {
+		for _, header := range headers {
+			file, err := header.Open()
+			if err != nil {
+				closeAll()
+				return nil, func() {}, err
+			}
+			files = append(files, file)
+			documents = append(documents, file)
+		}
+	}
+	if len(documents) == 0 {
+		return nil, closeAll, fmt.Errorf("No files in form")
+	}
+	return documents, closeAll, nil
 }
 
+// CgmesImport imports CGMES RDF/XML documents into a model. The report of mapped and unmapped classes
+// and properties is returned without writing anything when dry-run=true.
+func (e *EntityStore) CgmesImport(w http.ResponseWriter, r *http.Request) {
+	hundredMb := int64(100 << 20)
+	selectedModel, _ := repository.ModelFromCtx(r.Context())
+	modelId := intOrDefault(r.URL.Query().Get("model-id"), selectedModel)
+
+	r.Body = http.MaxBytesReader(w, r.Body, hundredMb)
+	defer r.Body.Close()
+
+	documents, closeDocuments, err := cgmesDocuments(r)
+	defer closeDocuments()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Could not read documents", "error", err)
+		http.Error(w, "Could not read documents: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
+	defer cancel()
+
+	items, report, err := pkg.CgmesItems(ctx, e.db, modelId, documents...)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not map CGMES documents", "error", err)
+		http.Error(w, "Could not map CGMES documents: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	e.commitImport(ctx, w, r, items, report, "CGMES")
+}
+
+// NTriplesImport imports N-Triples written by the export, such that a model can be moved between instances
+func (e *EntityStore) NTriplesImport(w http.ResponseWriter, r *http.Request) {
+	hundredMb := int64(100 << 20)
+	selectedModel, _ := repository.ModelFromCtx(r.Context())
+	modelId := intOrDefault(r.URL.Query().Get("model-id"), selectedModel)
+
+	r.Body = http.MaxBytesReader(w, r.Body, hundredMb)
+	defer r.Body.Close()
+
+	ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
+	defer cancel()
+
+	items, report, err := pkg.NTriplesItems(ctx, e.db, modelId, r.Body)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not map N-Triples", "error", err)
+		http.Error(w, "Could not map N-Triples: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	e.commitImport(ctx, w, r, items, report, "N-Triples")
+}
+
+// commitImport inserts imported items in one commit and responds with the report. Nothing is
+// written when dry-run=true.
+func (e *EntityStore) commitImport(ctx context.Context, w http.ResponseWriter, r *http.Request, items []any, report pkg.RdfImportReport, source string) {
+	w.Header().Set(pkg.ContentType, pkg.ContentTypeJSON)
+	if r.URL.Query().Get("dry-run") == "true" || report.Objects == 0 {
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	commit := models.Commit{
+		Message: fmt.Sprintf("Import %d objects from %s", report.Objects, source),
+		Author:  UserFromCtx(r.Context()),
+	}
+	onInsert := func(v any) error {
+		if versioned, ok := v.(models.VersionedIdentifiedObject); ok {
+			report.CommitId = int64(versioned.GetCommitId())
+		}
+		return nil
+	}
+	if err := pkg.InsertAll(ctx, e.db, commit, slices.Values(items), onInsert); err != nil {
+		slog.ErrorContext(ctx, "Could not insert imported items", "error", err)
+		http.Error(w, "Could not insert imported items: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(report)
+}
+
+// GeoJsonImport positions substations and routes lines from a GeoJSON FeatureCollection. The report of
+// matched and unmatched features is returned without writing anything when dry-run=true.
+func (e *EntityStore) GeoJsonImport(w http.ResponseWriter, r *http.Request) {
+	hundredMb := int64(100 << 20)
+	selectedModel, _ := repository.ModelFromCtx(r.Context())
+	modelId := intOrDefault(r.URL.Query().Get("model-id"), selectedModel)
+
+	r.Body = http.MaxBytesReader(w, r.Body, hundredMb)
+	defer r.Body.Close()
+
+	var collection pkg.GeoJsonCollection
+	if err := json.NewDecoder(r.Body).Decode(&collection); err != nil {
+		slog.ErrorContext(r.Context(), "Could not decode GeoJSON", "error", err)
+		http.Error(w, "Could not decode GeoJSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if collection.Type != pkg.GeoJsonFeatureCollection {
+		http.Error(w, fmt.Sprintf("Expected a %s, got %q", pkg.GeoJsonFeatureCollection, collection.Type), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
+	defer cancel()
+
+	items, report, err := pkg.GeoJsonItems(ctx, e.db, modelId, collection)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not map GeoJSON features", "error", err)
+		http.Error(w, "Could not map GeoJSON features: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(pkg.ContentType, pkg.ContentTypeJSON)
+	if r.URL.Query().Get("dry-run") == "true" || len(items) == 0 {
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	commit := models.Commit{
+		Message: fmt.Sprintf("Import %d locations from GeoJSON", report.Matched-report.Unchanged),
+		Author:  UserFromCtx(r.Context()),
+	}
+	onInsert := func(v any) error {
+		if versioned, ok := v.(models.VersionedIdentifiedObject); ok {
+			report.CommitId = int64(versioned.GetCommitId())
+		}
+		return nil
+	}
+	if err := pkg.InsertAll(ctx, e.db, commit, slices.Values(items), onInsert); err != nil {
+		slog.ErrorContext(ctx, "Could not insert locations", "error", err)
+		http.Error(w, "Could not insert locations: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(report)
+}
+
+// GeoJsonExport writes substation positions and line routes as a GeoJSON FeatureCollection
+func (e *EntityStore) GeoJsonExport(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
+	defer cancel()
+
+	modelId := pkg.ModelOf(ctx)
+	collection, err := pkg.GeoJson(ctx, e.db, modelId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not collect locations", "error", err)
+		http.Error(w, "Could not collect locations: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(pkg.ContentType, pkg.ContentTypeGeoJSON)
+	json.NewEncoder(w).Encode(collection)
+}
+
+// CgmesExport writes the model as a CGMES RDF/XML document. The profile query parameter selects
+// EQ (default) or GL. The model version defaults to the latest commit visible from the branch and as-of commit.
+func (e *EntityStore) CgmesExport(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
+	defer cancel()
+
+	query := r.URL.Query()
+	shortName := cmp.Or(query.Get("profile"), "EQ")
+	profile, err := pkg.LoadCgmesProfile(shortName)
+	if err != nil {
+		slog.ErrorContext(ctx, "Invalid CGMES profile", "error", err)
+		http.Error(w, "Invalid CGMES profile: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Exports of different content get different versions, and thereby different FullModel ids
+	version := query.Get("version")
+	if version == "" {
+		head, err := repository.HeadCommitId(ctx, e.db)
+		if err != nil {
+			slog.ErrorContext(ctx, "Could not find the latest commit", "error", err)
+			http.Error(w, "Could not find the latest commit: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		version = strconv.FormatInt(head, 10)
+	}
+
+	modelId := pkg.ModelOf(ctx)
+	now := time.Now().UTC()
+	header := pkg.CgmesHeader{
+		Id:                   pkg.CgmesModelId(modelId, profile.ShortName, version),
+		Created:              now,
+		ScenarioTime:         now,
+		Version:              version,
+		Description:          query.Get("description"),
+		ModelingAuthoritySet: cmp.Or(query.Get("modeling-authority-set"), pkg.DefaultModelingAuthoritySet),
+	}
+	if profile.ShortName != "EQ" {
+		header.DependentOn = []uuid.UUID{pkg.CgmesModelId(modelId, "EQ", version)}
+	}
+
+	items, err := pkg.CgmesExportItems(ctx, e.db, modelId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not fetch all items", "error", err)
+		http.Error(w, "Could not fetch items: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := pkg.CgmesExport(ctx, e.db, &buf, profile, header, items); err != nil {
+		slog.ErrorContext(ctx, "Could not write CGMES document", "error", err)
+		http.Error(w, "Could not write CGMES document: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(pkg.ContentType, pkg.ContentTypeRdfXml)
+	w.Write(buf.Bytes())
+}
+
+// Commits lists commits newest first. The log is paged and can be filtered by author, branch, message,
+// time range and by the class or object the commits touched. Browsers get an HTML timeline.
 func (e *EntityStore) Commits(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseCommitLogFilter(r.URL.Query())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Invalid commit log filter", "error", err)
+		http.Error(w, "Invalid commit log filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.Class != "" {
+		if _, ok := pkg.FormTypes()[filter.Class]; !ok {
+			http.Error(w, fmt.Sprintf("Unknown class %s", filter.Class), http.StatusBadRequest)
+			return
+		}
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
 	defer cancel()
 
-	var commits []models.Commit
-	err := e.db.NewSelect().Model(&commits).Scan(ctx)
+	commitLog, err := pkg.Commits(ctx, e.db, filter)
 	if err != nil {
 		slog.ErrorContext(ctx, "Could not fetch commits", "error", err)
 		http.Error(w, "Could not fetch commits: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	pkg.PanicOnErr(json.NewEncoder(w).Encode(&commits))
-	w.Header().Set("Content-Type", "application/json")
+
+	if strings.Contains(r.Header.Get("Accept"), pkg.ContentTypeHTML) {
+		w.Header().Set(pkg.ContentType, pkg.ContentTypeHTML)
+		if err := pkg.WriteCommitLog(w, commitLog); err != nil {
+			slog.ErrorContext(ctx, "Failed to render commit log", "error", err)
+		}
+		return
+	}
+	w.Header().Set(pkg.ContentType, pkg.ContentTypeJSON)
+	json.NewEncoder(w).Encode(commitLog)
+}
+
+func parseCommitLogFilter(query url.Values) (pkg.CommitLogFilter, error) {
+	filter := pkg.CommitLogFilter{
+		Author:   query.Get("author"),
+		Branch:   query.Get("branch"),
+		Message:  query.Get("message"),
+		Class:    query.Get("class"),
+		Page:     intOrDefault(query.Get("page"), 1),
+		PageSize: intOrDefault(query.Get("page-size"), pkg.DefaultCommitPageSize),
+	}
+
+	var errs []error
+	if since := query.Get("since"); since != "" {
+		var err error
+		filter.Since, err = time.Parse(time.RFC3339, since)
+		errs = append(errs, err)
+	}
+	if until := query.Get("until"); until != "" {
+		var err error
+		filter.Until, err = time.Parse(time.RFC3339, until)
+		errs = append(errs, err)
+	}
+	if mrid := query.Get("mrid"); mrid != "" {
+		var err error
+		filter.Mrid, err = uuid.Parse(mrid)
+		errs = append(errs, err)
+	}
+	return filter, errors.Join(errs...)
+}
+
+func (e *EntityStore) CommitDiff(w http.ResponseWriter, r *http.Request) {
+	from, fromErr := strconv.ParseInt(r.PathValue("from"), 10, 64)
+	to, toErr := strconv.ParseInt(r.PathValue("to"), 10, 64)
+	if err := errors.Join(fromErr, toErr); err != nil {
+		slog.ErrorContext(r.Context(), "Commit ids must be integers", "error", err)
+		http.Error(w, "Commit ids must be integers: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if from > to {
+		http.Error(w, fmt.Sprintf("From commit %d must not be after to commit %d", from, to), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
+	defer cancel()
+
+	diff, err := pkg.DiffCommits(ctx, e.db, from, to)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to diff commits", "from", from, "to", to, "error", err)
+		http.Error(w, "Failed to diff commits: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch accept := r.Header.Get("Accept"); {
+	case strings.Contains(accept, pkg.ContentTypeJSON):
+		w.Header().Set(pkg.ContentType, pkg.ContentTypeJSON)
+		json.NewEncoder(w).Encode(diff)
+	default:
+		w.Header().Set(pkg.ContentType, pkg.ContentTypeHTML)
+		if err := pkg.WriteCommitDiff(w, diff); err != nil {
+			slog.ErrorContext(ctx, "Failed to render diff", "error", err)
+		}
+	}
+}
+
+// RevertCommit undoes a commit by adding a new commit. In contrast to DeleteCommit the history is kept.
+func (e *EntityStore) RevertCommit(w http.ResponseWriter, r *http.Request) {
+	commitId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Commit id is not an integer", "commitId", r.PathValue("id"))
+		http.Error(w, "Commit id is not an integer: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
+	defer cancel()
+
+	inserter := repository.BunInserter{Db: e.db}
+	result, err := pkg.RevertCommit(ctx, e.db, &inserter, commitId, UserFromCtx(r.Context()))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Commit %d does not exist", commitId), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to revert commit", "commitId", commitId, "error", err)
+		http.Error(w, "Failed to revert commit: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(pkg.ContentType, pkg.ContentTypeJSON)
+	if len(result.Dependencies) > 0 {
+		w.WriteHeader(http.StatusConflict)
+	}
+	json.NewEncoder(w).Encode(result)
 }
 
 func (e *EntityStore) DeleteCommit(w http.ResponseWriter, r *http.Request) {
@@ -465,7 +1212,7 @@ func (e *EntityStore) Map(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
 	defer cancel()
 
-	modelId := 0
+	modelId, _ := repository.ModelFromCtx(ctx)
 	var (
 		substations []models.Substation
 		acLines     []models.ACLineSegment
@@ -615,19 +1362,20 @@ func (e *EntityStore) ConnectDanglingLines(w http.ResponseWriter, r *http.Reques
 
 	ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
 	defer cancel()
+	modelId, _ := repository.ModelFromCtx(ctx)
 
 	failNo, err := pkg.ReturnOnFirstError(
 		func() error {
-			return e.db.NewSelect().Model(&substations).Scan(ctx)
+			return e.db.NewSelect().Model(&substations).Apply(repository.OnBranch(ctx)).Scan(ctx)
 		},
 		func() error {
-			return e.db.NewSelect().Model(&lines).Scan(ctx)
+			return e.db.NewSelect().Model(&lines).Apply(repository.OnBranch(ctx)).Scan(ctx)
 		},
 		func() error {
-			return e.db.NewSelect().Model(&terminals).Scan(ctx)
+			return e.db.NewSelect().Model(&terminals).Apply(repository.OnBranch(ctx)).Scan(ctx)
 		},
 		func() error {
-			return e.db.NewSelect().Model(&vls).Scan(ctx)
+			return e.db.NewSelect().Model(&vls).Apply(repository.OnBranch(ctx)).Scan(ctx)
 		},
 	)
 
@@ -688,7 +1436,7 @@ func (e *EntityStore) ConnectDanglingLines(w http.ResponseWriter, r *http.Reques
 			if result.VoltageLevel != nil {
 				vlsPerSubstation[sub.Mrid] = append(vlsPerSubstation[sub.Mrid], *result.VoltageLevel)
 			}
-			results = append(results, result.All(0))
+			results = append(results, result.All(modelId))
 		}
 	}
 
@@ -719,10 +1467,28 @@ func (e *EntityStore) ConnectDanglingLines(w http.ResponseWriter, r *http.Reques
 
 }
 
+// decodePatch reads a list of json patches, or a merge patch keyed by mrid when the content type
+// is application/merge-patch+json
+func decodePatch(r *http.Request) ([]pkg.JsonPatch, error) {
+	if strings.HasPrefix(r.Header.Get(pkg.ContentType), pkg.ContentTypeMergePatch) {
+		var mergePatch pkg.MergePatch
+		if err := json.NewDecoder(r.Body).Decode(&mergePatch); err != nil {
+			return nil, err
+		}
+		return mergePatch.JsonPatches()
+	}
+
+	var jsonPatch []pkg.JsonPatch
+	err := json.NewDecoder(r.Body).Decode(&jsonPatch)
+	return jsonPatch, err
+}
+
+// ApplyJsonPatch applies a list of json patches, or a merge patch, in one commit. With dry-run=true
+// the patches are only validated and the resulting change of each object is reported.
 func (e *EntityStore) ApplyJsonPatch(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024)
-	var jsonPatch []pkg.JsonPatch
-	if err := json.NewDecoder(r.Body).Decode(&jsonPatch); err != nil {
+	jsonPatch, err := decodePatch(r)
+	if err != nil {
 		slog.ErrorContext(r.Context(), "Failed to interpret json patch", "error", err)
 		http.Error(w, "Failed to interpret json patch: "+err.Error(), http.StatusBadRequest)
 		return
@@ -731,21 +1497,107 @@ func (e *EntityStore) ApplyJsonPatch(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
 	defer cancel()
 
+	if r.URL.Query().Get("dry-run") == "true" {
+		preview := pkg.PreviewPatch(ctx, e.db, jsonPatch)
+		w.Header().Set(pkg.ContentType, pkg.ContentTypeJSON)
+		if !preview.Valid {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(preview)
+		return
+	}
+
 	user := UserFromCtx(r.Context())
 	if err := pkg.ApplyPatch(ctx, e.db, user, jsonPatch); err != nil {
-		slog.ErrorContext(ctx, "Failed to apply patch", "error", err)
-		http.Error(w, "Failed to apply patch: "+err.Error(), http.StatusInternalServerError)
+		writePatchError(ctx, w, "Failed to apply patch", err)
 		return
 	}
 	fmt.Fprint(w, "Successfully updated database with patch")
 }
 
+// writePatchError answers a patch that could not be applied. Patches based on an outdated version and
+// failing tests are conflicts, failures of the database are server errors and other failing operations
+// are reported with their index and path like in a dry run.
+func writePatchError(ctx context.Context, w http.ResponseWriter, msg string, err error) {
+	var (
+		conflict   *pkg.ConflictError
+		failedTest *pkg.PatchTestError
+		storage    *pkg.StorageError
+		invalid    *pkg.PatchError
+	)
+	switch {
+	case errors.As(err, &conflict):
+		slog.InfoContext(ctx, "Rejected patch based on an outdated version", "error", err)
+		w.Header().Set(pkg.ContentType, pkg.ContentTypeJSON)
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(conflict)
+	case errors.As(err, &failedTest):
+		slog.InfoContext(ctx, "Rejected patch with a failing test", "error", err)
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.As(err, &storage):
+		slog.ErrorContext(ctx, msg, "error", err)
+		http.Error(w, msg+": "+err.Error(), http.StatusInternalServerError)
+	case errors.As(err, &invalid):
+		slog.InfoContext(ctx, "Rejected invalid patch", "error", err)
+		w.Header().Set(pkg.ContentType, pkg.ContentTypeJSON)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(invalid)
+	default:
+		slog.ErrorContext(ctx, msg, "error", err)
+		http.Error(w, msg+": "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// BulkPatch expands a selector and a set of field assignments into json patches and applies them
+// in one commit. With dry-run=true the matched objects and their changes are only reported.
+func (e *EntityStore) BulkPatch(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024)
+	var bulk pkg.BulkPatch
+	if err := json.NewDecoder(r.Body).Decode(&bulk); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to interpret bulk patch", "error", err)
+		http.Error(w, "Failed to interpret bulk patch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
+	defer cancel()
+
+	result, err := bulk.Expand(ctx, e.db)
+	var storage *pkg.StorageError
+	if errors.As(err, &storage) {
+		writePatchError(ctx, w, "Failed to expand bulk patch", err)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to expand bulk patch", "error", err)
+		http.Error(w, "Failed to expand bulk patch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set(pkg.ContentType, pkg.ContentTypeJSON)
+	if r.URL.Query().Get("dry-run") == "true" {
+		preview := pkg.PreviewPatch(ctx, e.db, result.Patches)
+		result.Preview = &preview
+		if !preview.Valid {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	if err := pkg.ApplyPatch(ctx, e.db, UserFromCtx(r.Context()), result.Patches); err != nil {
+		writePatchError(ctx, w, "Failed to apply bulk patch", err)
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
 func (e *EntityStore) Connection(w http.ResponseWriter, r *http.Request) {
 	mrid := r.PathValue("mrid")
 	ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
 	defer cancel()
 
-	query := repository.MustGetQuery("connection.sql")
+	query := repository.ScopeLatestViews(ctx, e.db, repository.MustGetQuery("connection.sql"))
 
 	var vertices []ConnectionVertex
 	err := e.db.NewRaw(query, mrid).Scan(ctx, &vertices)
@@ -805,6 +1657,7 @@ type ModelMetaData struct {
 	ModelId       int       `json:"modelId"`
 	ModelName     string    `json:"modelName"`
 	CommitMessage string    `json:"commitMessage"`
+	BaseCommitId  int64     `json:"baseCommitId"`
 }
 
 type finderForSubtypesResult struct {
@@ -3,10 +3,12 @@ package api
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"slices"
@@ -17,6 +19,7 @@ import (
 	"com.github/davidkleiven/tripleworks/migrations"
 	"com.github/davidkleiven/tripleworks/models"
 	"com.github/davidkleiven/tripleworks/pkg"
+	"com.github/davidkleiven/tripleworks/repository"
 	"com.github/davidkleiven/tripleworks/testutils"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
@@ -201,7 +204,7 @@ func TestSubstationReturnedWhenRequestingEquipmentContainer(t *testing.T) {
 	require.NoError(t, err)
 
 	finder := pkg.MustGet(pkg.Finders, "Substation")
-	result, err := finder(ctx, store.db, 0)
+	result, err := finder(ctx, store.db, pkg.AnyModel)
 	require.NoError(t, err)
 	require.Equal(t, 1, len(result))
 
@@ -343,6 +346,15 @@ func TestEditComponentForm(t *testing.T) {
 		mux.ServeHTTP(rec, req)
 		require.Equal(t, http.StatusOK, rec.Code)
 		require.Contains(t, rec.Body.String(), "<input")
+		require.Contains(t, rec.Body.String(), fmt.Sprintf("/resource/%s/history", entity.Mrid))
+	})
+
+	t.Run("history link keeps the model", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/entity-form/%s?model-id=0", entity.Mrid), nil)
+		ModelScope(mux).ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.Contains(t, rec.Body.String(), fmt.Sprintf("/resource/%s/history?model-id=0", entity.Mrid))
 	})
 
 	t.Run("success corresponding resource", func(t *testing.T) {
@@ -431,15 +443,22 @@ func TestSimpleUpload(t *testing.T) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/upload/{kind}", store.SimpleUpload)
 
+	// A dry run gives the objects as N-Triples along with the report of dangling references
+	triples := func(rec *httptest.ResponseRecorder) string {
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.Equal(t, pkg.ContentTypeJSON, rec.Header().Get(pkg.ContentType))
+		var preview UploadPreview
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&preview))
+		return preview.Triples
+	}
+
 	t.Run("substations no commit", func(t *testing.T) {
 		rec := httptest.NewRecorder()
 
 		body := jsonlEncode(t, pkg.SubstationLight{Name: "Sub A"}, pkg.SubstationLight{Name: "Sub B"})
 		req := httptest.NewRequest("POST", "/upload/substations", body)
 		mux.ServeHTTP(rec, req)
-		require.Equal(t, http.StatusOK, rec.Code)
-		require.Equal(t, "application/n-triples", rec.Header().Get("Content-Type"))
-		content := rec.Body.String()
+		content := triples(rec)
 		require.Contains(t, content, "Substation")
 	})
 
@@ -449,9 +468,7 @@ func TestSimpleUpload(t *testing.T) {
 		body := jsonlEncode(t, pkg.GeneratorLight{Substation: "Sub A"}, pkg.GeneratorLight{Substation: "Sub B"})
 		req := httptest.NewRequest("POST", "/upload/generators", body)
 		mux.ServeHTTP(rec, req)
-		require.Equal(t, http.StatusOK, rec.Code)
-		require.Equal(t, "application/n-triples", rec.Header().Get("Content-Type"))
-		content := rec.Body.String()
+		content := triples(rec)
 		require.Contains(t, content, "SynchronousMachine")
 	})
 
@@ -461,9 +478,7 @@ func TestSimpleUpload(t *testing.T) {
 		body := jsonlEncode(t, pkg.LineLight{FromSubstation: "Sub A"}, pkg.LineLight{FromSubstation: "Sub B"})
 		req := httptest.NewRequest("POST", "/upload/lines", body)
 		mux.ServeHTTP(rec, req)
-		require.Equal(t, http.StatusOK, rec.Code)
-		require.Equal(t, "application/n-triples", rec.Header().Get("Content-Type"))
-		content := rec.Body.String()
+		content := triples(rec)
 		require.Contains(t, content, "ACLineSegment")
 	})
 
@@ -473,12 +488,28 @@ func TestSimpleUpload(t *testing.T) {
 		body := jsonlEncode(t, pkg.LoadLight{Substation: "Sub A"}, pkg.LoadLight{Substation: "Sub B"})
 		req := httptest.NewRequest("POST", "/upload/loads", body)
 		mux.ServeHTTP(rec, req)
-		require.Equal(t, http.StatusOK, rec.Code)
-		require.Equal(t, "application/n-triples", rec.Header().Get("Content-Type"))
-		content := rec.Body.String()
+		content := triples(rec)
 		require.Contains(t, content, "ConformLoad")
 	})
 
+	for _, test := range []struct {
+		kind  string
+		items []any
+		class string
+	}{
+		{kind: "transformers", items: []any{pkg.TransformerLight{Substation: "Sub A", HighVoltage: 420, LowVoltage: 132}}, class: "PowerTransformerEnd"},
+		{kind: "shunts", items: []any{pkg.ShuntLight{Substation: "Sub A", Voltage: 132, Q: 50}}, class: "LinearShuntCompensator"},
+		{kind: "switches", items: []any{pkg.SwitchLight{Kind: "breaker", Substation: "Sub A", Voltage: 132}}, class: "Breaker"},
+		{kind: "hvdc", items: []any{pkg.HvdcLight{FromSubstation: "Sub A", ToSubstation: "Sub B", Voltage: 420}}, class: "VsConverter"},
+	} {
+		t.Run(test.kind+" no commit", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/upload/"+test.kind, jsonlEncode(t, test.items...))
+			mux.ServeHTTP(rec, req)
+			require.Contains(t, triples(rec), test.class)
+		})
+	}
+
 	t.Run("substations do commit", func(t *testing.T) {
 		origMrids, err := pkg.ExistingMrids(context.Background(), store.db, 0)
 		require.NoError(t, err)
@@ -508,6 +539,14 @@ func TestSimpleUpload(t *testing.T) {
 		require.Contains(t, rec.Body.String(), "existing mrids")
 	})
 
+	t.Run("bad request on unknown switch kind", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		body := jsonlEncode(t, pkg.SwitchLight{Kind: "braker", Substation: "Sub A", Voltage: 132})
+		mux.ServeHTTP(rec, httptest.NewRequest("POST", "/upload/switches", body))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.Contains(t, rec.Body.String(), "Unknown switch kind 'braker'")
+	})
+
 	t.Run("bad request on bad json", func(t *testing.T) {
 		buf := bytes.NewBufferString("not jsonl")
 		rec := httptest.NewRecorder()
@@ -519,12 +558,536 @@ func TestSimpleUpload(t *testing.T) {
 	t.Run("bad request on unknown type", func(t *testing.T) {
 		buf := bytes.NewBufferString("not jsonl")
 		rec := httptest.NewRecorder()
-		req := httptest.NewRequest("POST", "/upload/transformers", buf)
+		req := httptest.NewRequest("POST", "/upload/capacitors", buf)
 		mux.ServeHTTP(rec, req)
 		require.Equal(t, http.StatusBadRequest, rec.Code)
 	})
 }
 
+const cgmesDocument = `<rdf:RDF xmlns:cim="http://iec.ch/TC57/2013/CIM-schema-cim16#" xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <cim:BaseVoltage rdf:ID="_6e2a1b7c-9a43-4d1e-8b3f-2c5d7e9f1a2b">
+    <cim:BaseVoltage.nominalVoltage>400.0</cim:BaseVoltage.nominalVoltage>
+  </cim:BaseVoltage>
+  <cim:ACLineSegment rdf:ID="_0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e">
+    <cim:ConductingEquipment.BaseVoltage rdf:resource="#_6e2a1b7c-9a43-4d1e-8b3f-2c5d7e9f1a2b"/>
+    <cim:ACLineSegment.unknown>1</cim:ACLineSegment.unknown>
+  </cim:ACLineSegment>
+</rdf:RDF>`
+
+const cgmesGlDocument = `<rdf:RDF xmlns:cim="http://iec.ch/TC57/2013/CIM-schema-cim16#" xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <cim:Location rdf:ID="_location"/>
+</rdf:RDF>`
+
+func TestCgmesImport(t *testing.T) {
+	store := setupStore(t)
+	ctx := context.Background()
+
+	importCgmes := func(url string, body *bytes.Buffer, contentType string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", url, body)
+		if contentType != "" {
+			req.Header.Set(pkg.ContentType, contentType)
+		}
+		store.CgmesImport(rec, req)
+		return rec
+	}
+
+	countLines := func() int {
+		num, err := store.db.NewSelect().Model((*models.ACLineSegment)(nil)).Count(ctx)
+		require.NoError(t, err)
+		return num
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		rec := importCgmes("/import/cgmes?dry-run=true", bytes.NewBufferString(cgmesDocument), "application/rdf+xml")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var report pkg.RdfImportReport
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+		require.Equal(t, 2, report.Objects)
+		require.Equal(t, 1, report.UnmappedProperties[pkg.Cim16+"ACLineSegment.unknown"])
+		require.Zero(t, countLines())
+	})
+
+	t.Run("invalid document", func(t *testing.T) {
+		rec := importCgmes("/import/cgmes", bytes.NewBufferString("not xml"), "")
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("multiple files", func(t *testing.T) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		for name, content := range map[string]string{"eq.xml": cgmesDocument, "gl.xml": cgmesGlDocument} {
+			part, err := writer.CreateFormFile("files", name)
+			require.NoError(t, err)
+			_, err = part.Write([]byte(content))
+			require.NoError(t, err)
+		}
+		require.NoError(t, writer.Close())
+
+		rec := importCgmes("/import/cgmes", &body, writer.FormDataContentType())
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+		var report pkg.RdfImportReport
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+		require.Equal(t, 3, report.Objects)
+		require.NotZero(t, report.CommitId)
+		require.Equal(t, 1, countLines())
+	})
+
+	t.Run("reimport adds nothing", func(t *testing.T) {
+		rec := importCgmes("/import/cgmes", bytes.NewBufferString(cgmesDocument), "")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var report pkg.RdfImportReport
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+		require.Equal(t, 2, report.Existing)
+		require.Equal(t, 1, countLines())
+	})
+}
+
+func TestNTriplesImport(t *testing.T) {
+	source := setupStore(t)
+	target := setupStore(t)
+	ctx := context.Background()
+
+	var line models.ACLineSegment
+	line.Mrid, line.Name, line.R = uuid.New(), "Line A", 2.5
+	entity := pkg.MakeEntity(&line, 0)
+	require.NoError(t, pkg.InsertAll(ctx, source.db, models.Commit{}, slices.Values([]any{&entity, &line}), pkg.NoOpOnInsert))
+
+	exported := httptest.NewRecorder()
+	source.Export(exported, httptest.NewRequest("GET", "/export", nil))
+	require.Equal(t, http.StatusOK, exported.Code)
+
+	t.Run("invalid document", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		target.NTriplesImport(rec, httptest.NewRequest("POST", "/import/ntriples", strings.NewReader("not triples\n")))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("import export", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		target.NTriplesImport(rec, httptest.NewRequest("POST", "/import/ntriples", bytes.NewReader(exported.Body.Bytes())))
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+		var report pkg.RdfImportReport
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+		require.Equal(t, 1, report.ByClass["ACLineSegment"])
+
+		lines, err := pkg.FindAll[models.ACLineSegment](target.db, ctx, 0)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(lines))
+		require.Equal(t, line.Mrid, lines[0].Mrid)
+		require.Equal(t, 2.5, lines[0].R)
+	})
+}
+
+func TestGeoJsonImportExport(t *testing.T) {
+	store := setupStore(t)
+	ctx := context.Background()
+
+	var line models.ACLineSegment
+	line.Mrid, line.Name = uuid.New(), "Line A"
+	entity := pkg.MakeEntity(&line, 0)
+	require.NoError(t, pkg.InsertAll(ctx, store.db, models.Commit{}, slices.Values([]any{&entity, &line}), pkg.NoOpOnInsert))
+
+	document := `{"type": "FeatureCollection", "features": [
+		{"type": "Feature", "geometry": {"type": "LineString", "coordinates": [[10.0, 60.0], [10.5, 60.5]]}, "properties": {"name": "Line A"}},
+		{"type": "Feature", "geometry": {"type": "Point", "coordinates": [10.0, 60.0]}, "properties": {"name": "Sub A"}}
+	]}`
+
+	for _, test := range []struct {
+		desc string
+		body string
+	}{
+		{desc: "invalid json", body: "{"},
+		{desc: "not a feature collection", body: `{"type": "Feature"}`},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			store.GeoJsonImport(rec, httptest.NewRequest("POST", "/import/geojson", strings.NewReader(test.body)))
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		store.GeoJsonImport(rec, httptest.NewRequest("POST", "/import/geojson?dry-run=true", strings.NewReader(document)))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var report pkg.GeoJsonImportReport
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+		require.Equal(t, 1, report.Matched)
+		require.Equal(t, []string{"Feature no. 2: No match for Sub A"}, report.Unmatched)
+		require.Zero(t, report.CommitId)
+	})
+
+	t.Run("import export", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		store.GeoJsonImport(rec, httptest.NewRequest("POST", "/import/geojson", strings.NewReader(document)))
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+		var report pkg.GeoJsonImportReport
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+		require.NotZero(t, report.CommitId)
+
+		exported := httptest.NewRecorder()
+		store.GeoJsonExport(exported, httptest.NewRequest("GET", "/export/geojson", nil))
+		require.Equal(t, http.StatusOK, exported.Code, exported.Body.String())
+		require.Equal(t, pkg.ContentTypeGeoJSON, exported.Header().Get(pkg.ContentType))
+
+		var collection pkg.GeoJsonCollection
+		require.NoError(t, json.NewDecoder(exported.Body).Decode(&collection))
+		require.Equal(t, 1, len(collection.Features))
+
+		feature := collection.Features[0]
+		require.Equal(t, line.Mrid.String(), feature.Properties["mrid"])
+		require.Equal(t, pkg.GeoJsonLineString, feature.Geometry.Type)
+		require.JSONEq(t, "[[10.0, 60.0], [10.5, 60.5]]", string(feature.Geometry.Coordinates))
+	})
+}
+
+func TestCgmesExport(t *testing.T) {
+	store := setupStore(t)
+	ctx := context.Background()
+
+	var line models.ACLineSegment
+	line.Mrid, line.Name, line.R = uuid.New(), "Line A", 0.5
+	entity := pkg.MakeEntity(&line, 0)
+	require.NoError(t, pkg.InsertAll(ctx, store.db, models.Commit{}, slices.Values([]any{&entity, &line}), pkg.NoOpOnInsert))
+
+	t.Run("unknown profile", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		store.CgmesExport(rec, httptest.NewRequest("GET", "/export/cgmes?profile=TP", nil))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("equipment", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		store.CgmesExport(rec, httptest.NewRequest("GET", "/export/cgmes?version=4&modeling-authority-set=http://example.com", nil))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.Equal(t, pkg.ContentTypeRdfXml, rec.Header().Get(pkg.ContentType))
+
+		body := rec.Body.String()
+		require.Contains(t, body, "<md:Model.version>4</md:Model.version>")
+		require.Contains(t, body, "<md:Model.modelingAuthoritySet>http://example.com</md:Model.modelingAuthoritySet>")
+		require.Contains(t, body, `<cim:ACLineSegment rdf:ID="_`+line.Mrid.String()+`">`)
+		require.Contains(t, body, "<cim:ACLineSegment.r>0.5</cim:ACLineSegment.r>")
+	})
+
+	t.Run("geographical location depends on equipment", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		store.CgmesExport(rec, httptest.NewRequest("GET", "/export/cgmes?profile=GL", nil))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.Contains(t, rec.Body.String(), `<md:Model.DependentOn rdf:resource="urn:uuid:`)
+		require.NotContains(t, rec.Body.String(), "ACLineSegment")
+	})
+
+	t.Run("version defaults to the latest commit", func(t *testing.T) {
+		first, err := repository.HeadCommitId(ctx, store.db)
+		require.NoError(t, err)
+		require.NotZero(t, first)
+
+		renamed := line
+		renamed.Id, renamed.Name = 0, "Line B"
+		require.NoError(t, pkg.InsertAll(ctx, store.db, models.Commit{}, slices.Values([]any{&renamed}), pkg.NoOpOnInsert))
+
+		export := func(r *http.Request) string {
+			rec := httptest.NewRecorder()
+			store.CgmesExport(rec, r)
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+			return rec.Body.String()
+		}
+		versionOf := func(commitId int64) string {
+			return fmt.Sprintf("<md:Model.version>%d</md:Model.version>", commitId)
+		}
+
+		require.Contains(t, export(httptest.NewRequest("GET", "/export/cgmes", nil)), versionOf(int64(renamed.CommitId)))
+
+		req := httptest.NewRequest("GET", "/export/cgmes", nil)
+		req = req.WithContext(repository.WithAsOf(req.Context(), first))
+		require.Contains(t, export(req), versionOf(first))
+	})
+}
+
+func TestSimpleUploadUpsert(t *testing.T) {
+	store := setupStore(t)
+	ctx := context.Background()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upload/{kind}", store.SimpleUpload)
+
+	upload := func(url string, generators ...pkg.GeneratorLight) pkg.UpsertReport {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("POST", url, jsonlEncode(t, generators...)))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.Equal(t, pkg.ContentTypeJSON, rec.Header().Get(pkg.ContentType))
+
+		var report pkg.UpsertReport
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+		return report
+	}
+
+	maxP := func() []float64 {
+		units, err := pkg.FindAll[models.ThermalGeneratingUnit](store.db, ctx, 0)
+		require.NoError(t, err)
+		result := []float64{}
+		for _, unit := range units {
+			result = append(result, unit.MaxOperatingP)
+		}
+		return result
+	}
+
+	// The generator is placed in an existing substation
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("POST", "/upload/substations?commit=true", jsonlEncode(t, pkg.SubstationLight{Name: "Sub A"})))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	generator := pkg.GeneratorLight{Kind: "thermal", Substation: "Sub A", Num: 1, MaxP: 100.0, Voltage: 300}
+	report := upload("/upload/generators?mode=upsert&commit=true", generator)
+	require.Equal(t, 1, report.Classes["SynchronousMachine"].Created)
+	require.NotZero(t, report.CommitId)
+	require.Equal(t, []float64{100.0}, maxP())
+
+	generator.MaxP = 200.0
+	report = upload("/upload/generators?mode=upsert", generator)
+	require.Equal(t, 1, report.Classes["ThermalGeneratingUnit"].Updated)
+	require.Equal(t, []float64{100.0}, maxP(), "Nothing is written without commit")
+
+	report = upload("/upload/generators?mode=upsert&commit=true", generator)
+	require.Equal(t, 1, report.Classes["ThermalGeneratingUnit"].Updated)
+	require.Equal(t, []float64{200.0}, maxP())
+
+	report = upload("/upload/generators?mode=upsert&commit=true", generator)
+	require.Equal(t, 1, report.Classes["ThermalGeneratingUnit"].Unchanged)
+	require.Zero(t, report.CommitId)
+}
+
+func TestSimpleUploadDanglingReferences(t *testing.T) {
+	store := setupStore(t)
+	ctx := context.Background()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upload/{kind}", store.SimpleUpload)
+
+	upload := func(url string, accept string, body *bytes.Buffer) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", url, body)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	countLoads := func() int {
+		loads, err := pkg.FindAll[models.ConformLoad](store.db, ctx, 0)
+		require.NoError(t, err)
+		return len(loads)
+	}
+
+	substationMrid := func(name string) uuid.UUID {
+		substation := pkg.SubstationLight{Name: name}
+		for item := range substation.CimItems(0) {
+			if v, ok := item.(*models.Substation); ok {
+				return v.Mrid
+			}
+		}
+		return uuid.Nil
+	}
+
+	loads := []pkg.LoadLight{{Substation: "Sub A", Num: 1, Voltage: 300}, {Substation: "Sub B", Num: 1, Voltage: 300}}
+
+	t.Run("dry run reports missing substations", func(t *testing.T) {
+		rec := upload("/upload/loads", "", jsonlEncode(t, loads...))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, pkg.ContentTypeJSON, rec.Header().Get(pkg.ContentType))
+
+		var report UploadPreview
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+		require.Contains(t, report.Triples, "ConformLoad")
+		require.Equal(t, 2, report.Lines)
+		require.Equal(t, []pkg.DanglingReference{
+			{Line: 1, Class: "VoltageLevel", Field: "substation_mrid", Mrid: substationMrid("Sub A")},
+			{Line: 2, Class: "VoltageLevel", Field: "substation_mrid", Mrid: substationMrid("Sub B")},
+		}, report.Dangling)
+	})
+
+	t.Run("commit is blocked", func(t *testing.T) {
+		rec := upload("/upload/loads?commit=true", "", jsonlEncode(t, loads...))
+		require.Equal(t, http.StatusConflict, rec.Code)
+
+		var report pkg.ReferenceReport
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+		require.Equal(t, 2, len(report.Dangling))
+		require.Zero(t, countLoads())
+	})
+
+	t.Run("substations in the database resolve references", func(t *testing.T) {
+		rec := upload("/upload/substations?commit=true", "", jsonlEncode(t, pkg.SubstationLight{Name: "Sub A"}))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec = upload("/upload/loads", pkg.ContentTypeJSON, jsonlEncode(t, loads...))
+		var report pkg.ReferenceReport
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+		require.Equal(t, 1, len(report.Dangling))
+		require.Equal(t, 2, report.Dangling[0].Line)
+	})
+
+	t.Run("allow dangling references", func(t *testing.T) {
+		rec := upload("/upload/loads?commit=true&allow-dangling=true", "", jsonlEncode(t, loads...))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, 2, countLoads())
+	})
+}
+
+func TestSimpleUploadCsv(t *testing.T) {
+	store := setupStore(t)
+	ctx := context.Background()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upload/{kind}", store.SimpleUpload)
+
+	upload := func(url string, document string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", url, bytes.NewBufferString(document))
+		req.Header.Set(pkg.ContentType, pkg.ContentTypeCSV+"; charset=utf-8")
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("substations and loads", func(t *testing.T) {
+		rec := upload("/upload/substations?commit=true", "name;region;x;y\nSub A;NO1;10,4;63,2\n")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		rec = upload("/upload/loads?commit=true&alias=Station:substation&alias=Load:nominalP", "Station,Num,Load,Voltage\nSub A,1,2500 kW,300 kV\n")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		loads, err := pkg.FindAll[models.ConformLoad](store.db, ctx, 0)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(loads))
+		require.Equal(t, 2.5, loads[0].Pfixed)
+	})
+
+	t.Run("streamed", func(t *testing.T) {
+		rec := upload("/upload/substations?stream=true", "name,region\nSub B,NO1\nSub C,NO1\n")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), `"lines":2`)
+	})
+
+	for _, test := range []struct {
+		desc string
+		url  string
+		body string
+		msg  string
+	}{
+		{desc: "invalid value", url: "/upload/loads", body: "substation,voltage\nSub A,300\nSub B,high\n", msg: "Row 3, column 2 (voltage)"},
+		{desc: "unknown column", url: "/upload/loads", body: "substation,owner\n", msg: "Row 1, column 2 (owner)"},
+		{desc: "invalid alias", url: "/upload/loads?alias=owner", body: "substation\n", msg: "Invalid alias"},
+		{desc: "unknown kind", url: "/upload/capacitors", body: "name\n", msg: "Unknown type"},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			rec := upload(test.url, test.body)
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+			require.Contains(t, rec.Body.String(), test.msg)
+		})
+	}
+}
+
+func TestSimpleUploadStream(t *testing.T) {
+	store := setupStore(t)
+	ctx := context.Background()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upload/{kind}", store.SimpleUpload)
+
+	upload := func(url string, body *bytes.Buffer) []UploadProgress {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("POST", url, body))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.Equal(t, pkg.ContentTypeNDJSON, rec.Header().Get(pkg.ContentType))
+
+		var result []UploadProgress
+		dec := json.NewDecoder(rec.Body)
+		for dec.More() {
+			var progress UploadProgress
+			require.NoError(t, dec.Decode(&progress))
+			result = append(result, progress)
+		}
+		return result
+	}
+
+	countSubstations := func() int {
+		substations, err := pkg.FindAll[models.Substation](store.db, ctx, 0)
+		require.NoError(t, err)
+		return len(substations)
+	}
+
+	substations := []pkg.SubstationLight{
+		{Name: "A", Region: "NO1"}, {Name: "B", Region: "NO1"}, {Name: "C", Region: "NO1"}, {Name: "D", Region: "NO2"},
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		progress := upload("/upload/substations?stream=true&batch-size=3", jsonlEncode(t, substations...))
+		require.Equal(t, 2, len(progress))
+		require.Equal(t, 3, progress[0].Lines)
+		require.True(t, progress[1].Done)
+		require.Zero(t, countSubstations())
+
+		// Locations and substations, the shared coordinate system, country and the two regions
+		require.Equal(t, 4*2+1+1+2, progress[1].Objects)
+	})
+
+	t.Run("invalid line stops the upload", func(t *testing.T) {
+		body := jsonlEncode(t, substations[:2]...)
+		body.WriteString("not json\n")
+		progress := upload("/upload/substations?stream=true&batch-size=2&commit=true", body)
+		require.Equal(t, 2, len(progress))
+		require.Equal(t, 2, progress[0].Lines)
+		require.NotZero(t, progress[0].CommitId)
+		require.Contains(t, progress[1].Error, "line 3")
+		require.Equal(t, 2, countSubstations())
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		progress := upload("/upload/substations?stream=true&batch-size=1&commit=true&start-line=3", jsonlEncode(t, substations...))
+		require.Equal(t, 3, len(progress))
+		require.Equal(t, []int{3, 4, 4}, []int{progress[0].Lines, progress[1].Lines, progress[2].Lines})
+		require.Less(t, progress[0].CommitId, progress[1].CommitId)
+		require.Equal(t, 4, countSubstations())
+	})
+
+	t.Run("dangling references", func(t *testing.T) {
+		loads := []pkg.LoadLight{{Substation: "A", Num: 1, Voltage: 300}, {Substation: "E", Num: 1, Voltage: 300}}
+		countLoads := func() int {
+			loads, err := pkg.FindAll[models.ConformLoad](store.db, ctx, 0)
+			require.NoError(t, err)
+			return len(loads)
+		}
+
+		progress := upload("/upload/loads?stream=true&batch-size=1", jsonlEncode(t, loads...))
+		require.Equal(t, 3, len(progress))
+		require.Empty(t, progress[0].Dangling)
+		require.Equal(t, 2, progress[1].Dangling[0].Line)
+
+		progress = upload("/upload/loads?stream=true&batch-size=1&commit=true", jsonlEncode(t, loads...))
+		require.Equal(t, 2, len(progress))
+		require.Contains(t, progress[1].Error, "dangling references")
+		require.Equal(t, 1, countLoads())
+
+		progress = upload("/upload/loads?stream=true&batch-size=1&commit=true&allow-dangling=true", jsonlEncode(t, loads...))
+		require.True(t, progress[len(progress)-1].Done)
+		require.Equal(t, 2, countLoads())
+	})
+
+	t.Run("invalid batch size", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("POST", "/upload/substations?stream=true&batch-size=0", jsonlEncode(t, substations...)))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
 func TestGetCommits(t *testing.T) {
 	store := setupStore(t)
 	t.Run("success", func(t *testing.T) {
@@ -544,6 +1107,41 @@ func TestGetCommits(t *testing.T) {
 		require.Equal(t, http.StatusInternalServerError, rec.Code)
 	})
 
+	t.Run("filtered and paged", func(t *testing.T) {
+		for _, author := range []string{"alice", "bob", "alice"} {
+			bv := models.BaseVoltage{NominalVoltage: 132.0}
+			bv.Mrid = uuid.New()
+			err := pkg.InsertAll(context.Background(), store.db, models.Commit{Author: author}, slices.Values([]any{&bv}), pkg.NoOpOnInsert)
+			require.NoError(t, err)
+		}
+
+		rec := httptest.NewRecorder()
+		store.Commits(rec, httptest.NewRequest("GET", "/commits?author=alice&page-size=1", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var log pkg.CommitLog
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &log))
+		require.Equal(t, 2, log.Total)
+		require.Equal(t, 1, len(log.Commits))
+		require.Equal(t, []pkg.ClassStats{{Class: "BaseVoltage", Added: 1}}, log.Commits[0].Stats)
+	})
+
+	t.Run("html timeline", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/commits", nil)
+		req.Header.Set("Accept", "text/html,application/xhtml+xml")
+		store.Commits(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), "<html")
+	})
+
+	for _, query := range []string{"since=yesterday", "mrid=0000", "class=NotAClass"} {
+		t.Run(query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			store.Commits(rec, httptest.NewRequest("GET", "/commits?"+query, nil))
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
 }
 
 func TestDeleteCommits(t *testing.T) {
@@ -841,7 +1439,7 @@ func TestApplyJsonPatchEndpoint(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
 		patch := []pkg.JsonPatch{{
 			Op:    "replace",
-			Path:  fmt.Sprintf("/%s/nominval_voltage", bv.Mrid),
+			Path:  fmt.Sprintf("/%s/nominal_voltage", bv.Mrid),
 			Value: []byte{0x32, 0x32},
 		}}
 
@@ -860,6 +1458,37 @@ func TestApplyJsonPatchEndpoint(t *testing.T) {
 		require.Equal(t, 2, len(bvs))
 	})
 
+	t.Run("stale base commit", func(t *testing.T) {
+		var head models.BaseVoltage
+		err := store.db.NewSelect().Model(&head).Where("mrid = ?", bv.Mrid).Order("commit_id DESC").Limit(1).Scan(ctx)
+		require.NoError(t, err)
+
+		send := func() *httptest.ResponseRecorder {
+			patch := []pkg.JsonPatch{{
+				Op:           "replace",
+				Path:         fmt.Sprintf("/%s/nominal_voltage", bv.Mrid),
+				Value:        []byte("132"),
+				BaseCommitId: int64(head.CommitId),
+			}}
+			var body bytes.Buffer
+			require.NoError(t, json.NewEncoder(&body).Encode(patch))
+			rec := httptest.NewRecorder()
+			store.ApplyJsonPatch(rec, httptest.NewRequest("PATCH", "/resource", &body))
+			return rec
+		}
+
+		require.Equal(t, http.StatusOK, send().Code)
+
+		rec := send()
+		require.Equal(t, http.StatusConflict, rec.Code)
+
+		var conflict pkg.ConflictError
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &conflict))
+		require.Equal(t, int64(head.CommitId), conflict.BaseCommitId)
+		require.Greater(t, conflict.HeadCommitId, conflict.BaseCommitId)
+		require.Equal(t, []pkg.FieldChange{{Field: "nominal_voltage", Old: 22.0, New: 132.0}}, conflict.Changes)
+	})
+
 	t.Run("unknown mrid", func(t *testing.T) {
 		patch := []pkg.JsonPatch{{
 			Op:    "replace",
@@ -873,8 +1502,111 @@ func TestApplyJsonPatchEndpoint(t *testing.T) {
 		req := httptest.NewRequest("PATCH", "/resource", &body)
 		rec := httptest.NewRecorder()
 		store.ApplyJsonPatch(rec, req)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+
+		var patchErr pkg.PatchError
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&patchErr))
+		require.Equal(t, "/0000-0000/nominval_voltage", patchErr.Path)
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		patch := []pkg.JsonPatch{
+			{Op: "replace", Path: fmt.Sprintf("/%s/name", bv.Mrid), Value: []byte(`"renamed"`)},
+			{Op: "replace", Path: fmt.Sprintf("/%s/nominal_voltage", bv.Mrid), Value: []byte(`"high"`)},
+		}
+
+		var body bytes.Buffer
+		require.NoError(t, json.NewEncoder(&body).Encode(patch))
+		rec := httptest.NewRecorder()
+		store.ApplyJsonPatch(rec, httptest.NewRequest("PATCH", "/resource", &body))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+
+		var patchErr pkg.PatchError
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&patchErr))
+		require.Equal(t, 1, patchErr.Index)
+		require.Equal(t, patch[1].Path, patchErr.Path)
+	})
+
+	t.Run("storage failure", func(t *testing.T) {
+		patch := []pkg.JsonPatch{{Op: "replace", Path: fmt.Sprintf("/%s/nominal_voltage", bv.Mrid), Value: []byte("400")}}
+
+		var body bytes.Buffer
+		require.NoError(t, json.NewEncoder(&body).Encode(patch))
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		rec := httptest.NewRecorder()
+		store.ApplyJsonPatch(rec, httptest.NewRequest("PATCH", "/resource", &body).WithContext(canceled))
 		require.Equal(t, http.StatusInternalServerError, rec.Code)
 	})
+
+	t.Run("failing test", func(t *testing.T) {
+		patch := []pkg.JsonPatch{{
+			Op:    "test",
+			Path:  fmt.Sprintf("/%s/nominal_voltage", bv.Mrid),
+			Value: []byte("1000"),
+		}}
+
+		var body bytes.Buffer
+		require.NoError(t, json.NewEncoder(&body).Encode(patch))
+		rec := httptest.NewRecorder()
+		store.ApplyJsonPatch(rec, httptest.NewRequest("PATCH", "/resource", &body))
+		require.Equal(t, http.StatusConflict, rec.Code)
+		require.Contains(t, rec.Body.String(), "Test of patch 0 failed")
+	})
+
+	t.Run("merge patch", func(t *testing.T) {
+		body := fmt.Sprintf(`{"%s": {"name": "merged", "nominal_voltage": 300}}`, bv.Mrid)
+		req := httptest.NewRequest("PATCH", "/resource", strings.NewReader(body))
+		req.Header.Set(pkg.ContentType, pkg.ContentTypeMergePatch)
+		rec := httptest.NewRecorder()
+		store.ApplyJsonPatch(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var head models.BaseVoltage
+		require.NoError(t, store.db.NewSelect().Model(&head).Where("mrid = ?", bv.Mrid).Order("commit_id DESC").Limit(1).Scan(ctx))
+		require.Equal(t, "merged", head.Name)
+		require.Equal(t, 300.0, head.NominalVoltage)
+
+		req = httptest.NewRequest("PATCH", "/resource", strings.NewReader(`{"mrid": 1}`))
+		req.Header.Set(pkg.ContentType, pkg.ContentTypeMergePatch)
+		rec = httptest.NewRecorder()
+		store.ApplyJsonPatch(rec, req)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		countVersions := func() int {
+			num, err := store.db.NewSelect().Model((*models.BaseVoltage)(nil)).Count(ctx)
+			require.NoError(t, err)
+			return num
+		}
+		before := countVersions()
+
+		for _, test := range []struct {
+			value string
+			code  int
+			valid bool
+		}{
+			{value: "400", code: http.StatusOK, valid: true},
+			{value: `"high"`, code: http.StatusBadRequest, valid: false},
+		} {
+			patch := []pkg.JsonPatch{{
+				Op:    "replace",
+				Path:  fmt.Sprintf("/%s/nominal_voltage", bv.Mrid),
+				Value: []byte(test.value),
+			}}
+			var body bytes.Buffer
+			require.NoError(t, json.NewEncoder(&body).Encode(patch))
+			rec := httptest.NewRecorder()
+			store.ApplyJsonPatch(rec, httptest.NewRequest("PATCH", "/resource?dry-run=true", &body))
+			require.Equal(t, test.code, rec.Code, rec.Body.String())
+
+			var preview pkg.PatchPreview
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&preview))
+			require.Equal(t, test.valid, preview.Valid)
+		}
+		require.Equal(t, before, countVersions())
+	})
 }
 
 func TestConnection(t *testing.T) {
@@ -951,3 +1683,222 @@ func TestIntOrDefault(t *testing.T) {
 	require.Equal(t, intOrDefault("", 1), 1)
 	require.Equal(t, intOrDefault("20", 1), 20)
 }
+
+func TestCommitDiff(t *testing.T) {
+	store := setupStore(t)
+	ctx := context.Background()
+
+	mrid := uuid.New()
+	for _, voltage := range []float64{132.0, 220.0} {
+		bv := models.BaseVoltage{NominalVoltage: voltage}
+		bv.Mrid = mrid
+		err := pkg.InsertAll(ctx, store.db, models.Commit{Message: "bv"}, slices.Values([]any{&bv}), pkg.NoOpOnInsert)
+		require.NoError(t, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /commits/{from}/diff/{to}", store.CommitDiff)
+
+	t.Run("json", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/commits/1/diff/2", nil)
+		req.Header.Set("Accept", pkg.ContentTypeJSON)
+		mux.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var diff pkg.CommitDiff
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&diff))
+		require.Equal(t, 1, len(diff.Objects))
+		require.Equal(t, pkg.ChangeModified, diff.Objects[0].Change)
+	})
+
+	t.Run("html", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/commits/0/diff/2", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), "added")
+		require.Contains(t, rec.Body.String(), mrid.String())
+	})
+
+	for _, url := range []string{"/commits/a/diff/2", "/commits/2/diff/1"} {
+		t.Run(url, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
+
+func TestRevertCommitEndpoint(t *testing.T) {
+	store := setupStore(t)
+	ctx := context.Background()
+
+	mrid := uuid.New()
+	for _, voltage := range []float64{132.0, 220.0} {
+		bv := models.BaseVoltage{NominalVoltage: voltage}
+		bv.Mrid = mrid
+		entity := models.Entity{Mrid: mrid, EntityType: "BaseVoltage"}
+		err := pkg.InsertAll(ctx, store.db, models.Commit{Message: "bv"}, slices.Values([]any{&entity, &bv}), pkg.NoOpOnInsert)
+		require.NoError(t, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /commits/{id}/revert", store.RevertCommit)
+
+	for _, test := range []struct {
+		url  string
+		code int
+	}{
+		{url: "/commits/abc/revert", code: http.StatusBadRequest},
+		{url: "/commits/10/revert", code: http.StatusNotFound},
+		{url: "/commits/1/revert", code: http.StatusConflict},
+		{url: "/commits/2/revert", code: http.StatusOK},
+	} {
+		t.Run(test.url, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest("POST", test.url, nil))
+			require.Equal(t, test.code, rec.Code, rec.Body.String())
+		})
+	}
+
+	resource, err := store.GetResource(ctx, mrid.String())
+	require.NoError(t, err)
+	require.Equal(t, 132.0, resource.(*models.BaseVoltage).NominalVoltage)
+}
+
+func TestHistory(t *testing.T) {
+	store := setupStore(t)
+	ctx := context.Background()
+
+	mrid := uuid.New()
+	for i, voltage := range []float64{132.0, 220.0} {
+		bv := models.BaseVoltage{NominalVoltage: voltage}
+		bv.Mrid = mrid
+		bv.Name = "bv"
+		entity := models.Entity{Mrid: mrid, EntityType: "BaseVoltage"}
+		commit := models.Commit{Message: fmt.Sprintf("commit %d", i), Author: fmt.Sprintf("author %d", i)}
+		err := pkg.InsertAll(ctx, store.db, commit, slices.Values([]any{&entity, &bv}), pkg.NoOpOnInsert)
+		require.NoError(t, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /resource/{mrid}/history", store.History)
+	mux.HandleFunc("GET /resource/{mrid}/blame", store.Blame)
+
+	t.Run("json", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/resource/%s/history", mrid), nil)
+		req.Header.Set("Accept", pkg.ContentTypeJSON)
+		mux.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var history pkg.ObjectHistory
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&history))
+		require.Equal(t, "BaseVoltage", history.Type)
+		require.Equal(t, 2, len(history.Versions))
+		require.Equal(t, "author 0", history.Versions[0].Author)
+		require.Equal(t, "commit 1", history.Versions[1].Message)
+	})
+
+	t.Run("html", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", fmt.Sprintf("/resource/%s/history", mrid), nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), "nominal_voltage")
+	})
+
+	t.Run("blame", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", fmt.Sprintf("/resource/%s/blame", mrid), nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var blame []pkg.FieldBlame
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&blame))
+		byField := make(map[string]pkg.FieldBlame)
+		for _, b := range blame {
+			byField[b.Field] = b
+		}
+		require.Equal(t, 2, byField["nominal_voltage"].CommitId)
+		require.Equal(t, "author 1", byField["nominal_voltage"].Author)
+		require.Equal(t, 1, byField["name"].CommitId)
+	})
+
+	t.Run("unknown resource", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", fmt.Sprintf("/resource/%s/history", uuid.New()), nil))
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestBulkPatchEndpoint(t *testing.T) {
+	store := setupStore(t)
+	ctx := context.Background()
+
+	var line models.ACLineSegment
+	line.Mrid = uuid.New()
+	line.Name = "T-1"
+	entity := pkg.MakeEntity(&line, 0)
+	require.NoError(t, pkg.InsertAll(ctx, store.db, models.Commit{}, slices.Values([]any{&entity, &line}), pkg.NoOpOnInsert))
+
+	countVersions := func() int {
+		num, err := store.db.NewSelect().Model((*models.ACLineSegment)(nil)).Count(ctx)
+		require.NoError(t, err)
+		return num
+	}
+
+	for _, test := range []struct {
+		url      string
+		body     string
+		code     int
+		versions int
+	}{
+		{url: "/bulk-patch", body: "not json", code: http.StatusBadRequest, versions: 1},
+		{url: "/bulk-patch", body: `{"selector": {"class": "Unknown"}, "set": {"r": 1}}`, code: http.StatusBadRequest, versions: 1},
+		{url: "/bulk-patch?dry-run=true", body: `{"selector": {"class": "ACLineSegment"}, "set": {"r": "high"}}`, code: http.StatusBadRequest, versions: 1},
+		{url: "/bulk-patch", body: `{"selector": {"class": "ACLineSegment"}, "set": {"r": "high"}}`, code: http.StatusBadRequest, versions: 1},
+		{url: "/bulk-patch?dry-run=true", body: `{"selector": {"class": "ACLineSegment", "name_regex": "^T-"}, "set": {"r": 1}}`, code: http.StatusOK, versions: 1},
+		{url: "/bulk-patch", body: `{"selector": {"class": "ACLineSegment", "name_regex": "^T-"}, "set": {"r": 1}}`, code: http.StatusOK, versions: 2},
+	} {
+		t.Run(test.url+test.body, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			store.BulkPatch(rec, httptest.NewRequest("POST", test.url, strings.NewReader(test.body)))
+			require.Equal(t, test.code, rec.Code, rec.Body.String())
+			require.Equal(t, test.versions, countVersions())
+
+			if test.code == http.StatusOK {
+				var result pkg.BulkPatchResult
+				require.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+				require.Equal(t, 1, result.Matched)
+			}
+		})
+	}
+
+	t.Run("failing lookup", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		rec := httptest.NewRecorder()
+		body := `{"selector": {"class": "ACLineSegment", "name_regex": "^T-"}, "set": {"r": 1}}`
+		store.BulkPatch(rec, httptest.NewRequest("POST", "/bulk-patch", strings.NewReader(body)).WithContext(cancelled))
+		require.Equal(t, http.StatusInternalServerError, rec.Code, rec.Body.String())
+	})
+}
+
+func TestWritePatchError(t *testing.T) {
+	for _, test := range []struct {
+		desc string
+		err  error
+		code int
+	}{
+		{desc: "invalid operation", err: &pkg.PatchError{Index: 0, Path: "/a/b", Message: "moved"}, code: http.StatusBadRequest},
+		{desc: "outdated version", err: fmt.Errorf("wrapped: %w", &pkg.ConflictError{}), code: http.StatusConflict},
+		{desc: "failing test", err: &pkg.PatchTestError{Index: 1, Path: "/a/b"}, code: http.StatusConflict},
+		{desc: "storage", err: &pkg.StorageError{Err: sql.ErrConnDone}, code: http.StatusInternalServerError},
+		{desc: "unknown", err: errors.New("unknown"), code: http.StatusInternalServerError},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writePatchError(context.Background(), rec, "Failed to apply patch", test.err)
+			require.Equal(t, test.code, rec.Code)
+		})
+	}
+}
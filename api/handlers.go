@@ -61,7 +61,7 @@ func Setup(mux *http.ServeMux, config *pkg.Config) func() error {
 		timeout:         timeout,
 	}
 
-	commit := CommitEndpoint{Db: &repository.BunInserter{Db: db}, timeout: timeout}
+	commit := CommitEndpoint{Db: &repository.BunInserter{Db: db}, Versions: &repository.BunVersionReader{Db: db}, timeout: timeout}
 	validate := NewBunValidationEndpoint(db, timeout)
 	xiidmEndpoint := XiidmExport{BusBreakerRepo: &repository.BunBusBreakerRepo{Db: db}, Timeout: timeout}
 	userIdentifier := NoopMiddleware
@@ -111,6 +111,25 @@ func Setup(mux *http.ServeMux, config *pkg.Config) func() error {
 
 	actionForm := ActionFormEndpoint{Timeout: timeout}
 
+	branchRepo := repository.BunBranchRepository{Db: db}
+	branches := BranchEndpoint{Repo: &branchRepo, Timeout: timeout}
+	branchSelector := BranchSelector{Repo: &branchRepo, Timeout: timeout}
+	branchMerge := BranchMergeEndpoint{Repo: &branchRepo, Db: db, Timeout: timeout}
+	scoped := func(h http.Handler) http.Handler { return branchSelector.Apply(ModelScope(h)) }
+	staging := StagingEndpoint{
+		Repo:     &repository.BunStagingRepository{Db: db},
+		Inserter: &repository.BunInserter{Db: db},
+		Versions: &repository.BunVersionReader{Db: db},
+		Timeout:  timeout,
+	}
+	asOfSelector := AsOfSelector{Db: db, Timeout: timeout}
+	asOf := asOfSelector.Apply
+	tagRepo := repository.BunTagRepository{Db: db}
+	tags := TagEndpoint{Repo: &tagRepo, Timeout: timeout}
+	tagSelector := TagSelector{Repo: &tagRepo, Timeout: timeout}
+	modelClone := ModelCloneEndpoint{Db: db, Timeout: timeout}
+	atTag := tagSelector.Apply
+
 	ptdfChan := make(chan []pkg.PtdfRecord)
 
 	ptdfRecalc := RecalcPtdf{
@@ -142,32 +161,56 @@ func Setup(mux *http.ServeMux, config *pkg.Config) func() error {
 	mux.Handle("/", userIdentifier(http.HandlerFunc(RootHandler)))
 	mux.HandleFunc("/cim-types", CimTypes)
 	mux.HandleFunc("/entity-form", EntityForm)
-	mux.HandleFunc("GET /entity-form/{mrid}", entityHandler.EditComponentForm)
-	mux.HandleFunc("GET /resource/{mrid}", entityHandler.Resource)
-	mux.Handle("GET /voltage-levels/{mrid}/items", &equipmentInVoltageLevel)
-	mux.HandleFunc("GET /substations/{mrid}/voltage-levels", inVoltageLevel.VoltageLevelsInSubstation)
-	mux.HandleFunc("/entities", entityHandler.GetEntityForKind)
-	mux.HandleFunc("/enum", entityHandler.GetEnumOptions)
-	mux.HandleFunc("/entity-list", entityHandler.EntityList)
-	mux.Handle("POST /commit", userIdentifier(&commit))
+	mux.Handle("GET /entity-form/{mrid}", scoped(atTag(http.HandlerFunc(entityHandler.EditComponentForm))))
+	mux.Handle("GET /resource/{mrid}", scoped(atTag(asOf(http.HandlerFunc(entityHandler.Resource)))))
+	mux.Handle("GET /resource/{mrid}/history", scoped(atTag(http.HandlerFunc(entityHandler.History))))
+	mux.Handle("GET /resource/{mrid}/blame", scoped(atTag(http.HandlerFunc(entityHandler.Blame))))
+	mux.Handle("GET /voltage-levels/{mrid}/items", scoped(atTag(&equipmentInVoltageLevel)))
+	mux.Handle("GET /substations/{mrid}/voltage-levels", scoped(atTag(http.HandlerFunc(inVoltageLevel.VoltageLevelsInSubstation))))
+	mux.Handle("/entities", scoped(atTag(http.HandlerFunc(entityHandler.GetEntityForKind))))
+	mux.Handle("/enum", scoped(atTag(http.HandlerFunc(entityHandler.GetEnumOptions))))
+	mux.Handle("/entity-list", scoped(atTag(http.HandlerFunc(entityHandler.EntityList))))
+	mux.Handle("POST /commit", scoped(userIdentifier(&commit)))
 	mux.HandleFunc("DELETE /commit/{id}", entityHandler.DeleteCommit)
+	mux.Handle("POST /staging", scoped(userIdentifier(http.HandlerFunc(staging.Stage))))
+	mux.Handle("GET /staging", scoped(userIdentifier(http.HandlerFunc(staging.List))))
+	mux.Handle("GET /staging/preview", scoped(userIdentifier(http.HandlerFunc(staging.Preview))))
+	mux.Handle("POST /staging/commit", scoped(userIdentifier(http.HandlerFunc(staging.Commit))))
+	mux.Handle("DELETE /staging", scoped(userIdentifier(http.HandlerFunc(staging.Discard))))
+	mux.Handle("DELETE /staging/{id}", scoped(userIdentifier(http.HandlerFunc(staging.Discard))))
 	mux.HandleFunc("POST /autofill", AutofillHandler)
-	mux.HandleFunc("GET /substations/{mrid}/diagram", entityHandler.SubstationDiagram)
-	mux.HandleFunc("/export", entityHandler.Export)
-	mux.Handle("/xiidm", &xiidmEndpoint)
-	mux.HandleFunc("/upload/{kind}", entityHandler.SimpleUpload)
+	mux.Handle("GET /substations/{mrid}/diagram", scoped(atTag(http.HandlerFunc(entityHandler.SubstationDiagram))))
+	mux.Handle("/export", scoped(atTag(asOf(http.HandlerFunc(entityHandler.Export)))))
+	mux.Handle("GET /export/geojson", scoped(atTag(asOf(http.HandlerFunc(entityHandler.GeoJsonExport)))))
+	mux.Handle("GET /export/cgmes", scoped(atTag(asOf(http.HandlerFunc(entityHandler.CgmesExport)))))
+	mux.Handle("/xiidm", scoped(atTag(asOf(&xiidmEndpoint))))
+	mux.Handle("/upload/{kind}", scoped(http.HandlerFunc(entityHandler.SimpleUpload)))
+	mux.Handle("POST /import/cgmes", scoped(userIdentifier(http.HandlerFunc(entityHandler.CgmesImport))))
+	mux.Handle("POST /import/ntriples", scoped(userIdentifier(http.HandlerFunc(entityHandler.NTriplesImport))))
+	mux.Handle("POST /import/geojson", scoped(userIdentifier(http.HandlerFunc(entityHandler.GeoJsonImport))))
 	mux.HandleFunc("GET /commits", entityHandler.Commits)
-	mux.HandleFunc("/map", entityHandler.Map)
-	mux.Handle("POST /connect-dangling", userIdentifier(http.HandlerFunc(entityHandler.ConnectDanglingLines)))
-	mux.Handle("PATCH /resource", userIdentifier(http.HandlerFunc(entityHandler.ApplyJsonPatch)))
-	mux.HandleFunc("/connection/{mrid}", entityHandler.Connection)
-	mux.Handle("PUT /validate", validate)
-	mux.Handle("/models", &modelsEndpoint)
+	mux.Handle("POST /commits/{id}/revert", userIdentifier(http.HandlerFunc(entityHandler.RevertCommit)))
+	mux.Handle("GET /commits/{from}/diff/{to}", scoped(http.HandlerFunc(entityHandler.CommitDiff)))
+	mux.Handle("/map", scoped(atTag(asOf(http.HandlerFunc(entityHandler.Map)))))
+	mux.Handle("POST /connect-dangling", scoped(userIdentifier(http.HandlerFunc(entityHandler.ConnectDanglingLines))))
+	mux.Handle("PATCH /resource", scoped(userIdentifier(http.HandlerFunc(entityHandler.ApplyJsonPatch))))
+	mux.Handle("POST /bulk-patch", scoped(userIdentifier(http.HandlerFunc(entityHandler.BulkPatch))))
+	mux.Handle("/connection/{mrid}", scoped(atTag(http.HandlerFunc(entityHandler.Connection))))
+	mux.Handle("PUT /validate", scoped(validate))
+	mux.Handle("/models", ModelScope(&modelsEndpoint))
+	mux.HandleFunc("PUT /models/selection", SelectModel)
+	mux.Handle("POST /models/{id}/clone", branchSelector.Apply(atTag(userIdentifier(&modelClone))))
+	mux.Handle("POST /branches", userIdentifier(http.HandlerFunc(branches.Create)))
+	mux.HandleFunc("GET /branches", branches.List)
+	mux.Handle("POST /branches/{name}/merge", userIdentifier(&branchMerge))
+	mux.Handle("POST /tags", scoped(userIdentifier(http.HandlerFunc(tags.Create))))
+	mux.HandleFunc("GET /tags", tags.List)
+	mux.Handle("DELETE /tags/{name}", userIdentifier(http.HandlerFunc(tags.Delete)))
 
 	// Substation connection workkbench
-	mux.Handle("POST /connect/{mrid}", &substationConnector)
-	mux.Handle("GET /substation-connector/{mrid}", &substationWorkbench)
-	mux.Handle("/substation-list", &querySub)
+	mux.Handle("POST /connect/{mrid}", scoped(&substationConnector))
+	mux.Handle("GET /substation-connector/{mrid}", scoped(atTag(&substationWorkbench)))
+	mux.Handle("/substation-list", scoped(atTag(&querySub)))
 	mux.HandleFunc("/substation-selection", SetSelectedSubstation)
 	mux.Handle("POST /ptdf/recalculate", &ptdfRecalc)
 	mux.Handle("POST /production", &actionForm)
@@ -2,13 +2,21 @@ package api
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 
 	"com.github/davidkleiven/tripleworks/components"
 	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/pkg"
 	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/uptrace/bun"
 )
 
 type ModelsEndpoint struct {
@@ -27,6 +35,114 @@ func (m *ModelsEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	modelSelector := components.ModelSelector(models)
+	selected, hasSelection := repository.ModelFromCtx(r.Context())
+	modelSelector := components.ModelSelector(models, selected, hasSelection)
 	modelSelector.Render(ctx, w)
 }
+
+const modelCookie = "model-id"
+
+// ModelScope makes all reads of the wrapped handler only see the objects of one model. The model
+// is taken from the model-id query parameter, or else from the model selected in the session.
+// Without a selected model, or with an empty model-id query parameter, objects of all models are visible.
+func ModelScope(h http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			value := query.Get("model-id")
+			if cookie, err := r.Cookie(modelCookie); !query.Has("model-id") && err == nil {
+				value = cookie.Value
+			}
+			if value == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			modelId, err := parseModelId(value)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Invalid model id", "modelId", value, "error", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(repository.WithModel(r.Context(), modelId)))
+		})
+}
+
+func parseModelId(value string) (int, error) {
+	modelId, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Model id must be an integer: %w", err)
+	}
+	if modelId < 0 {
+		return 0, fmt.Errorf("Model id must not be negative, got %d", modelId)
+	}
+	return modelId, nil
+}
+
+// SelectModel stores the selected model in the session. An empty model id clears the selection.
+func SelectModel(w http.ResponseWriter, r *http.Request) {
+	value := r.FormValue("modelId")
+	cookie := http.Cookie{
+		Name:     modelCookie,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if value == "" {
+		cookie.MaxAge = -1
+	} else if _, err := parseModelId(value); err != nil {
+		slog.ErrorContext(r.Context(), "Invalid model id", "modelId", value, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &cookie)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type ModelCloneEndpoint struct {
+	Db      *bun.DB
+	Timeout time.Duration
+}
+
+// ServeHTTP copies a model, or the regions of it listed in the body, into a new model.
+// The body is optional and holds the name of the new model and the regions to copy.
+func (m *ModelCloneEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	modelId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Model id must be an integer", "modelId", r.PathValue("id"))
+		http.Error(w, "Model id must be an integer: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var options pkg.CloneOptions
+	if err := json.NewDecoder(r.Body).Decode(&options); err != nil && !errors.Is(err, io.EOF) {
+		slog.ErrorContext(r.Context(), "Could not decode clone options", "error", err)
+		http.Error(w, "Could not decode clone options: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), m.Timeout)
+	defer cancel()
+
+	inserter := repository.BunInserter{Db: m.Db}
+	result, err := pkg.CloneModel(ctx, m.Db, &inserter, modelId, options, UserFromCtx(r.Context()))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Model %d does not exist", modelId), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to clone model", "modelId", modelId, "error", err)
+		http.Error(w, "Failed to clone model: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result.Objects == 0 {
+		http.Error(w, fmt.Sprintf("No objects in model %d match the selection", modelId), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set(pkg.ContentType, pkg.ContentTypeJSON)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
@@ -1,14 +1,21 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/pkg"
 	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 )
 
@@ -25,9 +32,149 @@ func TestModelRepo(t *testing.T) {
 
 	body := rec.Body.String()
 	require.Contains(t, body, "<option value=\"1\">")
+	require.Contains(t, body, "<option value=\"\" selected>All models</option>")
+
+	t.Run("selected model is marked", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/models", nil)
+		req.AddCookie(&http.Cookie{Name: modelCookie, Value: "2"})
+		ModelScope(&endpoint).ServeHTTP(rec, req)
+		require.Contains(t, rec.Body.String(), "<option value=\"2\" selected>")
+		require.Contains(t, rec.Body.String(), "<option value=\"\">All models</option>")
+	})
 
 	repo.Err = errors.New("Something went wrong")
 	rec2 := httptest.NewRecorder()
 	endpoint.ServeHTTP(rec2, req)
 	require.Equal(t, http.StatusInternalServerError, rec2.Code)
 }
+
+func TestModelScope(t *testing.T) {
+	var (
+		modelId int
+		scoped  bool
+	)
+	handler := ModelScope(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		modelId, scoped = repository.ModelFromCtx(r.Context())
+	}))
+
+	for _, test := range []struct {
+		desc    string
+		query   string
+		cookie  string
+		code    int
+		scoped  bool
+		modelId int
+	}{
+		{desc: "no selection", code: http.StatusOK},
+		{desc: "query parameter", query: "?model-id=2", code: http.StatusOK, scoped: true, modelId: 2},
+		{desc: "session", cookie: "3", code: http.StatusOK, scoped: true, modelId: 3},
+		{desc: "query parameter wins", query: "?model-id=2", cookie: "3", code: http.StatusOK, scoped: true, modelId: 2},
+		{desc: "model zero", query: "?model-id=0", cookie: "3", code: http.StatusOK, scoped: true, modelId: 0},
+		{desc: "model zero in session", cookie: "0", code: http.StatusOK, scoped: true, modelId: 0},
+		{desc: "all models", query: "?model-id=", cookie: "3", code: http.StatusOK},
+		{desc: "not an integer", query: "?model-id=national", code: http.StatusBadRequest},
+		{desc: "negative", query: "?model-id=-1", code: http.StatusBadRequest},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			modelId, scoped = 0, false
+			req := httptest.NewRequest("GET", "/entity-list"+test.query, nil)
+			if test.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: modelCookie, Value: test.cookie})
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, test.code, rec.Code)
+			require.Equal(t, test.scoped, scoped)
+			if test.scoped {
+				require.Equal(t, test.modelId, modelId)
+			}
+		})
+	}
+}
+
+func TestSelectModel(t *testing.T) {
+	t.Run("select", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", "/models/selection", strings.NewReader("modelId=2"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		SelectModel(rec, req)
+		require.Equal(t, http.StatusNoContent, rec.Code)
+
+		cookies := rec.Result().Cookies()
+		require.Equal(t, 1, len(cookies))
+		require.Equal(t, modelCookie, cookies[0].Name)
+		require.Equal(t, "2", cookies[0].Value)
+	})
+
+	t.Run("select model zero", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", "/models/selection", strings.NewReader("modelId=0"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		SelectModel(rec, req)
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Equal(t, "0", rec.Result().Cookies()[0].Value)
+		require.Zero(t, rec.Result().Cookies()[0].MaxAge)
+	})
+
+	t.Run("clear", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", "/models/selection", strings.NewReader("modelId="))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		SelectModel(rec, req)
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Less(t, rec.Result().Cookies()[0].MaxAge, 0)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", "/models/selection", strings.NewReader("modelId=abc"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		SelectModel(rec, req)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestModelCloneEndpoint(t *testing.T) {
+	store := setupStore(t)
+	ctx := context.Background()
+
+	source := models.Model{Name: "base case"}
+	_, err := store.db.NewInsert().Model(&source).Exec(ctx)
+	require.NoError(t, err)
+
+	var bv models.BaseVoltage
+	bv.Mrid = uuid.New()
+	entity := pkg.MakeEntity(&bv, source.Id)
+	require.NoError(t, pkg.InsertAll(ctx, store.db, models.Commit{}, slices.Values([]any{&entity, &bv}), pkg.NoOpOnInsert))
+
+	endpoint := ModelCloneEndpoint{Db: store.db, Timeout: time.Second}
+	mux := http.NewServeMux()
+	mux.Handle("POST /models/{id}/clone", &endpoint)
+
+	for _, test := range []struct {
+		url  string
+		body string
+		code int
+	}{
+		{url: "/models/abc/clone", code: http.StatusBadRequest},
+		{url: "/models/100/clone", code: http.StatusNotFound},
+		{url: fmt.Sprintf("/models/%d/clone", source.Id), body: "{", code: http.StatusBadRequest},
+		{url: fmt.Sprintf("/models/%d/clone", source.Id), body: `{"bidzones": ["NO1"]}`, code: http.StatusBadRequest},
+		{url: fmt.Sprintf("/models/%d/clone", source.Id), body: `{"name": "study"}`, code: http.StatusCreated},
+		{url: fmt.Sprintf("/models/%d/clone", source.Id), code: http.StatusCreated},
+	} {
+		t.Run(test.url+test.body, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest("POST", test.url, strings.NewReader(test.body)))
+			require.Equal(t, test.code, rec.Code, rec.Body.String())
+
+			if test.code == http.StatusCreated {
+				var result pkg.CloneResult
+				require.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+				require.Equal(t, 1, result.Objects)
+				require.NotEqual(t, source.Id, result.ModelId)
+			}
+		})
+	}
+}
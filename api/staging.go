@@ -0,0 +1,213 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/pkg"
+	"com.github/davidkleiven/tripleworks/repository"
+)
+
+type StagedCommitRequest struct {
+	Message string `json:"message"`
+}
+
+type StagedCommitResult struct {
+	CommitId int64 `json:"commit_id"`
+	Num      int   `json:"num"`
+}
+
+// StagingEndpoint collects edits of many objects per user and branch, such that they can be
+// committed together with a single commit message
+type StagingEndpoint struct {
+	Repo     repository.StagingRepository
+	Inserter repository.Inserter
+	Versions repository.VersionReader
+	Timeout  time.Duration
+}
+
+// Stage accepts the same payload as POST /commit, but keeps the change aside instead of committing it
+func (s *StagingEndpoint) Stage(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+	ctx := r.Context()
+
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to read body", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	modelMetaData, model, err := parseObjectPayload(ctx, content)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	change := models.StagedChange{
+		Author:       UserFromCtx(ctx),
+		Branch:       repository.BranchFromCtx(ctx),
+		Mrid:         modelMetaData.Mrid,
+		CimType:      pkg.StructName(model),
+		ModelId:      modelMetaData.ModelId,
+		ModelName:    modelMetaData.ModelName,
+		BaseCommitId: modelMetaData.BaseCommitId,
+		Payload:      string(pkg.Must(json.Marshal(model))),
+		CreatedAt:    time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	if err := s.Repo.Stage(ctx, &change); err != nil {
+		slog.ErrorContext(ctx, "Failed to stage change", "error", err)
+		http.Error(w, "Failed to stage change: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "Staged changes to object %s", modelMetaData.Mrid)
+}
+
+func (s *StagingEndpoint) List(w http.ResponseWriter, r *http.Request) {
+	changes, ok := s.listOrError(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
+
+// Preview writes the staged objects as N-Triples
+func (s *StagingEndpoint) Preview(w http.ResponseWriter, r *http.Request) {
+	changes, ok := s.listOrError(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/n-triples")
+	if err := pkg.PreviewStaged(w, changes); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to preview staged changes", "error", err)
+		http.Error(w, "Failed to preview staged changes: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Commit inserts all staged changes of the user on the branch in one commit and clears the staging area
+func (s *StagingEndpoint) Commit(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+	var request StagedCommitRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to decode commit request", "error", err)
+		http.Error(w, "Failed to decode commit request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Message == "" {
+		http.Error(w, "A commit message is required", http.StatusBadRequest)
+		return
+	}
+
+	changes, ok := s.listOrError(w, r)
+	if !ok {
+		return
+	}
+	if len(changes) == 0 {
+		http.Error(w, "No staged changes to commit", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+
+	author := UserFromCtx(ctx)
+	branch := repository.BranchFromCtx(ctx)
+	commit := models.Commit{
+		Branch:    branch,
+		Message:   request.Message,
+		Author:    author,
+		CreatedAt: time.Now(),
+	}
+
+	// The committed changes are cleared in the transaction of the commit, such that they can not be committed
+	// twice. Changes staged after they were listed are kept.
+	ids := make([]int64, len(changes))
+	for i, change := range changes {
+		ids[i] = change.Id
+	}
+	clear := func(ctx context.Context, inserter repository.Inserter) error {
+		repo := s.Repo
+		if txRepo, ok := repo.(repository.TxStagingRepository); ok {
+			if inTx, ok := txRepo.InTxOf(inserter); ok {
+				repo = inTx
+			}
+		}
+		if err := repo.Discard(ctx, author, branch, ids...); err != nil {
+			return fmt.Errorf("Failed to clear staged changes: %w", err)
+		}
+		return nil
+	}
+	commitId, err := pkg.CommitStaged(ctx, s.Inserter, s.Versions, changes, commit, clear)
+
+	var conflict *pkg.ConflictError
+	if errors.As(err, &conflict) {
+		slog.InfoContext(ctx, "Rejected staged changes based on an outdated version", "error", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(conflict)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to commit staged changes", "error", err)
+		http.Error(w, "Failed to commit staged changes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(ctx, "Committed staged changes", "commitId", commitId, "num", len(changes))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(StagedCommitResult{CommitId: commitId, Num: len(changes)})
+}
+
+// Discard removes a single staged change if an id is given in the path, otherwise all staged changes
+func (s *StagingEndpoint) Discard(w http.ResponseWriter, r *http.Request) {
+	var ids []int64
+	if rawId := r.PathValue("id"); rawId != "" {
+		id, err := strconv.ParseInt(rawId, 10, 64)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Staged change ids must be integers", "error", err)
+			http.Error(w, "Staged change ids must be integers: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+
+	if err := s.Repo.Discard(ctx, UserFromCtx(ctx), repository.BranchFromCtx(ctx), ids...); err != nil {
+		slog.ErrorContext(ctx, "Failed to discard staged changes", "error", err)
+		http.Error(w, "Failed to discard staged changes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *StagingEndpoint) listOrError(w http.ResponseWriter, r *http.Request) ([]models.StagedChange, bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+
+	changes, err := s.Repo.List(ctx, UserFromCtx(ctx), repository.BranchFromCtx(ctx))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list staged changes", "error", err)
+		http.Error(w, "Failed to list staged changes: "+err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if changes == nil {
+		changes = []models.StagedChange{}
+	}
+	return changes, true
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/pkg"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func stagingPayload(mrid uuid.UUID, voltage float64, baseCommitId int) string {
+	return fmt.Sprintf(`{"mrid": "%s", "name": "Base voltage", "cim_type": "BaseVoltage", "checksum": "00",
+	"modelId": 0, "modelName": "national", "energy_ident_code_eic": "EIC", "description": "Desc", "short_name": "name",
+	"baseCommitId": %d, "nominal_voltage": %f, "deleted": false}`, mrid, baseCommitId, voltage)
+}
+
+func TestStagingEndpoint(t *testing.T) {
+	existing := models.BaseVoltage{NominalVoltage: 132.0}
+	existing.Mrid = uuid.New()
+	existing.CommitId = 1
+
+	var (
+		repo     repository.InMemStagingRepository
+		inserter repository.InMemInserter
+	)
+	endpoint := StagingEndpoint{
+		Repo:     &repo,
+		Inserter: &inserter,
+		Versions: &repository.InMemVersionReader{Items: []any{&existing}},
+		Timeout:  time.Second,
+	}
+
+	stage := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		endpoint.Stage(rec, httptest.NewRequest("POST", "/staging", bytes.NewBufferString(body)))
+		return rec
+	}
+
+	created := uuid.New()
+	require.Equal(t, http.StatusOK, stage(stagingPayload(created, 400.0, 0)).Code)
+	require.Equal(t, http.StatusOK, stage(stagingPayload(existing.Mrid, 220.0, 1)).Code)
+	require.Equal(t, http.StatusOK, stage(stagingPayload(existing.Mrid, 300.0, 0)).Code)
+	require.Equal(t, http.StatusBadRequest, stage("not json").Code)
+	require.Empty(t, inserter.Items)
+
+	t.Run("list", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		endpoint.List(rec, httptest.NewRequest("GET", "/staging", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var changes []models.StagedChange
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &changes))
+		require.Equal(t, 2, len(changes))
+		require.Equal(t, int64(1), changes[1].BaseCommitId)
+	})
+
+	t.Run("preview", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		endpoint.Preview(rec, httptest.NewRequest("GET", "/staging/preview", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "application/n-triples", rec.Header().Get("Content-Type"))
+		require.Contains(t, rec.Body.String(), created.String())
+	})
+
+	commit := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		endpoint.Commit(rec, httptest.NewRequest("POST", "/staging/commit", bytes.NewBufferString(body)))
+		return rec
+	}
+
+	t.Run("message is required", func(t *testing.T) {
+		require.Equal(t, http.StatusBadRequest, commit(`{"message": ""}`).Code)
+	})
+
+	t.Run("commit all staged changes", func(t *testing.T) {
+		rec := commit(`{"message": "new bay"}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var result StagedCommitResult
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+		require.Equal(t, 2, result.Num)
+
+		numCommits := 0
+		for _, item := range inserter.Items {
+			if c, ok := item.(*models.Commit); ok {
+				numCommits++
+				require.Equal(t, "new bay", c.Message)
+			}
+		}
+		require.Equal(t, 1, numCommits)
+		require.Empty(t, repo.Items)
+	})
+
+	t.Run("nothing to commit", func(t *testing.T) {
+		require.Equal(t, http.StatusBadRequest, commit(`{"message": "again"}`).Code)
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, stage(stagingPayload(existing.Mrid, 500.0, 1)).Code)
+		require.Equal(t, http.StatusOK, stage(stagingPayload(uuid.New(), 500.0, 0)).Code)
+
+		changedByOther := existing
+		changedByOther.CommitId = 2
+		endpoint.Versions = &repository.InMemVersionReader{Items: []any{&existing, &changedByOther}}
+
+		rec := commit(`{"message": "stale"}`)
+		require.Equal(t, http.StatusConflict, rec.Code)
+		require.Equal(t, 2, len(repo.Items))
+	})
+
+	t.Run("discard", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("DELETE", "/staging/1", nil)
+		req.SetPathValue("id", fmt.Sprintf("%d", repo.Items[0].Id))
+		endpoint.Discard(rec, req)
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Equal(t, 1, len(repo.Items))
+
+		rec = httptest.NewRecorder()
+		endpoint.Discard(rec, httptest.NewRequest("DELETE", "/staging", nil))
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Empty(t, repo.Items)
+
+		rec = httptest.NewRecorder()
+		req = httptest.NewRequest("DELETE", "/staging/a", nil)
+		req.SetPathValue("id", "a")
+		endpoint.Discard(rec, req)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("repo error", func(t *testing.T) {
+		repo.Err = errors.New("something went wrong")
+		rec := httptest.NewRecorder()
+		endpoint.List(rec, httptest.NewRequest("GET", "/staging", nil))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+}
+
+func TestStagedCommitUsesBranch(t *testing.T) {
+	var repo repository.InMemStagingRepository
+	endpoint := StagingEndpoint{Repo: &repo, Inserter: &repository.InMemInserter{}, Timeout: time.Second}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/staging", bytes.NewBufferString(stagingPayload(uuid.New(), 132.0, 0)))
+	req = req.WithContext(repository.WithBranch(req.Context(), "project"))
+	endpoint.Stage(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "project", repo.Items[0].Branch)
+	require.Equal(t, pkg.StructName(models.BaseVoltage{}), repo.Items[0].CimType)
+}
+
+func TestStagedCommitClearsStagingInTransaction(t *testing.T) {
+	db := setupStore(t).db
+	repo := repository.BunStagingRepository{Db: db}
+	endpoint := StagingEndpoint{
+		Repo:     &repo,
+		Inserter: &repository.BunInserter{Db: db},
+		Versions: &repository.BunVersionReader{Db: db},
+		Timeout:  time.Second,
+	}
+
+	rec := httptest.NewRecorder()
+	endpoint.Stage(rec, httptest.NewRequest("POST", "/staging", bytes.NewBufferString(stagingPayload(uuid.New(), 132.0, 0))))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	commit := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		endpoint.Commit(rec, httptest.NewRequest("POST", "/staging/commit", bytes.NewBufferString(`{"message": "new bay"}`)))
+		return rec
+	}
+	rec = commit()
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	staged, err := repo.List(context.Background(), defaultUser, models.MainBranch)
+	require.NoError(t, err)
+	require.Empty(t, staged)
+
+	// A retry finds nothing to commit instead of committing the same changes twice
+	require.Equal(t, http.StatusBadRequest, commit().Code)
+	numCommits, err := db.NewSelect().Model((*models.Commit)(nil)).Where("message = ?", "new bay").Count(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, numCommits)
+}
+
+// stagingAfterList stages another change right after the staged changes are listed
+type stagingAfterList struct {
+	*repository.BunStagingRepository
+	change models.StagedChange
+}
+
+func (s *stagingAfterList) List(ctx context.Context, author, branch string) ([]models.StagedChange, error) {
+	changes, err := s.BunStagingRepository.List(ctx, author, branch)
+	if err != nil {
+		return changes, err
+	}
+	return changes, s.Stage(ctx, &s.change)
+}
+
+func TestStagedCommitKeepsChangesStagedAfterList(t *testing.T) {
+	db := setupStore(t).db
+	late := models.StagedChange{Author: defaultUser, Branch: models.MainBranch, Mrid: uuid.New(), CimType: "BaseVoltage", Payload: "{}"}
+	repo := stagingAfterList{BunStagingRepository: &repository.BunStagingRepository{Db: db}, change: late}
+	endpoint := StagingEndpoint{
+		Repo:     repo.BunStagingRepository,
+		Inserter: &repository.BunInserter{Db: db},
+		Versions: &repository.BunVersionReader{Db: db},
+		Timeout:  time.Second,
+	}
+
+	rec := httptest.NewRecorder()
+	endpoint.Stage(rec, httptest.NewRequest("POST", "/staging", bytes.NewBufferString(stagingPayload(uuid.New(), 132.0, 0))))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	endpoint.Repo = &repo
+	rec = httptest.NewRecorder()
+	endpoint.Commit(rec, httptest.NewRequest("POST", "/staging/commit", bytes.NewBufferString(`{"message": "new bay"}`)))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var result StagedCommitResult
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	require.Equal(t, 1, result.Num)
+
+	staged, err := repo.BunStagingRepository.List(context.Background(), defaultUser, models.MainBranch)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(staged))
+	require.Equal(t, late.Mrid, staged[0].Mrid)
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
+)
+
+type TagRequest struct {
+	Name     string `json:"name"`
+	CommitId int64  `json:"commit_id"`
+	Message  string `json:"message"`
+}
+
+type TagEndpoint struct {
+	Repo    repository.TagRepository
+	Timeout time.Duration
+}
+
+// Create tags a commit. Without a commit id, the head of the branch in the request is tagged.
+func (t *TagEndpoint) Create(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+	var request TagRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to decode tag request", "error", err)
+		http.Error(w, "Failed to decode tag request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !refNameExpr.MatchString(request.Name) {
+		http.Error(w, fmt.Sprintf("Tag name '%s' must match %s", request.Name, refNameExpr.String()), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), t.Timeout)
+	defer cancel()
+
+	if _, err := t.Repo.Get(ctx, request.Name); err == nil {
+		http.Error(w, fmt.Sprintf("Tag %s already exists", request.Name), http.StatusConflict)
+		return
+	}
+
+	tag := models.Tag{
+		Name:      request.Name,
+		CommitId:  request.CommitId,
+		Branch:    repository.BranchFromCtx(ctx),
+		Message:   request.Message,
+		Author:    UserFromCtx(r.Context()),
+		CreatedAt: time.Now(),
+	}
+	err := t.Repo.Create(ctx, &tag)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Commit %d does not exist", request.CommitId), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create tag", "error", err)
+		http.Error(w, "Failed to create tag: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "Created tag", "tag", tag.Name, "commitId", tag.CommitId, "author", tag.Author)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(tag)
+}
+
+func (t *TagEndpoint) List(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), t.Timeout)
+	defer cancel()
+
+	tags, err := t.Repo.List(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list tags", "error", err)
+		http.Error(w, "Failed to list tags: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tags == nil {
+		tags = []models.Tag{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+func (t *TagEndpoint) Delete(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	ctx, cancel := context.WithTimeout(r.Context(), t.Timeout)
+	defer cancel()
+
+	if _, err := t.Repo.Get(ctx, name); err != nil {
+		http.Error(w, fmt.Sprintf("Could not find tag %s", name), http.StatusNotFound)
+		return
+	}
+
+	if err := t.Repo.Delete(ctx, name); err != nil {
+		slog.ErrorContext(ctx, "Failed to delete tag", "tag", name, "error", err)
+		http.Error(w, "Failed to delete tag: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "Deleted tag", "tag", name, "user", UserFromCtx(r.Context()))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TagSelector resolves the optional tag query parameter such that all reads in the wrapped
+// handler see the model as it was at the tagged commit on the branch of the tag
+type TagSelector struct {
+	Repo    repository.TagRepository
+	Timeout time.Duration
+}
+
+func (t *TagSelector) Apply(h http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			name := query.Get("tag")
+			if name == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			if query.Get("asOf") != "" {
+				http.Error(w, "tag and asOf can not be combined", http.StatusBadRequest)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), t.Timeout)
+			tag, err := t.Repo.Get(ctx, name)
+			cancel()
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Could not find tag", "tag", name, "error", err)
+				http.Error(w, fmt.Sprintf("Could not find tag %s", name), http.StatusNotFound)
+				return
+			}
+
+			if branch := query.Get("branch"); branch != "" && branch != tag.Branch {
+				http.Error(w, fmt.Sprintf("Tag %s is on branch %s, not %s", name, tag.Branch, branch), http.StatusBadRequest)
+				return
+			}
+
+			ctx = repository.WithAsOf(repository.WithBranch(r.Context(), tag.Branch), tag.CommitId)
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/pkg"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagEndpointCreate(t *testing.T) {
+	repo := repository.InMemTagRepository{}
+	endpoint := TagEndpoint{Repo: &repo, Timeout: time.Second}
+
+	for _, test := range []struct {
+		desc string
+		body string
+		code int
+	}{
+		{desc: "created", body: `{"name": "2026-Q3-release", "commit_id": 4}`, code: http.StatusCreated},
+		{desc: "tags are immutable", body: `{"name": "2026-Q3-release", "commit_id": 5}`, code: http.StatusConflict},
+		{desc: "invalid name", body: `{"name": "Q3 release"}`, code: http.StatusBadRequest},
+		{desc: "invalid json", body: `not json`, code: http.StatusBadRequest},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/tags", bytes.NewBufferString(test.body))
+			endpoint.Create(rec, req)
+			require.Equal(t, test.code, rec.Code, rec.Body.String())
+		})
+	}
+
+	require.Equal(t, 1, len(repo.Items))
+	require.Equal(t, int64(4), repo.Items[0].CommitId)
+	require.Equal(t, defaultUser, repo.Items[0].Author)
+
+	t.Run("repo error", func(t *testing.T) {
+		repo.Err = errors.New("something went wrong")
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/tags", bytes.NewBufferString(`{"name": "2026-Q4-release"}`))
+		endpoint.Create(rec, req)
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+}
+
+func TestTagEndpointListAndDelete(t *testing.T) {
+	repo := repository.InMemTagRepository{Items: []models.Tag{{Name: "a"}, {Name: "b"}}}
+	endpoint := TagEndpoint{Repo: &repo, Timeout: time.Second}
+
+	rec := httptest.NewRecorder()
+	endpoint.List(rec, httptest.NewRequest("GET", "/tags", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var tags []models.Tag
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&tags))
+	require.Equal(t, 2, len(tags))
+
+	for _, test := range []struct {
+		name string
+		code int
+	}{
+		{name: "a", code: http.StatusNoContent},
+		{name: "a", code: http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("DELETE", "/tags/"+test.name, nil)
+		req.SetPathValue("name", test.name)
+		endpoint.Delete(rec, req)
+		require.Equal(t, test.code, rec.Code)
+	}
+	require.Equal(t, []models.Tag{{Name: "b"}}, repo.Items)
+}
+
+func TestResourceAtTag(t *testing.T) {
+	store := setupStore(t)
+	ctx := context.Background()
+
+	mrid := uuid.New()
+	for _, voltage := range []float64{132.0, 220.0} {
+		bv := models.BaseVoltage{NominalVoltage: voltage}
+		bv.Mrid = mrid
+		entity := models.Entity{Mrid: mrid, EntityType: "BaseVoltage"}
+		err := pkg.InsertAll(ctx, store.db, models.Commit{Message: "bv"}, slices.Values([]any{&entity, &bv}), pkg.NoOpOnInsert)
+		require.NoError(t, err)
+	}
+
+	tagRepo := repository.BunTagRepository{Db: store.db}
+	require.NoError(t, tagRepo.Create(ctx, &models.Tag{Name: "release-1", CommitId: 1}))
+	require.NoError(t, tagRepo.Create(ctx, &models.Tag{Name: "head", Branch: models.MainBranch}))
+
+	selector := TagSelector{Repo: &tagRepo, Timeout: time.Second}
+	mux := http.NewServeMux()
+	mux.Handle("GET /resource/{mrid}", selector.Apply(http.HandlerFunc(store.Resource)))
+
+	for _, test := range []struct {
+		query   string
+		code    int
+		voltage float64
+	}{
+		{query: "", code: http.StatusOK, voltage: 220.0},
+		{query: "?tag=release-1", code: http.StatusOK, voltage: 132.0},
+		{query: "?tag=head", code: http.StatusOK, voltage: 220.0},
+		{query: "?tag=release-1&branch=main", code: http.StatusOK, voltage: 132.0},
+		{query: "?tag=release-1&branch=project", code: http.StatusBadRequest},
+		{query: "?tag=release-1&asOf=2", code: http.StatusBadRequest},
+		{query: "?tag=unknown", code: http.StatusNotFound},
+	} {
+		t.Run(test.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest("GET", "/resource/"+mrid.String()+test.query, nil))
+			require.Equal(t, test.code, rec.Code, rec.Body.String())
+			if test.code != http.StatusOK {
+				return
+			}
+
+			var item struct {
+				Data models.BaseVoltage `json:"data"`
+			}
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&item))
+			require.Equal(t, test.voltage, item.Data.NominalVoltage)
+		})
+	}
+
+	t.Run("unknown commit", func(t *testing.T) {
+		endpoint := TagEndpoint{Repo: &tagRepo, Timeout: time.Second}
+		rec := httptest.NewRecorder()
+		endpoint.Create(rec, httptest.NewRequest("POST", "/tags", bytes.NewBufferString(`{"name": "future", "commit_id": 100}`)))
+		require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+	})
+}
@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+)
+
+func init() {
+	migrations.MustRegister(addBranches, revertAddBranches)
+}
+
+func addBranches(ctx context.Context, db *bun.DB) error {
+	var branch models.Branch
+	_, err := db.NewCreateTable().
+		Model(&branch).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("Could not create branches table: %w", err)
+	}
+
+	// Re-create all latest views such that they only see commits on main
+	for i, item := range tablesWithLatestView2 {
+		name := db.Table(reflect.TypeOf(item).Elem()).Name
+		sql := MustGetReplaceViewSql(name)
+		if db.Dialect().Name() != dialect.PG {
+			// Dependent views are resolved lazily in sqlite, so the view can be dropped and re-created
+			sql = MustGetViewSql(name)
+			_, err := db.ExecContext(ctx, fmt.Sprintf("DROP VIEW IF EXISTS v_%s_latest", name))
+			if err != nil {
+				return fmt.Errorf("Failed to drop view for %d (%s): %w", i, name, err)
+			}
+		}
+
+		_, err := db.ExecContext(ctx, sql)
+		if err != nil {
+			return fmt.Errorf("Failed for %d (%s): %w", i, name, err)
+		}
+	}
+	return nil
+}
+
+func revertAddBranches(ctx context.Context, db *bun.DB) error {
+	var branch models.Branch
+	_, err := db.NewDropTable().Model(&branch).IfExists().Exec(ctx)
+	return err
+}
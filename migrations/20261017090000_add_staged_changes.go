@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/uptrace/bun"
+)
+
+func init() {
+	migrations.MustRegister(addStagedChanges, revertAddStagedChanges)
+}
+
+func addStagedChanges(ctx context.Context, db *bun.DB) error {
+	var change models.StagedChange
+	_, err := db.NewCreateTable().
+		Model(&change).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("Could not create staged changes table: %w", err)
+	}
+
+	_, err = db.NewCreateIndex().
+		Model(&change).
+		Index("idx_staged_changes_author_branch").
+		Column("author", "branch").
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("Could not create index on staged changes: %w", err)
+	}
+	return nil
+}
+
+func revertAddStagedChanges(ctx context.Context, db *bun.DB) error {
+	var change models.StagedChange
+	_, err := db.NewDropTable().Model(&change).IfExists().Exec(ctx)
+	return err
+}
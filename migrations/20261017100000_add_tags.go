@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/uptrace/bun"
+)
+
+func init() {
+	migrations.MustRegister(addTags, revertAddTags)
+}
+
+func addTags(ctx context.Context, db *bun.DB) error {
+	var tag models.Tag
+	_, err := db.NewCreateTable().
+		Model(&tag).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("Could not create tags table: %w", err)
+	}
+	return nil
+}
+
+func revertAddTags(ctx context.Context, db *bun.DB) error {
+	var tag models.Tag
+	_, err := db.NewDropTable().Model(&tag).IfExists().Exec(ctx)
+	return err
+}
@@ -0,0 +1,76 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+)
+
+func init() {
+	migrations.MustRegister(addLocationToAcLineSegments, revertAddLocationToAcLineSegments)
+}
+
+// Views selecting from v_ac_line_segments_latest, by the query re-creating them
+var acLineSegmentDependentViews = map[string]string{
+	"v_invalid_lines":             "invalid_lines.sql",
+	"v_cross_region_lines_latest": "cross_region_lines.sql",
+}
+
+func addLocationToAcLineSegments(ctx context.Context, db *bun.DB) error {
+	table := (*models.ACLineSegment)(nil)
+	var count int
+	err := db.NewSelect().Model(table).ColumnExpr("COUNT(location_mrid)").Where("1=1").Scan(ctx, &count)
+	if err == nil {
+		return nil
+	}
+	_, err = db.NewAddColumn().Model(table).ColumnExpr("location_mrid UUID").Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to add location_mrid column: %w", err)
+	}
+
+	// Dependent views are resolved lazily in sqlite, while postgres fixes the columns of a view when
+	// it is created. The latest view and the views on top of it must therefore be re-created.
+	if db.Dialect().Name() != dialect.PG {
+		return nil
+	}
+
+	var existing []string
+	for view := range acLineSegmentDependentViews {
+		var num int
+		err := db.NewSelect().
+			TableExpr("information_schema.views").
+			ColumnExpr("COUNT(*)").
+			Where("table_name = ?", view).
+			Scan(ctx, &num)
+		if err != nil {
+			return fmt.Errorf("Could not check for view %s: %w", view, err)
+		}
+		if num > 0 {
+			existing = append(existing, view)
+		}
+	}
+
+	_, err = db.ExecContext(ctx, "DROP VIEW IF EXISTS v_ac_line_segments_latest CASCADE")
+	if err != nil {
+		return fmt.Errorf("Could not drop view v_ac_line_segments_latest: %w", err)
+	}
+	_, err = db.ExecContext(ctx, MustGetViewSql("ac_line_segments"))
+	if err != nil {
+		return fmt.Errorf("Could not re-create view v_ac_line_segments_latest: %w", err)
+	}
+
+	for _, view := range existing {
+		_, err := db.ExecContext(ctx, MustGetQuery(acLineSegmentDependentViews[view]))
+		if err != nil {
+			return fmt.Errorf("Could not re-create view %s: %w", view, err)
+		}
+	}
+	return nil
+}
+
+func revertAddLocationToAcLineSegments(ctx context.Context, db *bun.DB) error {
+	return nil
+}
@@ -6,7 +6,7 @@ import (
 )
 
 const createLatestViewTmpl = `
-CREATE VIEW v_{{.TableName}}_latest AS
+CREATE {{if .OrReplace}}OR REPLACE {{end}}VIEW v_{{.TableName}}_latest AS
 SELECT sub.*
 FROM (
     SELECT 
@@ -17,16 +17,28 @@ FROM (
         ) AS row_num
     FROM {{.TableName}} t
     JOIN commits c 
-        ON t.commit_id = c.id
+        ON t.commit_id = c.id AND c.branch = 'main'
 ) sub
 WHERE sub.row_num = 1 AND NOT sub.deleted;
 `
 
 func MustGetViewSql(name string) string {
+	return mustRenderViewSql(name, false)
+}
+
+// MustGetReplaceViewSql returns the same view as MustGetViewSql, but replaces
+// an existing view. Only supported by postgres.
+func MustGetReplaceViewSql(name string) string {
+	return mustRenderViewSql(name, true)
+}
+
+func mustRenderViewSql(name string, orReplace bool) string {
 	data := struct {
 		TableName string
+		OrReplace bool
 	}{
 		TableName: name,
+		OrReplace: orReplace,
 	}
 	templ, err := template.New("view").Parse(createLatestViewTmpl)
 	if err != nil {
@@ -777,6 +777,7 @@ type NonConformLoad struct {
 }
 type ACLineSegment struct {
 	Conductor
+	RoutedPowerSystemResource
 	X   float64 `bun:"x" json:"x" iri:"http://iec.ch/TC57/2013/CIM-schema-cim16#ACLineSegment.x"`
 	Bch float64 `bun:"bch" json:"bch" iri:"http://iec.ch/TC57/2013/CIM-schema-cim16#ACLineSegment.bch"`
 	Gch float64 `bun:"gch" json:"gch" iri:"http://iec.ch/TC57/2013/CIM-schema-cim16#ACLineSegment.gch"`
@@ -7,6 +7,8 @@ import (
 	"github.com/uptrace/bun"
 )
 
+const MainBranch = "main"
+
 type Commit struct {
 	bun.BaseModel `bun:"table:commits"`
 	Id            int64     `bun:"id,pk,autoincrement"`
@@ -16,6 +18,41 @@ type Commit struct {
 	CreatedAt     time.Time `bun:"created_at,default:current_timestamp"`
 }
 
+type Branch struct {
+	bun.BaseModel `bun:"table:branches"`
+	Name          string    `bun:"name,pk" json:"name"`
+	ForkCommitId  int64     `bun:"fork_commit_id" json:"fork_commit_id"`
+	Author        string    `bun:"author" json:"author"`
+	CreatedAt     time.Time `bun:"created_at,default:current_timestamp" json:"created_at"`
+}
+
+// Tag gives a commit a permanent name, typically a model release. Tags can not be moved once created.
+type Tag struct {
+	bun.BaseModel `bun:"table:tags"`
+	Name          string    `bun:"name,pk" json:"name"`
+	CommitId      int64     `bun:"commit_id,notnull" json:"commit_id"`
+	Branch        string    `bun:"branch,default:'main'" json:"branch"`
+	Message       string    `bun:"message" json:"message"`
+	Author        string    `bun:"author" json:"author"`
+	CreatedAt     time.Time `bun:"created_at,default:current_timestamp" json:"created_at"`
+}
+
+// StagedChange is an edit of a single object that is kept aside until the author commits
+// all staged changes at once
+type StagedChange struct {
+	bun.BaseModel `bun:"table:staged_changes"`
+	Id            int64     `bun:"id,pk,autoincrement" json:"id"`
+	Author        string    `bun:"author,notnull" json:"author"`
+	Branch        string    `bun:"branch,notnull" json:"branch"`
+	Mrid          uuid.UUID `bun:"mrid,type:uuid,notnull" json:"mrid"`
+	CimType       string    `bun:"cim_type,notnull" json:"cim_type"`
+	ModelId       int       `bun:"model_id" json:"model_id"`
+	ModelName     string    `bun:"model_name" json:"model_name"`
+	BaseCommitId  int64     `bun:"base_commit_id" json:"base_commit_id"`
+	Payload       string    `bun:"payload,notnull" json:"payload"`
+	CreatedAt     time.Time `bun:"created_at,default:current_timestamp" json:"created_at"`
+}
+
 type Model struct {
 	bun.BaseModel `bun:"table:models"`
 	Id            int    `bun:"id,pk,autoincrement"`
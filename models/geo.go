@@ -9,6 +9,12 @@ type LocatedPowerSystemResource struct {
 	Location     *Entity   `bun:"rel:belongs-to,join:location_mrid=mrid" json:"location,omitempty"`
 }
 
+// RoutedPowerSystemResource points to the Location holding the route of a resource. Unlike
+// LocatedPowerSystemResource the reference has no foreign key, since most lines have no route.
+type RoutedPowerSystemResource struct {
+	LocationMrid uuid.UUID `bun:"location_mrid,type:uuid" json:"location_mrid" iri:"cim:LocatedPowerSystemResource.Location"`
+}
+
 type Location struct {
 	IdentifiedObject
 	CoordinateSystemMrid uuid.UUID `bun:"coordinate_system_mrid,type:uuid" json:"coordinate_system_mrid" iri:"cim:Location.CoordinateSystem"`
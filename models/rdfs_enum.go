@@ -15,9 +15,14 @@ func (r RdfsEnum) GetCode() string {
 	return r.Code
 }
 
+func (r RdfsEnum) GetIri() string {
+	return r.Iri
+}
+
 type Enum interface {
 	GetId() int
 	GetCode() string
+	GetIri() string
 }
 
 type ControlAreaTypeKind struct{ RdfsEnum }
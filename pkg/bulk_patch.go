@@ -0,0 +1,181 @@
+package pkg
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// PatchSelector picks the latest active objects of one class. All set criteria must match.
+type PatchSelector struct {
+	Class string `json:"class"`
+
+	// Fields holds values that the json fields of the objects must be equal to
+	Fields map[string]any `json:"fields"`
+
+	// Region and VoltageLevel select objects that are contained in a SubGeographicalRegion
+	// or a VoltageLevel, directly or through other containers
+	Region       uuid.UUID `json:"region"`
+	VoltageLevel uuid.UUID `json:"voltage_level"`
+
+	NameRegex string `json:"name_regex"`
+}
+
+// BulkPatch assigns the same values to all objects matching the selector, or deletes them
+type BulkPatch struct {
+	Selector PatchSelector              `json:"selector"`
+	Set      map[string]json.RawMessage `json:"set"`
+	Delete   bool                       `json:"delete"`
+}
+
+type BulkPatchResult struct {
+	Matched int           `json:"matched"`
+	Patches []JsonPatch   `json:"patches"`
+	Preview *PatchPreview `json:"preview,omitempty"`
+}
+
+func fieldsMatch(item any, fields map[string]any) (bool, error) {
+	generic := GenericFields(item)
+	for name, want := range fields {
+		value, ok := generic[name]
+		if !ok {
+			return false, fmt.Errorf("Unknown field %s of %s", name, StructName(item))
+		}
+
+		// Round trip through json such that the filter values compare like the object fields
+		var normalized any
+		PanicOnErr(json.Unmarshal(Must(json.Marshal(want)), &normalized))
+		if !reflect.DeepEqual(value, normalized) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// containersOf returns the objects contained in the passed containers. Nil is returned when
+// the selector does not restrict the containment.
+func containersOf(ctx context.Context, db bun.IDB, selector PatchSelector) (map[uuid.UUID]struct{}, error) {
+	var seeds []uuid.UUID
+	for _, mrid := range []uuid.UUID{selector.Region, selector.VoltageLevel} {
+		if mrid != uuid.Nil {
+			seeds = append(seeds, mrid)
+		}
+	}
+	if len(seeds) == 0 {
+		return nil, nil
+	}
+
+	objects, err := latestObjects(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var contained map[uuid.UUID]struct{}
+	for _, seed := range seeds {
+		inSeed := containedIn(objects, []uuid.UUID{seed})
+		if contained == nil {
+			contained = inSeed
+			continue
+		}
+		for mrid := range contained {
+			if _, ok := inSeed[mrid]; !ok {
+				delete(contained, mrid)
+			}
+		}
+	}
+	return contained, nil
+}
+
+// SelectObjects returns the latest active objects visible in the context that match the selector
+// sorted by mrid. Failures to read the objects give a *StorageError.
+func SelectObjects(ctx context.Context, db bun.IDB, selector PatchSelector) ([]models.VersionedIdentifiedObject, error) {
+	itemPtr, err := FormInputFieldsForType(selector.Class)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := itemPtr.(models.VersionedIdentifiedObject); !ok {
+		return nil, fmt.Errorf("Objects of type %s can not be patched", selector.Class)
+	}
+
+	var nameExpr *regexp.Regexp
+	if selector.NameRegex != "" {
+		if nameExpr, err = regexp.Compile(selector.NameRegex); err != nil {
+			return nil, fmt.Errorf("Invalid name regex: %w", err)
+		}
+		if _, ok := itemPtr.(models.NameGetter); !ok {
+			return nil, fmt.Errorf("Objects of type %s have no name", selector.Class)
+		}
+	}
+
+	contained, err := containersOf(ctx, db, selector)
+	if err != nil {
+		return nil, &StorageError{Err: err}
+	}
+
+	rowType := reflect.TypeOf(itemPtr).Elem()
+	rows := reflect.New(reflect.SliceOf(rowType))
+	table := db.Dialect().Tables().Get(rowType).Name
+	if err := repository.SelectLatest(ctx, db, table).Scan(ctx, rows.Interface()); err != nil {
+		return nil, &StorageError{Err: fmt.Errorf("Failed to fetch %s objects: %w", selector.Class, err)}
+	}
+
+	var result []models.VersionedIdentifiedObject
+	for i := range rows.Elem().Len() {
+		item := rows.Elem().Index(i).Addr().Interface().(models.VersionedIdentifiedObject)
+		if _, ok := contained[item.GetMrid()]; contained != nil && !ok {
+			continue
+		}
+		if nameExpr != nil && !nameExpr.MatchString(item.(models.NameGetter).GetName()) {
+			continue
+		}
+		match, err := fieldsMatch(item, selector.Fields)
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			result = append(result, item)
+		}
+	}
+	slices.SortFunc(result, func(a, b models.VersionedIdentifiedObject) int {
+		return cmp.Compare(a.GetMrid().String(), b.GetMrid().String())
+	})
+	return result, nil
+}
+
+// Expand turns the bulk patch into one json patch per matched object and assigned field
+func (b *BulkPatch) Expand(ctx context.Context, db bun.IDB) (BulkPatchResult, error) {
+	result := BulkPatchResult{Patches: []JsonPatch{}}
+	if len(b.Set) == 0 && !b.Delete {
+		return result, fmt.Errorf("Bulk patch must either set fields or delete objects")
+	}
+
+	items, err := SelectObjects(ctx, db, b.Selector)
+	if err != nil {
+		return result, err
+	}
+
+	fields := slices.Sorted(Keys(b.Set))
+	for _, item := range items {
+		for _, field := range fields {
+			result.Patches = append(result.Patches, JsonPatch{
+				Op:    "replace",
+				Path:  fmt.Sprintf("/%s/%s", item.GetMrid(), field),
+				Value: b.Set[field],
+			})
+		}
+		if b.Delete {
+			result.Patches = append(result.Patches, JsonPatch{Op: "remove", Path: fmt.Sprintf("/%s", item.GetMrid())})
+		}
+	}
+	result.Matched = len(items)
+	return result, nil
+}
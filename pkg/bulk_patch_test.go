@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"com.github/davidkleiven/tripleworks/migrations"
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkPatch(t *testing.T) {
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	ctx := context.Background()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	var (
+		region     models.SubGeographicalRegion
+		substation models.Substation
+		vl         models.VoltageLevel
+	)
+	region.Mrid = uuid.New()
+	substation.Mrid, substation.SubGeographicalRegionMrid = uuid.New(), region.Mrid
+	vl.Mrid, vl.SubstationMrid = uuid.New(), substation.Mrid
+
+	line := func(name string, container uuid.UUID, r float64) *models.ACLineSegment {
+		var l models.ACLineSegment
+		l.Mrid, l.Name, l.EquipmentContainerMrid, l.R = uuid.New(), name, container, r
+		return &l
+	}
+	inRegion := line("T-1", vl.Mrid, 1.0)
+	otherName := line("L-1", vl.Mrid, 2.0)
+	outside := line("T-2", uuid.Nil, 1.0)
+
+	var items []any
+	for _, item := range []models.MridGetter{&region, &substation, &vl, inRegion, otherName, outside} {
+		entity := MakeEntity(item, 0)
+		items = append(items, &entity, item)
+	}
+	require.NoError(t, InsertAll(ctx, db, models.Commit{}, slices.Values(items), NoOpOnInsert))
+
+	matched := func(selector PatchSelector) []uuid.UUID {
+		objects, err := SelectObjects(ctx, db, selector)
+		require.NoError(t, err)
+		result := []uuid.UUID{}
+		for _, item := range objects {
+			result = append(result, item.GetMrid())
+		}
+		return result
+	}
+
+	t.Run("select", func(t *testing.T) {
+		require.Equal(t, 3, len(matched(PatchSelector{Class: "ACLineSegment"})))
+		require.ElementsMatch(t, []uuid.UUID{inRegion.Mrid, otherName.Mrid}, matched(PatchSelector{Class: "ACLineSegment", Region: region.Mrid}))
+		require.ElementsMatch(t, []uuid.UUID{inRegion.Mrid, otherName.Mrid}, matched(PatchSelector{Class: "ACLineSegment", VoltageLevel: vl.Mrid}))
+		require.ElementsMatch(t, []uuid.UUID{inRegion.Mrid, outside.Mrid}, matched(PatchSelector{Class: "ACLineSegment", NameRegex: "^T-"}))
+		require.ElementsMatch(t, []uuid.UUID{inRegion.Mrid}, matched(PatchSelector{Class: "ACLineSegment", NameRegex: "^T-", Fields: map[string]any{"equipment_container_mrid": vl.Mrid}}))
+		require.ElementsMatch(t, []uuid.UUID{otherName.Mrid}, matched(PatchSelector{Class: "ACLineSegment", Fields: map[string]any{"r": 2}}))
+	})
+
+	for _, test := range []struct {
+		desc     string
+		selector PatchSelector
+		msg      string
+	}{
+		{desc: "unknown class", selector: PatchSelector{Class: "Unknown"}, msg: "Unknown type"},
+		{desc: "invalid regex", selector: PatchSelector{Class: "ACLineSegment", NameRegex: "("}, msg: "Invalid name regex"},
+		{desc: "unknown field", selector: PatchSelector{Class: "ACLineSegment", Fields: map[string]any{"unknown": 1}}, msg: "Unknown field"},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := SelectObjects(ctx, db, test.selector)
+			require.ErrorContains(t, err, test.msg)
+		})
+	}
+
+	t.Run("expand and apply", func(t *testing.T) {
+		bulk := BulkPatch{
+			Selector: PatchSelector{Class: "ACLineSegment", Region: region.Mrid},
+			Set:      map[string]json.RawMessage{"r": json.RawMessage("5"), "x": json.RawMessage("10")},
+		}
+		result, err := bulk.Expand(ctx, db)
+		require.NoError(t, err)
+		require.Equal(t, 2, result.Matched)
+		require.Equal(t, 4, len(result.Patches))
+		require.NoError(t, ApplyPatch(ctx, db, "author", result.Patches))
+
+		require.ElementsMatch(t, []uuid.UUID{inRegion.Mrid, otherName.Mrid}, matched(PatchSelector{Class: "ACLineSegment", Fields: map[string]any{"r": 5, "x": 10}}))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		bulk := BulkPatch{Selector: PatchSelector{Class: "ACLineSegment", NameRegex: "^T-"}, Delete: true}
+		result, err := bulk.Expand(ctx, db)
+		require.NoError(t, err)
+		require.NoError(t, ApplyPatch(ctx, db, "author", result.Patches))
+		require.Equal(t, []uuid.UUID{otherName.Mrid}, matched(PatchSelector{Class: "ACLineSegment"}))
+
+		// Objects are selected as they were at the as-of commit
+		objects, err := SelectObjects(repository.WithAsOf(ctx, 1), db, PatchSelector{Class: "ACLineSegment"})
+		require.NoError(t, err)
+		require.Equal(t, 3, len(objects))
+	})
+
+	t.Run("failing lookup", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := SelectObjects(cancelled, db, PatchSelector{Class: "ACLineSegment"})
+		var storage *StorageError
+		require.ErrorAs(t, err, &storage)
+	})
+
+	t.Run("nothing to change", func(t *testing.T) {
+		bulk := BulkPatch{Selector: PatchSelector{Class: "ACLineSegment"}}
+		_, err := bulk.Expand(ctx, db)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,466 @@
+package pkg
+
+import (
+	"cmp"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+	"gonum.org/v1/gonum/graph/formats/rdf"
+)
+
+const (
+	EntsoeExt                   = "http://entsoe.eu/CIM/SchemaExtension/3/1#"
+	UmlConcrete                 = "http://iec.ch/TC57/NonStandard/UML#concrete"
+	DefaultModelingAuthoritySet = "https://github.com/davidkleiven/tripleworks"
+)
+
+// CgmesProfileResources are the RDFS resources describing the CGMES 2.4.15 profiles that can be exported
+var CgmesProfileResources = map[string]string{
+	"EQ": "resources/equipment_operation.nq",
+	"GL": "resources/geo.nq",
+}
+
+var cgmesNamespaces = map[string]string{
+	Cim16:            "cim",
+	EntsoeExt:        "entsoe",
+	ModelDescription: "md",
+	Rdf:              "rdf",
+}
+
+type cgmesProperty struct {
+	iri    string
+	domain string
+	unused bool
+
+	// inverse is the local name of the other end of an association, such as Location.PowerSystemResources
+	inverse string
+}
+
+// CgmesProfile holds the classes and properties of one profile, keyed by their local names such as
+// ACLineSegment and ACLineSegment.r
+type CgmesProfile struct {
+	ShortName  string
+	Uris       []string
+	concrete   map[string]string
+	properties map[string]cgmesProperty
+}
+
+func localName(iri string) string {
+	return iri[strings.LastIndex(iri, "#")+1:]
+}
+
+type rdfsStatement struct {
+	subject   string
+	predicate string
+	object    string
+}
+
+func loadRdfsStatements(name string) ([]rdfsStatement, error) {
+	file, err := resource.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var statements []rdfsStatement
+	dec := rdf.NewDecoder(file)
+	for num := 1; ; num++ {
+		stmt, err := dec.Unmarshal()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Failed to read statement no. %d of %s: %w", num, name, err)
+		}
+		subject, _, _, err := stmt.Subject.Parts()
+		if err != nil {
+			return nil, err
+		}
+		predicate, _, _, err := stmt.Predicate.Parts()
+		if err != nil {
+			return nil, err
+		}
+		object, _, _, err := stmt.Object.Parts()
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, rdfsStatement{subject: subject, predicate: predicate, object: object})
+	}
+	return statements, nil
+}
+
+// cgmesHierarchy maps every class to its super class. The hierarchy is merged across profiles, since
+// a profile only describes the classes it exchanges while references may point to classes of other profiles.
+var cgmesHierarchy = sync.OnceValues(func() (map[string]string, error) {
+	superClass := make(map[string]string)
+	for _, name := range slices.Sorted(maps.Values(CgmesProfileResources)) {
+		statements, err := loadRdfsStatements(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, stmt := range statements {
+			if stmt.predicate == Rdfs+"subClassOf" {
+				superClass[localName(stmt.subject)] = localName(stmt.object)
+			}
+		}
+	}
+	return superClass, nil
+})
+
+// LoadCgmesProfile reads the classes, properties and profile uris of a profile from its RDFS resource
+func LoadCgmesProfile(shortName string) (*CgmesProfile, error) {
+	name, ok := CgmesProfileResources[shortName]
+	if !ok {
+		return nil, fmt.Errorf("Unknown profile %s", shortName)
+	}
+	statements, err := loadRdfsStatements(name)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := CgmesProfile{
+		ShortName:  shortName,
+		concrete:   make(map[string]string),
+		properties: make(map[string]cgmesProperty),
+	}
+	property := func(iri string) cgmesProperty {
+		prop := profile.properties[localName(iri)]
+		prop.iri = iri
+		return prop
+	}
+	for _, stmt := range statements {
+		switch stmt.predicate {
+		case RdfsExt + "stereotype":
+			if stmt.object == UmlConcrete {
+				profile.concrete[localName(stmt.subject)] = stmt.subject
+			}
+		case Rdfs + "domain":
+			prop := property(stmt.subject)
+			prop.domain = localName(stmt.object)
+			profile.properties[localName(stmt.subject)] = prop
+		case RdfsExt + "AssociationUsed":
+			prop := property(stmt.subject)
+			prop.unused = strings.EqualFold(stmt.object, "no")
+			profile.properties[localName(stmt.subject)] = prop
+		case RdfsExt + "inverseRoleName":
+			prop := property(stmt.subject)
+			prop.inverse = localName(stmt.object)
+			profile.properties[localName(stmt.subject)] = prop
+		case RdfsExt + "isFixed":
+			// The equipment resource also names the short circuit profile, whose properties are not included
+			version := localName(stmt.subject)
+			if strings.Contains(version, "Version.entsoeURI") && !strings.HasSuffix(version, "shortCircuit") {
+				profile.Uris = append(profile.Uris, stmt.object)
+			}
+		}
+	}
+	slices.Sort(profile.Uris)
+
+	// The version classes describe the profile itself and are not exchanged
+	for class := range profile.concrete {
+		if strings.HasSuffix(class, "Version") {
+			delete(profile.concrete, class)
+		}
+	}
+	return &profile, nil
+}
+
+// IsConcrete reports whether objects of a class are exchanged in the profile
+func (p *CgmesProfile) IsConcrete(class string) bool {
+	_, ok := p.concrete[class]
+	return ok
+}
+
+// property finds the property of a class or one of its super classes by name
+func (p *CgmesProfile) property(hierarchy map[string]string, class string, name string) (cgmesProperty, bool) {
+	for ; class != ""; class = hierarchy[class] {
+		if prop, ok := p.properties[class+"."+name]; ok {
+			return prop, true
+		}
+	}
+	return cgmesProperty{}, false
+}
+
+type CgmesHeader struct {
+	Id                   uuid.UUID
+	Created              time.Time
+	ScenarioTime         time.Time
+	Version              string
+	Description          string
+	ModelingAuthoritySet string
+	DependentOn          []uuid.UUID
+}
+
+// CgmesModelId gives the FullModel of a profile a stable id, such that the GL profile can refer to the
+// EQ profile of the same model version
+func CgmesModelId(modelId int, profile string, version string) uuid.UUID {
+	return mridFromName("FullModel", fmt.Sprintf("%d %s %s", modelId, profile, version))
+}
+
+func lowerFirst(name string) string {
+	runes := []rune(name)
+	runes[0] = unicode.ToLower(runes[0])
+	return string(runes)
+}
+
+type cgmesValue struct {
+	iri      string
+	literal  string
+	resource string
+}
+
+type cgmesObject struct {
+	class  string
+	id     uuid.UUID
+	values []cgmesValue
+}
+
+// cgmesId is the mrid of identified objects. Position points have no mrid and are identified by
+// their location and sequence number.
+func cgmesId(item any) uuid.UUID {
+	if point, ok := item.(*models.PositionPoint); ok {
+		return mridFromName("PositionPoint", fmt.Sprintf("%s %d", point.LocationMrid, point.SequenceNumber))
+	}
+	return mridIfPossible(item)
+}
+
+func cgmesLiteral(value reflect.Value) (string, bool) {
+	switch v := value.Interface().(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339), !v.IsZero()
+	case string:
+		return v, v != ""
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(v), true
+	}
+	return "", false
+}
+
+// cgmesObjects maps items onto the classes and properties of a profile. Objects of classes outside the
+// profile are left out, but their references can still end up in the profile through the inverse end of
+// an association, such as Location.PowerSystemResources in the GL profile.
+func cgmesObjects(ctx context.Context, db *bun.DB, profile *CgmesProfile, items []any) ([]cgmesObject, error) {
+	hierarchy, err := cgmesHierarchy()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load the class hierarchy: %w", err)
+	}
+	enums := newEnumLookup(db)
+
+	objects := make(map[uuid.UUID]*cgmesObject)
+	inverse := make(map[uuid.UUID][]cgmesValue)
+	for _, item := range items {
+		class := StructName(item)
+		id := cgmesId(item)
+		if id == uuid.Nil {
+			continue
+		}
+
+		object := cgmesObject{class: class, id: id}
+		for _, field := range structRdfFields(reflect.Indirect(reflect.ValueOf(item))) {
+			name := field.propertyName()
+			prop, ok := profile.property(hierarchy, class, name)
+			if !ok {
+				continue
+			}
+
+			if mrid, isRef := field.value.Interface().(uuid.UUID); isRef {
+				// The mrid is given by rdf:ID
+				if mrid == uuid.Nil || name == "mRID" {
+					continue
+				}
+				reference := fmt.Sprintf("#_%s", mrid)
+				if !prop.unused {
+					object.values = append(object.values, cgmesValue{iri: prop.iri, resource: reference})
+				} else if other, ok := profile.properties[prop.inverse]; ok && !other.unused && prop.inverse != "" {
+					inverse[mrid] = append(inverse[mrid], cgmesValue{iri: other.iri, resource: fmt.Sprintf("#_%s", id)})
+				}
+				continue
+			}
+			if prop.unused {
+				continue
+			}
+
+			if field.enum != "" {
+				enumId := int(field.value.Int())
+				if enumId == 0 {
+					continue
+				}
+				iri, err := enums.iri(ctx, field.enum, enumId)
+				if err != nil {
+					return nil, fmt.Errorf("Object %s: %w", id, err)
+				}
+				object.values = append(object.values, cgmesValue{iri: prop.iri, resource: iri})
+				continue
+			}
+
+			if literal, ok := cgmesLiteral(field.value); ok {
+				object.values = append(object.values, cgmesValue{iri: prop.iri, literal: literal})
+			}
+		}
+
+		if profile.IsConcrete(class) {
+			objects[id] = &object
+		}
+	}
+
+	for mrid, values := range inverse {
+		if object, ok := objects[mrid]; ok {
+			object.values = append(object.values, values...)
+		}
+	}
+
+	result := make([]cgmesObject, 0, len(objects))
+	for _, object := range objects {
+		slices.SortStableFunc(object.values, func(a, b cgmesValue) int { return cmp.Compare(a.iri, b.iri) })
+		result = append(result, *object)
+	}
+	slices.SortFunc(result, func(a, b cgmesObject) int {
+		return cmp.Or(cmp.Compare(a.class, b.class), cmp.Compare(a.id.String(), b.id.String()))
+	})
+	return result, nil
+}
+
+func qualifiedName(iri string) string {
+	idx := strings.LastIndex(iri, "#") + 1
+	prefix, ok := cgmesNamespaces[iri[:idx]]
+	if !ok {
+		return iri
+	}
+	return prefix + ":" + iri[idx:]
+}
+
+func escapeXml(value string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(value))
+	return b.String()
+}
+
+// WriteCgmes writes objects as a CGMES RDF/XML document with a FullModel header
+func WriteCgmes(w io.Writer, profile *CgmesProfile, header CgmesHeader, objects []cgmesObject) error {
+	ew := errWriter{w: w}
+	ew.printf("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<rdf:RDF")
+	for _, namespace := range slices.Sorted(maps.Keys(cgmesNamespaces)) {
+		ew.printf(" xmlns:%s=\"%s\"", cgmesNamespaces[namespace], namespace)
+	}
+	ew.printf(">\n")
+
+	ew.printf("  <md:FullModel rdf:about=\"urn:uuid:%s\">\n", header.Id)
+	ew.printf("    <md:Model.created>%s</md:Model.created>\n", header.Created.UTC().Format(time.RFC3339))
+	ew.printf("    <md:Model.scenarioTime>%s</md:Model.scenarioTime>\n", header.ScenarioTime.UTC().Format(time.RFC3339))
+	ew.printf("    <md:Model.version>%s</md:Model.version>\n", escapeXml(header.Version))
+	if header.Description != "" {
+		ew.printf("    <md:Model.description>%s</md:Model.description>\n", escapeXml(header.Description))
+	}
+	for _, dependency := range header.DependentOn {
+		ew.printf("    <md:Model.DependentOn rdf:resource=\"urn:uuid:%s\"/>\n", dependency)
+	}
+	for _, uri := range profile.Uris {
+		ew.printf("    <md:Model.profile>%s</md:Model.profile>\n", escapeXml(uri))
+	}
+	ew.printf("    <md:Model.modelingAuthoritySet>%s</md:Model.modelingAuthoritySet>\n", escapeXml(header.ModelingAuthoritySet))
+	ew.printf("  </md:FullModel>\n")
+
+	for _, object := range objects {
+		class := qualifiedName(profile.concrete[object.class])
+		ew.printf("  <%s rdf:ID=\"_%s\">\n", class, object.id)
+		for _, value := range object.values {
+			name := qualifiedName(value.iri)
+			if value.resource != "" {
+				ew.printf("    <%s rdf:resource=\"%s\"/>\n", name, escapeXml(value.resource))
+			} else {
+				ew.printf("    <%s>%s</%s>\n", name, escapeXml(value.literal), name)
+			}
+		}
+		ew.printf("  </%s>\n", class)
+	}
+	ew.printf("</rdf:RDF>\n")
+	return ew.err
+}
+
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) printf(format string, args ...any) {
+	if e.err == nil {
+		_, e.err = fmt.Fprintf(e.w, format, args...)
+	}
+}
+
+// CgmesExportItems collects the latest version of all objects in a model together with the position
+// points and coordinate systems of their locations
+func CgmesExportItems(ctx context.Context, db *bun.DB, modelId int) ([]any, error) {
+	latest, err := LatestOfAllItems(ctx, db, modelId)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]any, 0, len(latest))
+	locations := make(map[uuid.UUID]struct{})
+	coordinateSystems := make(map[uuid.UUID]struct{})
+	for _, item := range latest {
+		items = append(items, item)
+		if location, ok := item.(models.Location); ok {
+			locations[location.Mrid] = struct{}{}
+			coordinateSystems[location.CoordinateSystemMrid] = struct{}{}
+		}
+	}
+	if len(locations) == 0 {
+		return items, nil
+	}
+
+	var points []models.PositionPoint
+	err = db.NewSelect().
+		Model(&points).
+		Where("location_mrid IN (?)", bun.In(slices.Collect(maps.Keys(locations)))).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch position points: %w", err)
+	}
+	for i := range points {
+		items = append(items, &points[i])
+	}
+
+	// Coordinate systems are shared between models and have no latest view, so the newest version wins
+	var systems []models.CoordinateSystem
+	err = db.NewSelect().
+		Model(&systems).
+		Where("mrid IN (?)", bun.In(slices.Collect(maps.Keys(coordinateSystems)))).
+		Order("commit_id").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch coordinate systems: %w", err)
+	}
+	for _, system := range IndexBy(systems, func(c models.CoordinateSystem) uuid.UUID { return c.Mrid }) {
+		if !system.Deleted {
+			items = append(items, &system)
+		}
+	}
+	return items, nil
+}
+
+// CgmesExport writes the objects of a model that belong to a profile as CGMES RDF/XML
+func CgmesExport(ctx context.Context, db *bun.DB, w io.Writer, profile *CgmesProfile, header CgmesHeader, items []any) error {
+	objects, err := cgmesObjects(ctx, db, profile, items)
+	if err != nil {
+		return err
+	}
+	return WriteCgmes(w, profile, header, objects)
+}
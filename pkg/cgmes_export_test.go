@@ -0,0 +1,153 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"iter"
+	"slices"
+	"testing"
+	"time"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadCgmesProfile(t *testing.T) {
+	eq, err := LoadCgmesProfile("EQ")
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://entsoe.eu/CIM/EquipmentCore/3/1", "http://entsoe.eu/CIM/EquipmentOperation/3/1"}, eq.Uris)
+	require.True(t, eq.IsConcrete("ACLineSegment"))
+	require.False(t, eq.IsConcrete("Conductor"))
+	require.False(t, eq.IsConcrete("Location"))
+
+	gl, err := LoadCgmesProfile("GL")
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://entsoe.eu/CIM/GeographicalLocation/2/1"}, gl.Uris)
+	require.True(t, gl.IsConcrete("PositionPoint"))
+	require.False(t, gl.IsConcrete("Substation"))
+
+	_, err = LoadCgmesProfile("SSH")
+	require.ErrorContains(t, err, "Unknown profile")
+}
+
+// cgmesObjectsById parses an exported document and indexes the objects by their rdf:ID
+func cgmesObjectsById(t *testing.T, document []byte) map[string]rdfObject {
+	t.Helper()
+	objects, err := parseCgmes(bytes.NewReader(document))
+	require.NoError(t, err)
+
+	byId := make(map[string]rdfObject)
+	for _, object := range objects {
+		byId[object.Id] = object
+	}
+	return byId
+}
+
+func propertyOf(object rdfObject, iri string) rdfProperty {
+	for _, prop := range object.Properties {
+		if prop.Iri == iri {
+			return prop
+		}
+	}
+	return rdfProperty{}
+}
+
+func TestCgmesExport(t *testing.T) {
+	ctx := context.Background()
+	db, modelId := setupDb(t)
+
+	transformer := TransformerLight{Substation: "Sub A", Num: 1, HighVoltage: 420, LowVoltage: 132, RatedS: 400, Uk: 12}
+	var items []any
+	for _, record := range []interface{ CimItems(int) iter.Seq[any] }{
+		&SubstationLight{Name: "Sub A", Region: "NO1", X: 10.4, Y: 63.4},
+		&transformer,
+	} {
+		items = append(items, slices.Collect(record.CimItems(modelId))...)
+	}
+	require.NoError(t, InsertAll(ctx, db, models.Commit{Message: "Create grid"}, slices.Values(items), NoOpOnInsert))
+
+	exported, err := CgmesExportItems(ctx, db, modelId)
+	require.NoError(t, err)
+
+	header := CgmesHeader{
+		Id:                   CgmesModelId(modelId, "EQ", "1"),
+		Created:              time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC),
+		ScenarioTime:         time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC),
+		Version:              "1",
+		ModelingAuthoritySet: DefaultModelingAuthoritySet,
+	}
+
+	eq, err := LoadCgmesProfile("EQ")
+	require.NoError(t, err)
+	var eqDocument bytes.Buffer
+	require.NoError(t, CgmesExport(ctx, db, &eqDocument, eq, header, exported))
+
+	eqObjects := cgmesObjectsById(t, eqDocument.Bytes())
+	fullModel := eqObjects["urn:uuid:"+header.Id.String()]
+	require.Equal(t, ModelDescription+"FullModel", fullModel.Class)
+	require.Equal(t, "2026-10-17T12:00:00Z", propertyOf(fullModel, ModelDescription+"Model.created").Value)
+	require.Equal(t, DefaultModelingAuthoritySet, propertyOf(fullModel, ModelDescription+"Model.modelingAuthoritySet").Value)
+	require.Equal(t, "http://entsoe.eu/CIM/EquipmentCore/3/1", propertyOf(fullModel, ModelDescription+"Model.profile").Value)
+
+	hvEnd := eqObjects["_"+transformerEndMrid("Sub A T1 HV").String()]
+	require.Equal(t, Cim16+"PowerTransformerEnd", hvEnd.Class)
+	require.Equal(t, Cim16+"WindingConnection.Yn", propertyOf(hvEnd, Cim16+"PowerTransformerEnd.connectionKind").Resource)
+	require.Equal(t, "#_"+transformerMrid("Sub A T1").String(), propertyOf(hvEnd, Cim16+"PowerTransformerEnd.PowerTransformer").Resource)
+	require.Equal(t, "420", propertyOf(hvEnd, Cim16+"PowerTransformerEnd.ratedU").Value)
+
+	substation := eqObjects["_"+substationMrid("Sub A").String()]
+	require.Equal(t, "Sub A", propertyOf(substation, Cim16+"IdentifiedObject.name").Value)
+	require.Equal(t, "Sub A", propertyOf(substation, EntsoeExt+"IdentifiedObject.shortName").Value)
+
+	for id, object := range eqObjects {
+		require.NotEqual(t, Cim16+"Location", object.Class, id)
+		for _, prop := range object.Properties {
+			require.NotEqual(t, Cim16+"IdentifiedObject.mRID", prop.Iri, id)
+			require.NotEqual(t, Cim16+"PowerSystemResource.Location", prop.Iri, id)
+		}
+	}
+
+	t.Run("import the export", func(t *testing.T) {
+		target, targetModelId := setupDb(t)
+		imported, report, err := CgmesItems(ctx, target, targetModelId, bytes.NewReader(eqDocument.Bytes()))
+		require.NoError(t, err)
+		require.Empty(t, report.UnmappedClasses)
+		require.Equal(t, 2, report.ByClass["PowerTransformerEnd"])
+		require.NotEmpty(t, imported)
+	})
+
+	t.Run("geographical location", func(t *testing.T) {
+		gl, err := LoadCgmesProfile("GL")
+		require.NoError(t, err)
+
+		glHeader := header
+		glHeader.Id = CgmesModelId(modelId, "GL", "1")
+		glHeader.DependentOn = []uuid.UUID{header.Id}
+
+		var glDocument bytes.Buffer
+		require.NoError(t, CgmesExport(ctx, db, &glDocument, gl, glHeader, exported))
+		glObjects := cgmesObjectsById(t, glDocument.Bytes())
+		require.Equal(t, 4, len(glObjects))
+
+		fullModel := glObjects["urn:uuid:"+glHeader.Id.String()]
+		require.Equal(t, "urn:uuid:"+header.Id.String(), propertyOf(fullModel, ModelDescription+"Model.DependentOn").Resource)
+
+		location := glObjects["_"+locationMrid("Sub A").String()]
+		require.Equal(t, Cim16+"Location", location.Class)
+		require.Equal(t, "#_"+substationMrid("Sub A").String(), propertyOf(location, Cim16+"Location.PowerSystemResources").Resource)
+		require.Equal(t, "#_"+CoordinateSystemMrid().String(), propertyOf(location, Cim16+"Location.CoordinateSystem").Resource)
+
+		system := glObjects["_"+CoordinateSystemMrid().String()]
+		require.Equal(t, "EPSG:4326", propertyOf(system, Cim16+"CoordinateSystem.crsUrn").Value)
+
+		var point rdfObject
+		for _, object := range glObjects {
+			if object.Class == Cim16+"PositionPoint" {
+				point = object
+			}
+		}
+		require.Equal(t, "10.4", propertyOf(point, Cim16+"PositionPoint.xPosition").Value)
+		require.Equal(t, "1", propertyOf(point, Cim16+"PositionPoint.sequenceNumber").Value)
+	})
+}
@@ -0,0 +1,96 @@
+package pkg
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/uptrace/bun"
+)
+
+const ModelDescription = "http://iec.ch/TC57/61970-552/ModelDescription/1#"
+
+func rdfAttr(attrs []xml.Attr, name string) string {
+	for _, attr := range attrs {
+		if attr.Name.Space == Rdf && attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// parseCgmes reads the objects of an RDF/XML document in the flat layout used by CGMES
+func parseCgmes(r io.Reader) ([]rdfObject, error) {
+	dec := xml.NewDecoder(r)
+	var (
+		objects []rdfObject
+		prop    rdfProperty
+		depth   int
+		hasRoot bool
+	)
+	for {
+		token, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return objects, fmt.Errorf("Failed to parse RDF/XML: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			switch depth {
+			case 1:
+				if t.Name.Space != Rdf || t.Name.Local != "RDF" {
+					return objects, fmt.Errorf("Expected rdf:RDF as root element, got %s", t.Name.Local)
+				}
+				hasRoot = true
+			case 2:
+				object := rdfObject{Class: t.Name.Space + t.Name.Local, Id: rdfAttr(t.Attr, "ID")}
+				if object.Id == "" {
+					object.Id = rdfAttr(t.Attr, "about")
+				}
+				if object.Id == "" {
+					return objects, fmt.Errorf("Object no. %d of type %s has neither rdf:ID nor rdf:about", len(objects)+1, t.Name.Local)
+				}
+				objects = append(objects, object)
+			case 3:
+				prop = rdfProperty{Iri: t.Name.Space + t.Name.Local, Resource: rdfAttr(t.Attr, "resource")}
+			}
+		case xml.CharData:
+			if depth == 3 {
+				prop.Value += string(t)
+			}
+		case xml.EndElement:
+			if depth == 3 {
+				prop.Value = strings.TrimSpace(prop.Value)
+				current := &objects[len(objects)-1]
+				current.Properties = append(current.Properties, prop)
+			}
+			depth--
+		}
+	}
+	if !hasRoot {
+		return objects, fmt.Errorf("Expected rdf:RDF as root element, got an empty document")
+	}
+	return objects, nil
+}
+
+// CgmesItems maps the objects in one or more CGMES RDF/XML documents, such as the EQ and GL profiles
+// of the same model, onto the models. Objects described in several documents are merged.
+func CgmesItems(ctx context.Context, db *bun.DB, modelId int, documents ...io.Reader) ([]any, RdfImportReport, error) {
+	report := newRdfImportReport()
+
+	var objects []rdfObject
+	for i, document := range documents {
+		parsed, err := parseCgmes(document)
+		if err != nil {
+			return nil, report, fmt.Errorf("Document no. %d: %w", i+1, err)
+		}
+		objects = append(objects, parsed...)
+	}
+	return rdfItems(ctx, db, modelId, objects)
+}
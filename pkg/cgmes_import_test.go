@@ -0,0 +1,249 @@
+package pkg
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"com.github/davidkleiven/tripleworks/migrations"
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+const cgmesEqSample = `<?xml version="1.0" encoding="UTF-8"?>
+<rdf:RDF xmlns:cim="http://iec.ch/TC57/2013/CIM-schema-cim16#" xmlns:entsoe="http://entsoe.eu/CIM/SchemaExtension/3/1#" xmlns:md="http://iec.ch/TC57/61970-552/ModelDescription/1#" xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <md:FullModel rdf:about="urn:uuid:8a5b2c0e-7c1b-4f5e-9d3a-0c6f1e2d3b4a">
+    <md:Model.profile>http://entsoe.eu/CIM/EquipmentCore/3/1</md:Model.profile>
+  </md:FullModel>
+  <cim:BaseVoltage rdf:ID="_6e2a1b7c-9a43-4d1e-8b3f-2c5d7e9f1a2b">
+    <cim:IdentifiedObject.name>400 kV</cim:IdentifiedObject.name>
+    <cim:BaseVoltage.nominalVoltage>400.0</cim:BaseVoltage.nominalVoltage>
+  </cim:BaseVoltage>
+  <cim:ACLineSegment rdf:ID="_0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e">
+    <cim:IdentifiedObject.name>Line 1</cim:IdentifiedObject.name>
+    <entsoe:IdentifiedObject.shortName>L1</entsoe:IdentifiedObject.shortName>
+    <cim:ACLineSegment.r>1.5</cim:ACLineSegment.r>
+    <cim:Conductor.length>12.0</cim:Conductor.length>
+    <cim:Equipment.aggregate>false</cim:Equipment.aggregate>
+    <cim:ConductingEquipment.BaseVoltage rdf:resource="#_6e2a1b7c-9a43-4d1e-8b3f-2c5d7e9f1a2b"/>
+    <cim:ACLineSegment.shortCircuitEndTemperature>80.0</cim:ACLineSegment.shortCircuitEndTemperature>
+  </cim:ACLineSegment>
+  <cim:Terminal rdf:ID="terminal-1">
+    <cim:ACDCTerminal.sequenceNumber>1</cim:ACDCTerminal.sequenceNumber>
+    <cim:Terminal.phases rdf:resource="http://iec.ch/TC57/2013/CIM-schema-cim16#PhaseCode.ABC"/>
+    <cim:Terminal.ConductingEquipment rdf:resource="#_0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e"/>
+  </cim:Terminal>
+  <cim:ThisClassDoesNotExist rdf:ID="_unknown"/>
+</rdf:RDF>`
+
+const cgmesGlSample = `<?xml version="1.0" encoding="UTF-8"?>
+<rdf:RDF xmlns:cim="http://iec.ch/TC57/2013/CIM-schema-cim16#" xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <cim:CoordinateSystem rdf:ID="_crs">
+    <cim:CoordinateSystem.crsUrn>urn:ogc:def:crs:EPSG::4326</cim:CoordinateSystem.crsUrn>
+  </cim:CoordinateSystem>
+  <cim:Location rdf:ID="_location">
+    <cim:Location.CoordinateSystem rdf:resource="#_crs"/>
+    <cim:Location.PowerSystemResources rdf:resource="#_0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e"/>
+  </cim:Location>
+  <cim:PositionPoint rdf:ID="_point">
+    <cim:PositionPoint.sequenceNumber>1</cim:PositionPoint.sequenceNumber>
+    <cim:PositionPoint.xPosition>10.5</cim:PositionPoint.xPosition>
+    <cim:PositionPoint.yPosition>59.9</cim:PositionPoint.yPosition>
+    <cim:PositionPoint.Location rdf:resource="#_location"/>
+  </cim:PositionPoint>
+  <cim:ACLineSegment rdf:about="#_0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e">
+    <cim:ACLineSegment.x>4.5</cim:ACLineSegment.x>
+  </cim:ACLineSegment>
+</rdf:RDF>`
+
+func TestCgmesMrid(t *testing.T) {
+	mrid := uuid.MustParse("0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e")
+	for _, id := range []string{
+		"_0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e",
+		"#_0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e",
+		"urn:uuid:0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e",
+		"http://example.com/boundary.xml#_0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e",
+	} {
+		require.Equal(t, mrid, rdfMrid(id), id)
+	}
+	require.Equal(t, rdfMrid("_terminal-1"), rdfMrid("#terminal-1"))
+	require.NotEqual(t, rdfMrid("terminal-1"), rdfMrid("terminal-2"))
+}
+
+func TestParseCgmes(t *testing.T) {
+	objects, err := parseCgmes(strings.NewReader(cgmesEqSample))
+	require.NoError(t, err)
+	require.Equal(t, 5, len(objects))
+	require.Equal(t, Cim16+"ACLineSegment", objects[2].Class)
+	require.Equal(t, "_0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e", objects[2].Id)
+	require.Contains(t, objects[2].Properties, rdfProperty{Iri: Cim16 + "ACLineSegment.r", Value: "1.5"})
+	require.Contains(t, objects[2].Properties, rdfProperty{
+		Iri:      Cim16 + "ConductingEquipment.BaseVoltage",
+		Resource: "#_6e2a1b7c-9a43-4d1e-8b3f-2c5d7e9f1a2b",
+	})
+
+	for _, test := range []struct {
+		desc     string
+		document string
+		msg      string
+	}{
+		{desc: "not rdf", document: "<html></html>", msg: "Expected rdf:RDF"},
+		{desc: "no identity", document: `<rdf:RDF xmlns:rdf="` + Rdf + `"><Thing/></rdf:RDF>`, msg: "neither rdf:ID nor rdf:about"},
+		{desc: "empty", document: "not xml", msg: "empty document"},
+		{desc: "malformed", document: `<rdf:RDF xmlns:rdf="` + Rdf + `">`, msg: "Failed to parse"},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := parseCgmes(strings.NewReader(test.document))
+			require.ErrorContains(t, err, test.msg)
+		})
+	}
+}
+
+func TestCgmesItems(t *testing.T) {
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	ctx := context.Background()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	lineMrid := uuid.MustParse("0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e")
+	items, report, err := CgmesItems(ctx, db, 0, strings.NewReader(cgmesEqSample), strings.NewReader(cgmesGlSample))
+	require.NoError(t, err)
+
+	require.Equal(t, 6, report.Objects)
+	require.Equal(t, map[string]int{
+		"BaseVoltage": 1, "ACLineSegment": 1, "Terminal": 1, "CoordinateSystem": 1, "Location": 1, "PositionPoint": 1,
+	}, report.ByClass)
+	require.Equal(t, map[string]int{Cim16 + "ThisClassDoesNotExist": 1}, report.UnmappedClasses)
+	require.Equal(t, map[string]int{
+		Cim16 + "ACLineSegment.shortCircuitEndTemperature": 1,
+		Cim16 + "Location.PowerSystemResources":            1,
+	}, report.UnmappedProperties)
+
+	var (
+		line     *models.ACLineSegment
+		terminal *models.Terminal
+		point    *models.PositionPoint
+		entities int
+	)
+	for _, item := range items {
+		switch v := item.(type) {
+		case *models.ACLineSegment:
+			line = v
+		case *models.Terminal:
+			terminal = v
+		case *models.PositionPoint:
+			point = v
+		case *models.Entity:
+			entities++
+		}
+	}
+
+	// Every object except the position point gets an entity
+	require.Equal(t, 5, entities)
+
+	require.Equal(t, lineMrid, line.Mrid)
+	require.Equal(t, "Line 1", line.Name)
+	require.Equal(t, "L1", line.ShortName)
+	require.Equal(t, 1.5, line.R)
+	require.Equal(t, 4.5, line.X)
+	require.Equal(t, 12.0, line.Length)
+	require.Equal(t, uuid.MustParse("6e2a1b7c-9a43-4d1e-8b3f-2c5d7e9f1a2b"), line.BaseVoltageMrid)
+
+	require.Equal(t, rdfMrid("terminal-1"), terminal.Mrid)
+	require.Equal(t, lineMrid, terminal.ConductingEquipmentMrid)
+	require.Equal(t, 3, terminal.PhasesId)
+	require.Equal(t, 1, terminal.SequenceNumber)
+
+	require.Equal(t, rdfMrid("_location"), point.LocationMrid)
+	require.Equal(t, 10.5, point.XPosition)
+
+	t.Run("existing objects are left out", func(t *testing.T) {
+		require.NoError(t, InsertAll(ctx, db, models.Commit{}, slices.Values(items), NoOpOnInsert))
+
+		lines, err := FindAll[models.ACLineSegment](db, ctx, 0)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(lines))
+		require.Equal(t, 4.5, lines[0].X)
+
+		_, report, err := CgmesItems(ctx, db, 0, strings.NewReader(cgmesEqSample))
+		require.NoError(t, err)
+		require.Equal(t, 3, report.Existing)
+		require.Zero(t, report.Objects)
+	})
+
+	for _, test := range []struct {
+		desc     string
+		property string
+		msg      string
+	}{
+		{desc: "invalid number", property: `<cim:ACLineSegment.r>abc</cim:ACLineSegment.r>`, msg: "ACLineSegment.r"},
+		{desc: "reference as literal", property: `<cim:Equipment.EquipmentContainer>abc</cim:Equipment.EquipmentContainer>`, msg: "Expected a reference"},
+		{desc: "unknown enum value", property: `<cim:Terminal.phases rdf:resource="` + Cim16 + `PhaseCode.XYZ"/>`, msg: "Unknown value"},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			class := "ACLineSegment"
+			if strings.Contains(test.property, "Terminal") {
+				class = "Terminal"
+			}
+			document := `<rdf:RDF xmlns:cim="` + Cim16 + `" xmlns:rdf="` + Rdf + `"><cim:` + class + ` rdf:ID="_x">` +
+				test.property + `</cim:` + class + `></rdf:RDF>`
+			_, _, err := CgmesItems(ctx, db, 0, strings.NewReader(document))
+			require.ErrorContains(t, err, test.msg)
+		})
+	}
+}
+
+const cgmesEqSeveralPerClassSample = `<?xml version="1.0" encoding="UTF-8"?>
+<rdf:RDF xmlns:cim="http://iec.ch/TC57/2013/CIM-schema-cim16#" xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <cim:BaseVoltage rdf:ID="_6e2a1b7c-9a43-4d1e-8b3f-2c5d7e9f1a2b">
+    <cim:IdentifiedObject.name>400 kV</cim:IdentifiedObject.name>
+    <cim:BaseVoltage.nominalVoltage>400.0</cim:BaseVoltage.nominalVoltage>
+  </cim:BaseVoltage>
+  <cim:BaseVoltage rdf:ID="_7f3b2c8d-0b54-4e2f-9c40-3d6e8f0a2b3c">
+    <cim:IdentifiedObject.name>132 kV</cim:IdentifiedObject.name>
+    <cim:BaseVoltage.nominalVoltage>132.0</cim:BaseVoltage.nominalVoltage>
+  </cim:BaseVoltage>
+  <cim:ACLineSegment rdf:ID="_0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e">
+    <cim:IdentifiedObject.name>Line 1</cim:IdentifiedObject.name>
+    <cim:ACLineSegment.r>1.5</cim:ACLineSegment.r>
+    <cim:ConductingEquipment.BaseVoltage rdf:resource="#_6e2a1b7c-9a43-4d1e-8b3f-2c5d7e9f1a2b"/>
+  </cim:ACLineSegment>
+  <cim:ACLineSegment rdf:ID="_1c2d3e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f">
+    <cim:IdentifiedObject.name>Line 2</cim:IdentifiedObject.name>
+    <cim:ACLineSegment.r>2.5</cim:ACLineSegment.r>
+    <cim:ConductingEquipment.BaseVoltage rdf:resource="#_7f3b2c8d-0b54-4e2f-9c40-3d6e8f0a2b3c"/>
+  </cim:ACLineSegment>
+</rdf:RDF>`
+
+func TestCgmesItemsSeveralObjectsPerClass(t *testing.T) {
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	ctx := context.Background()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	items, report, err := CgmesItems(ctx, db, 0, strings.NewReader(cgmesEqSeveralPerClassSample))
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"BaseVoltage": 2, "ACLineSegment": 2}, report.ByClass)
+	require.NoError(t, InsertAll(ctx, db, models.Commit{}, slices.Values(items), NoOpOnInsert))
+
+	bvs, err := FindAll[models.BaseVoltage](db, ctx, 0)
+	require.NoError(t, err)
+	voltages := make(map[string]float64)
+	for _, bv := range bvs {
+		voltages[bv.Name] = bv.NominalVoltage
+	}
+	require.Equal(t, map[string]float64{"400 kV": 400.0, "132 kV": 132.0}, voltages)
+
+	lines, err := FindAll[models.ACLineSegment](db, ctx, 0)
+	require.NoError(t, err)
+	byName := IndexBy(lines, func(l models.ACLineSegment) string { return l.Name })
+	require.Equal(t, 2, len(byName))
+	require.Equal(t, uuid.MustParse("0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e"), byName["Line 1"].Mrid)
+	require.Equal(t, 1.5, byName["Line 1"].R)
+	require.Equal(t, uuid.MustParse("6e2a1b7c-9a43-4d1e-8b3f-2c5d7e9f1a2b"), byName["Line 1"].BaseVoltageMrid)
+	require.Equal(t, uuid.MustParse("1c2d3e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f"), byName["Line 2"].Mrid)
+	require.Equal(t, 2.5, byName["Line 2"].R)
+	require.Equal(t, uuid.MustParse("7f3b2c8d-0b54-4e2f-9c40-3d6e8f0a2b3c"), byName["Line 2"].BaseVoltageMrid)
+}
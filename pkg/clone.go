@@ -0,0 +1,220 @@
+package pkg
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type CloneOptions struct {
+	Name string `json:"name"`
+
+	// Regions and Bidzones limit the clone to the objects contained in the listed
+	// SubGeographicalRegions, given by mrid or by name. Everything is cloned when both are empty.
+	Regions  []uuid.UUID `json:"regions"`
+	Bidzones []string    `json:"bidzones"`
+}
+
+func (c *CloneOptions) filtersRegions() bool {
+	return len(c.Regions) > 0 || len(c.Bidzones) > 0
+}
+
+type CloneResult struct {
+	SourceModelId int   `json:"source_model_id"`
+	ModelId       int   `json:"model_id,omitempty"`
+	CommitId      int64 `json:"commit_id,omitempty"`
+	Objects       int   `json:"objects"`
+}
+
+// cloneMrid derives the mrid of a cloned object. The same object cloned into the same model
+// always gets the same mrid.
+func cloneMrid(modelId int, mrid uuid.UUID) uuid.UUID {
+	return mridFromName(fmt.Sprintf("model-%d", modelId), mrid.String())
+}
+
+// remapMrids replaces all uuid fields of an object that are keys in the mapping
+func remapMrids(item any, mapping map[uuid.UUID]uuid.UUID) {
+	remapValue(reflect.ValueOf(item).Elem(), mapping)
+}
+
+func remapValue(v reflect.Value, mapping map[uuid.UUID]uuid.UUID) {
+	uuidType := reflect.TypeOf(uuid.UUID{})
+	for i := range v.NumField() {
+		field := v.Field(i)
+		switch {
+		case field.Type() == uuidType && field.CanSet():
+			if remapped, ok := mapping[field.Interface().(uuid.UUID)]; ok {
+				field.Set(reflect.ValueOf(remapped))
+			}
+		case v.Type().Field(i).Anonymous && field.Kind() == reflect.Struct:
+			remapValue(field, mapping)
+		}
+	}
+}
+
+// containedIn returns the seeds together with all objects that directly or indirectly refer to them
+func containedIn(objects map[uuid.UUID]models.VersionedIdentifiedObject, seeds []uuid.UUID) map[uuid.UUID]struct{} {
+	referrers := make(map[uuid.UUID][]uuid.UUID)
+	for mrid, item := range objects {
+		for _, ref := range referencedMrids(item) {
+			referrers[ref] = append(referrers[ref], mrid)
+		}
+	}
+
+	result := Set(seeds...)
+	queue := slices.Clone(seeds)
+	for len(queue) > 0 {
+		mrid := queue[0]
+		queue = queue[1:]
+		for _, referrer := range referrers[mrid] {
+			if _, ok := result[referrer]; !ok {
+				result[referrer] = struct{}{}
+				queue = append(queue, referrer)
+			}
+		}
+	}
+	return result
+}
+
+// selectRegions picks the objects contained in the requested regions. Objects that do not belong to
+// any region, such as base voltages or lines between regions, are added when a selected object refers to them.
+func selectRegions(objects map[uuid.UUID]models.VersionedIdentifiedObject, options CloneOptions) map[uuid.UUID]struct{} {
+	var seeds, allRegions []uuid.UUID
+	for mrid, item := range objects {
+		region, ok := item.(*models.SubGeographicalRegion)
+		if !ok {
+			continue
+		}
+		allRegions = append(allRegions, mrid)
+		if slices.Contains(options.Regions, mrid) || slices.Contains(options.Bidzones, region.Name) {
+			seeds = append(seeds, mrid)
+		}
+	}
+
+	regional := containedIn(objects, allRegions)
+	selected := containedIn(objects, seeds)
+	queue := slices.Collect(Keys(selected))
+	for len(queue) > 0 {
+		mrid := queue[0]
+		queue = queue[1:]
+		for _, ref := range referencedMrids(objects[mrid]) {
+			_, known := objects[ref]
+			_, isRegional := regional[ref]
+			_, isSelected := selected[ref]
+			if known && !isRegional && !isSelected {
+				selected[ref] = struct{}{}
+				queue = append(queue, ref)
+			}
+		}
+	}
+	return selected
+}
+
+// latestInModel returns the latest active version of all objects in a model
+func latestInModel(ctx context.Context, db bun.IDB, modelId int) (map[uuid.UUID]models.VersionedIdentifiedObject, error) {
+	return latestObjects(repository.WithModel(ctx, modelId), db)
+}
+
+// latestObjects returns the latest active version of all objects visible in the context
+func latestObjects(ctx context.Context, db bun.IDB) (map[uuid.UUID]models.VersionedIdentifiedObject, error) {
+	objects := make(map[uuid.UUID]models.VersionedIdentifiedObject)
+	for _, itemPtr := range FormTypes() {
+		if _, ok := itemPtr.(models.VersionedIdentifiedObject); !ok {
+			continue
+		}
+		versions, err := VersionsOf(ctx, db, itemPtr, func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Apply(repository.OnBranch(ctx))
+		})
+		if err != nil {
+			return objects, err
+		}
+		for item := range OnlyLatestVersion(versions) {
+			if !item.(models.DeletedGetter).GetDeleted() {
+				objects[item.GetMrid()] = item
+			}
+		}
+	}
+	return objects, nil
+}
+
+// CloneModel copies the latest active version of the objects in a model into a new model using a
+// single new commit. An mrid can only belong to one model, so all cloned objects get new mrids derived
+// from the new model id and the original mrid, and references between cloned objects are updated
+// accordingly. References to objects that are not cloned are kept. Nothing is written if no objects
+// match the options.
+func CloneModel(ctx context.Context, db bun.IDB, inserter repository.Inserter, sourceModelId int, options CloneOptions, author string) (CloneResult, error) {
+	result := CloneResult{SourceModelId: sourceModelId}
+
+	var source models.Model
+	if err := db.NewSelect().Model(&source).Where("id = ?", sourceModelId).Scan(ctx); err != nil {
+		return result, fmt.Errorf("Failed to find model %d: %w", sourceModelId, err)
+	}
+
+	objects, err := latestInModel(ctx, db, sourceModelId)
+	if err != nil {
+		return result, fmt.Errorf("Failed to read model %d: %w", sourceModelId, err)
+	}
+
+	selected := Set(slices.Collect(Keys(objects))...)
+	if options.filtersRegions() {
+		selected = selectRegions(objects, options)
+	}
+	if len(selected) == 0 {
+		return result, nil
+	}
+
+	mrids := slices.SortedFunc(Keys(selected), func(a, b uuid.UUID) int { return cmp.Compare(a.String(), b.String()) })
+	model := models.Model{Name: options.Name}
+	if model.Name == "" {
+		model.Name = "Copy of " + source.Name
+	}
+
+	clone := func(ctx context.Context, inserter repository.Inserter) error {
+		if err := inserter.Insert(ctx, &model); err != nil {
+			return fmt.Errorf("Failed to insert model: %w", err)
+		}
+
+		mapping := make(map[uuid.UUID]uuid.UUID, len(mrids))
+		for _, mrid := range mrids {
+			mapping[mrid] = cloneMrid(model.Id, mrid)
+		}
+
+		items := make([]any, 0, 2*len(mrids))
+		for _, mrid := range mrids {
+			entity := MakeEntity(objects[mrid], model.Id)
+			entity.Mrid = mapping[mrid]
+			items = append(items, &entity)
+		}
+		for _, mrid := range mrids {
+			item := newVersionOf(objects[mrid], false)
+			remapMrids(item, mapping)
+			items = append(items, item)
+		}
+
+		commit := models.Commit{
+			Message: fmt.Sprintf("Clone of model %d (%s)", source.Id, source.Name),
+			Author:  author,
+		}
+		onInsert := func(v any) error {
+			if versioned, ok := v.(models.VersionedIdentifiedObject); ok {
+				result.CommitId = int64(versioned.GetCommitId())
+			}
+			return nil
+		}
+		return InsertAllInserter(ctx, inserter, commit, slices.Values(items), onInsert)
+	}
+	if err := repository.WithTx(clone)(ctx, inserter); err != nil {
+		return result, fmt.Errorf("Failed to clone model %d: %w", sourceModelId, err)
+	}
+
+	result.ModelId = model.Id
+	result.Objects = len(mrids)
+	return result, nil
+}
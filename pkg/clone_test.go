@@ -0,0 +1,149 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"testing"
+
+	"com.github/davidkleiven/tripleworks/migrations"
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemapMrids(t *testing.T) {
+	old, replacement, untouched := uuid.New(), uuid.New(), uuid.New()
+	var vl models.VoltageLevel
+	vl.Mrid = old
+	vl.SubstationMrid = old
+	vl.BaseVoltageMrid = untouched
+
+	remapMrids(&vl, map[uuid.UUID]uuid.UUID{old: replacement})
+	require.Equal(t, replacement, vl.Mrid)
+	require.Equal(t, replacement, vl.SubstationMrid)
+	require.Equal(t, untouched, vl.BaseVoltageMrid)
+}
+
+func TestCloneModel(t *testing.T) {
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	ctx := context.Background()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	source := models.Model{Name: "base case"}
+	_, err = db.NewInsert().Model(&source).Exec(ctx)
+	require.NoError(t, err)
+
+	var (
+		country  models.GeographicalRegion
+		north    models.SubGeographicalRegion
+		south    models.SubGeographicalRegion
+		subNorth models.Substation
+		subSouth models.Substation
+		vlNorth  models.VoltageLevel
+		vlSouth  models.VoltageLevel
+	)
+	bv := baseVoltageVersion(uuid.New(), "bv", 420.0)
+	country.Mrid = uuid.New()
+	north.Mrid, north.Name, north.GeographicalRegionMrid = uuid.New(), "NO1", country.Mrid
+	south.Mrid, south.Name, south.GeographicalRegionMrid = uuid.New(), "NO2", country.Mrid
+	subNorth.Mrid, subNorth.SubGeographicalRegionMrid = uuid.New(), north.Mrid
+	subSouth.Mrid, subSouth.SubGeographicalRegionMrid = uuid.New(), south.Mrid
+	vlNorth.Mrid, vlNorth.SubstationMrid, vlNorth.BaseVoltageMrid = uuid.New(), subNorth.Mrid, bv.Mrid
+	vlSouth.Mrid, vlSouth.SubstationMrid, vlSouth.BaseVoltageMrid = uuid.New(), subSouth.Mrid, bv.Mrid
+
+	var items []any
+	for _, item := range []models.MridGetter{bv, &country, &north, &south, &subNorth, &subSouth, &vlNorth, &vlSouth} {
+		entity := MakeEntity(item, source.Id)
+		items = append(items, &entity, item)
+	}
+	require.NoError(t, InsertAll(ctx, db, models.Commit{Message: "model"}, slices.Values(items), NoOpOnInsert))
+
+	deleted := baseVoltageVersion(uuid.New(), "deleted", 22.0)
+	deleted.Deleted = true
+	entity := MakeEntity(deleted, source.Id)
+	require.NoError(t, InsertAll(ctx, db, models.Commit{Message: "deleted"}, slices.Values([]any{&entity, deleted}), NoOpOnInsert))
+
+	inserter := repository.BunInserter{Db: db}
+
+	t.Run("whole model", func(t *testing.T) {
+		result, err := CloneModel(ctx, db, &inserter, source.Id, CloneOptions{}, "author")
+		require.NoError(t, err)
+		require.Equal(t, 8, result.Objects)
+		require.NotZero(t, result.CommitId)
+
+		var model models.Model
+		require.NoError(t, db.NewSelect().Model(&model).Where("id = ?", result.ModelId).Scan(ctx))
+		require.Equal(t, "Copy of base case", model.Name)
+
+		vls, err := FindAll[models.VoltageLevel](db, ctx, result.ModelId)
+		require.NoError(t, err)
+		require.Equal(t, 2, len(vls))
+		for _, vl := range vls {
+			require.Contains(t, []uuid.UUID{cloneMrid(result.ModelId, vlNorth.Mrid), cloneMrid(result.ModelId, vlSouth.Mrid)}, vl.Mrid)
+			require.Equal(t, cloneMrid(result.ModelId, bv.Mrid), vl.BaseVoltageMrid)
+		}
+
+		original, err := FindAll[models.VoltageLevel](db, ctx, source.Id)
+		require.NoError(t, err)
+		require.Equal(t, 2, len(original))
+	})
+
+	t.Run("limited to bidzone", func(t *testing.T) {
+		result, err := CloneModel(ctx, db, &inserter, source.Id, CloneOptions{Name: "north", Bidzones: []string{"NO1"}}, "author")
+		require.NoError(t, err)
+
+		// Region, substation and voltage level together with the shared country and base voltage
+		require.Equal(t, 5, result.Objects)
+		subs, err := FindAll[models.Substation](db, ctx, result.ModelId)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(subs))
+		require.Equal(t, cloneMrid(result.ModelId, north.Mrid), subs[0].SubGeographicalRegionMrid)
+	})
+
+	t.Run("limited to region by mrid", func(t *testing.T) {
+		result, err := CloneModel(ctx, db, &inserter, source.Id, CloneOptions{Regions: []uuid.UUID{south.Mrid}}, "author")
+		require.NoError(t, err)
+		vls, err := FindAll[models.VoltageLevel](db, ctx, result.ModelId)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(vls))
+		require.Equal(t, cloneMrid(result.ModelId, vlSouth.Mrid), vls[0].Mrid)
+	})
+
+	t.Run("source model is unchanged", func(t *testing.T) {
+		// Every object, including the base voltage shared by the cloned regions, stays in the source model
+		bvs, err := FindAll[models.BaseVoltage](db, ctx, source.Id)
+		require.NoError(t, err)
+		require.Equal(t, []uuid.UUID{bv.Mrid}, mridsOf(OnlyActiveLatest(bvs)))
+
+		vls, err := FindAll[models.VoltageLevel](db, ctx, source.Id)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []uuid.UUID{vlNorth.Mrid, vlSouth.Mrid}, mridsOf(vls))
+
+		var entities []models.Entity
+		require.NoError(t, db.NewSelect().Model(&entities).Where("model_id = ?", source.Id).Scan(ctx))
+		require.Equal(t, 9, len(entities))
+	})
+
+	t.Run("no matching region", func(t *testing.T) {
+		result, err := CloneModel(ctx, db, &inserter, source.Id, CloneOptions{Bidzones: []string{"SE3"}}, "author")
+		require.NoError(t, err)
+		require.Zero(t, result.Objects)
+		require.Zero(t, result.ModelId)
+	})
+
+	t.Run("unknown model", func(t *testing.T) {
+		_, err := CloneModel(ctx, db, &inserter, 100, CloneOptions{}, "author")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+}
+
+func mridsOf[T models.MridGetter](items []T) []uuid.UUID {
+	result := []uuid.UUID{}
+	for _, item := range items {
+		result = append(result, item.GetMrid())
+	}
+	return result
+}
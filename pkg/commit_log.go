@@ -0,0 +1,278 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+const (
+	DefaultCommitPageSize = 50
+	MaxCommitPageSize     = 500
+)
+
+// CommitLogFilter selects commits for the commit log. Zero values do not filter.
+type CommitLogFilter struct {
+	Author   string
+	Branch   string
+	Message  string
+	Since    time.Time
+	Until    time.Time
+	Class    string
+	Mrid     uuid.UUID
+	Page     int
+	PageSize int
+}
+
+// ClassStats counts the objects of one class that a commit added, changed or deleted
+type ClassStats struct {
+	Class   string `json:"class"`
+	Added   int    `json:"added"`
+	Changed int    `json:"changed"`
+	Deleted int    `json:"deleted"`
+}
+
+type CommitLogEntry struct {
+	models.Commit
+	Stats []ClassStats `json:"stats"`
+}
+
+type CommitLog struct {
+	Commits  []CommitLogEntry `json:"commits"`
+	Total    int              `json:"total"`
+	Page     int              `json:"page"`
+	PageSize int              `json:"page_size"`
+	Filter   CommitLogFilter  `json:"-"`
+}
+
+func (c CommitLog) NumPages() int {
+	return max(1, (c.Total+c.PageSize-1)/c.PageSize)
+}
+
+func (f *CommitLogFilter) normalize() {
+	f.Page = max(f.Page, 1)
+	if f.PageSize <= 0 {
+		f.PageSize = DefaultCommitPageSize
+	}
+	f.PageSize = min(f.PageSize, MaxCommitPageSize)
+}
+
+// tableOfClass returns the name of the table that holds the versions of a class
+func tableOfClass(db *bun.DB, class string) (string, error) {
+	itemPtr, ok := FormTypes()[class]
+	if !ok {
+		return "", fmt.Errorf("Unknown class %s", class)
+	}
+	return db.Table(reflect.TypeOf(itemPtr).Elem()).Name, nil
+}
+
+func (f *CommitLogFilter) apply(ctx context.Context, db *bun.DB, q *bun.SelectQuery) (*bun.SelectQuery, error) {
+	if f.Author != "" {
+		q = q.Where("?TableAlias.author = ?", f.Author)
+	}
+	if f.Branch != "" {
+		q = q.Where("?TableAlias.branch = ?", f.Branch)
+	}
+	if f.Message != "" {
+		q = q.Where("LOWER(?TableAlias.message) LIKE ?", "%"+strings.ToLower(f.Message)+"%")
+	}
+	if !f.Since.IsZero() {
+		q = q.Where("?TableAlias.created_at >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		q = q.Where("?TableAlias.created_at <= ?", f.Until)
+	}
+	if f.Class != "" {
+		table, err := tableOfClass(db, f.Class)
+		if err != nil {
+			return q, err
+		}
+		q = q.Where("?TableAlias.id IN (SELECT commit_id FROM ?)", bun.Ident(table))
+	}
+	if f.Mrid != uuid.Nil {
+		var class string
+		err := db.NewSelect().Model((*models.Entity)(nil)).Column("entity_type").Where("mrid = ?", f.Mrid).Limit(1).Scan(ctx, &class)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Nothing has touched an object that does not exist
+			return q.Where("1 = 0"), nil
+		}
+		if err != nil {
+			return q, fmt.Errorf("Failed to find type of %s: %w", f.Mrid, err)
+		}
+		table, err := tableOfClass(db, class)
+		if err != nil {
+			return q, err
+		}
+		q = q.Where("?TableAlias.id IN (SELECT commit_id FROM ? WHERE mrid = ?)", bun.Ident(table), f.Mrid)
+	}
+	return q, nil
+}
+
+// Commits returns a page of the commits matching the filter, newest first, together with the number
+// of objects each commit added, changed and deleted per class
+func Commits(ctx context.Context, db *bun.DB, filter CommitLogFilter) (CommitLog, error) {
+	filter.normalize()
+	result := CommitLog{Commits: []CommitLogEntry{}, Page: filter.Page, PageSize: filter.PageSize, Filter: filter}
+
+	var commits []models.Commit
+	q, err := filter.apply(ctx, db, db.NewSelect().Model(&commits))
+	if err != nil {
+		return result, err
+	}
+
+	result.Total, err = q.
+		OrderExpr("?TableAlias.id DESC").
+		Limit(filter.PageSize).
+		Offset((filter.Page - 1) * filter.PageSize).
+		ScanAndCount(ctx)
+	if err != nil {
+		return result, fmt.Errorf("Failed to fetch commits: %w", err)
+	}
+	if len(commits) == 0 {
+		return result, nil
+	}
+
+	ids := make([]int64, len(commits))
+	for i, commit := range commits {
+		ids[i] = commit.Id
+	}
+	stats, err := CommitStats(ctx, db, ids)
+	if err != nil {
+		return result, err
+	}
+
+	for _, commit := range commits {
+		entry := CommitLogEntry{Commit: commit, Stats: stats[commit.Id]}
+		if entry.Stats == nil {
+			entry.Stats = []ClassStats{}
+		}
+		result.Commits = append(result.Commits, entry)
+	}
+	return result, nil
+}
+
+type versionRow struct {
+	Mrid     uuid.UUID `bun:"mrid"`
+	CommitId int64     `bun:"commit_id"`
+	Deleted  bool      `bun:"deleted"`
+}
+
+// CommitStats counts per class the objects each commit added, changed and deleted. A version is
+// counted as added when it is the first version of the object.
+func CommitStats(ctx context.Context, db *bun.DB, commitIds []int64) (map[int64][]ClassStats, error) {
+	result := make(map[int64][]ClassStats)
+	formTypes := FormTypes()
+	for _, class := range slices.Sorted(Keys(formTypes)) {
+		if _, ok := formTypes[class].(models.VersionedIdentifiedObject); !ok {
+			continue
+		}
+		table, err := tableOfClass(db, class)
+		if err != nil {
+			return result, err
+		}
+
+		var rows []versionRow
+		err = db.NewSelect().
+			TableExpr("?", bun.Ident(table)).
+			Column("mrid", "commit_id", "deleted").
+			Where("commit_id IN (?)", bun.In(commitIds)).
+			Scan(ctx, &rows)
+		if err != nil {
+			return result, fmt.Errorf("Failed to fetch versions of %s: %w", class, err)
+		}
+		if len(rows) == 0 {
+			continue
+		}
+
+		mrids := make([]uuid.UUID, len(rows))
+		for i, row := range rows {
+			mrids[i] = row.Mrid
+		}
+		var first []versionRow
+		err = db.NewSelect().
+			TableExpr("?", bun.Ident(table)).
+			ColumnExpr("mrid, MIN(commit_id) AS commit_id").
+			Where("mrid IN (?)", bun.In(mrids)).
+			Group("mrid").
+			Scan(ctx, &first)
+		if err != nil {
+			return result, fmt.Errorf("Failed to fetch first versions of %s: %w", class, err)
+		}
+		created := make(map[uuid.UUID]int64)
+		for _, row := range first {
+			created[row.Mrid] = row.CommitId
+		}
+
+		perCommit := make(map[int64]*ClassStats)
+		for _, row := range rows {
+			stats, ok := perCommit[row.CommitId]
+			if !ok {
+				stats = &ClassStats{Class: class}
+				perCommit[row.CommitId] = stats
+			}
+			switch {
+			case row.Deleted:
+				stats.Deleted++
+			case created[row.Mrid] == row.CommitId:
+				stats.Added++
+			default:
+				stats.Changed++
+			}
+		}
+		for commitId, stats := range perCommit {
+			result[commitId] = append(result[commitId], *stats)
+		}
+	}
+	return result, nil
+}
+
+func WriteCommitLog(w io.Writer, commitLog CommitLog) error {
+	funcs := template.FuncMap{
+		"pageLink": func(page int) template.URL {
+			return template.URL(commitLogQuery(commitLog.Filter, page))
+		},
+		"add": func(a, b int) int { return a + b },
+		"sub": func(a, b int) int { return a - b },
+	}
+	tmpl := template.Must(template.New("commit_log.html").Funcs(funcs).ParseFS(htmlPages, "html/commit_log.html"))
+	return tmpl.Execute(w, commitLog)
+}
+
+// commitLogQuery returns the query string of a commit log page with the filter applied
+func commitLogQuery(filter CommitLogFilter, page int) string {
+	params := url.Values{}
+	params.Set("page", strconv.Itoa(page))
+	params.Set("page-size", strconv.Itoa(filter.PageSize))
+	add := func(key, value string) {
+		if value != "" {
+			params.Set(key, value)
+		}
+	}
+	add("author", filter.Author)
+	add("branch", filter.Branch)
+	add("message", filter.Message)
+	add("class", filter.Class)
+	if !filter.Since.IsZero() {
+		add("since", filter.Since.Format(time.RFC3339))
+	}
+	if !filter.Until.IsZero() {
+		add("until", filter.Until.Format(time.RFC3339))
+	}
+	if filter.Mrid != uuid.Nil {
+		add("mrid", filter.Mrid.String())
+	}
+	return "?" + params.Encode()
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"com.github/davidkleiven/tripleworks/migrations"
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommits(t *testing.T) {
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	ctx := context.Background()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	insert := func(commit models.Commit, items ...any) {
+		require.NoError(t, InsertAll(ctx, db, commit, slices.Values(items), NoOpOnInsert))
+	}
+
+	first, second := uuid.New(), uuid.New()
+	entity := models.Entity{Mrid: first, EntityType: "BaseVoltage"}
+	insert(models.Commit{Author: "alice", Message: "Add base voltages"}, &entity, baseVoltageVersion(first, "first", 132.0), baseVoltageVersion(second, "second", 220.0))
+
+	removed := baseVoltageVersion(second, "second", 220.0)
+	removed.Deleted = true
+	insert(models.Commit{Author: "bob", Message: "Upgrade first"}, baseVoltageVersion(first, "first", 300.0), removed)
+	insert(models.Commit{Author: "alice", Message: "Unrelated"}, baseVoltageVersion(uuid.New(), "third", 400.0))
+
+	t.Run("newest first with stats", func(t *testing.T) {
+		log, err := Commits(ctx, db, CommitLogFilter{})
+		require.NoError(t, err)
+		require.Equal(t, 3, log.Total)
+		require.Equal(t, []string{"Unrelated", "Upgrade first", "Add base voltages"}, []string{log.Commits[0].Message, log.Commits[1].Message, log.Commits[2].Message})
+		require.Equal(t, []ClassStats{{Class: "BaseVoltage", Changed: 1, Deleted: 1}}, log.Commits[1].Stats)
+		require.Equal(t, []ClassStats{{Class: "BaseVoltage", Added: 2}}, log.Commits[2].Stats)
+	})
+
+	for _, test := range []struct {
+		desc     string
+		filter   CommitLogFilter
+		messages []string
+	}{
+		{desc: "author", filter: CommitLogFilter{Author: "bob"}, messages: []string{"Upgrade first"}},
+		{desc: "message", filter: CommitLogFilter{Message: "BASE"}, messages: []string{"Add base voltages"}},
+		{desc: "mrid", filter: CommitLogFilter{Mrid: first}, messages: []string{"Upgrade first", "Add base voltages"}},
+		{desc: "unknown mrid", filter: CommitLogFilter{Mrid: uuid.New()}, messages: []string{}},
+		{desc: "class", filter: CommitLogFilter{Class: "Substation"}, messages: []string{}},
+		{desc: "branch", filter: CommitLogFilter{Branch: "project"}, messages: []string{}},
+		{desc: "future", filter: CommitLogFilter{Since: time.Now().Add(time.Hour)}, messages: []string{}},
+		{desc: "page", filter: CommitLogFilter{Page: 2, PageSize: 2}, messages: []string{"Add base voltages"}},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			log, err := Commits(ctx, db, test.filter)
+			require.NoError(t, err)
+			messages := []string{}
+			for _, commit := range log.Commits {
+				messages = append(messages, commit.Message)
+			}
+			require.Equal(t, test.messages, messages)
+		})
+	}
+
+	t.Run("unknown class", func(t *testing.T) {
+		_, err := Commits(ctx, db, CommitLogFilter{Class: "NotAClass"})
+		require.Error(t, err)
+	})
+
+	t.Run("timeline", func(t *testing.T) {
+		log, err := Commits(ctx, db, CommitLogFilter{PageSize: 1, Author: "alice"})
+		require.NoError(t, err)
+		require.Equal(t, 2, log.NumPages())
+
+		var buf bytes.Buffer
+		require.NoError(t, WriteCommitLog(&buf, log))
+		require.Contains(t, buf.String(), "Unrelated")
+		require.Contains(t, buf.String(), "?author=alice&amp;page=2&amp;page-size=1")
+	})
+}
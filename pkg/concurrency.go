@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+)
+
+// ConflictError is returned when an object was changed by someone else after the version
+// an edit is based on was loaded
+type ConflictError struct {
+	Mrid         uuid.UUID     `json:"mrid"`
+	BaseCommitId int64         `json:"base_commit_id"`
+	HeadCommitId int64         `json:"head_commit_id"`
+	Changes      []FieldChange `json:"changes"`
+}
+
+func (c *ConflictError) Error() string {
+	return fmt.Sprintf("Object %s was changed by commit %d after base commit %d", c.Mrid, c.HeadCommitId, c.BaseCommitId)
+}
+
+// conflictIfMoved returns a ConflictError if head is not the version stored by the base commit.
+// The conflict lists the fields that changed from the base version to head.
+func conflictIfMoved(ctx context.Context, reader repository.VersionReader, head models.VersionedIdentifiedObject, baseCommitId int64) error {
+	if int64(head.GetCommitId()) == baseCommitId {
+		return nil
+	}
+
+	base := reflect.New(reflect.TypeOf(head).Elem()).Interface()
+	if err := reader.AtCommit(ctx, base, head.GetMrid(), baseCommitId); err != nil {
+		base = nil
+	}
+	return &ConflictError{
+		Mrid:         head.GetMrid(),
+		BaseCommitId: baseCommitId,
+		HeadCommitId: int64(head.GetCommitId()),
+		Changes:      FieldChanges(base, head),
+	}
+}
+
+// CheckBaseCommit verifies that the newest version of the object is the version stored by the base commit.
+// A base commit of zero means that the edit is not based on a stored version and is never in conflict.
+func CheckBaseCommit(ctx context.Context, reader repository.VersionReader, model any, mrid uuid.UUID, baseCommitId int64) error {
+	if baseCommitId == 0 {
+		return nil
+	}
+
+	head := reflect.New(reflect.TypeOf(model).Elem()).Interface()
+	err := reader.Head(ctx, head, mrid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &ConflictError{Mrid: mrid, BaseCommitId: baseCommitId, Changes: []FieldChange{}}
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to read newest version of %s: %w", mrid, err)
+	}
+
+	versioned, ok := head.(models.VersionedIdentifiedObject)
+	if !ok {
+		return nil
+	}
+	return conflictIfMoved(ctx, reader, versioned, baseCommitId)
+}
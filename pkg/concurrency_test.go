@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckBaseCommit(t *testing.T) {
+	mrid := uuid.New()
+	version := func(commitId int, name string) *models.BaseVoltage {
+		bv := baseVoltageVersion(mrid, name, 132.0)
+		bv.CommitId = commitId
+		return bv
+	}
+	reader := repository.InMemVersionReader{Items: []any{version(1, "first"), version(3, "second")}}
+	ctx := context.Background()
+
+	t.Run("no base commit", func(t *testing.T) {
+		require.NoError(t, CheckBaseCommit(ctx, &reader, &models.BaseVoltage{}, mrid, 0))
+	})
+
+	t.Run("base is newest version", func(t *testing.T) {
+		require.NoError(t, CheckBaseCommit(ctx, &reader, &models.BaseVoltage{}, mrid, 3))
+	})
+
+	t.Run("newer version exists", func(t *testing.T) {
+		err := CheckBaseCommit(ctx, &reader, &models.BaseVoltage{}, mrid, 1)
+		var conflict *ConflictError
+		require.True(t, errors.As(err, &conflict))
+		require.Equal(t, int64(3), conflict.HeadCommitId)
+		require.Equal(t, []FieldChange{{Field: "name", Old: "first", New: "second"}}, conflict.Changes)
+	})
+
+	t.Run("object does not exist", func(t *testing.T) {
+		err := CheckBaseCommit(ctx, &reader, &models.BaseVoltage{}, uuid.New(), 1)
+		var conflict *ConflictError
+		require.True(t, errors.As(err, &conflict))
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		failing := repository.InMemVersionReader{Items: reader.Items, Err: errors.New("what?")}
+		err := CheckBaseCommit(ctx, &failing, &models.BaseVoltage{}, mrid, 1)
+		require.Error(t, err)
+		var conflict *ConflictError
+		require.False(t, errors.As(err, &conflict))
+	})
+}
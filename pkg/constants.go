@@ -1,7 +1,12 @@
 package pkg
 
 const (
-	ContentTypeJSON = "application/json"
-	ContentTypeHTML = "text/html"
-	ContentType     = "Content-Type"
+	ContentTypeJSON       = "application/json"
+	ContentTypeHTML       = "text/html"
+	ContentTypeMergePatch = "application/merge-patch+json"
+	ContentTypeNDJSON     = "application/x-ndjson"
+	ContentTypeCSV        = "text/csv"
+	ContentTypeGeoJSON    = "application/geo+json"
+	ContentTypeRdfXml     = "application/rdf+xml"
+	ContentType           = "Content-Type"
 )
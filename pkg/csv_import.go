@@ -0,0 +1,269 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type unitScale struct {
+	base   string
+	factor float64
+}
+
+// Unit suffixes accepted in spreadsheets, keyed by lower case symbol, with their factor to the unit of the
+// light formats
+var csvUnits = map[string]unitScale{
+	"v":    {base: "kV", factor: 1e-3},
+	"kv":   {base: "kV", factor: 1.0},
+	"m":    {base: "km", factor: 1e-3},
+	"km":   {base: "km", factor: 1.0},
+	"kw":   {base: "MW", factor: 1e-3},
+	"mw":   {base: "MW", factor: 1.0},
+	"gw":   {base: "MW", factor: 1e3},
+	"kva":  {base: "MVA", factor: 1e-3},
+	"mva":  {base: "MVA", factor: 1.0},
+	"gva":  {base: "MVA", factor: 1e3},
+	"kvar": {base: "MVAr", factor: 1e-3},
+	"mvar": {base: "MVAr", factor: 1.0},
+	"a":    {base: "A", factor: 1.0},
+	"ka":   {base: "A", factor: 1e3},
+	"%":    {base: "%", factor: 1.0},
+}
+
+var (
+	quantityRe   = regexp.MustCompile(`^([-+]?(?:[0-9]+(?:[.,][0-9]*)?|[.,][0-9]+)(?:[eE][-+]?[0-9]+)?)\s*(\S*)$`)
+	headerUnitRe = regexp.MustCompile(`^(.*?)\s*[\[(]([^\])]*)[\])]$`)
+)
+
+// normalizeColumn makes column names comparable, such that "Max P" matches the json field maxP
+func normalizeColumn(name string) string {
+	return strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(strings.TrimSpace(name)))
+}
+
+// parseQuantity parses a number with an optional unit suffix and expresses it in the unit of the field.
+// A decimal comma is only accepted when decimalComma is set, since it can not be told apart from a
+// thousands separator in documents delimited by commas.
+func parseQuantity(value string, fieldUnit string, defaultUnit string, decimalComma bool) (float64, error) {
+	match := quantityRe.FindStringSubmatch(value)
+	if match == nil {
+		return 0, fmt.Errorf("%s is not a number", value)
+	}
+	if !decimalComma && strings.Contains(match[1], ",") {
+		return 0, fmt.Errorf("%s is not a number, a decimal comma is only accepted when columns are separated by ; or tabs", value)
+	}
+
+	// Spreadsheets in many locales use a decimal comma
+	number, err := strconv.ParseFloat(strings.Replace(match[1], ",", ".", 1), 64)
+	if err != nil {
+		return 0, err
+	}
+
+	unit := match[2]
+	if unit == "" {
+		unit = defaultUnit
+	}
+	if unit == "" {
+		return number, nil
+	}
+	if fieldUnit == "" {
+		return 0, fmt.Errorf("Unexpected unit %s", unit)
+	}
+	scale, ok := csvUnits[strings.ToLower(unit)]
+	if !ok || scale.base != fieldUnit {
+		return 0, fmt.Errorf("Can not convert %s to %s", unit, fieldUnit)
+	}
+	return number * scale.factor, nil
+}
+
+type csvColumn struct {
+	name  string
+	field string
+	kind  reflect.Kind
+	unit  string
+
+	// Unit given in the header, such as "Voltage (kV)", used for values without a suffix
+	headerUnit string
+	skip       bool
+}
+
+// CsvScanner reads a CSV document with a header row and yields every row as the JSON record of a light
+// upload format. It mirrors bufio.Scanner such that it can replace the line scanner of JSONL uploads.
+type CsvScanner struct {
+	reader  *csv.Reader
+	columns []csvColumn
+	line    []byte
+	err     error
+}
+
+func (s *CsvScanner) parseQuantity(column csvColumn, cell string) (float64, error) {
+	return parseQuantity(cell, column.unit, column.headerUnit, s.reader.Comma != ',')
+}
+
+// detectComma picks the most frequent of the delimiters used by spreadsheet exports in the header line
+func detectComma(header string) rune {
+	comma := ','
+	for _, candidate := range []rune{';', '\t'} {
+		if strings.Count(header, string(candidate)) > strings.Count(header, string(comma)) {
+			comma = candidate
+		}
+	}
+	return comma
+}
+
+// NewCsvScanner reads the header of the document and maps the columns onto the json fields of prototype.
+// Aliases map column names onto json field names, an alias of "-" ignores the column as do columns without a name.
+func NewCsvScanner(r io.Reader, prototype any, aliases map[string]string) (*CsvScanner, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.ReadBytes('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("Failed to read header row: %w", err)
+	}
+
+	// Excel prefixes UTF-8 exports with a byte order mark
+	header = bytes.TrimPrefix(header, []byte("\xef\xbb\xbf"))
+
+	reader := csv.NewReader(io.MultiReader(bytes.NewReader(header), buffered))
+	reader.Comma = detectComma(string(header))
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	names, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read header row: %w", err)
+	}
+
+	fields := make(map[string]csvColumn)
+	t := reflect.TypeOf(prototype)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		fields[normalizeColumn(name)] = csvColumn{field: name, kind: field.Type.Kind(), unit: field.Tag.Get("unit")}
+	}
+
+	normalizedAliases := make(map[string]string)
+	for column, field := range aliases {
+		normalizedAliases[normalizeColumn(column)] = field
+	}
+
+	scanner := CsvScanner{reader: reader}
+	mapped := make(map[string]string)
+	for i, name := range names {
+		column := csvColumn{name: name}
+		title := name
+		if match := headerUnitRe.FindStringSubmatch(name); match != nil {
+			title, column.headerUnit = match[1], strings.TrimSpace(match[2])
+		}
+
+		target := title
+		if alias, ok := normalizedAliases[normalizeColumn(title)]; ok {
+			target = alias
+		}
+		if target == "-" || strings.TrimSpace(name) == "" {
+			column.skip = true
+			scanner.columns = append(scanner.columns, column)
+			continue
+		}
+
+		field, ok := fields[normalizeColumn(target)]
+		if !ok {
+			return nil, fmt.Errorf("Row 1, column %d (%s): Unknown column, map it onto a field with an alias", i+1, name)
+		}
+		if previous, ok := mapped[field.field]; ok {
+			return nil, fmt.Errorf("Row 1, column %d (%s): Field %s is already given by column %s", i+1, name, field.field, previous)
+		}
+		if column.headerUnit != "" {
+			if _, err := parseQuantity("1", field.unit, column.headerUnit, false); err != nil {
+				return nil, fmt.Errorf("Row 1, column %d (%s): %w", i+1, name, err)
+			}
+		}
+		mapped[field.field] = name
+		column.field, column.kind, column.unit = field.field, field.kind, field.unit
+		scanner.columns = append(scanner.columns, column)
+	}
+	return &scanner, nil
+}
+
+func (s *CsvScanner) value(column csvColumn, cell string) (any, error) {
+	switch column.kind {
+	case reflect.String:
+		return cell, nil
+	case reflect.Bool:
+		return strconv.ParseBool(strings.ToLower(cell))
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		number, err := s.parseQuantity(column, cell)
+		if err != nil {
+			return nil, err
+		}
+		if number != math.Trunc(number) {
+			return nil, fmt.Errorf("Expected a whole number, got %v", number)
+		}
+		return int(number), nil
+	case reflect.Float64, reflect.Float32:
+		return s.parseQuantity(column, cell)
+	}
+	return nil, fmt.Errorf("Unsupported field type %s", column.kind)
+}
+
+// Scan advances to the next non-empty row. It returns false at the end of the document or on errors.
+func (s *CsvScanner) Scan() bool {
+	for {
+		record, err := s.reader.Read()
+		if errors.Is(err, io.EOF) {
+			return false
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			s.err = fmt.Errorf("Row %d, column %d: %w", parseErr.Line, parseErr.Column, parseErr.Err)
+			return false
+		}
+		if err != nil {
+			s.err = err
+			return false
+		}
+
+		object := make(map[string]any)
+		for i, cell := range record {
+			cell = strings.TrimSpace(cell)
+			column := s.columns[i]
+			if column.skip || cell == "" {
+				continue
+			}
+			value, err := s.value(column, cell)
+			if err != nil {
+				row, _ := s.reader.FieldPos(i)
+				s.err = fmt.Errorf("Row %d, column %d (%s): %w", row, i+1, column.name, err)
+				return false
+			}
+			object[column.field] = value
+		}
+
+		// Spreadsheet exports often end with rows where every cell is empty
+		if len(object) == 0 {
+			continue
+		}
+		s.line, s.err = json.Marshal(object)
+		return s.err == nil
+	}
+}
+
+// Bytes returns the JSON record of the current row
+func (s *CsvScanner) Bytes() []byte {
+	return s.line
+}
+
+func (s *CsvScanner) Err() error {
+	return s.err
+}
@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func scanCsv(t *testing.T, document string, prototype any, aliases map[string]string) ([]string, error) {
+	t.Helper()
+	scanner, err := NewCsvScanner(strings.NewReader(document), prototype, aliases)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, string(scanner.Bytes()))
+	}
+	return lines, scanner.Err()
+}
+
+func TestParseQuantity(t *testing.T) {
+	for _, test := range []struct {
+		value       string
+		fieldUnit   string
+		defaultUnit string
+		want        float64
+	}{
+		{value: "300", fieldUnit: "kV", want: 300.0},
+		{value: "300 kV", fieldUnit: "kV", want: 300.0},
+		{value: "132000V", fieldUnit: "kV", want: 132.0},
+		{value: "32,5 km", fieldUnit: "km", want: 32.5},
+		{value: "1500", fieldUnit: "km", defaultUnit: "m", want: 1.5},
+		{value: "1.2e3 kW", fieldUnit: "MW", want: 1.2},
+		{value: "-50 MVAr", fieldUnit: "MVAr", want: -50.0},
+		{value: "12 %", fieldUnit: "%", want: 12.0},
+	} {
+		got, err := parseQuantity(test.value, test.fieldUnit, test.defaultUnit, true)
+		require.NoError(t, err, test.value)
+		require.InDelta(t, test.want, got, 1e-9, test.value)
+	}
+
+	for _, test := range []struct {
+		value     string
+		fieldUnit string
+		msg       string
+	}{
+		{value: "abc", fieldUnit: "kV", msg: "not a number"},
+		{value: "32 km", fieldUnit: "kV", msg: "Can not convert km to kV"},
+		{value: "32 km", fieldUnit: "", msg: "Unexpected unit"},
+	} {
+		_, err := parseQuantity(test.value, test.fieldUnit, "", true)
+		require.ErrorContains(t, err, test.msg, test.value)
+	}
+
+	_, err := parseQuantity("1,000", "MW", "", false)
+	require.ErrorContains(t, err, "decimal comma is only accepted")
+}
+
+func TestCsvScanner(t *testing.T) {
+	document := "\xef\xbb\xbfFrom;To;Length [km];Voltage;Comment\n" +
+		"Sub A;Sub B;32,5;300 kV;new line\n" +
+		"Sub B;Sub C;1500 m;420;\n" +
+		";;;;\n"
+
+	lines, err := scanCsv(t, document, &LineLight{}, map[string]string{"Comment": "-"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(lines))
+
+	var line LineLight
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &line))
+	require.Equal(t, LineLight{FromSubstation: "Sub A", ToSubstation: "Sub B", Length: 32.5, Voltage: 300}, line)
+
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &line))
+	require.Equal(t, 1.5, line.Length)
+
+	t.Run("aliases", func(t *testing.T) {
+		document := "Stasjon,Effekt (kW),Spenning,Nummer\nSub A,2500,132,1\n"
+		aliases := map[string]string{"stasjon": "substation", "Effekt": "nominalP", "Spenning": "voltage", "Nummer": "num"}
+		lines, err := scanCsv(t, document, &LoadLight{}, aliases)
+		require.NoError(t, err)
+
+		var load LoadLight
+		require.NoError(t, json.Unmarshal([]byte(lines[0]), &load))
+		require.Equal(t, LoadLight{Substation: "Sub A", Num: 1, NominalP: 2.5, Voltage: 132}, load)
+	})
+
+	for _, test := range []struct {
+		desc     string
+		document string
+		msg      string
+	}{
+		{desc: "unknown column", document: "substation,num,owner\n", msg: "Row 1, column 3 (owner): Unknown column"},
+		{desc: "duplicate column", document: "voltage,Voltage\n", msg: "Row 1, column 2 (Voltage): Field voltage is already given"},
+		{desc: "header unit", document: "voltage (km)\n", msg: "Row 1, column 1 (voltage (km)): Can not convert km to kV"},
+		{desc: "invalid number", document: "substation,maxP\nSub A,10\nSub B,lots\n", msg: "Row 3, column 2 (maxP): lots is not a number"},
+		{desc: "thousands separator", document: "substation,maxP\nSub A,\"1,000\"\n", msg: "Row 2, column 2 (maxP): 1,000 is not a number"},
+		{desc: "fraction in whole number", document: "substation,voltage\nSub A,400 V\n", msg: "Row 2, column 2 (voltage): Expected a whole number"},
+		{desc: "missing cells", document: "substation,voltage\nSub A\n", msg: "Row 2"},
+		{desc: "empty", document: "", msg: "Failed to read header row"},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := scanCsv(t, test.document, &GeneratorLight{}, nil)
+			require.ErrorContains(t, err, test.msg)
+		})
+	}
+}
@@ -25,13 +25,33 @@ type DataStore struct {
 	Db *bun.DB
 }
 
+// AnyModel can be passed as model id to read objects regardless of the model they belong to
+const AnyModel = -1
+
+// ModelOf returns the model in the context, or AnyModel if the context does not select a model
+func ModelOf(ctx context.Context) int {
+	if modelId, ok := repository.ModelFromCtx(ctx); ok {
+		return modelId
+	}
+	return AnyModel
+}
+
+// FindAll returns the latest version of all items in the model as seen from the branch and as-of commit
+// in the context. The passed model replaces any model in the context, and AnyModel lifts the restriction.
 func FindAll[T any](db *bun.DB, ctx context.Context, modelId int) ([]T, error) {
-	_ = modelId // TODO: add multi model support later
+	ctx = withModelScope(ctx, modelId)
 
 	repo := repository.BunReadRepository[T]{Db: db, UseLatestView: true}
 	return repo.List(ctx)
 }
 
+func withModelScope(ctx context.Context, modelId int) context.Context {
+	if modelId == AnyModel {
+		return repository.WithoutModel(ctx)
+	}
+	return repository.WithModel(ctx, modelId)
+}
+
 func FindNameAndMrid[T models.VersionedObject](db *bun.DB, ctx context.Context, modelId int) ([]models.VersionedObject, error) {
 	result, err := FindAll[T](db, ctx, modelId)
 	if err != nil {
@@ -188,6 +208,9 @@ func NoOpOnInsert(v any) error {
 
 func InsertAll(ctx context.Context, db *bun.DB, commit models.Commit, items iter.Seq[any], onInsert func(v any) error) error {
 	commit.CreatedAt = time.Now()
+	if commit.Branch == "" {
+		commit.Branch = repository.BranchFromCtx(ctx)
+	}
 	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
 		_, err := tx.NewInsert().Model(&commit).Exec(ctx)
 		if err != nil {
@@ -213,7 +236,31 @@ func InsertAll(ctx context.Context, db *bun.DB, commit models.Commit, items iter
 }
 
 func InsertAllInserter(ctx context.Context, inserter repository.Inserter, commit models.Commit, items iter.Seq[any], onInsert func(v any) error) error {
+	return InsertAllWithHooks(ctx, inserter, commit, items, onInsert, TxHooks{})
+}
+
+// TxHooks run in the transaction of an insert. Before runs ahead of the first insert and After once
+// all items are inserted. The insert is rolled back if any of them fails.
+type TxHooks struct {
+	Before func(ctx context.Context, inserter repository.Inserter) error
+	After  func(ctx context.Context, inserter repository.Inserter) error
+}
+
+// InsertAllWithHooks works like InsertAllInserter and runs the hooks in the same transaction as the insert
+func InsertAllWithHooks(ctx context.Context, inserter repository.Inserter, commit models.Commit, items iter.Seq[any], onInsert func(v any) error, hooks TxHooks) error {
+	if commit.Branch == "" {
+		commit.Branch = repository.BranchFromCtx(ctx)
+	}
+	if commit.CreatedAt.IsZero() {
+		commit.CreatedAt = time.Now()
+	}
 	insertFn := func(ctx context.Context, inserter repository.Inserter) error {
+		if hooks.Before != nil {
+			if err := hooks.Before(ctx, inserter); err != nil {
+				return err
+			}
+		}
+
 		err := inserter.Insert(ctx, &commit)
 		if err != nil {
 			return fmt.Errorf("Failed to insert commit: %w", err)
@@ -233,6 +280,10 @@ func InsertAllInserter(ctx context.Context, inserter repository.Inserter, commit
 			num++
 		}
 		slog.InfoContext(ctx, "Inserted records into the database", "num", num)
+
+		if hooks.After != nil {
+			return hooks.After(ctx, inserter)
+		}
 		return nil
 	}
 	insertFnTx := repository.WithTx(insertFn)
@@ -290,11 +341,12 @@ func LinesConnectedToSubstationByName(ctx context.Context, db *bun.DB, substatio
 	// Collect line and substations that matches the name of the target substation
 	failNo, err := ReturnOnFirstError(
 		func() error {
-			return db.NewSelect().Model(&lines).Where("? LIKE ?", bun.Ident("name"), fmt.Sprintf("%%%s%%", substation.Name)).Scan(ctx)
+			return db.NewSelect().Model(&lines).Apply(repository.OnBranch(ctx)).Where("? LIKE ?", bun.Ident("name"), fmt.Sprintf("%%%s%%", substation.Name)).Scan(ctx)
 		},
 		func() error {
 			return db.NewSelect().
 				Model(&substations).
+				Apply(repository.OnBranch(ctx)).
 				Where("? LIKE ?", bun.Ident("name"), fmt.Sprintf("%%%s%%", substation.Name)).
 				Scan(ctx)
 		},
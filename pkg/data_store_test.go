@@ -9,6 +9,7 @@ import (
 
 	"com.github/davidkleiven/tripleworks/migrations"
 	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 	"github.com/uptrace/bun"
@@ -54,15 +55,75 @@ func TestFindAll(t *testing.T) {
 	_, err = db.NewInsert().Model(&bv).Exec(ctx)
 	require.NoError(t, err)
 
-	items, err := FindAll[models.BaseVoltage](db, ctx, 0)
+	items, err := FindAll[models.BaseVoltage](db, ctx, AnyModel)
 	require.NoError(t, err)
 	require.Equal(t, 1, len(items))
 
-	nameAndMrid, err := FindNameAndMrid[models.BaseVoltage](db, ctx, 0)
+	nameAndMrid, err := FindNameAndMrid[models.BaseVoltage](db, ctx, AnyModel)
 	require.NoError(t, err)
 	require.Equal(t, 1, len(nameAndMrid))
 }
 
+func TestFindAllInModel(t *testing.T) {
+	ctx := context.Background()
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	transmission := baseVoltageVersion(uuid.New(), "transmission", 420.0)
+	distribution := baseVoltageVersion(uuid.New(), "distribution", 22.0)
+	items := []any{
+		transmission, &models.Entity{Mrid: transmission.Mrid, EntityType: "BaseVoltage", ModelEntity: models.ModelEntity{ModelId: 1}},
+		distribution, &models.Entity{Mrid: distribution.Mrid, EntityType: "BaseVoltage", ModelEntity: models.ModelEntity{ModelId: 2}},
+	}
+	require.NoError(t, InsertAll(ctx, db, models.Commit{}, slices.Values(items), NoOpOnInsert))
+
+	names := func(ctx context.Context, modelId int) []string {
+		bvs, err := FindAll[models.BaseVoltage](db, ctx, modelId)
+		require.NoError(t, err)
+		result := []string{}
+		for _, bv := range bvs {
+			result = append(result, bv.Name)
+		}
+		slices.Sort(result)
+		return result
+	}
+
+	require.Equal(t, []string{"distribution", "transmission"}, names(ctx, AnyModel))
+	require.Equal(t, []string{"transmission"}, names(ctx, 1))
+	require.Equal(t, []string{"distribution"}, names(repository.WithModel(ctx, 1), 2))
+	require.Equal(t, []string{"distribution", "transmission"}, names(repository.WithModel(ctx, 1), AnyModel))
+	require.Empty(t, names(ctx, 3))
+
+	// Model zero is selected like any other model and does not mean all models
+	require.Empty(t, names(ctx, 0))
+	require.Empty(t, names(repository.WithModel(ctx, 0), 0))
+	require.Equal(t, 2, ModelOf(repository.WithModel(ctx, 2)))
+	require.Equal(t, AnyModel, ModelOf(ctx))
+
+	t.Run("raw queries", func(t *testing.T) {
+		query := repository.ScopeLatestViews(repository.WithModel(ctx, 1), db, "SELECT name FROM v_base_voltages_latest")
+		var result []string
+		require.NoError(t, db.NewRaw(query).Scan(ctx, &result))
+		require.Equal(t, []string{"transmission"}, result)
+	})
+
+	t.Run("membership registered after as-of", func(t *testing.T) {
+		var asOf int64
+		require.NoError(t, db.NewSelect().TableExpr("commits").ColumnExpr("MAX(id)").Scan(ctx, &asOf))
+
+		// Register an existing object in a model by a later commit
+		later := baseVoltageVersion(uuid.New(), "later", 33.0)
+		require.NoError(t, InsertAll(ctx, db, models.Commit{}, slices.Values([]any{later}), NoOpOnInsert))
+		member := []any{&models.Entity{Mrid: later.Mrid, EntityType: "BaseVoltage", ModelEntity: models.ModelEntity{ModelId: 1}}}
+		require.NoError(t, InsertAll(ctx, db, models.Commit{}, slices.Values(member), NoOpOnInsert))
+
+		require.Equal(t, []string{"later", "transmission"}, names(ctx, 1))
+		require.Equal(t, []string{"transmission"}, names(repository.WithAsOf(ctx, asOf+1), 1))
+		require.Equal(t, []string{"distribution", "later", "transmission"}, names(repository.WithAsOf(ctx, asOf+1), AnyModel))
+	})
+}
+
 func TestFailedToFetchAllError(t *testing.T) {
 	ctx := context.Background()
 	db := NewTestConfig().DatabaseConnection()
@@ -135,7 +196,7 @@ func TestFilteredFinder(t *testing.T) {
 	t.Run("IdentifiedObject", func(t *testing.T) {
 		finder, err := GetFinder("IdentifiedObject", "", "")
 		require.NoError(t, err)
-		result, err := finder(ctx, db, 0)
+		result, err := finder(ctx, db, AnyModel)
 		require.NoError(t, err)
 		require.Equal(t, 1, len(result))
 	})
@@ -143,7 +204,7 @@ func TestFilteredFinder(t *testing.T) {
 	t.Run("all unfiltered", func(t *testing.T) {
 		finder, err := GetFinder("all", "", "")
 		require.NoError(t, err)
-		result, err := finder(ctx, db, 0)
+		result, err := finder(ctx, db, AnyModel)
 		require.NoError(t, err)
 		require.Equal(t, 100, len(result))
 	})
@@ -151,7 +212,7 @@ func TestFilteredFinder(t *testing.T) {
 	t.Run("all name filtered", func(t *testing.T) {
 		finder, err := GetFinder("all", "my", "")
 		require.NoError(t, err)
-		result, err := finder(ctx, db, 0)
+		result, err := finder(ctx, db, AnyModel)
 		require.NoError(t, err)
 		require.Equal(t, 1, len(result))
 	})
@@ -159,7 +220,7 @@ func TestFilteredFinder(t *testing.T) {
 	t.Run("all name filtered no result", func(t *testing.T) {
 		finder, err := GetFinder("all", "base voltage", "")
 		require.NoError(t, err)
-		result, err := finder(ctx, db, 0)
+		result, err := finder(ctx, db, AnyModel)
 		require.NoError(t, err)
 		require.Equal(t, 0, len(result))
 	})
@@ -167,7 +228,7 @@ func TestFilteredFinder(t *testing.T) {
 	t.Run("all type filtered", func(t *testing.T) {
 		finder, err := GetFinder("all", "", "ident")
 		require.NoError(t, err)
-		result, err := finder(ctx, db, 0)
+		result, err := finder(ctx, db, AnyModel)
 		require.NoError(t, err)
 		require.Equal(t, 1, len(result))
 	})
@@ -175,7 +236,7 @@ func TestFilteredFinder(t *testing.T) {
 	t.Run("all type filtered no result", func(t *testing.T) {
 		finder, err := GetFinder("all", "", "BaseVoltage")
 		require.NoError(t, err)
-		result, err := finder(ctx, db, 0)
+		result, err := finder(ctx, db, AnyModel)
 		require.NoError(t, err)
 		require.Equal(t, 0, len(result))
 	})
@@ -215,6 +276,30 @@ func TestCommitInsertAllStopWhenCallbackFails(t *testing.T) {
 	require.ErrorContains(t, err, "went wrong")
 }
 
+func TestInsertAllWithHooks(t *testing.T) {
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	ctx := context.Background()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	bv := baseVoltageVersion(uuid.New(), "bv", 132.0)
+	entity := MakeEntity(bv, 0)
+	inserter := repository.BunInserter{Db: db}
+
+	var inTx bool
+	before := func(ctx context.Context, inserter repository.Inserter) error {
+		_, inTx = inserter.(repository.VersionReaderProvider).Versions().(*repository.BunVersionReader).Db.(bun.Tx)
+		return errors.New("stale")
+	}
+	err = InsertAllWithHooks(ctx, &inserter, models.Commit{}, slices.Values([]any{&entity, bv}), NoOpOnInsert, TxHooks{Before: before})
+	require.ErrorContains(t, err, "stale")
+	require.True(t, inTx)
+
+	num, err := db.NewSelect().Model((*models.Commit)(nil)).Count(ctx)
+	require.NoError(t, err)
+	require.Zero(t, num)
+}
+
 func TestMridIfPossible(t *testing.T) {
 	var substation models.Substation
 	substation.Mrid = uuid.New()
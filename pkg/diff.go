@@ -0,0 +1,187 @@
+package pkg
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+const (
+	ChangeAdded    = "added"
+	ChangeDeleted  = "deleted"
+	ChangeModified = "modified"
+)
+
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+type ObjectDiff struct {
+	Mrid   uuid.UUID     `json:"mrid"`
+	Type   string        `json:"type"`
+	Name   string        `json:"name"`
+	Change string        `json:"change"`
+	Fields []FieldChange `json:"fields"`
+}
+
+type CommitDiff struct {
+	From    int64        `json:"from"`
+	To      int64        `json:"to"`
+	Objects []ObjectDiff `json:"objects"`
+}
+
+// DiffFields returns the values of all data fields of an object keyed by their json name.
+// The fields are collected with FlattenStruct, such that they match the fields in the edit forms.
+// Version bookkeeping, relations and the deleted flag are left out.
+func DiffFields(v any) map[string]any {
+	result := make(map[string]any)
+	if rv := reflect.ValueOf(v); !rv.IsValid() || (rv.Kind() == reflect.Ptr && rv.IsNil()) {
+		return result
+	}
+
+	for name, field := range FlattenStruct(v) {
+		if field.IsBunRelation || name == "Deleted" || slices.Contains(versionFields, name) {
+			continue
+		}
+		jsonName := strings.Split(field.JsonTag, ",")[0]
+		if jsonName == "-" {
+			continue
+		}
+		if jsonName == "" {
+			jsonName = name
+		}
+		result[jsonName] = field.Value
+	}
+	return result
+}
+
+// FieldChanges lists the fields that differ between two versions of an object sorted by field name.
+// A nil version is treated as an object without fields.
+func FieldChanges(before, after any) []FieldChange {
+	beforeFields := DiffFields(before)
+	afterFields := DiffFields(after)
+
+	var changes []FieldChange
+	for _, name := range slices.Sorted(Keys(afterFields)) {
+		if old, ok := beforeFields[name]; !ok || !reflect.DeepEqual(old, afterFields[name]) {
+			changes = append(changes, FieldChange{Field: name, Old: beforeFields[name], New: afterFields[name]})
+		}
+	}
+	for _, name := range slices.Sorted(Keys(beforeFields)) {
+		if _, ok := afterFields[name]; !ok {
+			changes = append(changes, FieldChange{Field: name, Old: beforeFields[name]})
+		}
+	}
+	return changes
+}
+
+func activeOrNil(v models.VersionedIdentifiedObject) models.VersionedIdentifiedObject {
+	if deleted, ok := v.(models.DeletedGetter); ok && deleted.GetDeleted() {
+		return nil
+	}
+	return v
+}
+
+func diffObject(kind string, before, after models.VersionedIdentifiedObject) *ObjectDiff {
+	before, after = activeOrNil(before), activeOrNil(after)
+
+	var diff ObjectDiff
+	switch {
+	case before == nil && after == nil:
+		return nil
+	case before == nil:
+		diff = ObjectDiff{Mrid: after.GetMrid(), Change: ChangeAdded, Fields: FieldChanges(nil, after)}
+	case after == nil:
+		diff = ObjectDiff{Mrid: before.GetMrid(), Change: ChangeDeleted, Fields: FieldChanges(before, nil)}
+	default:
+		diff = ObjectDiff{Mrid: after.GetMrid(), Change: ChangeModified, Fields: FieldChanges(before, after)}
+		if len(diff.Fields) == 0 {
+			return nil
+		}
+	}
+
+	diff.Type = kind
+	for _, v := range []any{after, before} {
+		if named, ok := v.(models.NameGetter); ok {
+			diff.Name = named.GetName()
+			break
+		}
+	}
+	return &diff
+}
+
+// DiffCommits lists all objects that differ between the model as of commit from and as of commit to.
+// Only commits visible from the branch in the context are considered.
+func DiffCommits(ctx context.Context, db bun.IDB, from, to int64) (CommitDiff, error) {
+	result := CommitDiff{From: from, To: to, Objects: []ObjectDiff{}}
+	if from > to {
+		return result, fmt.Errorf("From commit %d must not be after to commit %d", from, to)
+	}
+
+	atFrom := func(commitId int) bool { return int64(commitId) <= from }
+	atTo := func(commitId int) bool { return int64(commitId) <= to }
+
+	formTypes := FormTypes()
+	for _, kind := range slices.Sorted(Keys(formTypes)) {
+		itemPtr := formTypes[kind]
+		if _, ok := itemPtr.(models.VersionedIdentifiedObject); !ok {
+			continue
+		}
+
+		touched, err := VersionsOf(ctx, db, itemPtr, func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Apply(repository.OnBranch(ctx)).Where("?TableAlias.commit_id > ? AND ?TableAlias.commit_id <= ?", from, to)
+		})
+		if err != nil {
+			return result, err
+		}
+		if len(touched) == 0 {
+			continue
+		}
+
+		mrids := make([]uuid.UUID, len(touched))
+		for i, item := range touched {
+			mrids[i] = item.GetMrid()
+		}
+
+		versions, err := VersionsOf(ctx, db, itemPtr, func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Apply(repository.OnBranch(ctx)).Where("?TableAlias.mrid IN (?)", bun.In(mrids)).Where("?TableAlias.commit_id <= ?", to)
+		})
+		if err != nil {
+			return result, err
+		}
+
+		byMrid := GroupBy(versions, func(v models.VersionedIdentifiedObject) uuid.UUID { return v.GetMrid() })
+		for _, mrid := range slices.SortedFunc(Keys(byMrid), func(a, b uuid.UUID) int { return cmp.Compare(a.String(), b.String()) }) {
+			diff := diffObject(kind, latestVersion(byMrid[mrid], atFrom), latestVersion(byMrid[mrid], atTo))
+			if diff != nil {
+				result.Objects = append(result.Objects, *diff)
+			}
+		}
+	}
+	return result, nil
+}
+
+func WriteCommitDiff(w io.Writer, diff CommitDiff) error {
+	funcs := template.FuncMap{
+		"display": func(v any) string {
+			if v == nil {
+				return ""
+			}
+			return fmt.Sprintf("%v", v)
+		},
+	}
+	tmpl := template.Must(template.New("commit_diff.html").Funcs(funcs).ParseFS(htmlPages, "html/commit_diff.html"))
+	return tmpl.Execute(w, diff)
+}
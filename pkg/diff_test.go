@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+
+	"com.github/davidkleiven/tripleworks/migrations"
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldChanges(t *testing.T) {
+	mrid := uuid.New()
+	before := baseVoltageVersion(mrid, "bv", 132.0)
+	after := baseVoltageVersion(mrid, "bv", 220.0)
+	after.Id = 10
+	after.CommitId = 5
+
+	changes := FieldChanges(before, after)
+	require.Equal(t, []FieldChange{{Field: "nominal_voltage", Old: 132.0, New: 220.0}}, changes)
+
+	added := FieldChanges(nil, after)
+	require.Contains(t, added, FieldChange{Field: "mrid", New: mrid})
+	for _, change := range added {
+		require.Nil(t, change.Old)
+	}
+}
+
+func TestDiffCommits(t *testing.T) {
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	ctx := context.Background()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	insert := func(items ...any) {
+		require.NoError(t, InsertAll(ctx, db, models.Commit{Message: "test"}, slices.Values(items), NoOpOnInsert))
+	}
+
+	modified, deleted, added := uuid.New(), uuid.New(), uuid.New()
+	insert(baseVoltageVersion(modified, "modified", 132.0), baseVoltageVersion(deleted, "deleted", 132.0))
+
+	removed := baseVoltageVersion(deleted, "deleted", 132.0)
+	removed.Deleted = true
+	insert(baseVoltageVersion(modified, "modified", 220.0), removed, baseVoltageVersion(added, "added", 400.0))
+
+	diff, err := DiffCommits(ctx, db, 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(diff.Objects))
+
+	changes := make(map[uuid.UUID]ObjectDiff)
+	for _, object := range diff.Objects {
+		changes[object.Mrid] = object
+	}
+	require.Equal(t, ChangeModified, changes[modified].Change)
+	require.Equal(t, []FieldChange{{Field: "nominal_voltage", Old: 132.0, New: 220.0}}, changes[modified].Fields)
+	require.Equal(t, ChangeDeleted, changes[deleted].Change)
+	require.Equal(t, ChangeAdded, changes[added].Change)
+	require.Equal(t, "added", changes[added].Name)
+	require.Equal(t, "BaseVoltage", changes[added].Type)
+
+	noChange, err := DiffCommits(ctx, db, 2, 2)
+	require.NoError(t, err)
+	require.Empty(t, noChange.Objects)
+
+	_, err = DiffCommits(ctx, db, 2, 1)
+	require.Error(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteCommitDiff(&buf, diff))
+	require.Contains(t, buf.String(), "nominal_voltage")
+	require.Contains(t, buf.String(), modified.String())
+}
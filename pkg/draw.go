@@ -6,6 +6,7 @@ import (
 	"log/slog"
 
 	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
 	"github.com/google/uuid"
 	"github.com/uptrace/bun"
 	"gonum.org/v1/gonum/graph"
@@ -86,7 +87,7 @@ func CollectSubstationData(ctx context.Context, db *bun.DB, s *models.Substation
 	var result SubstationDiagramData
 	_, overallErr := ReturnOnFirstError(
 		func() error {
-			return db.NewSelect().Model(&result.VoltageLevels).Where("substation_mrid = ?", s.Mrid).Scan(ctx)
+			return db.NewSelect().Model(&result.VoltageLevels).Apply(repository.OnBranch(ctx)).Where("substation_mrid = ?", s.Mrid).Scan(ctx)
 		},
 		func() error {
 			mrids := make([]uuid.UUID, len(result.VoltageLevels))
@@ -95,6 +96,7 @@ func CollectSubstationData(ctx context.Context, db *bun.DB, s *models.Substation
 			}
 			return db.NewSelect().
 				Model(&result.ConnectivityNodes).
+				Apply(repository.OnBranch(ctx)).
 				Relation("ConnectivityNodeContainer").
 				Where("connectivity_node_container_mrid IN (?)", bun.In(mrids)).
 				Scan(ctx)
@@ -106,6 +108,7 @@ func CollectSubstationData(ctx context.Context, db *bun.DB, s *models.Substation
 			}
 			err := db.NewSelect().
 				Model(&result.Terminals).
+				Apply(repository.OnBranch(ctx)).
 				Relation("ConnectivityNode").
 				Relation("ConductingEquipment").
 				Where("connectivity_node_mrid IN (?)", bun.In(mrids)).
@@ -124,6 +127,7 @@ func CollectSubstationData(ctx context.Context, db *bun.DB, s *models.Substation
 			}
 			err := db.NewSelect().
 				Model(&result.ACLineSegments).
+				Apply(repository.OnBranch(ctx)).
 				Where("mrid IN (?)", bun.In(mrids)).Scan(ctx)
 			return err
 		},
@@ -134,6 +138,7 @@ func CollectSubstationData(ctx context.Context, db *bun.DB, s *models.Substation
 			}
 			err := db.NewSelect().
 				Model(&result.SyncMachines).
+				Apply(repository.OnBranch(ctx)).
 				Where("mrid IN (?)", bun.In(mrids)).Scan(ctx)
 			return err
 		},
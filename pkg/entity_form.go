@@ -205,7 +205,11 @@ func FormInputFieldsForType(itemName string) (any, error) {
 
 func FormInputFields(w io.Writer, item any) {
 	checksum := MustGetHash(item)
-	fmt.Fprintf(w, "<div id=\"entity-editor\" class=\"card-content\" checksum=\"%s\">\n", checksum)
+	baseCommitId := 0
+	if versioned, ok := item.(models.VersionedIdentifiedObject); ok {
+		baseCommitId = versioned.GetCommitId()
+	}
+	fmt.Fprintf(w, "<div id=\"entity-editor\" class=\"card-content\" checksum=\"%s\" base-commit-id=\"%d\">\n", checksum, baseCommitId)
 	fields := FlattenStruct(item)
 	fieldNames := make([]string, 0, len(fields))
 	for name := range fields {
@@ -12,6 +12,7 @@ import (
 	"com.github/davidkleiven/tripleworks/models"
 	"github.com/google/uuid"
 	"github.com/uptrace/bun"
+	"gonum.org/v1/gonum/graph/formats/rdf"
 )
 
 func Export(w io.Writer, items iter.Seq[models.MridGetter]) {
@@ -47,7 +48,8 @@ func ExportItem(w io.Writer, item models.MridGetter) {
 		if reflect.TypeOf(field.Value) == uuidType && name != "Mrid" {
 			fmt.Fprintf(w, "%s <%s> <%s%s> .\n", subject, iri, "urn:uuid:", field.Value)
 		} else {
-			fmt.Fprintf(w, "%s <%s> \"%v\"%s .\n", subject, iri, field.Value, typeSpecifier(field.Value))
+			literal := Must(rdf.NewLiteralTerm(fmt.Sprint(field.Value), "")).Value
+			fmt.Fprintf(w, "%s <%s> %s%s .\n", subject, iri, literal, typeSpecifier(field.Value))
 		}
 	}
 	slog.Info("Fields missing iris", "fields", fieldWithoutIri)
@@ -60,7 +60,7 @@ func TestListAll(t *testing.T) {
 	require.NoError(t, err)
 
 	t.Run("receives all", func(t *testing.T) {
-		allItems, err := LatestOfAllItems(ctx, db, 0)
+		allItems, err := LatestOfAllItems(ctx, db, AnyModel)
 		require.NoError(t, err)
 		require.Equal(t, 2, len(allItems))
 	})
@@ -0,0 +1,396 @@
+package pkg
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+const (
+	GeoJsonPoint             = "Point"
+	GeoJsonLineString        = "LineString"
+	GeoJsonFeatureType       = "Feature"
+	GeoJsonFeatureCollection = "FeatureCollection"
+)
+
+type GeoJsonGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+type GeoJsonFeature struct {
+	Type       string           `json:"type"`
+	Id         any              `json:"id,omitempty"`
+	Geometry   *GeoJsonGeometry `json:"geometry"`
+	Properties map[string]any   `json:"properties"`
+}
+
+type GeoJsonCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJsonFeature `json:"features"`
+}
+
+type GeoJsonImportReport struct {
+	Features  int `json:"features"`
+	Matched   int `json:"matched"`
+	Unchanged int `json:"unchanged"`
+
+	// Unmatched describes the features that could not be placed on a substation or a line
+	Unmatched []string `json:"unmatched"`
+	CommitId  int64    `json:"commit_id,omitempty"`
+}
+
+// positions returns the longitude, latitude and optional altitude of every position in a geometry
+func positions(geometry *GeoJsonGeometry) ([][]float64, error) {
+	if geometry == nil {
+		return nil, fmt.Errorf("Feature has no geometry")
+	}
+
+	var coordinates [][]float64
+	switch geometry.Type {
+	case GeoJsonPoint:
+		var point []float64
+		if err := json.Unmarshal(geometry.Coordinates, &point); err != nil {
+			return nil, fmt.Errorf("Invalid point: %w", err)
+		}
+		coordinates = [][]float64{point}
+	case GeoJsonLineString:
+		if err := json.Unmarshal(geometry.Coordinates, &coordinates); err != nil {
+			return nil, fmt.Errorf("Invalid line string: %w", err)
+		}
+		if len(coordinates) < 2 {
+			return nil, fmt.Errorf("A line string needs at least two positions, got %d", len(coordinates))
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported geometry %s", geometry.Type)
+	}
+
+	for i, position := range coordinates {
+		if len(position) < 2 || len(position) > 3 {
+			return nil, fmt.Errorf("Position no. %d has %d coordinates, expected longitude, latitude and optionally altitude", i+1, len(position))
+		}
+	}
+	return coordinates, nil
+}
+
+// geoTarget is a resource that can be positioned, that is a substation or a line segment
+type geoTarget struct {
+	item     any
+	mrid     uuid.UUID
+	name     string
+	location uuid.UUID
+}
+
+type geoPlacement struct {
+	target      *geoTarget
+	location    uuid.UUID
+	coordinates [][]float64
+}
+
+func (p *geoPlacement) newLocation() *models.Location {
+	var location models.Location
+	location.Mrid = p.location
+	location.Name = "Location " + p.target.name
+	location.ShortName = "Loc " + p.target.name
+	location.Description = "Geographical location of " + p.target.name
+	location.CoordinateSystemMrid = CoordinateSystemMrid()
+	return &location
+}
+
+func (p *geoPlacement) points() []*models.PositionPoint {
+	points := make([]*models.PositionPoint, len(p.coordinates))
+	for i, position := range p.coordinates {
+		var point models.PositionPoint
+		point.XPosition = position[0]
+		point.YPosition = position[1]
+		if len(position) == 3 {
+			point.ZPosition = position[2]
+		}
+		point.SequenceNumber = i + 1
+		point.LocationMrid = p.location
+		points[i] = &point
+	}
+	return points
+}
+
+// resource returns a new version of the positioned resource that points to the location
+func (p *geoPlacement) resource() any {
+	resource := reflect.New(reflect.TypeOf(p.target.item))
+	resource.Elem().Set(reflect.ValueOf(p.target.item))
+	resource.Elem().FieldByName("LocationMrid").Set(reflect.ValueOf(p.location))
+	return newVersionOf(resource.Interface(), false)
+}
+
+type geoTargets struct {
+	byMrid map[uuid.UUID]*geoTarget
+	byName map[string][]*geoTarget
+}
+
+func newGeoTargets[T models.MridNameGetter](items []T, location func(T) uuid.UUID) geoTargets {
+	targets := geoTargets{byMrid: make(map[uuid.UUID]*geoTarget), byName: make(map[string][]*geoTarget)}
+	for _, item := range items {
+		target := geoTarget{item: item, mrid: item.GetMrid(), name: item.GetName(), location: location(item)}
+		targets.byMrid[target.mrid] = &target
+		targets.byName[target.name] = append(targets.byName[target.name], &target)
+	}
+	return targets
+}
+
+// match finds the resource of a feature by the mrid in its properties or id, and otherwise by its name
+func (g *geoTargets) match(feature GeoJsonFeature) (*geoTarget, error) {
+	mrid, _ := feature.Properties["mrid"].(string)
+	if id, ok := feature.Id.(string); ok && mrid == "" {
+		mrid = id
+	}
+	if parsed, err := uuid.Parse(mrid); err == nil {
+		if target, ok := g.byMrid[parsed]; ok {
+			return target, nil
+		}
+	}
+
+	name, _ := feature.Properties["name"].(string)
+	candidates := g.byName[name]
+	switch {
+	case name == "" && mrid == "":
+		return nil, fmt.Errorf("Feature has neither mrid nor name")
+	case len(candidates) == 0 && name == "":
+		return nil, fmt.Errorf("No match for mrid %s", mrid)
+	case len(candidates) == 0:
+		return nil, fmt.Errorf("No match for %s", name)
+	case len(candidates) > 1:
+		return nil, fmt.Errorf("%s matches %d resources, give the mrid instead", name, len(candidates))
+	}
+	return candidates[0], nil
+}
+
+// GeoJsonItems maps Point features onto substations and LineString features onto line segments. Every
+// changed geometry gets a new Location with one PositionPoint per position, and a new version of the resource
+// pointing to it. The replaced Location is deleted unless another resource still refers to it. Features are
+// matched by the mrid property or the feature id, and otherwise by the name property.
+func GeoJsonItems(ctx context.Context, db *bun.DB, modelId int, collection GeoJsonCollection) ([]any, GeoJsonImportReport, error) {
+	report := GeoJsonImportReport{Features: len(collection.Features), Unmatched: []string{}}
+
+	substations, err := FindAll[models.Substation](db, ctx, modelId)
+	if err != nil {
+		return nil, report, fmt.Errorf("Failed to fetch substations: %w", err)
+	}
+	lines, err := FindAll[models.ACLineSegment](db, ctx, modelId)
+	if err != nil {
+		return nil, report, fmt.Errorf("Failed to fetch line segments: %w", err)
+	}
+
+	targets := map[string]geoTargets{
+		GeoJsonPoint:      newGeoTargets(substations, func(s models.Substation) uuid.UUID { return s.LocationMrid }),
+		GeoJsonLineString: newGeoTargets(lines, func(l models.ACLineSegment) uuid.UUID { return l.LocationMrid }),
+	}
+
+	var (
+		placements []geoPlacement
+		placed     = make(map[uuid.UUID]int)
+	)
+	for i, feature := range collection.Features {
+		coordinates, err := positions(feature.Geometry)
+		if err != nil {
+			report.Unmatched = append(report.Unmatched, fmt.Sprintf("Feature no. %d: %s", i+1, err))
+			continue
+		}
+
+		candidates := targets[feature.Geometry.Type]
+		target, err := candidates.match(feature)
+		if err != nil {
+			report.Unmatched = append(report.Unmatched, fmt.Sprintf("Feature no. %d: %s", i+1, err))
+			continue
+		}
+		if previous, ok := placed[target.mrid]; ok {
+			report.Unmatched = append(report.Unmatched, fmt.Sprintf("Feature no. %d: %s is already positioned by feature no. %d", i+1, target.name, previous))
+			continue
+		}
+		placed[target.mrid] = i + 1
+		report.Matched++
+
+		// The location is derived from the geometry, such that uploading the same geometry twice is a no-op
+		location := mridFromName("Location", fmt.Sprintf("%s %s", target.mrid, MustGetHash(coordinates)))
+		if location == target.location {
+			report.Unchanged++
+			continue
+		}
+		placements = append(placements, geoPlacement{target: target, location: location, coordinates: coordinates})
+	}
+	if len(placements) == 0 {
+		return []any{}, report, nil
+	}
+
+	// A resource can be moved back to an earlier geometry, whose location and points already exist
+	lookup := []uuid.UUID{CoordinateSystemMrid()}
+	for _, placement := range placements {
+		lookup = append(lookup, placement.location)
+	}
+	var found []uuid.UUID
+	err = db.NewSelect().
+		TableExpr("entities").
+		Column("mrid").
+		Where("mrid IN (?)", bun.In(lookup)).
+		Scan(ctx, &found)
+	if err != nil {
+		return nil, report, fmt.Errorf("Failed to look up existing locations: %w", err)
+	}
+	existing := Set(found...)
+
+	var entities, objects []any
+	if _, ok := existing[CoordinateSystemMrid()]; !ok {
+		var coordinateSystem models.CoordinateSystem
+		coordinateSystem.Mrid = CoordinateSystemMrid()
+		coordinateSystem.Name = "Longitude, latitude"
+		coordinateSystem.CrsUrn = "EPSG:4326"
+		entity := MakeEntity(&coordinateSystem, modelId)
+		entities = append(entities, &entity)
+		objects = append(objects, &coordinateSystem)
+	}
+
+	for _, placement := range placements {
+		if _, ok := existing[placement.location]; !ok {
+			location := placement.newLocation()
+			entity := MakeEntity(location, modelId)
+			entities = append(entities, &entity)
+			objects = append(objects, location)
+			for _, point := range placement.points() {
+				objects = append(objects, point)
+			}
+		}
+		objects = append(objects, placement.resource())
+	}
+
+	// Locations that are no longer used are deleted in the same commit, and earlier locations that are
+	// used again are restored
+	var (
+		moved    = make(map[uuid.UUID]struct{})
+		located  = make(map[uuid.UUID]struct{})
+		replaced []uuid.UUID
+		reused   []uuid.UUID
+	)
+	for _, placement := range placements {
+		moved[placement.target.mrid] = struct{}{}
+		located[placement.location] = struct{}{}
+		if _, ok := existing[placement.location]; ok {
+			reused = append(reused, placement.location)
+		}
+	}
+	for _, placement := range placements {
+		_, ok := located[placement.target.location]
+		if placement.target.location != uuid.Nil && !ok && !slices.Contains(replaced, placement.target.location) {
+			replaced = append(replaced, placement.target.location)
+		}
+	}
+	retired, err := retireUnreferenced(ctx, db, &models.Location{}, replaced, moved)
+	if err != nil {
+		return nil, report, fmt.Errorf("Failed to delete replaced locations: %w", err)
+	}
+	objects = append(objects, retired...)
+
+	if len(reused) > 0 {
+		versions, err := VersionsOf(ctx, db, &models.Location{}, func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("?TableAlias.mrid IN (?)", bun.In(reused)).Apply(repository.OnBranch(ctx))
+		})
+		if err != nil {
+			return nil, report, fmt.Errorf("Failed to look up reused locations: %w", err)
+		}
+		for latest := range OnlyLatestVersion(versions) {
+			if latest.(models.DeletedGetter).GetDeleted() {
+				objects = append(objects, newVersionOf(latest, false))
+			}
+		}
+	}
+	return append(entities, objects...), report, nil
+}
+
+// geoJsonCoordinates writes positions as longitude, latitude and altitude, where a zero altitude is left out
+func geoJsonCoordinates(point models.PositionPoint) []float64 {
+	if point.ZPosition == 0.0 {
+		return []float64{point.XPosition, point.YPosition}
+	}
+	return []float64{point.XPosition, point.YPosition, point.ZPosition}
+}
+
+func geoJsonFeature(mrid uuid.UUID, name string, kind string, geometryType string, coordinates any) GeoJsonFeature {
+	return GeoJsonFeature{
+		Type:     GeoJsonFeatureType,
+		Id:       mrid.String(),
+		Geometry: &GeoJsonGeometry{Type: geometryType, Coordinates: Must(json.Marshal(coordinates))},
+		Properties: map[string]any{
+			"mrid": mrid.String(),
+			"name": name,
+			"type": kind,
+		},
+	}
+}
+
+// GeoJson collects the positions of substations as Points and the routes of line segments as LineStrings.
+// Resources without a location are left out.
+func GeoJson(ctx context.Context, db *bun.DB, modelId int) (GeoJsonCollection, error) {
+	collection := GeoJsonCollection{Type: GeoJsonFeatureCollection, Features: []GeoJsonFeature{}}
+
+	substations, err := FindAll[models.Substation](db, ctx, modelId)
+	if err != nil {
+		return collection, fmt.Errorf("Failed to fetch substations: %w", err)
+	}
+	lines, err := FindAll[models.ACLineSegment](db, ctx, modelId)
+	if err != nil {
+		return collection, fmt.Errorf("Failed to fetch line segments: %w", err)
+	}
+	slices.SortFunc(substations, func(a, b models.Substation) int { return cmp.Compare(a.Name, b.Name) })
+	slices.SortFunc(lines, func(a, b models.ACLineSegment) int { return cmp.Compare(a.Name, b.Name) })
+
+	locations := make(map[uuid.UUID]struct{})
+	for _, substation := range substations {
+		locations[substation.LocationMrid] = struct{}{}
+	}
+	for _, line := range lines {
+		locations[line.LocationMrid] = struct{}{}
+	}
+	delete(locations, uuid.Nil)
+	if len(locations) == 0 {
+		return collection, nil
+	}
+
+	var points []models.PositionPoint
+	err = db.NewSelect().
+		Model(&points).
+		Where("location_mrid IN (?)", bun.In(slices.Collect(maps.Keys(locations)))).
+		Order("sequence_number").
+		Scan(ctx)
+	if err != nil {
+		return collection, fmt.Errorf("Failed to fetch position points: %w", err)
+	}
+	pointsOf := GroupBy(points, func(p models.PositionPoint) uuid.UUID { return p.LocationMrid })
+
+	for _, substation := range substations {
+		located := pointsOf[substation.LocationMrid]
+		if len(located) == 0 {
+			continue
+		}
+		feature := geoJsonFeature(substation.Mrid, substation.Name, StructName(substation), GeoJsonPoint, geoJsonCoordinates(located[0]))
+		collection.Features = append(collection.Features, feature)
+	}
+	for _, line := range lines {
+		route := pointsOf[line.LocationMrid]
+		if len(route) < 2 {
+			continue
+		}
+		coordinates := make([][]float64, len(route))
+		for i, point := range route {
+			coordinates[i] = geoJsonCoordinates(point)
+		}
+		feature := geoJsonFeature(line.Mrid, line.Name, StructName(line), GeoJsonLineString, coordinates)
+		collection.Features = append(collection.Features, feature)
+	}
+	return collection, nil
+}
@@ -0,0 +1,133 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"iter"
+	"slices"
+	"testing"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeoJsonImportExport(t *testing.T) {
+	ctx := context.Background()
+	db, modelId := setupDb(t)
+
+	var items []any
+	for _, record := range []interface{ CimItems(int) iter.Seq[any] }{
+		&SubstationLight{Name: "Sub A", Region: "NO1", X: 10.4, Y: 63.4},
+		&SubstationLight{Name: "Sub B", Region: "NO1", X: 10.7, Y: 59.9},
+		&LineLight{FromSubstation: "Sub A", ToSubstation: "Sub B", Length: 400, Voltage: 420},
+	} {
+		items = append(items, slices.Collect(record.CimItems(modelId))...)
+	}
+	require.NoError(t, InsertAll(ctx, db, models.Commit{Message: "Create grid"}, slices.Values(items), NoOpOnInsert))
+
+	locationOf := func(name string) uuid.UUID {
+		substations, err := FindAll[models.Substation](db, ctx, modelId)
+		require.NoError(t, err)
+		for _, substation := range substations {
+			if substation.Name == name {
+				return substation.LocationMrid
+			}
+		}
+		return uuid.Nil
+	}
+	activeLocations := func() map[uuid.UUID]struct{} {
+		locations, err := FindAll[models.Location](db, ctx, modelId)
+		require.NoError(t, err)
+		active := make(map[uuid.UUID]struct{})
+		for _, location := range OnlyActiveLatest(locations) {
+			active[location.Mrid] = struct{}{}
+		}
+		return active
+	}
+	createdLocation := locationOf("Sub A")
+
+	document := `{
+		"type": "FeatureCollection",
+		"features": [
+			{"type": "Feature", "geometry": {"type": "Point", "coordinates": [10.5, 63.5]}, "properties": {"name": "Sub A"}},
+			{"type": "Feature", "id": "` + lineMrid("Sub A-Sub B (420 kV)").String() + `",
+			 "geometry": {"type": "LineString", "coordinates": [[10.5, 63.5], [10.6, 61.0, 450.0], [10.7, 59.9]]}, "properties": {}},
+			{"type": "Feature", "geometry": {"type": "Point", "coordinates": [11.0, 60.0]}, "properties": {"name": "Sub X"}},
+			{"type": "Feature", "geometry": {"type": "Polygon", "coordinates": []}, "properties": {"name": "Sub B"}},
+			{"type": "Feature", "geometry": {"type": "Point", "coordinates": [10.0, 63.0]}, "properties": {"name": "Sub A"}}
+		]
+	}`
+	var collection GeoJsonCollection
+	require.NoError(t, json.Unmarshal([]byte(document), &collection))
+
+	items, report, err := GeoJsonItems(ctx, db, modelId, collection)
+	require.NoError(t, err)
+	require.Equal(t, 5, report.Features)
+	require.Equal(t, 2, report.Matched)
+	require.Equal(t, 0, report.Unchanged)
+	require.Equal(t, []string{
+		"Feature no. 3: No match for Sub X",
+		"Feature no. 4: Unsupported geometry Polygon",
+		"Feature no. 5: Sub A is already positioned by feature no. 1",
+	}, report.Unmatched)
+	require.NoError(t, InsertAll(ctx, db, models.Commit{Message: "Import GeoJSON"}, slices.Values(items), NoOpOnInsert))
+
+	// The location Sub A had before the import is deleted
+	importedLocation := locationOf("Sub A")
+	require.Contains(t, activeLocations(), importedLocation)
+	require.NotContains(t, activeLocations(), createdLocation)
+	require.Contains(t, activeLocations(), locationOf("Sub B"))
+
+	exported, err := GeoJson(ctx, db, modelId)
+	require.NoError(t, err)
+	require.Equal(t, GeoJsonFeatureCollection, exported.Type)
+
+	geometries := make(map[string]string)
+	for _, feature := range exported.Features {
+		geometries[feature.Properties["name"].(string)] = feature.Geometry.Type + " " + string(feature.Geometry.Coordinates)
+	}
+	require.Equal(t, map[string]string{
+		"Sub A":                "Point [10.5,63.5]",
+		"Sub B":                "Point [10.7,59.9]",
+		"Sub A-Sub B (420 kV)": "LineString [[10.5,63.5],[10.6,61,450],[10.7,59.9]]",
+	}, geometries)
+
+	t.Run("uploading the same geometries again changes nothing", func(t *testing.T) {
+		items, report, err := GeoJsonItems(ctx, db, modelId, collection)
+		require.NoError(t, err)
+		require.Empty(t, items)
+		require.Equal(t, 2, report.Unchanged)
+	})
+
+	t.Run("exported collection matches by mrid", func(t *testing.T) {
+		items, report, err := GeoJsonItems(ctx, db, modelId, exported)
+		require.NoError(t, err)
+		require.Empty(t, report.Unmatched)
+		require.Equal(t, 3, report.Matched)
+
+		// Sub B still has the location of the substation upload
+		require.Equal(t, 1, report.Matched-report.Unchanged)
+		require.NotEmpty(t, items)
+	})
+	t.Run("moving back restores the earlier location", func(t *testing.T) {
+		move := func(coordinates string) {
+			var collection GeoJsonCollection
+			document := `{"type": "FeatureCollection", "features": [
+				{"type": "Feature", "geometry": {"type": "Point", "coordinates": ` + coordinates + `}, "properties": {"name": "Sub A"}}
+			]}`
+			require.NoError(t, json.Unmarshal([]byte(document), &collection))
+			items, _, err := GeoJsonItems(ctx, db, modelId, collection)
+			require.NoError(t, err)
+			require.NoError(t, InsertAll(ctx, db, models.Commit{Message: "Move Sub A"}, slices.Values(items), NoOpOnInsert))
+		}
+
+		move("[10.0, 63.0]")
+		require.NotContains(t, activeLocations(), importedLocation)
+		require.Contains(t, activeLocations(), locationOf("Sub A"))
+
+		move("[10.5, 63.5]")
+		require.Equal(t, importedLocation, locationOf("Sub A"))
+		require.Contains(t, activeLocations(), importedLocation)
+	})
+}
@@ -0,0 +1,131 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/uptrace/bun"
+)
+
+type ObjectVersion struct {
+	CommitId  int            `json:"commit_id"`
+	Branch    string         `json:"branch"`
+	Author    string         `json:"author"`
+	Message   string         `json:"message"`
+	CreatedAt time.Time      `json:"created_at"`
+	Deleted   bool           `json:"deleted"`
+	Data      map[string]any `json:"data"`
+}
+
+type FieldBlame struct {
+	Field     string    `json:"field"`
+	Value     any       `json:"value"`
+	CommitId  int       `json:"commit_id"`
+	Author    string    `json:"author"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ObjectHistory struct {
+	Mrid     string          `json:"mrid"`
+	Type     string          `json:"type"`
+	Versions []ObjectVersion `json:"versions"`
+	Blame    []FieldBlame    `json:"blame"`
+}
+
+// History returns every stored version of an object that is visible from the branch in the context,
+// oldest first, together with the commit that last changed each field
+func History(ctx context.Context, db bun.IDB, mrid string) (ObjectHistory, error) {
+	result := ObjectHistory{Mrid: mrid, Versions: []ObjectVersion{}, Blame: []FieldBlame{}}
+	err := db.NewSelect().Model((*models.Entity)(nil)).Column("entity_type").Where("mrid = ?", mrid).Scan(ctx, &result.Type)
+	if err != nil {
+		return result, fmt.Errorf("Failed to find entity %s: %w", mrid, err)
+	}
+
+	itemPtr, ok := FormTypes()[result.Type]
+	if !ok {
+		return result, fmt.Errorf("Could not find a form type for type %s", result.Type)
+	}
+
+	versions, err := VersionsOf(ctx, db, itemPtr, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Relation("Commit").Apply(repository.OnBranch(ctx)).Where("?TableAlias.mrid = ?", mrid).Order("commit_id")
+	})
+	if err != nil {
+		return result, err
+	}
+
+	blame := make(map[string]FieldBlame)
+	var previous map[string]any
+	for _, version := range versions {
+		commit := reflect.ValueOf(version).Elem().FieldByName("Commit").Interface().(*models.Commit)
+		if commit == nil {
+			commit = &models.Commit{Id: int64(version.GetCommitId())}
+		}
+
+		fields := DiffFields(version)
+		result.Versions = append(result.Versions, ObjectVersion{
+			CommitId:  version.GetCommitId(),
+			Branch:    commit.Branch,
+			Author:    commit.Author,
+			Message:   commit.Message,
+			CreatedAt: commit.CreatedAt,
+			Deleted:   version.(models.DeletedGetter).GetDeleted(),
+			Data:      fields,
+		})
+
+		for field, value := range fields {
+			if old, ok := previous[field]; ok && reflect.DeepEqual(old, value) {
+				continue
+			}
+			blame[field] = FieldBlame{
+				Field:     field,
+				Value:     value,
+				CommitId:  version.GetCommitId(),
+				Author:    commit.Author,
+				Message:   commit.Message,
+				CreatedAt: commit.CreatedAt,
+			}
+		}
+		previous = fields
+	}
+
+	for _, field := range slices.Sorted(Keys(blame)) {
+		result.Blame = append(result.Blame, blame[field])
+	}
+	return result, nil
+}
+
+// HistoryLink returns the link to the history page of an object. The branch and model of the context
+// are passed on, such that the page shows the history as seen from where the link was rendered.
+func HistoryLink(ctx context.Context, mrid string) string {
+	query := url.Values{}
+	if branch := repository.BranchFromCtx(ctx); branch != models.MainBranch {
+		query.Set("branch", branch)
+	}
+	if modelId, ok := repository.ModelFromCtx(ctx); ok {
+		query.Set("model-id", strconv.Itoa(modelId))
+	}
+
+	link := fmt.Sprintf("/resource/%s/history", url.PathEscape(mrid))
+	if len(query) > 0 {
+		link += "?" + query.Encode()
+	}
+	return link
+}
+
+func WriteHistory(w io.Writer, history ObjectHistory) error {
+	funcs := template.FuncMap{
+		"display": func(v any) string { return fmt.Sprintf("%v", v) },
+	}
+	tmpl := template.Must(template.New("history.html").Funcs(funcs).ParseFS(htmlPages, "html/history.html"))
+	return tmpl.Execute(w, history)
+}
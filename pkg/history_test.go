@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"slices"
+	"testing"
+
+	"com.github/davidkleiven/tripleworks/migrations"
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	ctx := context.Background()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	insert := func(ctx context.Context, author string, items ...any) {
+		commit := models.Commit{Message: "by " + author, Author: author}
+		require.NoError(t, InsertAll(ctx, db, commit, slices.Values(items), NoOpOnInsert))
+	}
+
+	mrid := uuid.New()
+	first := baseVoltageVersion(mrid, "bv", 132.0)
+	entity := MakeEntity(first, 0)
+	insert(ctx, "alice", &entity, first)
+	insert(ctx, "bob", baseVoltageVersion(mrid, "bv", 220.0))
+
+	branchRepo := repository.BunBranchRepository{Db: db}
+	require.NoError(t, branchRepo.Create(ctx, &models.Branch{Name: "project"}))
+	branchCtx := repository.WithBranch(ctx, "project")
+	insert(branchCtx, "carol", baseVoltageVersion(mrid, "renamed", 220.0))
+	insert(ctx, "dave", baseVoltageVersion(mrid, "bv", 300.0))
+
+	blameOf := func(history ObjectHistory) map[string]string {
+		result := make(map[string]string)
+		for _, blame := range history.Blame {
+			result[blame.Field] = blame.Author
+		}
+		return result
+	}
+
+	t.Run("main", func(t *testing.T) {
+		history, err := History(ctx, db, mrid.String())
+		require.NoError(t, err)
+		require.Equal(t, "BaseVoltage", history.Type)
+
+		var authors []string
+		for _, version := range history.Versions {
+			authors = append(authors, version.Author)
+			require.Equal(t, models.MainBranch, version.Branch)
+		}
+		require.Equal(t, []string{"alice", "bob", "dave"}, authors)
+		require.Equal(t, 300.0, history.Versions[2].Data["nominal_voltage"])
+
+		blame := blameOf(history)
+		require.Equal(t, "dave", blame["nominal_voltage"])
+		require.Equal(t, "alice", blame["name"])
+	})
+
+	t.Run("branch", func(t *testing.T) {
+		history, err := History(branchCtx, db, mrid.String())
+		require.NoError(t, err)
+		require.Equal(t, 3, len(history.Versions))
+		require.Equal(t, "project", history.Versions[2].Branch)
+		require.Equal(t, "carol", history.Versions[2].Author)
+
+		blame := blameOf(history)
+		require.Equal(t, "bob", blame["nominal_voltage"])
+		require.Equal(t, "carol", blame["name"])
+	})
+
+	t.Run("other model", func(t *testing.T) {
+		history, err := History(repository.WithModel(ctx, 1), db, mrid.String())
+		require.NoError(t, err)
+		require.Empty(t, history.Versions)
+		require.Empty(t, history.Blame)
+	})
+
+	t.Run("unknown object", func(t *testing.T) {
+		_, err := History(ctx, db, uuid.NewString())
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("render", func(t *testing.T) {
+		history, err := History(ctx, db, mrid.String())
+		require.NoError(t, err)
+
+		var page bytes.Buffer
+		require.NoError(t, WriteHistory(&page, history))
+		require.Contains(t, page.String(), mrid.String())
+		require.Contains(t, page.String(), "by dave")
+	})
+}
+
+func TestHistoryLink(t *testing.T) {
+	ctx := context.Background()
+	mrid := uuid.NewString()
+
+	require.Equal(t, "/resource/"+mrid+"/history", HistoryLink(ctx, mrid))
+	require.Equal(t, "/resource/"+mrid+"/history?branch=project", HistoryLink(repository.WithBranch(ctx, "project"), mrid))
+	require.Equal(t, "/resource/"+mrid+"/history?model-id=0", HistoryLink(repository.WithModel(ctx, 0), mrid))
+
+	scoped := repository.WithModel(repository.WithBranch(ctx, "project"), 2)
+	require.Equal(t, "/resource/"+mrid+"/history?branch=project&model-id=2", HistoryLink(scoped, mrid))
+}
@@ -2,12 +2,17 @@ package pkg
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"slices"
 	"strings"
 
 	"com.github/davidkleiven/tripleworks/models"
 	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
 	"github.com/uptrace/bun"
 )
 
@@ -16,11 +21,29 @@ type JsonPatch struct {
 	Path  string          `json:"path"`
 	From  string          `json:"from,omitempty"`
 	Value json.RawMessage `json:"value,omitempty"`
+
+	// BaseCommitId is the commit of the version the patch was written against. When set, the patch
+	// is rejected if the object has been changed by a later commit.
+	BaseCommitId int64 `json:"baseCommitId,omitempty"`
+}
+
+// PatchTestError is returned when a test operation does not match the current value. The whole
+// patch set is rejected in that case.
+type PatchTestError struct {
+	Index    int
+	Path     string
+	Expected any
+	Actual   any
+}
+
+func (p *PatchTestError) Error() string {
+	return fmt.Sprintf("Test of patch %d failed for %s: expected %v got %v", p.Index, p.Path, p.Expected, p.Actual)
 }
 
 type PreparePatchCtx struct {
 	Kind            string
 	Path            ParsedPath
+	From            ParsedPath
 	Model           any
 	Value           any
 	SerializedPatch []byte
@@ -39,11 +62,64 @@ func parsePathStep(patch JsonPatch) Step[PreparePatchCtx] {
 	}
 }
 
-func typeFromEntitiesStep(ctx context.Context, db *bun.DB) Step[PreparePatchCtx] {
+// newObjectStep creates the object added by an add operation on /<mrid>. The value holds the
+// fields of the object together with its cim_type.
+func newObjectStep(ctx context.Context, db *bun.DB, patch JsonPatch, modelsCache map[string]any) Step[PreparePatchCtx] {
+	return Step[PreparePatchCtx]{
+		Name: "Create new object",
+		Run: func(pctx *PreparePatchCtx) error {
+			if patch.Op != "add" || pctx.Path.Field != "" {
+				return nil
+			}
+
+			mrid, err := uuid.Parse(pctx.Path.Mrid)
+			if err != nil {
+				return fmt.Errorf("New objects must have a valid mrid: %w", err)
+			}
+			exists, err := db.NewSelect().Model((*models.Entity)(nil)).Where("mrid = ?", mrid).Exists(ctx)
+			if err != nil {
+				return &StorageError{Err: fmt.Errorf("Failed to check whether object %s exists: %w", mrid, err)}
+			}
+			if _, cached := modelsCache[pctx.Path.Mrid]; exists || cached {
+				return fmt.Errorf("Can not add object %s since it already exists", mrid)
+			}
+
+			var fields map[string]any
+			if err := json.Unmarshal(patch.Value, &fields); err != nil {
+				return fmt.Errorf("Value of a new object must be a json object: %w", err)
+			}
+			if current, ok := fields["mrid"]; ok && current != mrid.String() {
+				return fmt.Errorf("Mrid %v of the value does not match the path %s", current, patch.Path)
+			}
+			fields["mrid"] = mrid.String()
+
+			kind, _ := fields["cim_type"].(string)
+			model, err := FormInputFieldsForType(kind)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(Must(json.Marshal(fields)), model); err != nil {
+				return err
+			}
+			modelsCache[pctx.Path.Mrid] = model
+			return nil
+		},
+	}
+}
+
+func typeFromEntitiesStep(ctx context.Context, db *bun.DB, modelsCache map[string]any) Step[PreparePatchCtx] {
 	return Step[PreparePatchCtx]{
 		Name: "Extract type from entities",
 		Run: func(pctx *PreparePatchCtx) error {
-			return db.NewSelect().Model((*models.Entity)(nil)).Where("mrid = ?", pctx.Path.Mrid).Column("entity_type").Scan(ctx, &pctx.Kind)
+			if item, ok := modelsCache[pctx.Path.Mrid]; ok {
+				pctx.Kind = StructName(item)
+				return nil
+			}
+			err := db.NewSelect().Model((*models.Entity)(nil)).Where("mrid = ?", pctx.Path.Mrid).Column("entity_type").Scan(ctx, &pctx.Kind)
+			if err != nil {
+				return lookupError(pctx.Path.Mrid, err)
+			}
+			return nil
 		},
 	}
 }
@@ -60,7 +136,17 @@ func formTypeStep() Step[PreparePatchCtx] {
 	}
 }
 
-func extractLastEntryStep(ctx context.Context, db *bun.DB, modelsCache map[string]any) Step[PreparePatchCtx] {
+// copyOf returns a shallow copy of a pointer to a struct
+func copyOf(item any) any {
+	v := reflect.ValueOf(item).Elem()
+	c := reflect.New(v.Type())
+	c.Elem().Set(v)
+	return c.Interface()
+}
+
+// extractLastEntryStep loads the newest version of the object the patch applies to. Objects are
+// only loaded once per patch set and the version as it was stored is kept in originals.
+func extractLastEntryStep(ctx context.Context, db *bun.DB, modelsCache map[string]any, originals map[string]any) Step[PreparePatchCtx] {
 	return Step[PreparePatchCtx]{
 		Name: "Extract last entity",
 		Run: func(pctx *PreparePatchCtx) error {
@@ -68,9 +154,26 @@ func extractLastEntryStep(ctx context.Context, db *bun.DB, modelsCache map[strin
 				pctx.Model = item
 				return nil
 			}
-			err := db.NewSelect().Model(pctx.Model).Where("mrid = ?", pctx.Path.Mrid).OrderBy("commit_id", bun.OrderDesc).Limit(1).Scan(ctx)
+			err := db.NewSelect().Model(pctx.Model).Apply(repository.OnBranch(ctx)).Where("mrid = ?", pctx.Path.Mrid).OrderBy("commit_id", bun.OrderDesc).Limit(1).Scan(ctx)
+			if err != nil {
+				return lookupError(pctx.Path.Mrid, err)
+			}
 			modelsCache[pctx.Path.Mrid] = pctx.Model
-			return err
+			originals[pctx.Path.Mrid] = copyOf(pctx.Model)
+			return nil
+		},
+	}
+}
+
+func checkBaseCommitStep(ctx context.Context, db *bun.DB, patch JsonPatch) Step[PreparePatchCtx] {
+	return Step[PreparePatchCtx]{
+		Name: "Check base commit",
+		Run: func(pctx *PreparePatchCtx) error {
+			head, ok := pctx.Model.(models.VersionedIdentifiedObject)
+			if patch.BaseCommitId == 0 || !ok {
+				return nil
+			}
+			return conflictIfMoved(ctx, &repository.BunVersionReader{Db: db}, head, patch.BaseCommitId)
 		},
 	}
 }
@@ -79,11 +182,97 @@ func interpretValueStep(patch JsonPatch) Step[PreparePatchCtx] {
 	return Step[PreparePatchCtx]{
 		Name: "Interpret value",
 		Run: func(ctx *PreparePatchCtx) error {
+			if len(patch.Value) == 0 {
+				if slices.Contains([]string{"add", "replace", "test"}, patch.Op) {
+					return fmt.Errorf("Operation %s requires a value", patch.Op)
+				}
+				return nil
+			}
 			return json.Unmarshal(patch.Value, &ctx.Value)
 		},
 	}
 }
 
+// valueAt returns the value found by following the keys through nested json objects
+func valueAt(generic map[string]any, keys []string) (any, error) {
+	var current any = generic
+	for i, key := range keys {
+		object, ok := current.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s is not an object", strings.Join(keys[:i], "/"))
+		}
+		if current, ok = object[key]; !ok {
+			return nil, fmt.Errorf("Unknown field %s", strings.Join(keys[:i+1], "/"))
+		}
+	}
+	return current, nil
+}
+
+// setValueAt replaces the value found by following the keys through nested json objects
+func setValueAt(generic map[string]any, keys []string, value any) error {
+	parent, err := valueAt(generic, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	object, ok := parent.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s is not an object", strings.Join(keys[:len(keys)-1], "/"))
+	}
+	if _, ok := object[keys[len(keys)-1]]; !ok {
+		return fmt.Errorf("Unknown field %s", strings.Join(keys, "/"))
+	}
+	object[keys[len(keys)-1]] = value
+	return nil
+}
+
+// zeroValueAt returns the json value at the keys in a newly created object
+func zeroValueAt(model any, keys []string) (any, error) {
+	return valueAt(GenericFields(reflect.New(reflect.TypeOf(model).Elem()).Interface()), keys)
+}
+
+// valueFromStep reads the value at the from location of move and copy operations. Move resets the
+// field at the from location.
+func valueFromStep(ctx context.Context, db *bun.DB, patch JsonPatch, modelsCache map[string]any, originals map[string]any) Step[PreparePatchCtx] {
+	return Step[PreparePatchCtx]{
+		Name: "Read value at from location",
+		Run: func(pctx *PreparePatchCtx) error {
+			if patch.Op != "move" && patch.Op != "copy" {
+				return nil
+			}
+
+			var fromCtx PreparePatchCtx
+			err := Pipe(&fromCtx,
+				parsePathStep(JsonPatch{Path: patch.From}),
+				typeFromEntitiesStep(ctx, db, modelsCache),
+				formTypeStep(),
+				extractLastEntryStep(ctx, db, modelsCache, originals),
+			)
+			if err != nil {
+				return err
+			}
+			if fromCtx.Path.Field == "" {
+				return fmt.Errorf("Operation %s requires a from path to a field, got %s", patch.Op, patch.From)
+			}
+
+			generic := GenericFields(fromCtx.Model)
+			value, err := valueAt(generic, fromCtx.Path.Keys())
+			if err != nil {
+				return fmt.Errorf("%w of %s", err, fromCtx.Kind)
+			}
+			pctx.Value = value
+			pctx.From = fromCtx.Path
+
+			if patch.Op == "move" {
+				zero := Must(zeroValueAt(fromCtx.Model, fromCtx.Path.Keys()))
+				PanicOnErr(setValueAt(generic, fromCtx.Path.Keys(), zero))
+				generic["id"] = 0
+				return json.Unmarshal(Must(json.Marshal(generic)), fromCtx.Model)
+			}
+			return nil
+		},
+	}
+}
+
 func serializePatchStep(patch JsonPatch) Step[PreparePatchCtx] {
 	return Step[PreparePatchCtx]{
 		Name: "Serialize patch step",
@@ -95,20 +284,157 @@ func serializePatchStep(patch JsonPatch) Step[PreparePatchCtx] {
 	}
 }
 
-func applyPatchStep(patch JsonPatch) Step[PreparePatchCtx] {
+// applyObjectOp applies operations on whole objects. New objects are created by newObjectStep
+// and removing an object marks it as deleted.
+func applyObjectOp(ctx *PreparePatchCtx, patch JsonPatch) error {
+	switch patch.Op {
+	case "add":
+		return nil
+	case "remove":
+		ctx.Generic["deleted"] = true
+		ctx.Generic["id"] = 0
+		return nil
+	default:
+		return fmt.Errorf("Operation %s requires a path to a field, got %s", patch.Op, patch.Path)
+	}
+}
+
+func applyPatchStep(index int, patch JsonPatch) Step[PreparePatchCtx] {
 	return Step[PreparePatchCtx]{
 		Name: "Apply patch",
 		Run: func(ctx *PreparePatchCtx) error {
 			ctx.Content = Must(json.Marshal(ctx.Model))
 			PanicOnErr(json.Unmarshal(ctx.Content, &ctx.Generic))
+			if ctx.Path.Field == "" {
+				return applyObjectOp(ctx, patch)
+			}
+
+			keys := ctx.Path.Keys()
+			current, err := valueAt(ctx.Generic, keys)
+			if err != nil {
+				return fmt.Errorf("%w of %s", err, ctx.Kind)
+			}
 
 			switch patch.Op {
-			case "replace":
-				ctx.Generic[ctx.Path.Field] = ctx.Value
-				ctx.Generic["id"] = 0
+			case "add", "replace", "move", "copy":
+				PanicOnErr(setValueAt(ctx.Generic, keys, ctx.Value))
+			case "remove":
+				PanicOnErr(setValueAt(ctx.Generic, keys, Must(zeroValueAt(ctx.Model, keys))))
+			case "test":
+				if !reflect.DeepEqual(current, ctx.Value) {
+					return &PatchTestError{Index: index, Path: patch.Path, Expected: ctx.Value, Actual: current}
+				}
+				return nil
 			default:
 				return fmt.Errorf("Unsupported operation %s", patch.Op)
 			}
+			ctx.Generic["id"] = 0
+			return nil
+		},
+	}
+}
+
+// fieldByJsonName finds a field of a struct, including fields of embedded structs, by its json name
+func fieldByJsonName(v reflect.Value, name string) (reflect.StructField, reflect.Value, bool) {
+	t := v.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if f, value, ok := fieldByJsonName(v.Field(i), name); ok {
+				return f, value, true
+			}
+			continue
+		}
+		if strings.Split(field.Tag.Get("json"), ",")[0] == name {
+			return field, v.Field(i), true
+		}
+	}
+	return reflect.StructField{}, reflect.Value{}, false
+}
+
+// enumOfColumn returns the enum type a bun column refers to through a belongs-to relation
+func enumOfColumn(t reflect.Type, column string) (string, bool) {
+	enumType := reflect.TypeOf((*models.Enum)(nil)).Elem()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if kind, ok := enumOfColumn(field.Type, column); ok {
+				return kind, true
+			}
+			continue
+		}
+		tag := field.Tag.Get("bun")
+		if strings.Contains(tag, "belongs-to") && strings.Contains(tag, "join:"+column+"=id") && field.Type.Implements(enumType) {
+			return field.Type.Elem().Name(), true
+		}
+	}
+	return "", false
+}
+
+// validateField checks that a json value can be stored in a field of the model. Referenced objects
+// must exist and enum ids must be among the allowed values.
+func validateField(ctx context.Context, db *bun.DB, model any, name string, value any, modelsCache map[string]any) error {
+	target := reflect.New(reflect.TypeOf(model).Elem())
+	field, fieldValue, ok := fieldByJsonName(target.Elem(), name)
+	if !ok {
+		return fmt.Errorf("Unknown field %s of %s", name, StructName(model))
+	}
+	content := Must(json.Marshal(map[string]any{name: value}))
+	if err := json.Unmarshal(content, target.Interface()); err != nil {
+		return fmt.Errorf("Value %v is not valid for field %s of type %s: %w", value, name, field.Type, err)
+	}
+
+	if mrid, isUuid := fieldValue.Interface().(uuid.UUID); isUuid && name != "mrid" && mrid != uuid.Nil {
+		if _, ok := modelsCache[mrid.String()]; ok {
+			return nil
+		}
+		exists, err := db.NewSelect().Model((*models.Entity)(nil)).Where("mrid = ?", mrid).Exists(ctx)
+		if err != nil {
+			return &StorageError{Err: fmt.Errorf("Failed to look up %s: %w", mrid, err)}
+		}
+		if !exists {
+			return fmt.Errorf("Field %s refers to %s which does not exist", name, mrid)
+		}
+		return nil
+	}
+
+	column := strings.Split(field.Tag.Get("bun"), ",")[0]
+	if kind, isEnum := enumOfColumn(target.Elem().Type(), column); isEnum && column != "" {
+		finder, ok := EnumFinders[kind]
+		if !ok {
+			return nil
+		}
+		options, err := finder(ctx, db)
+		if err != nil {
+			return &StorageError{Err: fmt.Errorf("Failed to fetch values of %s: %w", kind, err)}
+		}
+		id := int(fieldValue.Int())
+		if !slices.ContainsFunc(options, func(e models.Enum) bool { return e.GetId() == id }) {
+			return fmt.Errorf("%d is not a valid id of %s for field %s", id, kind, name)
+		}
+	}
+	return nil
+}
+
+// validateValueStep checks the types, references and enum ids of the values a patch writes
+func validateValueStep(ctx context.Context, db *bun.DB, patch JsonPatch, modelsCache map[string]any) Step[PreparePatchCtx] {
+	return Step[PreparePatchCtx]{
+		Name: "Validate value",
+		Run: func(pctx *PreparePatchCtx) error {
+			switch {
+			case patch.Op == "add" && pctx.Path.Field == "":
+				fields, _ := pctx.Value.(map[string]any)
+				for _, name := range slices.Sorted(Keys(fields)) {
+					if _, _, known := fieldByJsonName(reflect.ValueOf(pctx.Model).Elem(), name); !known {
+						continue
+					}
+					if err := validateField(ctx, db, pctx.Model, name, fields[name], modelsCache); err != nil {
+						return err
+					}
+				}
+			case slices.Contains([]string{"add", "replace", "move", "copy"}, patch.Op):
+				return validateField(ctx, db, pctx.Model, pctx.Path.Field, pctx.Generic[pctx.Path.Field], modelsCache)
+			}
 			return nil
 		},
 	}
@@ -134,56 +460,241 @@ func updatingOriginalModelStep() Step[PreparePatchCtx] {
 	}
 }
 
-func ApplyPatch(ctx context.Context, db *bun.DB, author string, patches []JsonPatch) error {
-	result := make(map[string]any)
+// StorageError marks a patch that failed because the database could not be read or written, as
+// opposed to a patch that is invalid
+type StorageError struct {
+	Err error
+}
+
+func (s *StorageError) Error() string {
+	return s.Err.Error()
+}
+
+func (s *StorageError) Unwrap() error {
+	return s.Err
+}
+
+// lookupError marks a failed lookup of the object at path as a storage error, unless the object does not exist
+func lookupError(mrid string, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("Object %s does not exist: %w", mrid, err)
+	}
+	return &StorageError{Err: err}
+}
+
+// PatchError reports which patch in a list failed
+type PatchError struct {
+	Index   int    `json:"index"`
+	Path    string `json:"path"`
+	Message string `json:"error"`
+	err     error
+}
+
+func (p *PatchError) Error() string {
+	return fmt.Sprintf("Failed to apply patch %d at %s: %s", p.Index, p.Path, p.Message)
+}
+
+func (p *PatchError) Unwrap() error {
+	return p.err
+}
+
+type PatchPreview struct {
+	Valid   bool         `json:"valid"`
+	Errors  []PatchError `json:"errors"`
+	Objects []ObjectDiff `json:"objects"`
+}
 
+type patchSet struct {
+	objects   map[string]any
+	originals map[string]any
+	changed   map[string]struct{}
+	created   map[string]struct{}
+	bases     []patchBase
+}
+
+// patchBase is the commit a patch on a stored object was based on
+type patchBase struct {
+	index    int
+	path     string
+	mrid     string
+	commitId int64
+}
+
+// checkBaseCommits verifies that none of the patched objects changed after the commit the patches
+// were based on. It runs in the transaction of the insert, such that two patches based on the same
+// version can not both be stored.
+func (p patchSet) checkBaseCommits(ctx context.Context, inserter repository.Inserter) error {
+	versions := repository.TxVersionReader(inserter, nil)
+	if versions == nil {
+		return nil
+	}
+	for _, base := range p.bases {
+		mrid, err := uuid.Parse(base.mrid)
+		if err != nil {
+			return err
+		}
+		if err := CheckBaseCommit(ctx, versions, p.originals[base.mrid], mrid, base.commitId); err != nil {
+			return &PatchError{Index: base.index, Path: base.path, Message: err.Error(), err: err}
+		}
+	}
+	return nil
+}
+
+// preparePatches applies the patches to in-memory copies of the objects. Unless all is set,
+// the first failing patch stops the preparation.
+func preparePatches(ctx context.Context, db *bun.DB, patches []JsonPatch, all bool) (patchSet, []PatchError) {
+	set := patchSet{
+		objects:   make(map[string]any),
+		originals: make(map[string]any),
+		changed:   make(map[string]struct{}),
+		created:   make(map[string]struct{}),
+	}
+
+	var errs []PatchError
 	for i, patch := range patches {
 		var prepCtx PreparePatchCtx
 		err := Pipe(&prepCtx,
 			parsePathStep(patch),
-			typeFromEntitiesStep(ctx, db),
+			newObjectStep(ctx, db, patch, set.objects),
+			typeFromEntitiesStep(ctx, db, set.objects),
 			formTypeStep(),
-			extractLastEntryStep(ctx, db, result),
+			extractLastEntryStep(ctx, db, set.objects, set.originals),
+			checkBaseCommitStep(ctx, db, patch),
 			interpretValueStep(patch),
+			valueFromStep(ctx, db, patch, set.objects, set.originals),
 			serializePatchStep(patch),
-			applyPatchStep(patch),
+			applyPatchStep(i, patch),
+			validateValueStep(ctx, db, patch, set.objects),
 			serializingGenericModelStep(),
 			updatingOriginalModelStep(),
 		)
 		if err != nil {
-			return fmt.Errorf("Failed to apply patch %d: %s: %w", i, patch, err)
+			errs = append(errs, PatchError{Index: i, Path: patch.Path, Message: err.Error(), err: err})
+			if !all {
+				return set, errs
+			}
+			continue
+		}
+
+		if _, ok := set.originals[prepCtx.Path.Mrid]; ok && patch.BaseCommitId != 0 {
+			set.bases = append(set.bases, patchBase{index: i, path: patch.Path, mrid: prepCtx.Path.Mrid, commitId: patch.BaseCommitId})
+		}
+
+		switch {
+		case patch.Op == "test":
+			continue
+		case patch.Op == "add" && prepCtx.Path.Field == "":
+			set.created[prepCtx.Path.Mrid] = struct{}{}
+		case patch.Op == "move":
+			set.changed[prepCtx.From.Mrid] = struct{}{}
+		}
+		set.changed[prepCtx.Path.Mrid] = struct{}{}
+	}
+	return set, errs
+}
+
+// PreviewPatch validates all patches and reports how each changed object would differ from its
+// stored version without writing anything
+func PreviewPatch(ctx context.Context, db *bun.DB, patches []JsonPatch) PatchPreview {
+	set, errs := preparePatches(ctx, db, patches, true)
+	preview := PatchPreview{Valid: len(errs) == 0, Errors: errs, Objects: []ObjectDiff{}}
+	if preview.Errors == nil {
+		preview.Errors = []PatchError{}
+	}
+
+	for _, mrid := range slices.Sorted(Keys(set.changed)) {
+		var before models.VersionedIdentifiedObject
+		if original, ok := set.originals[mrid]; ok {
+			before = original.(models.VersionedIdentifiedObject)
 		}
-		result[prepCtx.Path.Mrid] = prepCtx.Model
+		after := set.objects[mrid].(models.VersionedIdentifiedObject)
+		if diff := diffObject(StructName(after), before, after); diff != nil {
+			preview.Objects = append(preview.Objects, *diff)
+		}
+	}
+	return preview
+}
+
+// ApplyPatch applies a list of RFC 6902 operations and stores all changed objects in one commit.
+// Paths are either /<mrid>/<field> or /<mrid> for operations on whole objects, where add creates a
+// new object and remove marks an object as deleted. Nothing is written if any operation fails,
+// including test operations. Invalid patches, and patches on objects that changed after their base
+// commit, give a *PatchError, failures to store the changes a *StorageError.
+func ApplyPatch(ctx context.Context, db *bun.DB, author string, patches []JsonPatch) error {
+	set, errs := preparePatches(ctx, db, patches, false)
+	if len(errs) > 0 {
+		return &errs[0]
+	}
+	if len(set.changed) == 0 {
+		return nil
 	}
 
 	commit := models.Commit{
-		Message: fmt.Sprintf("Applied json patch to %d objects", len(result)),
+		Message: fmt.Sprintf("Applied json patch to %d objects", len(set.changed)),
 		Author:  author,
 	}
 
+	modelId, _ := repository.ModelFromCtx(ctx)
 	itemIter := func(yield func(v any) bool) {
-		for _, v := range result {
-			if !yield(v) {
+		for _, mrid := range slices.Sorted(Keys(set.created)) {
+			entity := MakeEntity(set.objects[mrid].(models.MridGetter), modelId)
+			if !yield(&entity) {
+				return
+			}
+		}
+		for _, mrid := range slices.Sorted(Keys(set.changed)) {
+			if !yield(set.objects[mrid]) {
 				return
 			}
 		}
 	}
 
 	inserter := repository.BunInserter{Db: db}
-	return InsertAllInserter(ctx, &inserter, commit, itemIter, NoOpOnInsert)
+	err := InsertAllWithHooks(ctx, &inserter, commit, itemIter, NoOpOnInsert, TxHooks{Before: set.checkBaseCommits})
+	var invalid *PatchError
+	if errors.As(err, &invalid) {
+		return invalid
+	}
+	if err != nil {
+		return &StorageError{Err: err}
+	}
+	return nil
 }
 
 type ParsedPath struct {
 	Mrid  string
 	Field string
+
+	// Nested holds the keys below the field for paths into embedded value types
+	Nested []string
+}
+
+// Keys returns the field followed by the nested keys
+func (p ParsedPath) Keys() []string {
+	return append([]string{p.Field}, p.Nested...)
 }
 
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// ParsePath splits a path of the form /<resourceId>/<field>/<nested>... Paths to whole objects,
+// /<resourceId>, have an empty field. Keys are unescaped as described in RFC 6901.
 func ParsePath(path string) (ParsedPath, error) {
-	result := strings.Split(path, "/")
-	if len(result) != 3 {
-		return ParsedPath{}, fmt.Errorf("Path %s must match /<resourceId>/<field>", path)
+	segments := strings.Split(path, "/")
+	if len(segments) < 2 || segments[0] != "" || slices.Contains(segments[1:], "") {
+		return ParsedPath{}, fmt.Errorf("Path %s must match /<resourceId>/<field> or /<resourceId>", path)
+	}
+	for i, segment := range segments {
+		segments[i] = pointerUnescaper.Replace(segment)
+	}
+
+	result := ParsedPath{Mrid: segments[1]}
+	if len(segments) > 2 {
+		result.Field = segments[2]
+	}
+	if len(segments) > 3 {
+		result.Nested = segments[3:]
 	}
-	return ParsedPath{Mrid: result[1], Field: result[2]}, nil
+	return result, nil
 }
 
 func ErrorIfNotOk(ok bool, msg string) error {
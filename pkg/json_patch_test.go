@@ -3,11 +3,14 @@ package pkg
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
 	"testing"
 
 	"com.github/davidkleiven/tripleworks/migrations"
 	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 )
@@ -64,7 +67,7 @@ func TestApplyPatch(t *testing.T) {
 	t.Run("unknown wrong path format", func(t *testing.T) {
 		patch := []JsonPatch{{
 			Op:   "replace",
-			Path: "/0000-0000/nominal_voltage/what",
+			Path: "0000-0000/nominal_voltage",
 		}}
 		err = ApplyPatch(ctx, db, "author", patch)
 		require.ErrorContains(t, err, "Parse patch")
@@ -105,4 +108,258 @@ func TestApplyPatch(t *testing.T) {
 		require.Equal(t, 135.0, active[0].NominalVoltage)
 		require.Equal(t, "double modified bv", active[0].Name)
 	})
+
+	t.Run("base commit checked in transaction", func(t *testing.T) {
+		var head models.BaseVoltage
+		require.NoError(t, db.NewSelect().Model(&head).Where("mrid = ?", bv.Mrid).Order("commit_id DESC").Limit(1).Scan(ctx))
+
+		based := []JsonPatch{{
+			Op:           "replace",
+			Path:         fmt.Sprintf("/%s/nominal_voltage", bv.Mrid),
+			Value:        []byte("400"),
+			BaseCommitId: int64(head.CommitId),
+		}}
+		set, errs := preparePatches(ctx, db, based, false)
+		require.Empty(t, errs)
+		require.NoError(t, set.checkBaseCommits(ctx, &repository.BunInserter{Db: db}))
+
+		// Someone else stores a change between the preparation and the insert
+		require.NoError(t, ApplyPatch(ctx, db, "other", patch))
+
+		err := set.checkBaseCommits(ctx, &repository.BunInserter{Db: db})
+		var invalid *PatchError
+		require.True(t, errors.As(err, &invalid))
+		require.Equal(t, based[0].Path, invalid.Path)
+		var conflict *ConflictError
+		require.True(t, errors.As(err, &conflict))
+		require.Equal(t, int64(head.CommitId), conflict.BaseCommitId)
+	})
+}
+
+func TestApplyPatchOperations(t *testing.T) {
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	ctx := context.Background()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	first := baseVoltageVersion(uuid.New(), "first", 132.0)
+	second := baseVoltageVersion(uuid.New(), "second", 220.0)
+	items := []any{
+		&models.Entity{Mrid: first.Mrid, EntityType: "BaseVoltage"}, first,
+		&models.Entity{Mrid: second.Mrid, EntityType: "BaseVoltage"}, second,
+	}
+	require.NoError(t, InsertAll(ctx, db, models.Commit{}, slices.Values(items), NoOpOnInsert))
+
+	latest := func(mrid uuid.UUID) models.BaseVoltage {
+		var bv models.BaseVoltage
+		require.NoError(t, db.NewSelect().Model(&bv).Where("mrid = ?", mrid).Order("commit_id DESC").Limit(1).Scan(ctx))
+		return bv
+	}
+	numCommits := func() int {
+		num, err := db.NewSelect().Model((*models.Commit)(nil)).Count(ctx)
+		require.NoError(t, err)
+		return num
+	}
+	path := func(mrid uuid.UUID, field string) string {
+		return fmt.Sprintf("/%s/%s", mrid, field)
+	}
+
+	t.Run("copy and move", func(t *testing.T) {
+		patches := []JsonPatch{
+			{Op: "copy", From: path(first.Mrid, "nominal_voltage"), Path: path(second.Mrid, "nominal_voltage")},
+			{Op: "move", From: path(first.Mrid, "name"), Path: path(second.Mrid, "description")},
+		}
+		require.NoError(t, ApplyPatch(ctx, db, "author", patches))
+		require.Equal(t, 132.0, latest(second.Mrid).NominalVoltage)
+		require.Equal(t, "first", latest(second.Mrid).Description)
+		require.Equal(t, "", latest(first.Mrid).Name)
+	})
+
+	t.Run("add and remove fields", func(t *testing.T) {
+		patches := []JsonPatch{
+			{Op: "add", Path: path(first.Mrid, "name"), Value: json.RawMessage(`"renamed"`)},
+			{Op: "remove", Path: path(second.Mrid, "description")},
+		}
+		require.NoError(t, ApplyPatch(ctx, db, "author", patches))
+		require.Equal(t, "renamed", latest(first.Mrid).Name)
+		require.Equal(t, "", latest(second.Mrid).Description)
+	})
+
+	t.Run("failing test aborts all operations", func(t *testing.T) {
+		before := numCommits()
+		patches := []JsonPatch{
+			{Op: "replace", Path: path(first.Mrid, "nominal_voltage"), Value: json.RawMessage("400")},
+			{Op: "test", Path: path(second.Mrid, "nominal_voltage"), Value: json.RawMessage("220")},
+		}
+		err := ApplyPatch(ctx, db, "author", patches)
+		var testErr *PatchTestError
+		require.ErrorAs(t, err, &testErr)
+		require.Equal(t, 1, testErr.Index)
+		require.Equal(t, 132.0, latest(first.Mrid).NominalVoltage)
+		require.Equal(t, before, numCommits())
+	})
+
+	t.Run("passing test alone writes nothing", func(t *testing.T) {
+		before := numCommits()
+		patches := []JsonPatch{{Op: "test", Path: path(second.Mrid, "nominal_voltage"), Value: json.RawMessage("132")}}
+		require.NoError(t, ApplyPatch(ctx, db, "author", patches))
+		require.Equal(t, before, numCommits())
+	})
+
+	t.Run("add new object", func(t *testing.T) {
+		mrid := uuid.New()
+		patches := []JsonPatch{
+			{Op: "add", Path: "/" + mrid.String(), Value: json.RawMessage(`{"cim_type": "BaseVoltage", "name": "new", "nominal_voltage": 400}`)},
+			{Op: "replace", Path: path(mrid, "nominal_voltage"), Value: json.RawMessage("420")},
+		}
+		require.NoError(t, ApplyPatch(ctx, db, "author", patches))
+		require.Equal(t, 420.0, latest(mrid).NominalVoltage)
+
+		var entity models.Entity
+		require.NoError(t, db.NewSelect().Model(&entity).Where("mrid = ?", mrid).Scan(ctx))
+		require.Equal(t, "BaseVoltage", entity.EntityType)
+
+		err := ApplyPatch(ctx, db, "author", patches[:1])
+		require.ErrorContains(t, err, "already exists")
+		require.NotContains(t, err.Error(), "%!w")
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		err = ApplyPatch(canceled, db, "author", []JsonPatch{{Op: "add", Path: "/" + uuid.New().String(), Value: patches[0].Value}})
+		require.ErrorIs(t, err, context.Canceled)
+		require.NotContains(t, err.Error(), "already exists")
+	})
+
+	t.Run("remove object soft deletes", func(t *testing.T) {
+		require.NoError(t, ApplyPatch(ctx, db, "author", []JsonPatch{{Op: "remove", Path: "/" + second.Mrid.String()}}))
+		require.True(t, latest(second.Mrid).Deleted)
+	})
+
+	for _, test := range []struct {
+		desc  string
+		patch JsonPatch
+		msg   string
+	}{
+		{desc: "unknown field", patch: JsonPatch{Op: "replace", Path: path(first.Mrid, "unknown"), Value: json.RawMessage("1")}, msg: "Unknown field"},
+		{desc: "missing value", patch: JsonPatch{Op: "replace", Path: path(first.Mrid, "name")}, msg: "requires a value"},
+		{desc: "replace whole object", patch: JsonPatch{Op: "replace", Path: "/" + first.Mrid.String(), Value: json.RawMessage("{}")}, msg: "requires a path to a field"},
+		{desc: "move from object", patch: JsonPatch{Op: "move", From: "/" + first.Mrid.String(), Path: path(first.Mrid, "name")}, msg: "requires a from path"},
+		{desc: "add with unknown type", patch: JsonPatch{Op: "add", Path: "/" + uuid.New().String(), Value: json.RawMessage(`{"cim_type": "Unknown"}`)}, msg: "Unknown type"},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			require.ErrorContains(t, ApplyPatch(ctx, db, "author", []JsonPatch{test.patch}), test.msg)
+		})
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	for _, test := range []struct {
+		path string
+		want ParsedPath
+		ok   bool
+	}{
+		{path: "/abc/name", want: ParsedPath{Mrid: "abc", Field: "name"}, ok: true},
+		{path: "/abc", want: ParsedPath{Mrid: "abc"}, ok: true},
+		{path: "/abc/rated_s/value", want: ParsedPath{Mrid: "abc", Field: "rated_s", Nested: []string{"value"}}, ok: true},
+		{path: "/abc/a~1b/c~0d", want: ParsedPath{Mrid: "abc", Field: "a/b", Nested: []string{"c~d"}}, ok: true},
+		{path: "/abc/", ok: false},
+		{path: "abc/name", ok: false},
+		{path: "/", ok: false},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			got, err := ParsePath(test.path)
+			require.Equal(t, test.ok, err == nil)
+			require.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestPreviewPatch(t *testing.T) {
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	ctx := context.Background()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	bv := baseVoltageVersion(uuid.New(), "bv", 132.0)
+	var terminal models.Terminal
+	terminal.Mrid = uuid.New()
+	terminal.PhasesId = 1
+	items := []any{
+		&models.Entity{Mrid: bv.Mrid, EntityType: "BaseVoltage"}, bv,
+		&models.Entity{Mrid: terminal.Mrid, EntityType: "Terminal"}, &terminal,
+	}
+	require.NoError(t, InsertAll(ctx, db, models.Commit{}, slices.Values(items), NoOpOnInsert))
+
+	numCommits := func() int {
+		num, err := db.NewSelect().Model((*models.Commit)(nil)).Count(ctx)
+		require.NoError(t, err)
+		return num
+	}
+	before := numCommits()
+
+	t.Run("valid patch reports diff", func(t *testing.T) {
+		patches := []JsonPatch{
+			{Op: "replace", Path: fmt.Sprintf("/%s/nominal_voltage", bv.Mrid), Value: json.RawMessage("220")},
+			{Op: "replace", Path: fmt.Sprintf("/%s/connectivity_node_mrid", terminal.Mrid), Value: json.RawMessage(`"` + bv.Mrid.String() + `"`)},
+		}
+		preview := PreviewPatch(ctx, db, patches)
+		require.True(t, preview.Valid)
+		require.Equal(t, 2, len(preview.Objects))
+
+		diffs := make(map[uuid.UUID]ObjectDiff)
+		for _, diff := range preview.Objects {
+			diffs[diff.Mrid] = diff
+		}
+		require.Equal(t, ChangeModified, diffs[bv.Mrid].Change)
+		require.Equal(t, []FieldChange{{Field: "nominal_voltage", Old: 132.0, New: 220.0}}, diffs[bv.Mrid].Fields)
+		require.Equal(t, before, numCommits())
+	})
+
+	t.Run("new and removed objects", func(t *testing.T) {
+		mrid := uuid.New()
+		patches := []JsonPatch{
+			{Op: "add", Path: "/" + mrid.String(), Value: json.RawMessage(`{"cim_type": "BaseVoltage", "nominal_voltage": 22}`)},
+			{Op: "remove", Path: "/" + bv.Mrid.String()},
+		}
+		preview := PreviewPatch(ctx, db, patches)
+		require.True(t, preview.Valid)
+
+		changes := make(map[uuid.UUID]string)
+		for _, diff := range preview.Objects {
+			changes[diff.Mrid] = diff.Change
+		}
+		require.Equal(t, map[uuid.UUID]string{mrid: ChangeAdded, bv.Mrid: ChangeDeleted}, changes)
+		require.Equal(t, before, numCommits())
+	})
+
+	t.Run("reports every invalid patch", func(t *testing.T) {
+		patches := []JsonPatch{
+			{Op: "replace", Path: fmt.Sprintf("/%s/nominal_voltage", bv.Mrid), Value: json.RawMessage(`"high"`)},
+			{Op: "replace", Path: fmt.Sprintf("/%s/name", bv.Mrid), Value: json.RawMessage(`"valid"`)},
+			{Op: "replace", Path: fmt.Sprintf("/%s/phases_id", terminal.Mrid), Value: json.RawMessage("1000")},
+			{Op: "replace", Path: fmt.Sprintf("/%s/connectivity_node_mrid", terminal.Mrid), Value: json.RawMessage(`"` + uuid.New().String() + `"`)},
+		}
+		preview := PreviewPatch(ctx, db, patches)
+		require.False(t, preview.Valid)
+		require.Equal(t, 3, len(preview.Errors))
+
+		indices := []int{}
+		for _, patchErr := range preview.Errors {
+			indices = append(indices, patchErr.Index)
+			require.Equal(t, patches[patchErr.Index].Path, patchErr.Path)
+		}
+		require.Equal(t, []int{0, 2, 3}, indices)
+		require.Contains(t, preview.Errors[0].Message, "not valid for field nominal_voltage")
+		require.Contains(t, preview.Errors[1].Message, "not a valid id of PhaseCode")
+		require.Contains(t, preview.Errors[2].Message, "does not exist")
+	})
+
+	t.Run("apply reports the failing patch", func(t *testing.T) {
+		patches := []JsonPatch{{Op: "replace", Path: fmt.Sprintf("/%s/nominal_voltage", bv.Mrid), Value: json.RawMessage(`"high"`)}}
+		err := ApplyPatch(ctx, db, "author", patches)
+		var patchErr *PatchError
+		require.ErrorAs(t, err, &patchErr)
+		require.Equal(t, 0, patchErr.Index)
+		require.Equal(t, before, numCommits())
+	})
 }
@@ -5,7 +5,9 @@ import (
 	"iter"
 	"math"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 
 	"com.github/davidkleiven/tripleworks/models"
 	"github.com/google/uuid"
@@ -186,8 +188,8 @@ func CreateBaseVoltage(voltage int) models.BaseVoltage {
 type LineLight struct {
 	FromSubstation string  `json:"from"`
 	ToSubstation   string  `json:"to"`
-	Length         float64 `json:"length"`
-	Voltage        int     `json:"voltage"`
+	Length         float64 `json:"length" unit:"km"`
+	Voltage        int     `json:"voltage" unit:"kV"`
 }
 
 func (l *LineLight) CimItems(modelId int) iter.Seq[any] {
@@ -264,9 +266,9 @@ type GeneratorLight struct {
 	Kind       string  `json:"kind"`
 	Substation string  `json:"substation"`
 	Num        int     `json:"num"`
-	MaxP       float64 `json:"maxP"`
-	MinP       float64 `json:"minP"`
-	Voltage    int     `json:"voltage"`
+	MaxP       float64 `json:"maxP" unit:"MW"`
+	MinP       float64 `json:"minP" unit:"MW"`
+	Voltage    int     `json:"voltage" unit:"kV"`
 }
 
 func (g *GeneratorLight) CimItems(modelId int) iter.Seq[any] {
@@ -433,8 +435,8 @@ func YieldMany(yield func(v any) bool, values ...any) {
 type LoadLight struct {
 	Substation string  `json:"substation"`
 	Num        int     `json:"num"`
-	NominalP   float64 `json:"nominalP"`
-	Voltage    int     `json:"voltage"`
+	NominalP   float64 `json:"nominalP" unit:"MW"`
+	Voltage    int     `json:"voltage" unit:"kV"`
 }
 
 func (l *LoadLight) CimItems(modelId int) iter.Seq[any] {
@@ -488,3 +490,404 @@ func (l *LoadLight) CimItems(modelId int) iter.Seq[any] {
 			&bv, &vl, &loadResponse, &conformLoad, &loadGroup, &loadArea, &subLoadArea)
 	}
 }
+
+func transformerMrid(name string) uuid.UUID {
+	return mridFromName("PowerTransformer", name)
+}
+
+func transformerEndMrid(name string) uuid.UUID {
+	return mridFromName("PowerTransformerEnd", name)
+}
+
+func shuntMrid(name string) uuid.UUID {
+	return mridFromName("LinearShuntCompensator", name)
+}
+
+func switchMrid(name string) uuid.UUID {
+	return mridFromName("Switch", name)
+}
+
+func dcLineMrid(name string) uuid.UUID {
+	return mridFromName("DCLine", name)
+}
+
+func dcLineSegmentMrid(name string) uuid.UUID {
+	return mridFromName("DCLineSegment", name)
+}
+
+func perLengthDcParameterMrid(resistance float64) uuid.UUID {
+	return mridFromName("PerLengthDCLineParameter", fmt.Sprintf("%.4f", resistance))
+}
+
+func converterUnitMrid(name string) uuid.UUID {
+	return mridFromName("DCConverterUnit", name)
+}
+
+func converterMrid(name string) uuid.UUID {
+	return mridFromName("VsConverter", name)
+}
+
+func vsCapabilityCurveMrid(p float64) uuid.UUID {
+	return mridFromName("VsCapabilityCurve", fmt.Sprintf("%.2f", p))
+}
+
+func dcNodeMrid(name string) uuid.UUID {
+	return mridFromName("DCNode", name)
+}
+
+func dcTerminalMrid(name string) uuid.UUID {
+	return mridFromName("DCTerminal", name)
+}
+
+// objectGraph yields an entity for every object followed by the objects themselves
+func objectGraph(modelId int, objects ...any) iter.Seq[any] {
+	return func(yield func(v any) bool) {
+		for _, object := range objects {
+			mridGetter, ok := object.(models.MridGetter)
+			if !ok {
+				continue
+			}
+			entity := MakeEntity(mridGetter, modelId)
+			if !yield(&entity) {
+				return
+			}
+		}
+		YieldMany(yield, objects...)
+	}
+}
+
+// CreateReportingGroup creates the reporting group collecting the bus name markers of a substation
+func CreateReportingGroup(substation string) models.ReportingGroup {
+	var repGroup models.ReportingGroup
+	repGroup.Mrid = reportingGroupMrid(substation)
+	repGroup.Name = "RG " + substation
+	repGroup.ShortName = repGroup.Name
+	repGroup.Description = "Reporting group for " + substation
+	return repGroup
+}
+
+// connection connects one terminal of a piece of equipment to its own connectivity node
+type connection struct {
+	marker   models.BusNameMarker
+	conNode  models.ConnectivityNode
+	terminal models.Terminal
+}
+
+func newConnection(name string, vl models.VoltageLevel, repGroup models.ReportingGroup, equipment uuid.UUID, sequenceNumber int) *connection {
+	var c connection
+	c.marker.Mrid = busNameMarkerMrid(name)
+	c.marker.Name = "Bus Name Marker " + name
+	c.marker.ShortName = c.marker.Name
+	c.marker.ReportingGroupMrid = repGroup.Mrid
+
+	c.conNode.Mrid = conNodeMrid(name)
+	c.conNode.Name = "Connectivity node " + name
+	c.conNode.ShortName = "CN " + name
+	c.conNode.ConnectivityNodeContainerMrid = vl.Mrid
+
+	c.terminal.Mrid = terminalMrid(name)
+	c.terminal.Name = name
+	c.terminal.ShortName = name
+	c.terminal.BusNameMarkerMrid = c.marker.Mrid
+	c.terminal.SequenceNumber = sequenceNumber
+	c.terminal.PhasesId = 1
+	c.terminal.ConductingEquipmentMrid = equipment
+	c.terminal.ConnectivityNodeMrid = c.conNode.Mrid
+	return &c
+}
+
+func (c *connection) objects() []any {
+	return []any{&c.marker, &c.conNode, &c.terminal}
+}
+
+type TransformerLight struct {
+	Substation  string  `json:"substation"`
+	Num         int     `json:"num"`
+	HighVoltage int     `json:"hv" unit:"kV"`
+	LowVoltage  int     `json:"lv" unit:"kV"`
+	RatedS      float64 `json:"ratedS" unit:"MVA"`
+	Uk          float64 `json:"uk" unit:"%"`
+}
+
+func (t *TransformerLight) CimItems(modelId int) iter.Seq[any] {
+	name := fmt.Sprintf("%s T%d", t.Substation, t.Num)
+	repGroup := CreateReportingGroup(t.Substation)
+
+	var transformer models.PowerTransformer
+	transformer.Mrid = transformerMrid(name)
+	transformer.Name = name
+	transformer.ShortName = name
+	transformer.Description = fmt.Sprintf("Transformer %d/%d kV", t.HighVoltage, t.LowVoltage)
+	transformer.BaseVoltageMrid = baseVoltageMrid(t.HighVoltage)
+	transformer.EquipmentContainerMrid = substationMrid(t.Substation)
+
+	objects := []any{&repGroup, &transformer}
+	for i, voltage := range []int{t.HighVoltage, t.LowVoltage} {
+		side := [2]string{"HV", "LV"}[i]
+		endName := fmt.Sprintf("%s %s", name, side)
+
+		bv := CreateBaseVoltage(voltage)
+		vl := CreateVoltageLevel(t.Substation, voltage)
+		conn := newConnection(endName, vl, repGroup, transformer.Mrid, i+1)
+
+		var end models.PowerTransformerEnd
+		end.Mrid = transformerEndMrid(endName)
+		end.Name = endName
+		end.ShortName = side
+		end.EndNumber = i + 1
+		end.BaseVoltageMrid = bv.Mrid
+		end.TerminalMrid = conn.terminal.Mrid
+		end.PowerTransformerMrid = transformer.Mrid
+		end.RatedS = t.RatedS
+		end.RatedU = float64(voltage)
+		end.ConnectionKindId = 2 // D
+
+		// The short circuit impedance is referred to the high voltage side
+		if i == 0 {
+			end.ConnectionKindId = 5 // Yn
+			if t.RatedS > 0 {
+				end.X = t.Uk / 100.0 * float64(voltage*voltage) / t.RatedS
+			}
+		}
+		objects = append(objects, &bv, &vl)
+		objects = append(objects, conn.objects()...)
+		objects = append(objects, &end)
+	}
+	return objectGraph(modelId, objects...)
+}
+
+type ShuntLight struct {
+	Substation string  `json:"substation"`
+	Num        int     `json:"num"`
+	Voltage    int     `json:"voltage" unit:"kV"`
+	Q          float64 `json:"q" unit:"MVAr"`
+	Sections   int     `json:"sections"`
+}
+
+func (s *ShuntLight) CimItems(modelId int) iter.Seq[any] {
+	name := fmt.Sprintf("%s SH%d", s.Substation, s.Num)
+	sections := max(s.Sections, 1)
+
+	repGroup := CreateReportingGroup(s.Substation)
+	bv := CreateBaseVoltage(s.Voltage)
+	vl := CreateVoltageLevel(s.Substation, s.Voltage)
+
+	var shunt models.LinearShuntCompensator
+	shunt.Mrid = shuntMrid(name)
+	shunt.Name = name
+	shunt.ShortName = name
+	shunt.Description = fmt.Sprintf("Shunt compensator %.1f MVAr", s.Q)
+	shunt.BaseVoltageMrid = bv.Mrid
+	shunt.EquipmentContainerMrid = vl.Mrid
+	shunt.NomU = float64(s.Voltage)
+	shunt.MaximumSections = sections
+	shunt.NormalSections = sections
+	shunt.Grounded = true
+
+	// Positive reactive power is capacitive
+	if s.Voltage > 0 {
+		shunt.BPerSection = s.Q / float64(s.Voltage*s.Voltage) / float64(sections)
+	}
+
+	conn := newConnection(name, vl, repGroup, shunt.Mrid, 1)
+
+	var regControl models.RegulatingControl
+	regControl.Mrid = regulatingControlMrid(name)
+	regControl.Name = "Reg: " + name
+	regControl.ShortName = regControl.Name
+	regControl.Description = "Regulating control for " + name
+	regControl.TerminalMrid = conn.terminal.Mrid
+	regControl.ModeId = 8 // Voltage
+	shunt.RegulatingControlMrid = regControl.Mrid
+
+	objects := append([]any{&repGroup, &bv, &vl}, conn.objects()...)
+	return objectGraph(modelId, append(objects, &regControl, &shunt)...)
+}
+
+// SwitchLight describes a switch in a substation. Kind is one of breaker, disconnector or
+// loadBreakSwitch, while an empty kind or "switch" creates a generic Switch.
+type SwitchLight struct {
+	Kind         string  `json:"kind"`
+	Substation   string  `json:"substation"`
+	Num          int     `json:"num"`
+	Voltage      int     `json:"voltage" unit:"kV"`
+	Open         bool    `json:"open"`
+	RatedCurrent float64 `json:"ratedCurrent" unit:"A"`
+}
+
+var switchKinds = []string{"", "switch", "breaker", "disconnector", "loadBreakSwitch"}
+
+// Validate rejects unknown kinds, such that a misspelled kind does not silently create a generic switch
+func (s *SwitchLight) Validate() error {
+	if !slices.Contains(switchKinds, s.Kind) {
+		return fmt.Errorf("Unknown switch kind '%s', expected one of %s", s.Kind, strings.Join(switchKinds[1:], ", "))
+	}
+	return nil
+}
+
+func (s *SwitchLight) CimItems(modelId int) iter.Seq[any] {
+	name := fmt.Sprintf("%s S%d", s.Substation, s.Num)
+
+	repGroup := CreateReportingGroup(s.Substation)
+	bv := CreateBaseVoltage(s.Voltage)
+	vl := CreateVoltageLevel(s.Substation, s.Voltage)
+
+	var sw models.Switch
+	sw.Mrid = switchMrid(name)
+	sw.Name = name
+	sw.ShortName = name
+	sw.Description = "Switch " + name
+	sw.BaseVoltageMrid = bv.Mrid
+	sw.EquipmentContainerMrid = vl.Mrid
+	sw.NormalOpen = s.Open
+	sw.RatedCurrent = s.RatedCurrent
+
+	var device any
+	switch s.Kind {
+	case "breaker":
+		device = &models.Breaker{ProtectedSwitch: models.ProtectedSwitch{Switch: sw}}
+	case "disconnector":
+		device = &models.Disconnector{Switch: sw}
+	case "loadBreakSwitch":
+		device = &models.LoadBreakSwitch{ProtectedSwitch: models.ProtectedSwitch{Switch: sw}}
+	default:
+		// Validate only lets an empty kind or "switch" through
+		device = &sw
+	}
+
+	objects := []any{&repGroup, &bv, &vl, device}
+	objects = append(objects, newConnection(name+" A", vl, repGroup, sw.Mrid, 1).objects()...)
+	objects = append(objects, newConnection(name+" B", vl, repGroup, sw.Mrid, 2).objects()...)
+	return objectGraph(modelId, objects...)
+}
+
+type HvdcLight struct {
+	FromSubstation string  `json:"from"`
+	ToSubstation   string  `json:"to"`
+	Voltage        int     `json:"voltage" unit:"kV"`
+	DCVoltage      float64 `json:"dcVoltage" unit:"kV"`
+	RatedP         float64 `json:"ratedP" unit:"MW"`
+	Length         float64 `json:"length" unit:"km"`
+	Resistance     float64 `json:"resistance"` // Ohm per km
+}
+
+func (h *HvdcLight) CimItems(modelId int) iter.Seq[any] {
+	name := fmt.Sprintf("%s-%s (HVDC %.0f kV)", h.FromSubstation, h.ToSubstation, h.DCVoltage)
+
+	var geo models.GeographicalRegion
+	geo.Mrid = geoRegionMrid("Transmission lines")
+	geo.Name = "Transmission lines"
+	geo.Description = "All transmission lines belongs to this region"
+
+	var subRegion models.SubGeographicalRegion
+	subRegion.Name = "HVDC links"
+	subRegion.Mrid = subGeoRegionMrid(subRegion.Name)
+	subRegion.Description = "All HVDC links"
+	subRegion.GeographicalRegionMrid = geo.Mrid
+
+	var dcLine models.DCLine
+	dcLine.Mrid = dcLineMrid(name)
+	dcLine.Name = "DC line: " + name
+	dcLine.ShortName = dcLine.Name
+	dcLine.RegionMrid = subRegion.Mrid
+
+	// PerLengthDCLineParameter has no table of its own, so only its entity is registered
+	perLength := models.Entity{
+		Mrid:        perLengthDcParameterMrid(h.Resistance),
+		EntityType:  "PerLengthDCLineParameter",
+		ModelEntity: models.ModelEntity{ModelId: modelId},
+	}
+
+	var segment models.DCLineSegment
+	segment.Mrid = dcLineSegmentMrid(name)
+	segment.Name = name
+	segment.ShortName = name
+	segment.Description = "DC line segment " + name
+	segment.Length = h.Length
+	segment.Resistance = h.Resistance * h.Length
+	segment.PerLengthParameterMrid = perLength.Mrid
+	segment.EquipmentContainerMrid = dcLine.Mrid
+
+	var capability models.VsCapabilityCurve
+	capability.Mrid = vsCapabilityCurveMrid(h.RatedP)
+	capability.Name = fmt.Sprintf("VSC capability %.0f MW", h.RatedP)
+	capability.XUnitId = 10     // W
+	capability.Y1UnitId = 11    // VAr
+	capability.Y2UnitId = 11    // VAr
+	capability.CurveStyleId = 2 // Linear
+
+	qMax := math.Tan(math.Acos(0.95)) * h.RatedP
+	pts := []models.CurveData{
+		{Xvalue: -h.RatedP, Y1value: -qMax, Y2value: qMax, CurveMrid: capability.Mrid},
+		{Xvalue: h.RatedP, Y1value: -qMax, Y2value: qMax, CurveMrid: capability.Mrid},
+	}
+
+	bv := CreateBaseVoltage(h.Voltage)
+	objects := []any{&geo, &subRegion, &dcLine, &perLength, &segment, &capability, &pts[0], &pts[1], &bv}
+	for i, substation := range []string{h.FromSubstation, h.ToSubstation} {
+		endName := fmt.Sprintf("%s %s", name, substation)
+
+		repGroup := CreateReportingGroup(substation)
+		vl := CreateVoltageLevel(substation, h.Voltage)
+
+		var unit models.DCConverterUnit
+		unit.Mrid = converterUnitMrid(endName)
+		unit.Name = "Converter unit " + endName
+		unit.ShortName = unit.Name
+		unit.SubstationMrid = substationMrid(substation)
+		unit.OperationModeId = 3 // Monopolar metallic return
+
+		var converter models.VsConverter
+		converter.Mrid = converterMrid(endName)
+		converter.Name = "Converter " + endName
+		converter.ShortName = converter.Name
+		converter.BaseVoltageMrid = bv.Mrid
+		converter.EquipmentContainerMrid = unit.Mrid
+		converter.BaseS = h.RatedP
+		converter.RatedUdc = h.DCVoltage
+		converter.MaxUdc = 1.1 * h.DCVoltage
+		converter.MinUdc = 0.9 * h.DCVoltage
+		converter.NumberOfValves = 1
+		converter.CapabilityCurveMrid = capability.Mrid
+
+		conn := newConnection(endName, vl, repGroup, converter.Mrid, 1)
+		converter.PccTerminalMrid = conn.terminal.Mrid
+
+		dcName := endName + " DC"
+		var marker models.BusNameMarker
+		marker.Mrid = busNameMarkerMrid(dcName)
+		marker.Name = "Bus Name Marker " + dcName
+		marker.ShortName = marker.Name
+		marker.ReportingGroupMrid = repGroup.Mrid
+
+		var dcNode models.DCNode
+		dcNode.Mrid = dcNodeMrid(dcName)
+		dcNode.Name = "DC node " + endName
+		dcNode.ShortName = dcNode.Name
+		dcNode.DCEquipmentContainerMrid = unit.Mrid
+
+		var converterTerminal models.ACDCConverterDCTerminal
+		converterTerminal.Mrid = dcTerminalMrid(endName + " converter")
+		converterTerminal.Name = "DC terminal " + converter.Name
+		converterTerminal.ShortName = converterTerminal.Name
+		converterTerminal.SequenceNumber = 1
+		converterTerminal.BusNameMarkerMrid = marker.Mrid
+		converterTerminal.DCNodeMrid = dcNode.Mrid
+		converterTerminal.DCConductingEquipmentMrid = converter.Mrid
+		converterTerminal.PolarityId = 3 // Positive
+
+		var lineTerminal models.DCTerminal
+		lineTerminal.Mrid = dcTerminalMrid(endName + " line")
+		lineTerminal.Name = "DC terminal " + endName
+		lineTerminal.ShortName = lineTerminal.Name
+		lineTerminal.SequenceNumber = i + 1
+		lineTerminal.BusNameMarkerMrid = marker.Mrid
+		lineTerminal.DCNodeMrid = dcNode.Mrid
+		lineTerminal.DCConductingEquipmentMrid = segment.Mrid
+
+		objects = append(objects, &repGroup, &vl, &unit, &converter)
+		objects = append(objects, conn.objects()...)
+		objects = append(objects, &marker, &dcNode, &converterTerminal, &lineTerminal)
+	}
+	return objectGraph(modelId, objects...)
+}
@@ -2,6 +2,7 @@ package pkg
 
 import (
 	"context"
+	"slices"
 	"testing"
 
 	"com.github/davidkleiven/tripleworks/migrations"
@@ -147,3 +148,104 @@ func TestConformLoad(t *testing.T) {
 	err = InsertAll(context.Background(), db, loadCommit, itemIterator, NoOpOnInsert)
 	require.NoError(t, err)
 }
+
+func insertSubstationEntities(t *testing.T, db *bun.DB, modelId int, names ...string) {
+	t.Helper()
+	var items []any
+	for _, name := range names {
+		items = append(items, &models.Entity{
+			Mrid: substationMrid(name), EntityType: "Substation", ModelEntity: models.ModelEntity{ModelId: modelId},
+		})
+	}
+	err := InsertAll(context.Background(), db, models.Commit{Message: "Create substations"}, slices.Values(items), NoOpOnInsert)
+	require.NoError(t, err)
+}
+
+func TestTransformer(t *testing.T) {
+	transformer := TransformerLight{Substation: "Sub A", Num: 1, HighVoltage: 420, LowVoltage: 132, RatedS: 400, Uk: 12}
+
+	db, modelId := setupDb(t)
+	insertSubstationEntities(t, db, modelId, transformer.Substation)
+
+	var ends []*models.PowerTransformerEnd
+	for item := range transformer.CimItems(modelId) {
+		if end, ok := item.(*models.PowerTransformerEnd); ok {
+			ends = append(ends, end)
+		}
+	}
+	require.Equal(t, 2, len(ends))
+	require.InDelta(t, 52.92, ends[0].X, 1e-9)
+	require.Equal(t, 132.0, ends[1].RatedU)
+	require.Zero(t, ends[1].X)
+
+	commit := models.Commit{Message: "Create transformer in substation A"}
+	err := InsertAll(context.Background(), db, commit, transformer.CimItems(modelId), NoOpOnInsert)
+	require.NoError(t, err)
+}
+
+func TestShunt(t *testing.T) {
+	shunt := ShuntLight{Substation: "Sub A", Num: 1, Voltage: 100, Q: 50, Sections: 2}
+
+	db, modelId := setupDb(t)
+	insertSubstationEntities(t, db, modelId, shunt.Substation)
+
+	for item := range shunt.CimItems(modelId) {
+		if compensator, ok := item.(*models.LinearShuntCompensator); ok {
+			require.InDelta(t, 0.0025, compensator.BPerSection, 1e-12)
+			require.Equal(t, 2, compensator.MaximumSections)
+		}
+	}
+
+	commit := models.Commit{Message: "Create shunt in substation A"}
+	err := InsertAll(context.Background(), db, commit, shunt.CimItems(modelId), NoOpOnInsert)
+	require.NoError(t, err)
+}
+
+func TestSwitch(t *testing.T) {
+	db, modelId := setupDb(t)
+	insertSubstationEntities(t, db, modelId, "Sub A")
+
+	for i, kind := range []string{"breaker", "disconnector", "loadBreakSwitch", "switch", ""} {
+		sw := SwitchLight{Kind: kind, Substation: "Sub A", Num: i, Voltage: 132, Open: true}
+		require.NoError(t, sw.Validate())
+		commit := models.Commit{Message: "Create " + kind}
+		err := InsertAll(context.Background(), db, commit, sw.CimItems(modelId), NoOpOnInsert)
+		require.NoError(t, err)
+	}
+
+	breakers, err := FindAll[models.Breaker](db, context.Background(), modelId)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(breakers))
+	require.True(t, breakers[0].NormalOpen)
+
+	switches, err := FindAll[models.Switch](db, context.Background(), modelId)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(switches))
+
+	terminals, err := FindAll[models.Terminal](db, context.Background(), modelId)
+	require.NoError(t, err)
+	require.Equal(t, 10, len(terminals))
+
+	misspelled := SwitchLight{Kind: "braker", Substation: "Sub A", Voltage: 132}
+	require.ErrorContains(t, misspelled.Validate(), "Unknown switch kind 'braker'")
+}
+
+func TestHvdc(t *testing.T) {
+	link := HvdcLight{FromSubstation: "Sub A", ToSubstation: "Sub B", Voltage: 420, DCVoltage: 525, RatedP: 1400, Length: 600}
+
+	db, modelId := setupDb(t)
+	insertSubstationEntities(t, db, modelId, link.FromSubstation, link.ToSubstation)
+
+	commit := models.Commit{Message: "Create HVDC link"}
+	err := InsertAll(context.Background(), db, commit, link.CimItems(modelId), NoOpOnInsert)
+	require.NoError(t, err)
+
+	converters, err := FindAll[models.VsConverter](db, context.Background(), modelId)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(converters))
+
+	lineTerminals, err := FindAll[models.DCTerminal](db, context.Background(), modelId)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(lineTerminals))
+	require.NotEqual(t, lineTerminals[0].DCNodeMrid, lineTerminals[1].DCNodeMrid)
+}
@@ -0,0 +1,280 @@
+package pkg
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Fields that carry version bookkeeping rather than data. They are ignored when comparing versions
+var versionFields = []string{"Id", "CommitId", "Commit"}
+
+type MergeConflict struct {
+	Mrid   uuid.UUID `json:"mrid"`
+	Type   string    `json:"type"`
+	Field  string    `json:"field"`
+	Base   any       `json:"base"`
+	Main   any       `json:"main"`
+	Branch any       `json:"branch"`
+}
+
+type MergeResult struct {
+	Branch    string          `json:"branch"`
+	DryRun    bool            `json:"dry_run"`
+	CommitId  int64           `json:"commit_id,omitempty"`
+	Merged    int             `json:"merged"`
+	Conflicts []MergeConflict `json:"conflicts"`
+}
+
+// VersionsOf returns all rows of the table backing itemPtr that pass the filter
+func VersionsOf(ctx context.Context, db bun.IDB, itemPtr any, filter func(q *bun.SelectQuery) *bun.SelectQuery) ([]models.VersionedIdentifiedObject, error) {
+	rows := reflect.New(reflect.SliceOf(reflect.TypeOf(itemPtr).Elem()))
+	if err := db.NewSelect().Model(rows.Interface()).Apply(filter).Scan(ctx); err != nil {
+		return nil, fmt.Errorf("Failed to fetch versions of %s: %w", StructName(itemPtr), err)
+	}
+
+	result := make([]models.VersionedIdentifiedObject, rows.Elem().Len())
+	for i := range result {
+		result[i] = rows.Elem().Index(i).Addr().Interface().(models.VersionedIdentifiedObject)
+	}
+	return result, nil
+}
+
+// GenericFields returns the json representation of a versioned object without version bookkeeping fields
+func GenericFields(v any) map[string]any {
+	var generic map[string]any
+	PanicOnErr(json.Unmarshal(Must(json.Marshal(v)), &generic))
+	for _, field := range versionFields {
+		delete(generic, field)
+	}
+	return generic
+}
+
+func latestVersion(items []models.VersionedIdentifiedObject, keep func(commitId int) bool) models.VersionedIdentifiedObject {
+	var latest models.VersionedIdentifiedObject
+	for _, item := range items {
+		if !keep(item.GetCommitId()) {
+			continue
+		}
+		if latest == nil || item.GetCommitId() > latest.GetCommitId() {
+			latest = item
+		}
+	}
+	return latest
+}
+
+func fieldsOrEmpty(v models.VersionedIdentifiedObject) map[string]any {
+	if v == nil {
+		return map[string]any{}
+	}
+	return GenericFields(v)
+}
+
+type mergeCandidate struct {
+	fields    map[string]any
+	conflicts []MergeConflict
+}
+
+// modifiesData reports whether any field other than the deleted flag differs from the base
+func modifiesData(base, other map[string]any) bool {
+	for field, value := range other {
+		if field != "deleted" && !reflect.DeepEqual(base[field], value) {
+			return true
+		}
+	}
+	return false
+}
+
+// deletedSince reports whether the object was active in the base version and is deleted in the other
+func deletedSince(base, other map[string]any) bool {
+	return base["deleted"] == false && other["deleted"] == true
+}
+
+// mergeObject performs a three-way merge of a single object. The base is the newest version on main
+// at the fork point, head is the newest version on main and branch is the newest version on the branch.
+// Deleting the object on one side while modifying it on the other is a conflict on the deleted field.
+// A nil candidate is returned if merging does not change main.
+func mergeObject(kind string, base, head, branch models.VersionedIdentifiedObject) *mergeCandidate {
+	baseFields := fieldsOrEmpty(base)
+	headFields := fieldsOrEmpty(head)
+	branchFields := fieldsOrEmpty(branch)
+
+	deletedOnMain := deletedSince(baseFields, headFields)
+	deletedOnBranch := deletedSince(baseFields, branchFields)
+	if deletedOnMain && deletedOnBranch {
+		return nil
+	}
+	if (deletedOnMain && modifiesData(baseFields, branchFields)) || (deletedOnBranch && modifiesData(baseFields, headFields)) {
+		conflict := MergeConflict{
+			Mrid:   branch.GetMrid(),
+			Type:   kind,
+			Field:  "deleted",
+			Base:   baseFields["deleted"],
+			Main:   headFields["deleted"],
+			Branch: branchFields["deleted"],
+		}
+		return &mergeCandidate{conflicts: []MergeConflict{conflict}}
+	}
+
+	merged := headFields
+	if head == nil {
+		merged = map[string]any{}
+	}
+
+	candidate := mergeCandidate{fields: merged}
+	changed := false
+	for field, value := range branchFields {
+		if reflect.DeepEqual(baseFields[field], value) {
+			continue
+		}
+
+		mainChanged := !reflect.DeepEqual(baseFields[field], headFields[field])
+		if mainChanged && !reflect.DeepEqual(headFields[field], value) {
+			candidate.conflicts = append(candidate.conflicts, MergeConflict{
+				Mrid:   branch.GetMrid(),
+				Type:   kind,
+				Field:  field,
+				Base:   baseFields[field],
+				Main:   headFields[field],
+				Branch: value,
+			})
+			continue
+		}
+		if !reflect.DeepEqual(merged[field], value) {
+			merged[field] = value
+			changed = true
+		}
+	}
+
+	if !changed && len(candidate.conflicts) == 0 {
+		return nil
+	}
+	return &candidate
+}
+
+// PrepareMerge replays the newest version of every object changed on the branch onto the head of main.
+// Fields that were also changed on main after the fork point are reported as conflicts. The commit of
+// the newest version on main of every merged object is returned keyed by mrid, or zero if main has none.
+func PrepareMerge(ctx context.Context, db bun.IDB, branch models.Branch) ([]any, map[uuid.UUID]int64, []MergeConflict, error) {
+	var (
+		merged    []any
+		conflicts []MergeConflict
+		mainHeads = make(map[uuid.UUID]int64)
+	)
+
+	mainCtx := repository.WithBranch(ctx, models.MainBranch)
+	all := func(int) bool { return true }
+	beforeFork := func(commitId int) bool { return int64(commitId) <= branch.ForkCommitId }
+
+	formTypes := FormTypes()
+	kinds := slices.Sorted(Keys(formTypes))
+	for _, kind := range kinds {
+		itemPtr := formTypes[kind]
+		if _, ok := itemPtr.(models.VersionedIdentifiedObject); !ok {
+			continue
+		}
+
+		onBranch, err := VersionsOf(ctx, db, itemPtr, func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("?TableAlias.commit_id IN (SELECT id FROM commits WHERE branch = ?)", branch.Name)
+		})
+		if err != nil {
+			return merged, mainHeads, conflicts, err
+		}
+		if len(onBranch) == 0 {
+			continue
+		}
+
+		mrids := make([]uuid.UUID, len(onBranch))
+		for i, item := range onBranch {
+			mrids[i] = item.GetMrid()
+		}
+
+		onMain, err := VersionsOf(ctx, db, itemPtr, func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("?TableAlias.mrid IN (?)", bun.In(mrids)).Apply(repository.OnBranch(mainCtx))
+		})
+		if err != nil {
+			return merged, mainHeads, conflicts, err
+		}
+
+		branchByMrid := GroupBy(onBranch, func(v models.VersionedIdentifiedObject) uuid.UUID { return v.GetMrid() })
+		mainByMrid := GroupBy(onMain, func(v models.VersionedIdentifiedObject) uuid.UUID { return v.GetMrid() })
+		sortedMrids := slices.SortedFunc(Keys(branchByMrid), func(a, b uuid.UUID) int { return cmp.Compare(a.String(), b.String()) })
+		for _, mrid := range sortedMrids {
+			mainHead := latestVersion(mainByMrid[mrid], all)
+			candidate := mergeObject(
+				kind,
+				latestVersion(mainByMrid[mrid], beforeFork),
+				mainHead,
+				latestVersion(branchByMrid[mrid], all),
+			)
+			if candidate == nil {
+				continue
+			}
+			if len(candidate.conflicts) > 0 {
+				conflicts = append(conflicts, candidate.conflicts...)
+				continue
+			}
+
+			item := reflect.New(reflect.TypeOf(itemPtr).Elem()).Interface()
+			if err := json.Unmarshal(Must(json.Marshal(candidate.fields)), item); err != nil {
+				return merged, mainHeads, conflicts, fmt.Errorf("Failed to build merged %s %s: %w", kind, mrid, err)
+			}
+			merged = append(merged, item)
+			if mainHead != nil {
+				mainHeads[mrid] = int64(mainHead.GetCommitId())
+			}
+		}
+	}
+	return merged, mainHeads, conflicts, nil
+}
+
+// MergeBranch merges the branch into main as a single commit. Nothing is written if there are
+// conflicts or if dryRun is true. A *ConflictError is returned if main changed a merged object while
+// the merge was prepared.
+func MergeBranch(ctx context.Context, db bun.IDB, inserter repository.Inserter, branch models.Branch, author string, dryRun bool) (MergeResult, error) {
+	result := MergeResult{Branch: branch.Name, DryRun: dryRun, Conflicts: []MergeConflict{}}
+	merged, mainHeads, conflicts, err := PrepareMerge(ctx, db, branch)
+	if err != nil {
+		return result, fmt.Errorf("Failed to prepare merge: %w", err)
+	}
+	result.Merged = len(merged)
+	result.Conflicts = append(result.Conflicts, conflicts...)
+
+	if dryRun || len(conflicts) > 0 || len(merged) == 0 {
+		return result, nil
+	}
+
+	commit := models.Commit{
+		Branch:  models.MainBranch,
+		Message: fmt.Sprintf("Merge branch %s (%d objects)", branch.Name, len(merged)),
+		Author:  author,
+	}
+
+	// The merge is only stored if main has not changed any of the merged objects since they were merged,
+	// which is checked in the transaction of the insert
+	mainCtx := repository.WithBranch(ctx, models.MainBranch)
+	checkMainHeads := func(ctx context.Context, inserter repository.Inserter) error {
+		versions := repository.TxVersionReader(inserter, &repository.BunVersionReader{Db: db})
+		for _, item := range merged {
+			mrid := item.(models.MridGetter).GetMrid()
+			if err := CheckBaseCommit(mainCtx, versions, item, mrid, mainHeads[mrid]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err = InsertAllWithHooks(ctx, inserter, commit, slices.Values(merged), NoOpOnInsert, TxHooks{Before: checkMainHeads})
+	if err != nil {
+		return result, fmt.Errorf("Failed to insert merged objects: %w", err)
+	}
+	result.CommitId = int64(merged[0].(models.VersionedIdentifiedObject).GetCommitId())
+	return result, nil
+}
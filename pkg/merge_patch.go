@@ -0,0 +1,58 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// MergePatch is a JSON Merge Patch (RFC 7396) document where the keys are mrids and the values are
+// partial objects. A null value deletes the object and an object with a cim_type is created.
+type MergePatch map[string]json.RawMessage
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// mergeOps translates a merged value into operations. Nested objects are merged key by key and null
+// resets a value.
+func mergeOps(path string, value any) []JsonPatch {
+	object, ok := value.(map[string]any)
+	if !ok {
+		if value == nil {
+			return []JsonPatch{{Op: "remove", Path: path}}
+		}
+		return []JsonPatch{{Op: "replace", Path: path, Value: Must(json.Marshal(value))}}
+	}
+
+	var result []JsonPatch
+	for _, key := range slices.Sorted(Keys(object)) {
+		result = append(result, mergeOps(path+"/"+pointerEscaper.Replace(key), object[key])...)
+	}
+	return result
+}
+
+// JsonPatches translates the merge patch into the equivalent list of json patches
+func (m MergePatch) JsonPatches() ([]JsonPatch, error) {
+	result := []JsonPatch{}
+	for _, mrid := range slices.Sorted(Keys(m)) {
+		path := "/" + pointerEscaper.Replace(mrid)
+
+		var value any
+		if err := json.Unmarshal(m[mrid], &value); err != nil {
+			return result, fmt.Errorf("Failed to interpret value of %s: %w", mrid, err)
+		}
+
+		object, ok := value.(map[string]any)
+		switch {
+		case value == nil:
+			result = append(result, JsonPatch{Op: "remove", Path: path})
+		case !ok:
+			return result, fmt.Errorf("Value of %s must be an object or null", mrid)
+		case object["cim_type"] != nil:
+			result = append(result, JsonPatch{Op: "add", Path: path, Value: m[mrid]})
+		default:
+			result = append(result, mergeOps(path, object)...)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,114 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+
+	"com.github/davidkleiven/tripleworks/migrations"
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergePatchJsonPatches(t *testing.T) {
+	doc := MergePatch{
+		"b":   json.RawMessage(`{"name": "bv", "Commit": {"Message": "m", "Author": null}}`),
+		"a":   json.RawMessage(`null`),
+		"c/d": json.RawMessage(`{"cim_type": "BaseVoltage"}`),
+	}
+	patches, err := doc.JsonPatches()
+	require.NoError(t, err)
+
+	want := []JsonPatch{
+		{Op: "remove", Path: "/a"},
+		{Op: "remove", Path: "/b/Commit/Author"},
+		{Op: "replace", Path: "/b/Commit/Message", Value: json.RawMessage(`"m"`)},
+		{Op: "replace", Path: "/b/name", Value: json.RawMessage(`"bv"`)},
+		{Op: "add", Path: "/c~1d", Value: json.RawMessage(`{"cim_type": "BaseVoltage"}`)},
+	}
+	require.Equal(t, want, patches)
+
+	_, err = MergePatch{"a": json.RawMessage(`1`)}.JsonPatches()
+	require.ErrorContains(t, err, "must be an object or null")
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	ctx := context.Background()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	bv := baseVoltageVersion(uuid.New(), "bv", 132.0)
+	bv.Description = "old"
+	deleted := baseVoltageVersion(uuid.New(), "deleted", 22.0)
+	items := []any{
+		&models.Entity{Mrid: bv.Mrid, EntityType: "BaseVoltage"}, bv,
+		&models.Entity{Mrid: deleted.Mrid, EntityType: "BaseVoltage"}, deleted,
+	}
+	require.NoError(t, InsertAll(ctx, db, models.Commit{}, slices.Values(items), NoOpOnInsert))
+
+	doc := MergePatch{
+		bv.Mrid.String():      json.RawMessage(`{"name": "renamed", "nominal_voltage": 220, "description": null}`),
+		deleted.Mrid.String(): json.RawMessage(`null`),
+	}
+	patches, err := doc.JsonPatches()
+	require.NoError(t, err)
+	require.NoError(t, ApplyPatch(ctx, db, "author", patches))
+
+	var bvs []models.BaseVoltage
+	require.NoError(t, db.NewSelect().Model(&bvs).Scan(ctx))
+	active := OnlyActiveLatest(bvs)
+	require.Equal(t, 1, len(active))
+	require.Equal(t, "renamed", active[0].Name)
+	require.Equal(t, 220.0, active[0].NominalVoltage)
+	require.Equal(t, "", active[0].Description)
+
+	t.Run("nested path into a scalar", func(t *testing.T) {
+		patch := []JsonPatch{{Op: "replace", Path: fmt.Sprintf("/%s/nominal_voltage/value", bv.Mrid), Value: json.RawMessage("1")}}
+		require.ErrorContains(t, ApplyPatch(ctx, db, "author", patch), "nominal_voltage is not an object")
+	})
+
+	t.Run("nested keys are rejected", func(t *testing.T) {
+		for _, test := range []struct {
+			value string
+			msg   string
+		}{
+			{value: `{"nominal_voltage": {"value": 1}}`, msg: "nominal_voltage is not an object"},
+			{value: `{"unknown": {"value": 1}}`, msg: "Unknown field unknown"},
+
+			// The commit relation is the only object valued field and it is not loaded with the object
+			{value: `{"Commit": {"Message": "rewritten"}}`, msg: "Commit is not an object"},
+		} {
+			patches, err := MergePatch{bv.Mrid.String(): json.RawMessage(test.value)}.JsonPatches()
+			require.NoError(t, err)
+			require.ErrorContains(t, ApplyPatch(ctx, db, "author", patches), test.msg, test.value)
+		}
+
+		var bvs []models.BaseVoltage
+		require.NoError(t, db.NewSelect().Model(&bvs).Where("mrid = ?", bv.Mrid).Scan(ctx))
+		require.Equal(t, 220.0, OnlyActiveLatest(bvs)[0].NominalVoltage)
+	})
+}
+
+func TestNestedValues(t *testing.T) {
+	bv := baseVoltageVersion(uuid.New(), "x", 132.0)
+	bv.Commit = &models.Commit{Message: "initial"}
+	var generic map[string]any
+	require.NoError(t, json.Unmarshal(Must(json.Marshal(bv)), &generic))
+
+	value, err := valueAt(generic, []string{"Commit", "Message"})
+	require.NoError(t, err)
+	require.Equal(t, "initial", value)
+
+	require.NoError(t, setValueAt(generic, []string{"Commit", "Message"}, "changed"))
+	require.Equal(t, "changed", generic["Commit"].(map[string]any)["Message"])
+
+	_, err = valueAt(generic, []string{"Commit", "unknown"})
+	require.ErrorContains(t, err, "Unknown field Commit/unknown")
+	require.ErrorContains(t, setValueAt(generic, []string{"Commit", "unknown"}, 1.0), "Unknown field Commit/unknown")
+	require.ErrorContains(t, setValueAt(generic, []string{"name", "value"}, 1.0), "name is not an object")
+	require.ErrorContains(t, setValueAt(generic, []string{"unknown"}, 1.0), "Unknown field unknown")
+}
@@ -0,0 +1,194 @@
+package pkg
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"com.github/davidkleiven/tripleworks/migrations"
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+)
+
+func baseVoltageVersion(mrid uuid.UUID, name string, voltage float64) *models.BaseVoltage {
+	bv := models.BaseVoltage{NominalVoltage: voltage}
+	bv.Mrid = mrid
+	bv.Name = name
+	return &bv
+}
+
+func TestMergeObject(t *testing.T) {
+	mrid := uuid.New()
+	base := baseVoltageVersion(mrid, "bv", 132.0)
+
+	t.Run("unchanged on branch", func(t *testing.T) {
+		require.Nil(t, mergeObject("BaseVoltage", base, base, baseVoltageVersion(mrid, "bv", 132.0)))
+	})
+
+	t.Run("different fields changed", func(t *testing.T) {
+		head := baseVoltageVersion(mrid, "renamed", 132.0)
+		candidate := mergeObject("BaseVoltage", base, head, baseVoltageVersion(mrid, "bv", 220.0))
+		require.NotNil(t, candidate)
+		require.Empty(t, candidate.conflicts)
+		require.Equal(t, "renamed", candidate.fields["name"])
+		require.Equal(t, 220.0, candidate.fields["nominal_voltage"])
+	})
+
+	t.Run("same change on both sides", func(t *testing.T) {
+		head := baseVoltageVersion(mrid, "bv", 220.0)
+		require.Nil(t, mergeObject("BaseVoltage", base, head, baseVoltageVersion(mrid, "bv", 220.0)))
+	})
+
+	t.Run("conflicting change", func(t *testing.T) {
+		head := baseVoltageVersion(mrid, "bv", 300.0)
+		candidate := mergeObject("BaseVoltage", base, head, baseVoltageVersion(mrid, "bv", 220.0))
+		require.NotNil(t, candidate)
+		require.Equal(t, []MergeConflict{{Mrid: mrid, Type: "BaseVoltage", Field: "nominal_voltage", Base: 132.0, Main: 300.0, Branch: 220.0}}, candidate.conflicts)
+	})
+
+	deletedVersion := func(name string, voltage float64) *models.BaseVoltage {
+		bv := baseVoltageVersion(mrid, name, voltage)
+		bv.Deleted = true
+		return bv
+	}
+
+	t.Run("deleted on main and modified on branch", func(t *testing.T) {
+		candidate := mergeObject("BaseVoltage", base, deletedVersion("bv", 132.0), baseVoltageVersion(mrid, "bv", 220.0))
+		require.NotNil(t, candidate)
+		require.Equal(t, []MergeConflict{{Mrid: mrid, Type: "BaseVoltage", Field: "deleted", Base: false, Main: true, Branch: false}}, candidate.conflicts)
+	})
+
+	t.Run("deleted on branch and modified on main", func(t *testing.T) {
+		head := baseVoltageVersion(mrid, "renamed", 132.0)
+		candidate := mergeObject("BaseVoltage", base, head, deletedVersion("bv", 132.0))
+		require.NotNil(t, candidate)
+		require.Equal(t, []MergeConflict{{Mrid: mrid, Type: "BaseVoltage", Field: "deleted", Base: false, Main: false, Branch: true}}, candidate.conflicts)
+	})
+
+	t.Run("deleted on branch and unchanged on main", func(t *testing.T) {
+		candidate := mergeObject("BaseVoltage", base, base, deletedVersion("bv", 132.0))
+		require.NotNil(t, candidate)
+		require.Empty(t, candidate.conflicts)
+		require.Equal(t, true, candidate.fields["deleted"])
+	})
+
+	t.Run("deleted on both sides", func(t *testing.T) {
+		require.Nil(t, mergeObject("BaseVoltage", base, deletedVersion("bv", 132.0), deletedVersion("bv", 220.0)))
+	})
+
+	t.Run("created on branch", func(t *testing.T) {
+		candidate := mergeObject("BaseVoltage", nil, nil, baseVoltageVersion(mrid, "bv", 220.0))
+		require.NotNil(t, candidate)
+		require.Empty(t, candidate.conflicts)
+		require.Equal(t, mrid.String(), candidate.fields["mrid"])
+	})
+}
+
+func TestMergeBranch(t *testing.T) {
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	ctx := context.Background()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	insert := func(ctx context.Context, items ...any) {
+		require.NoError(t, InsertAll(ctx, db, models.Commit{Message: "test"}, slices.Values(items), NoOpOnInsert))
+	}
+
+	first, second := uuid.New(), uuid.New()
+	insert(ctx, baseVoltageVersion(first, "first", 132.0), baseVoltageVersion(second, "second", 132.0))
+
+	branchRepo := repository.BunBranchRepository{Db: db}
+	require.NoError(t, branchRepo.Create(ctx, &models.Branch{Name: "project"}))
+
+	branchCtx := repository.WithBranch(ctx, "project")
+	created := uuid.New()
+	insert(branchCtx, baseVoltageVersion(first, "first", 220.0), baseVoltageVersion(second, "second", 220.0), baseVoltageVersion(created, "new", 400.0))
+	insert(ctx, baseVoltageVersion(second, "second", 300.0))
+
+	branch, err := branchRepo.Get(ctx, "project")
+	require.NoError(t, err)
+	inserter := repository.BunInserter{Db: db}
+
+	latestOnMain := func() map[uuid.UUID]float64 {
+		var bvs []models.BaseVoltage
+		require.NoError(t, db.NewSelect().Model(&bvs).Apply(repository.OnBranch(ctx)).Scan(ctx))
+		result := make(map[uuid.UUID]float64)
+		for bv := range OnlyActiveLatestIter(bvs) {
+			result[bv.Mrid] = bv.NominalVoltage
+		}
+		return result
+	}
+
+	t.Run("conflicts block the merge", func(t *testing.T) {
+		result, err := MergeBranch(ctx, db, &inserter, branch, "author", false)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(result.Conflicts))
+		require.Equal(t, second, result.Conflicts[0].Mrid)
+		require.Equal(t, "nominal_voltage", result.Conflicts[0].Field)
+		require.Equal(t, 2, len(latestOnMain()))
+	})
+
+	t.Run("dry run writes nothing", func(t *testing.T) {
+		insert(branchCtx, baseVoltageVersion(second, "second", 300.0))
+		result, err := MergeBranch(ctx, db, &inserter, branch, "author", true)
+		require.NoError(t, err)
+		require.Empty(t, result.Conflicts)
+		require.Equal(t, 2, result.Merged)
+		require.Equal(t, 2, len(latestOnMain()))
+	})
+
+	t.Run("main changed while merging", func(t *testing.T) {
+		racing := beforeTxInserter{BunInserter: inserter, before: func() {
+			insert(ctx, baseVoltageVersion(first, "renamed", 132.0))
+		}}
+		_, err := MergeBranch(ctx, db, &racing, branch, "author", false)
+		var conflict *ConflictError
+		require.ErrorAs(t, err, &conflict)
+		require.Equal(t, first, conflict.Mrid)
+		require.Equal(t, 132.0, latestOnMain()[first])
+	})
+
+	t.Run("merge into main", func(t *testing.T) {
+		result, err := MergeBranch(ctx, db, &inserter, branch, "author", false)
+		require.NoError(t, err)
+		require.Empty(t, result.Conflicts)
+		require.NotZero(t, result.CommitId)
+		require.Equal(t, map[uuid.UUID]float64{first: 220.0, second: 300.0, created: 400.0}, latestOnMain())
+
+		var commit models.Commit
+		require.NoError(t, db.NewSelect().Model(&commit).Where("id = ?", result.CommitId).Scan(ctx))
+		require.Equal(t, models.MainBranch, commit.Branch)
+	})
+}
+
+// beforeTxInserter runs before ahead of the transaction of the insert, like a concurrent request would
+type beforeTxInserter struct {
+	repository.BunInserter
+	before func()
+}
+
+func (b *beforeTxInserter) InTx(ctx context.Context, fn repository.InsertFunc) error {
+	b.before()
+	return b.BunInserter.InTx(ctx, fn)
+}
+
+func TestVersionsOf(t *testing.T) {
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	ctx := context.Background()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	mrid := uuid.New()
+	items := []any{baseVoltageVersion(mrid, "bv", 132.0), baseVoltageVersion(uuid.New(), "other", 132.0)}
+	require.NoError(t, InsertAll(ctx, db, models.Commit{}, slices.Values(items), NoOpOnInsert))
+
+	versions, err := VersionsOf(ctx, db, &models.BaseVoltage{}, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("mrid = ?", mrid)
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(versions))
+	require.Equal(t, mrid, versions[0].GetMrid())
+}
@@ -0,0 +1,427 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+	"gonum.org/v1/gonum/graph/formats/rdf"
+)
+
+// rdfObject is a subject together with its type and properties. Class and property names are full iris.
+type rdfObject struct {
+	Class      string
+	Id         string
+	Properties []rdfProperty
+}
+
+type rdfProperty struct {
+	Iri      string
+	Value    string
+	Resource string
+}
+
+type RdfImportReport struct {
+	Objects  int            `json:"objects"`
+	ByClass  map[string]int `json:"by_class"`
+	Existing int            `json:"existing"`
+
+	// UnmappedClasses and UnmappedProperties count the objects and property values that
+	// have no counterpart in the models and were left out
+	UnmappedClasses    map[string]int `json:"unmapped_classes"`
+	UnmappedProperties map[string]int `json:"unmapped_properties"`
+	CommitId           int64          `json:"commit_id,omitempty"`
+}
+
+func newRdfImportReport() RdfImportReport {
+	return RdfImportReport{
+		ByClass:            make(map[string]int),
+		UnmappedClasses:    make(map[string]int),
+		UnmappedProperties: make(map[string]int),
+	}
+}
+
+// rdfMrid turns rdf:ID, rdf:about, rdf:resource and urn:uuid: values into mrids. Identifiers that are
+// not uuids get a stable mrid derived from the identifier.
+func rdfMrid(id string) uuid.UUID {
+	if idx := strings.LastIndex(id, "#"); idx >= 0 {
+		id = id[idx+1:]
+	}
+	id = strings.TrimPrefix(id, "urn:uuid:")
+	id = strings.TrimPrefix(id, "_")
+	if mrid, err := uuid.Parse(id); err == nil {
+		return mrid
+	}
+	return mridFromName("cgmes", id)
+}
+
+func expandIri(iri string) string {
+	if strings.HasPrefix(iri, "cim:") {
+		return Cim16 + strings.TrimPrefix(iri, "cim:")
+	}
+	return iri
+}
+
+// importableTypes are the classes that can be imported. Geographical locations come from the GL profile.
+func importableTypes() map[string]any {
+	types := FormTypes()
+	types["Location"] = &models.Location{}
+	types["CoordinateSystem"] = &models.CoordinateSystem{}
+	types["PositionPoint"] = &models.PositionPoint{}
+	return types
+}
+
+// rdfField is a field of an object that maps onto a CIM property
+type rdfField struct {
+	value reflect.Value
+
+	// iri is the property of fields with an iri tag, such as cim:IdentifiedObject.name
+	iri string
+
+	// enum is the name of the enum for fields holding enum ids, and relation the name of the relation
+	// to the enum, which matches the CIM property name
+	enum     string
+	relation string
+}
+
+// propertyName is the name of the CIM property without its class, such as name or phaseCode
+func (f rdfField) propertyName() string {
+	if f.iri != "" {
+		return f.iri[strings.LastIndexAny(f.iri, "#.:")+1:]
+	}
+	return lowerFirst(f.relation)
+}
+
+func enumKey(name string) string {
+	return "enum:" + strings.ToLower(name)
+}
+
+func bunColumn(tag string) string {
+	return strings.Split(tag, ",")[0]
+}
+
+func joinColumn(tag string) string {
+	for part := range strings.SplitSeq(tag, ",") {
+		if join, ok := strings.CutPrefix(part, "join:"); ok {
+			column, _, _ := strings.Cut(join, "=")
+			return column
+		}
+	}
+	return ""
+}
+
+// structRdfFields collects the fields of a struct that map onto CIM properties, including those of
+// embedded structs. Enum ids have no iri and are found through the join column of their relation.
+func structRdfFields(v reflect.Value) []rdfField {
+	enumType := reflect.TypeOf((*models.Enum)(nil)).Elem()
+	var fields []rdfField
+	t := v.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			fields = append(fields, structRdfFields(v.Field(i))...)
+			continue
+		}
+		if iri := field.Tag.Get("iri"); iri != "" {
+			fields = append(fields, rdfField{value: v.Field(i), iri: iri})
+			continue
+		}
+		if field.Type.Kind() != reflect.Ptr || !field.Type.Implements(enumType) {
+			continue
+		}
+		column := joinColumn(field.Tag.Get("bun"))
+		for j := range t.NumField() {
+			if bunColumn(t.Field(j).Tag.Get("bun")) == column {
+				fields = append(fields, rdfField{value: v.Field(j), enum: field.Type.Elem().Name(), relation: field.Name})
+			}
+		}
+	}
+	return fields
+}
+
+// rdfFields collects the settable fields of an object keyed by iri. Enum ids have no iri and are
+// keyed by the name of their relation.
+func rdfFields(item any) map[string]rdfField {
+	fields := make(map[string]rdfField)
+	for _, field := range structRdfFields(reflect.ValueOf(item).Elem()) {
+		if field.iri != "" {
+			fields[expandIri(field.iri)] = field
+		} else {
+			fields[enumKey(field.relation)] = field
+		}
+	}
+	return fields
+}
+
+// lookupRdfField finds the field of a property, falling back to enum ids for enum valued properties
+func lookupRdfField(fields map[string]rdfField, prop rdfProperty) (rdfField, bool) {
+	if field, ok := fields[prop.Iri]; ok {
+		return field, true
+	}
+	local := prop.Iri[strings.LastIndexAny(prop.Iri, "#.")+1:]
+	field, ok := fields[enumKey(local)]
+	return field, ok && prop.Resource != ""
+}
+
+// enumLookup translates between the ids of enum values and their resources, such as ...#PhaseCode.ABC.
+// The values of an enum are fetched once.
+type enumLookup struct {
+	db     *bun.DB
+	values map[string][]models.Enum
+}
+
+func newEnumLookup(db *bun.DB) *enumLookup {
+	return &enumLookup{db: db, values: make(map[string][]models.Enum)}
+}
+
+func (e *enumLookup) valuesOf(ctx context.Context, kind string) ([]models.Enum, error) {
+	if values, ok := e.values[kind]; ok {
+		return values, nil
+	}
+	finder, ok := EnumFinders[kind]
+	if !ok {
+		return nil, fmt.Errorf("Unknown enum %s", kind)
+	}
+	values, err := finder(ctx, e.db)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch values of %s: %w", kind, err)
+	}
+	e.values[kind] = values
+	return values, nil
+}
+
+// id returns the id of an enum value given as a resource
+func (e *enumLookup) id(ctx context.Context, kind string, resource string) (int, error) {
+	values, err := e.valuesOf(ctx, kind)
+	if err != nil {
+		return 0, err
+	}
+	code := resource[strings.LastIndex(resource, ".")+1:]
+	for _, value := range values {
+		if value.GetCode() == code {
+			return value.GetId(), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown value %s of %s", resource, kind)
+}
+
+// iri returns the resource of an enum value, falling back to the CIM name of its code
+func (e *enumLookup) iri(ctx context.Context, kind string, id int) (string, error) {
+	values, err := e.valuesOf(ctx, kind)
+	if err != nil {
+		return "", err
+	}
+	for _, value := range values {
+		if value.GetId() != id {
+			continue
+		}
+		if iri := value.GetIri(); iri != "" {
+			return iri, nil
+		}
+		return Cim16 + kind + "." + value.GetCode(), nil
+	}
+	return "", fmt.Errorf("Unknown value %d of %s", id, kind)
+}
+
+func setRdfValue(field reflect.Value, prop rdfProperty) error {
+	if field.Type() == reflect.TypeOf(uuid.UUID{}) {
+		if prop.Resource == "" {
+			return fmt.Errorf("Expected a reference (rdf:resource)")
+		}
+		field.Set(reflect.ValueOf(rdfMrid(prop.Resource)))
+		return nil
+	}
+	if field.Type() == reflect.TypeOf(time.Time{}) {
+		value, err := time.Parse(time.RFC3339, prop.Value)
+		if err != nil {
+			value, err = time.Parse("2006-01-02T15:04:05", prop.Value)
+		}
+		if err != nil {
+			// The format written by the N-Triples export
+			value, err = time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", prop.Value)
+		}
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(value))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(prop.Value)
+	case reflect.Float64:
+		value, err := strconv.ParseFloat(prop.Value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(value)
+	case reflect.Int:
+		value, err := strconv.ParseInt(prop.Value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(value)
+	case reflect.Bool:
+		value, err := strconv.ParseBool(prop.Value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(value)
+	default:
+		return fmt.Errorf("Unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+// rdfItems maps rdf objects onto the models. Objects described several times are merged by their
+// identifier. The result contains entities for all new objects followed by the objects themselves,
+// ready to be inserted. Objects that already exist in the model are left out.
+func rdfItems(ctx context.Context, db *bun.DB, modelId int, objects []rdfObject) ([]any, RdfImportReport, error) {
+	report := newRdfImportReport()
+
+	existing, err := ExistingMrids(ctx, db, modelId)
+	if err != nil {
+		return nil, report, fmt.Errorf("Failed to get existing mrids: %w", err)
+	}
+	existingSet := Set(existing...)
+
+	types := importableTypes()
+	enums := newEnumLookup(db)
+	mridIri := Cim16 + "IdentifiedObject.mRID"
+
+	var order []uuid.UUID
+	built := make(map[uuid.UUID]any)
+	fieldsOf := make(map[uuid.UUID]map[string]rdfField)
+	for _, object := range objects {
+		key := rdfMrid(object.Id)
+		item, ok := built[key]
+		if !ok {
+			itemPtr, known := types[strings.TrimPrefix(object.Class, Cim16)]
+			if object.Class == ModelDescription+"FullModel" {
+				continue
+			} else if !known || !strings.HasPrefix(object.Class, Cim16) {
+				report.UnmappedClasses[object.Class]++
+				continue
+			}
+
+			item = reflect.New(reflect.TypeOf(itemPtr).Elem()).Interface()
+			fields := rdfFields(item)
+			if mrid, ok := fields[mridIri]; ok {
+				mrid.value.Set(reflect.ValueOf(key))
+			}
+			built[key] = item
+			fieldsOf[key] = fields
+			order = append(order, key)
+		}
+
+		fields := fieldsOf[key]
+		for _, prop := range object.Properties {
+			if prop.Iri == mridIri {
+				continue
+			}
+			field, ok := lookupRdfField(fields, prop)
+			if !ok {
+				report.UnmappedProperties[prop.Iri]++
+				continue
+			}
+
+			if field.enum != "" {
+				id, err := enums.id(ctx, field.enum, prop.Resource)
+				if err != nil {
+					return nil, report, fmt.Errorf("Object %s: %w", object.Id, err)
+				}
+				field.value.SetInt(int64(id))
+				continue
+			}
+			if err := setRdfValue(field.value, prop); err != nil {
+				return nil, report, fmt.Errorf("Invalid value of %s for object %s: %w", prop.Iri, object.Id, err)
+			}
+		}
+	}
+
+	var entities, items []any
+	for _, key := range order {
+		item := built[key]
+		if mridGetter, ok := item.(models.MridGetter); ok {
+			if _, exists := existingSet[mridGetter.GetMrid()]; exists {
+				report.Existing++
+				continue
+			}
+			entity := MakeEntity(mridGetter, modelId)
+			entities = append(entities, &entity)
+		}
+		items = append(items, item)
+		report.ByClass[StructName(item)]++
+		report.Objects++
+	}
+	return append(entities, items...), report, nil
+}
+
+// parseNTriples groups the statements of an N-Triples document, such as the output of Export, by subject
+func parseNTriples(r io.Reader) ([]rdfObject, error) {
+	dec := rdf.NewDecoder(r)
+	var objects []rdfObject
+	bySubject := make(map[string]int)
+	for num := 1; ; num++ {
+		stmt, err := dec.Unmarshal()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return objects, fmt.Errorf("Failed to read statement no. %d: %w", num, err)
+		}
+
+		subject, _, _, err := stmt.Subject.Parts()
+		if err != nil {
+			return objects, fmt.Errorf("Invalid subject of statement no. %d: %w", num, err)
+		}
+		predicate, _, _, err := stmt.Predicate.Parts()
+		if err != nil {
+			return objects, fmt.Errorf("Invalid predicate of statement no. %d: %w", num, err)
+		}
+		value, _, kind, err := stmt.Object.Parts()
+		if err != nil {
+			return objects, fmt.Errorf("Invalid object of statement no. %d: %w", num, err)
+		}
+
+		idx, ok := bySubject[subject]
+		if !ok {
+			idx = len(objects)
+			bySubject[subject] = idx
+			objects = append(objects, rdfObject{Id: subject})
+		}
+
+		switch {
+		case predicate == Rdf+"type":
+			objects[idx].Class = value
+		case kind == rdf.IRI || kind == rdf.Blank:
+			objects[idx].Properties = append(objects[idx].Properties, rdfProperty{Iri: predicate, Resource: value})
+		default:
+			objects[idx].Properties = append(objects[idx].Properties, rdfProperty{Iri: predicate, Value: value})
+		}
+	}
+
+	for _, object := range objects {
+		if object.Class == "" {
+			return objects, fmt.Errorf("Subject %s has no rdf:type", object.Id)
+		}
+	}
+	return objects, nil
+}
+
+// NTriplesItems maps an N-Triples document onto the models. Subjects are typed by their rdf:type.
+func NTriplesItems(ctx context.Context, db *bun.DB, modelId int, r io.Reader) ([]any, RdfImportReport, error) {
+	objects, err := parseNTriples(r)
+	if err != nil {
+		return nil, newRdfImportReport(), err
+	}
+	return rdfItems(ctx, db, modelId, objects)
+}
@@ -0,0 +1,91 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"com.github/davidkleiven/tripleworks/migrations"
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNTriples(t *testing.T) {
+	document := `<urn:uuid:0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://iec.ch/TC57/2013/CIM-schema-cim16#ACLineSegment> .
+<urn:uuid:0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e> <http://iec.ch/TC57/2013/CIM-schema-cim16#IdentifiedObject.name> "Line \"A\"" .
+<urn:uuid:0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e> <http://iec.ch/TC57/2013/CIM-schema-cim16#ACLineSegment.r> "1.5"^^<http://www.w3.org/2001/XMLSchema#float> .
+<urn:uuid:0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e> <http://iec.ch/TC57/2013/CIM-schema-cim16#ConductingEquipment.BaseVoltage> <urn:uuid:6e2a1b7c-9a43-4d1e-8b3f-2c5d7e9f1a2b> .
+`
+	objects, err := parseNTriples(strings.NewReader(document))
+	require.NoError(t, err)
+	require.Equal(t, []rdfObject{{
+		Id:    "urn:uuid:0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e",
+		Class: Cim16 + "ACLineSegment",
+		Properties: []rdfProperty{
+			{Iri: Cim16 + "IdentifiedObject.name", Value: `Line "A"`},
+			{Iri: Cim16 + "ACLineSegment.r", Value: "1.5"},
+			{Iri: Cim16 + "ConductingEquipment.BaseVoltage", Resource: "urn:uuid:6e2a1b7c-9a43-4d1e-8b3f-2c5d7e9f1a2b"},
+		},
+	}}, objects)
+
+	t.Run("untyped subject", func(t *testing.T) {
+		_, err := parseNTriples(strings.NewReader(`<urn:uuid:x> <http://example.com/name> "x" .` + "\n"))
+		require.ErrorContains(t, err, "no rdf:type")
+	})
+
+	t.Run("invalid statement", func(t *testing.T) {
+		_, err := parseNTriples(strings.NewReader("not a triple\n"))
+		require.ErrorContains(t, err, "statement no. 1")
+	})
+}
+
+func TestNTriplesRoundTrip(t *testing.T) {
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	ctx := context.Background()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	bvs := []*models.BaseVoltage{baseVoltageVersion(uuid.New(), `400 "kV"`, 400.0), baseVoltageVersion(uuid.New(), "132 kV", 132.0)}
+	lines := make([]*models.ACLineSegment, 2)
+	for i, bv := range bvs {
+		var line models.ACLineSegment
+		line.Mrid, line.Name, line.R, line.BaseVoltageMrid = uuid.New(), fmt.Sprintf("Line\n%d", i), 1.5+float64(i), bv.Mrid
+		line.Aggregate = i == 0
+		lines[i] = &line
+	}
+
+	var buf bytes.Buffer
+	Export(&buf, slices.Values([]models.MridGetter{bvs[0], lines[0], bvs[1], lines[1]}))
+
+	items, report, err := NTriplesItems(ctx, db, 0, &buf)
+	require.NoError(t, err)
+	require.Equal(t, 4, report.Objects)
+	require.Equal(t, map[string]int{"BaseVoltage": 2, "ACLineSegment": 2}, report.ByClass)
+	require.Empty(t, report.UnmappedClasses)
+	require.Empty(t, report.UnmappedProperties)
+	require.NoError(t, InsertAll(ctx, db, models.Commit{}, slices.Values(items), NoOpOnInsert))
+
+	stored, err := FindAll[models.ACLineSegment](db, ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(stored))
+	byMrid := IndexBy(stored, func(l models.ACLineSegment) uuid.UUID { return l.Mrid })
+	for _, line := range lines {
+		require.Equal(t, line.Name, byMrid[line.Mrid].Name)
+		require.Equal(t, line.R, byMrid[line.Mrid].R)
+		require.Equal(t, line.Aggregate, byMrid[line.Mrid].Aggregate)
+		require.Equal(t, line.BaseVoltageMrid, byMrid[line.Mrid].BaseVoltageMrid)
+	}
+
+	storedBvs, err := FindAll[models.BaseVoltage](db, ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(storedBvs))
+	bvByMrid := IndexBy(storedBvs, func(b models.BaseVoltage) uuid.UUID { return b.Mrid })
+	for _, bv := range bvs {
+		require.Equal(t, bv.Name, bvByMrid[bv.Mrid].Name)
+		require.Equal(t, bv.NominalVoltage, bvByMrid[bv.Mrid].NominalVoltage)
+	}
+}
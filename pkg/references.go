@@ -0,0 +1,252 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type DanglingReference struct {
+	Line  int       `json:"line"`
+	Class string    `json:"class"`
+	Field string    `json:"field"`
+	Mrid  uuid.UUID `json:"mrid"`
+}
+
+type ReferenceReport struct {
+	Lines    int                 `json:"lines"`
+	Dangling []DanglingReference `json:"dangling"`
+}
+
+// references returns the mrids an object points to, sorted by field name
+func references(item any) []DanglingReference {
+	var refs []DanglingReference
+	for _, field := range FlattenStruct(item) {
+		mrid, ok := field.Value.(uuid.UUID)
+		if !ok || mrid == uuid.Nil || !strings.HasSuffix(field.JsonTag, "_mrid") {
+			continue
+		}
+		refs = append(refs, DanglingReference{Class: StructName(item), Field: field.JsonTag, Mrid: mrid})
+	}
+	slices.SortFunc(refs, func(a, b DanglingReference) int { return strings.Compare(a.Field, b.Field) })
+	return refs
+}
+
+// ReferenceChecker checks the objects of an upload for references to mrids that are neither part of
+// the upload nor visible in the target model. Mrids provided by lines that have been checked resolve
+// references of the lines checked after them, such that a streamed upload can be checked batch by batch.
+type ReferenceChecker struct {
+	db       bun.IDB
+	modelId  int
+	provided map[uuid.UUID]struct{}
+}
+
+func NewReferenceChecker(db bun.IDB, modelId int) *ReferenceChecker {
+	return &ReferenceChecker{db: db, modelId: modelId, provided: make(map[uuid.UUID]struct{})}
+}
+
+// Check checks lines of an upload grouped by line. firstLine is the line number of the first of them.
+func (c *ReferenceChecker) Check(ctx context.Context, firstLine int, lines [][]any) (ReferenceReport, error) {
+	report := ReferenceReport{Lines: len(lines), Dangling: []DanglingReference{}}
+
+	for _, items := range lines {
+		for _, item := range items {
+			if entity, ok := item.(*models.Entity); ok {
+				c.provided[entity.Mrid] = struct{}{}
+			} else if mrid := mridIfPossible(item); mrid != uuid.Nil {
+				c.provided[mrid] = struct{}{}
+			}
+		}
+	}
+
+	var (
+		candidates []DanglingReference
+		unknown    = make(map[uuid.UUID]struct{})
+	)
+	for i, items := range lines {
+		for _, item := range items {
+			if _, isEntity := item.(*models.Entity); isEntity {
+				continue
+			}
+			for _, ref := range references(item) {
+				if _, ok := c.provided[ref.Mrid]; ok {
+					continue
+				}
+				ref.Line = firstLine + i
+				candidates = append(candidates, ref)
+				unknown[ref.Mrid] = struct{}{}
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return report, nil
+	}
+
+	existing, err := c.visible(ctx, slices.Collect(maps.Keys(unknown)))
+	if err != nil {
+		return report, err
+	}
+	for _, ref := range candidates {
+		if _, ok := existing[ref.Mrid]; !ok {
+			report.Dangling = append(report.Dangling, ref)
+		}
+	}
+	return report, nil
+}
+
+// visible returns the mrids that are registered in the model and whose latest version on the
+// branch in the context is not deleted
+func (c *ReferenceChecker) visible(ctx context.Context, mrids []uuid.UUID) (map[uuid.UUID]struct{}, error) {
+	var registered []models.Entity
+	err := c.db.NewSelect().
+		Model(&registered).
+		Where("mrid IN (?)", bun.In(mrids)).
+		Where("model_id = ?", c.modelId).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to look up referenced mrids: %w", err)
+	}
+
+	existing := make(map[uuid.UUID]struct{})
+	formTypes := FormTypes()
+	for kind, entities := range GroupBy(registered, func(e models.Entity) string { return e.EntityType }) {
+		ids := make([]uuid.UUID, 0, len(entities))
+		for _, entity := range entities {
+			ids = append(ids, entity.Mrid)
+		}
+
+		itemPtr, ok := formTypes[kind]
+		if _, versioned := itemPtr.(models.VersionedIdentifiedObject); !ok || !versioned {
+			for _, mrid := range ids {
+				existing[mrid] = struct{}{}
+			}
+			continue
+		}
+
+		versions, err := VersionsOf(ctx, c.db, itemPtr, func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("?TableAlias.mrid IN (?)", bun.In(ids)).Apply(repository.OnBranch(ctx))
+		})
+		if err != nil {
+			return nil, err
+		}
+		for latest := range OnlyLatestVersion(versions) {
+			if deleted, ok := latest.(models.DeletedGetter); ok && deleted.GetDeleted() {
+				continue
+			}
+			existing[latest.GetMrid()] = struct{}{}
+		}
+	}
+	return existing, nil
+}
+
+// DanglingReferences checks the objects of an upload, grouped by line, for references to mrids that are
+// neither part of the upload nor visible in the model. Line numbers start at one.
+func DanglingReferences(ctx context.Context, db bun.IDB, modelId int, lines [][]any) (ReferenceReport, error) {
+	return NewReferenceChecker(db, modelId).Check(ctx, 1, lines)
+}
+
+// referenceColumns returns the columns of a row type that hold mrids of other objects
+func referenceColumns(t reflect.Type) []string {
+	var columns []string
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			columns = append(columns, referenceColumns(field.Type)...)
+			continue
+		}
+		column := bunColumn(field.Tag.Get("bun"))
+		if field.Type == reflect.TypeOf(uuid.UUID{}) && column != "" && column != "mrid" {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// referredTo returns the mrids the latest version of an object on the branch in the context still refers
+// to. Objects in skip are left out, as they are about to get a new version.
+func referredTo(ctx context.Context, db bun.IDB, mrids []uuid.UUID, skip map[uuid.UUID]struct{}) (map[uuid.UUID]struct{}, error) {
+	wanted := Set(mrids...)
+	result := make(map[uuid.UUID]struct{})
+	formTypes := FormTypes()
+	for _, kind := range slices.Sorted(Keys(formTypes)) {
+		itemPtr := formTypes[kind]
+		if _, ok := itemPtr.(models.VersionedIdentifiedObject); !ok {
+			continue
+		}
+		columns := referenceColumns(reflect.TypeOf(itemPtr).Elem())
+		if len(columns) == 0 {
+			continue
+		}
+
+		referring := db.NewSelect().
+			Model(reflect.New(reflect.TypeOf(itemPtr).Elem()).Interface()).
+			Column("mrid").
+			WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+				for _, column := range columns {
+					q = q.WhereOr("? IN (?)", bun.Ident(column), bun.In(mrids))
+				}
+				return q
+			})
+		versions, err := VersionsOf(ctx, db, itemPtr, func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("?TableAlias.mrid IN (?)", referring).Apply(repository.OnBranch(ctx))
+		})
+		if err != nil {
+			return nil, err
+		}
+		for latest := range OnlyLatestVersion(versions) {
+			if _, ok := skip[latest.GetMrid()]; ok || latest.(models.DeletedGetter).GetDeleted() {
+				continue
+			}
+			for _, mrid := range referencedMrids(latest) {
+				if _, ok := wanted[mrid]; ok {
+					result[mrid] = struct{}{}
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// retireUnreferenced returns deleted versions of the objects of the type of itemPtr that no object
+// outside of skip refers to anymore, like the location of a resource that was moved to a new location
+func retireUnreferenced(ctx context.Context, db bun.IDB, itemPtr any, mrids []uuid.UUID, skip map[uuid.UUID]struct{}) ([]any, error) {
+	if len(mrids) == 0 {
+		return nil, nil
+	}
+	referred, err := referredTo(ctx, db, mrids, skip)
+	if err != nil {
+		return nil, err
+	}
+	unreferenced := slices.DeleteFunc(slices.Clone(mrids), func(mrid uuid.UUID) bool {
+		_, ok := referred[mrid]
+		return ok
+	})
+	if len(unreferenced) == 0 {
+		return nil, nil
+	}
+
+	versions, err := VersionsOf(ctx, db, itemPtr, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("?TableAlias.mrid IN (?)", bun.In(unreferenced)).Apply(repository.OnBranch(ctx))
+	})
+	if err != nil {
+		return nil, err
+	}
+	var retired []any
+	for latest := range OnlyLatestVersion(versions) {
+		if !latest.(models.DeletedGetter).GetDeleted() {
+			retired = append(retired, newVersionOf(latest, true))
+		}
+	}
+	slices.SortFunc(retired, func(a, b any) int {
+		return strings.Compare(a.(models.MridGetter).GetMrid().String(), b.(models.MridGetter).GetMrid().String())
+	})
+	return retired, nil
+}
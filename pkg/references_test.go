@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"com.github/davidkleiven/tripleworks/migrations"
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDanglingReferences(t *testing.T) {
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	ctx := context.Background()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	line := LineLight{FromSubstation: "Sub A", ToSubstation: "Sub B", Voltage: 300}
+	generator := GeneratorLight{Kind: "thermal", Substation: "Sub A", Num: 1, Voltage: 300}
+	lines := [][]any{slices.Collect(line.CimItems(0)), slices.Collect(generator.CimItems(0))}
+
+	report, err := DanglingReferences(ctx, db, 0, lines)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Lines)
+	require.Equal(t, []DanglingReference{
+		{Line: 2, Class: "VoltageLevel", Field: "substation_mrid", Mrid: substationMrid("Sub A")},
+	}, report.Dangling)
+
+	var substation models.Substation
+	substation.Mrid, substation.Name = substationMrid("Sub A"), "Sub A"
+	entity := MakeEntity(&substation, 1)
+	require.NoError(t, InsertAll(ctx, db, models.Commit{}, slices.Values([]any{&entity, &substation}), NoOpOnInsert))
+
+	t.Run("resolved by the database", func(t *testing.T) {
+		report, err := DanglingReferences(ctx, db, 1, lines)
+		require.NoError(t, err)
+		require.Empty(t, report.Dangling)
+	})
+
+	t.Run("substations of other models do not resolve references", func(t *testing.T) {
+		report, err := DanglingReferences(ctx, db, 0, lines)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(report.Dangling))
+	})
+
+	t.Run("deleted substations do not resolve references", func(t *testing.T) {
+		deleted := substation
+		require.NoError(t, InsertAll(ctx, db, models.Commit{}, slices.Values([]any{newVersionOf(&deleted, true)}), NoOpOnInsert))
+
+		report, err := DanglingReferences(ctx, db, 1, lines)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(report.Dangling))
+	})
+
+	t.Run("checked batches resolve references of later batches", func(t *testing.T) {
+		checker := NewReferenceChecker(db, 0)
+		report, err := checker.Check(ctx, 1, lines[:1])
+		require.NoError(t, err)
+		require.Empty(t, report.Dangling)
+
+		report, err = checker.Check(ctx, 2, [][]any{slices.Collect(generator.CimItems(0)), slices.Collect(line.CimItems(0))})
+		require.NoError(t, err)
+		require.Equal(t, []DanglingReference{
+			{Line: 2, Class: "VoltageLevel", Field: "substation_mrid", Mrid: substationMrid("Sub A")},
+		}, report.Dangling)
+	})
+}
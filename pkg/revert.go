@@ -0,0 +1,180 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type RevertDependency struct {
+	CommitId int       `json:"commit_id"`
+	Mrid     uuid.UUID `json:"mrid"`
+	Type     string    `json:"type"`
+	Reason   string    `json:"reason"`
+}
+
+type RevertResult struct {
+	CommitId       int64              `json:"commit_id"`
+	RevertCommitId int64              `json:"revert_commit_id,omitempty"`
+	Restored       int                `json:"restored"`
+	Deleted        int                `json:"deleted"`
+	Dependencies   []RevertDependency `json:"dependencies"`
+}
+
+// newVersionOf prepares a row that was read from the database to be inserted as a new version
+func newVersionOf(item any, deleted bool) any {
+	v := reflect.ValueOf(item).Elem()
+	v.FieldByName("Id").SetInt(0)
+	v.FieldByName("Deleted").SetBool(deleted)
+	return item
+}
+
+// referencedMrids returns all mrids an object refers to through its fields
+func referencedMrids(item any) []uuid.UUID {
+	var result []uuid.UUID
+	self := item.(models.MridGetter).GetMrid()
+	for _, field := range FlattenStruct(item) {
+		if mrid, ok := field.Value.(uuid.UUID); ok && mrid != self {
+			result = append(result, mrid)
+		}
+	}
+	return result
+}
+
+// PrepareRevert collects the versions that undo a commit. Objects the commit modified are restored to
+// their previous version and objects it created are marked as deleted. Later commits that modified or
+// refer to any of these objects are reported as dependencies, in which case the revert is unsafe.
+func PrepareRevert(ctx context.Context, db bun.IDB, commit models.Commit) ([]any, RevertResult, error) {
+	result := RevertResult{CommitId: commit.Id, Dependencies: []RevertDependency{}}
+	ctx = repository.WithBranch(ctx, commit.Branch)
+
+	var (
+		versions []any
+		created  = make(map[uuid.UUID]struct{})
+	)
+
+	formTypes := FormTypes()
+	kinds := slices.Sorted(Keys(formTypes))
+	for _, kind := range kinds {
+		itemPtr := formTypes[kind]
+		if _, ok := itemPtr.(models.VersionedIdentifiedObject); !ok {
+			continue
+		}
+
+		touched, err := VersionsOf(ctx, db, itemPtr, func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("?TableAlias.commit_id = ?", commit.Id)
+		})
+		if err != nil {
+			return versions, result, err
+		}
+		if len(touched) == 0 {
+			continue
+		}
+
+		mrids := make([]uuid.UUID, len(touched))
+		for i, item := range touched {
+			mrids[i] = item.GetMrid()
+		}
+
+		others, err := VersionsOf(ctx, db, itemPtr, func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Apply(repository.OnBranch(ctx)).Where("?TableAlias.mrid IN (?)", bun.In(mrids)).Where("?TableAlias.commit_id <> ?", commit.Id)
+		})
+		if err != nil {
+			return versions, result, err
+		}
+		byMrid := GroupBy(others, func(v models.VersionedIdentifiedObject) uuid.UUID { return v.GetMrid() })
+
+		for _, item := range touched {
+			history := byMrid[item.GetMrid()]
+			later := latestVersion(history, func(commitId int) bool { return int64(commitId) > commit.Id })
+			if later != nil {
+				result.Dependencies = append(result.Dependencies, RevertDependency{
+					CommitId: later.GetCommitId(),
+					Mrid:     item.GetMrid(),
+					Type:     kind,
+					Reason:   "modified by a later commit",
+				})
+				continue
+			}
+
+			previous := latestVersion(history, func(commitId int) bool { return int64(commitId) < commit.Id })
+			if previous == nil {
+				created[item.GetMrid()] = struct{}{}
+				versions = append(versions, newVersionOf(item, true))
+				result.Deleted++
+			} else {
+				versions = append(versions, newVersionOf(previous, previous.(models.DeletedGetter).GetDeleted()))
+				result.Restored++
+			}
+		}
+	}
+
+	if len(created) == 0 {
+		return versions, result, nil
+	}
+
+	// Objects created by the commit can not be removed if later commits refer to them
+	for _, kind := range kinds {
+		itemPtr := formTypes[kind]
+		if _, ok := itemPtr.(models.VersionedIdentifiedObject); !ok {
+			continue
+		}
+		later, err := VersionsOf(ctx, db, itemPtr, func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Apply(repository.OnBranch(ctx)).Where("?TableAlias.commit_id > ?", commit.Id)
+		})
+		if err != nil {
+			return versions, result, err
+		}
+
+		for item := range OnlyLatestVersion(later) {
+			if item.(models.DeletedGetter).GetDeleted() {
+				continue
+			}
+			for _, mrid := range referencedMrids(item) {
+				if _, ok := created[mrid]; ok {
+					result.Dependencies = append(result.Dependencies, RevertDependency{
+						CommitId: item.GetCommitId(),
+						Mrid:     item.GetMrid(),
+						Type:     kind,
+						Reason:   fmt.Sprintf("refers to %s created by commit %d", mrid, commit.Id),
+					})
+				}
+			}
+		}
+	}
+	return versions, result, nil
+}
+
+// RevertCommit undoes a commit by inserting a new commit on the same branch. Nothing is written
+// if later commits depend on the objects the commit touched.
+func RevertCommit(ctx context.Context, db bun.IDB, inserter repository.Inserter, commitId int64, author string) (RevertResult, error) {
+	var commit models.Commit
+	if err := db.NewSelect().Model(&commit).Where("id = ?", commitId).Scan(ctx); err != nil {
+		return RevertResult{CommitId: commitId}, fmt.Errorf("Failed to find commit %d: %w", commitId, err)
+	}
+
+	versions, result, err := PrepareRevert(ctx, db, commit)
+	if err != nil {
+		return result, fmt.Errorf("Failed to prepare revert: %w", err)
+	}
+	if len(result.Dependencies) > 0 || len(versions) == 0 {
+		return result, nil
+	}
+
+	revert := models.Commit{
+		Branch:  commit.Branch,
+		Message: fmt.Sprintf("Revert commit %d: %s", commit.Id, commit.Message),
+		Author:  author,
+	}
+	if err := InsertAllInserter(ctx, inserter, revert, slices.Values(versions), NoOpOnInsert); err != nil {
+		return result, fmt.Errorf("Failed to insert reverted objects: %w", err)
+	}
+	result.RevertCommitId = int64(versions[0].(models.VersionedIdentifiedObject).GetCommitId())
+	return result, nil
+}
@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"com.github/davidkleiven/tripleworks/migrations"
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevertCommit(t *testing.T) {
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	ctx := context.Background()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	insert := func(items ...any) {
+		require.NoError(t, InsertAll(ctx, db, models.Commit{Message: "test"}, slices.Values(items), NoOpOnInsert))
+	}
+
+	modified, created := uuid.New(), uuid.New()
+	insert(baseVoltageVersion(modified, "modified", 132.0))
+	insert(baseVoltageVersion(modified, "modified", 220.0), baseVoltageVersion(created, "created", 400.0))
+
+	var vl models.VoltageLevel
+	vl.Mrid = uuid.New()
+	vl.BaseVoltageMrid = created
+	insert(&vl)
+
+	inserter := repository.BunInserter{Db: db}
+	latest := func() map[uuid.UUID]float64 {
+		var bvs []models.BaseVoltage
+		require.NoError(t, db.NewSelect().Model(&bvs).Scan(ctx))
+		result := make(map[uuid.UUID]float64)
+		for bv := range OnlyActiveLatestIter(bvs) {
+			result[bv.Mrid] = bv.NominalVoltage
+		}
+		return result
+	}
+
+	t.Run("refused when later commits refer to created objects", func(t *testing.T) {
+		result, err := RevertCommit(ctx, db, &inserter, 2, "author")
+		require.NoError(t, err)
+		require.Equal(t, 1, len(result.Dependencies))
+		require.Equal(t, vl.Mrid, result.Dependencies[0].Mrid)
+		require.Equal(t, 3, result.Dependencies[0].CommitId)
+		require.Zero(t, result.RevertCommitId)
+	})
+
+	t.Run("revert created object", func(t *testing.T) {
+		result, err := RevertCommit(ctx, db, &inserter, 3, "author")
+		require.NoError(t, err)
+		require.Empty(t, result.Dependencies)
+		require.Equal(t, 1, result.Deleted)
+		require.Equal(t, int64(4), result.RevertCommitId)
+	})
+
+	t.Run("revert restores previous version", func(t *testing.T) {
+		result, err := RevertCommit(ctx, db, &inserter, 2, "author")
+		require.NoError(t, err)
+		require.Empty(t, result.Dependencies)
+		require.Equal(t, 1, result.Restored)
+		require.Equal(t, 1, result.Deleted)
+		require.Equal(t, map[uuid.UUID]float64{modified: 132.0}, latest())
+
+		var commit models.Commit
+		require.NoError(t, db.NewSelect().Model(&commit).Where("id = ?", result.RevertCommitId).Scan(ctx))
+		require.Contains(t, commit.Message, "Revert commit 2")
+	})
+
+	t.Run("refused when later commits modified the objects", func(t *testing.T) {
+		result, err := RevertCommit(ctx, db, &inserter, 1, "author")
+		require.NoError(t, err)
+		require.NotEmpty(t, result.Dependencies)
+		require.Equal(t, "modified by a later commit", result.Dependencies[0].Reason)
+	})
+
+	t.Run("unknown commit", func(t *testing.T) {
+		_, err := RevertCommit(ctx, db, &inserter, 100, "author")
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
+)
+
+// StagedObject decodes the payload of a staged change into its form type
+func StagedObject(change models.StagedChange) (any, error) {
+	itemPtr, err := FormInputFieldsForType(change.CimType)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(change.Payload), itemPtr); err != nil {
+		return nil, fmt.Errorf("Failed to decode staged change %d: %w", change.Id, err)
+	}
+	return itemPtr, nil
+}
+
+// StagedItems returns everything that must be inserted to commit the staged changes. Every object is
+// registered as an entity in its model, exactly as when the object is committed on its own.
+func StagedItems(changes []models.StagedChange) ([]any, error) {
+	var (
+		items    []any
+		modelIds []int
+	)
+	for _, change := range changes {
+		item, err := StagedObject(change)
+		if err != nil {
+			return items, err
+		}
+
+		if !slices.Contains(modelIds, change.ModelId) {
+			modelIds = append(modelIds, change.ModelId)
+			items = append(items, &models.Model{Id: change.ModelId, Name: change.ModelName})
+		}
+
+		entity := models.Entity{
+			ModelEntity: models.ModelEntity{ModelId: change.ModelId},
+			Mrid:        change.Mrid,
+			EntityType:  StructName(item),
+		}
+		items = append(items, &entity, item)
+	}
+	return items, nil
+}
+
+// CommitStaged inserts all staged changes in a single commit. Nothing is written if any of the
+// objects was changed by someone else after it was staged, in which case a ConflictError is returned.
+// The base commits are checked and the staged changes are cleared by clear in the transaction of the
+// commit. reader is only used when the inserter can not read versions in its transaction.
+func CommitStaged(ctx context.Context, inserter repository.Inserter, reader repository.VersionReader, changes []models.StagedChange, commit models.Commit, clear func(ctx context.Context, inserter repository.Inserter) error) (int64, error) {
+	if len(changes) == 0 {
+		return 0, fmt.Errorf("No staged changes to commit")
+	}
+
+	checkBaseCommits := func(ctx context.Context, inserter repository.Inserter) error {
+		versions := repository.TxVersionReader(inserter, reader)
+		for _, change := range changes {
+			model, err := FormInputFieldsForType(change.CimType)
+			if err != nil {
+				return err
+			}
+			if err := CheckBaseCommit(ctx, versions, model, change.Mrid, change.BaseCommitId); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	items, err := StagedItems(changes)
+	if err != nil {
+		return 0, err
+	}
+
+	var commitId int64
+	onInsert := func(v any) error {
+		if versioned, ok := v.(models.VersionedIdentifiedObject); ok {
+			commitId = int64(versioned.GetCommitId())
+		}
+		return nil
+	}
+	if err := InsertAllWithHooks(ctx, inserter, commit, slices.Values(items), onInsert, TxHooks{Before: checkBaseCommits, After: clear}); err != nil {
+		return 0, fmt.Errorf("Failed to insert staged changes: %w", err)
+	}
+	return commitId, nil
+}
+
+// PreviewStaged writes the staged objects as N-Triples
+func PreviewStaged(w io.Writer, changes []models.StagedChange) error {
+	for _, change := range changes {
+		item, err := StagedObject(change)
+		if err != nil {
+			return err
+		}
+		if mridGetter, ok := item.(models.MridGetter); ok {
+			ExportItem(w, mridGetter)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	"com.github/davidkleiven/tripleworks/migrations"
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func stagedBaseVoltage(bv *models.BaseVoltage, baseCommitId int64) models.StagedChange {
+	return models.StagedChange{
+		Author:       "author",
+		Branch:       models.MainBranch,
+		Mrid:         bv.Mrid,
+		CimType:      "BaseVoltage",
+		ModelName:    "national",
+		BaseCommitId: baseCommitId,
+		Payload:      string(Must(json.Marshal(bv))),
+	}
+}
+
+func TestStagedItems(t *testing.T) {
+	changes := []models.StagedChange{
+		stagedBaseVoltage(baseVoltageVersion(uuid.New(), "first", 132.0), 0),
+		stagedBaseVoltage(baseVoltageVersion(uuid.New(), "second", 220.0), 0),
+	}
+	items, err := StagedItems(changes)
+	require.NoError(t, err)
+
+	// One model followed by an entity and the object for every change
+	require.Equal(t, 5, len(items))
+	require.IsType(t, &models.Model{}, items[0])
+	require.Equal(t, 220.0, items[4].(*models.BaseVoltage).NominalVoltage)
+
+	changes[0].CimType = "NotAType"
+	_, err = StagedItems(changes)
+	require.Error(t, err)
+}
+
+func TestCommitStaged(t *testing.T) {
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	ctx := context.Background()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	existing := baseVoltageVersion(uuid.New(), "existing", 132.0)
+	require.NoError(t, InsertAll(ctx, db, models.Commit{Message: "initial"}, slices.Values([]any{existing}), NoOpOnInsert))
+
+	created := baseVoltageVersion(uuid.New(), "created", 400.0)
+	edited := baseVoltageVersion(existing.Mrid, "existing", 300.0)
+	changes := []models.StagedChange{
+		stagedBaseVoltage(created, 0),
+		stagedBaseVoltage(edited, int64(existing.CommitId)),
+	}
+
+	var preview bytes.Buffer
+	require.NoError(t, PreviewStaged(&preview, changes))
+	require.Contains(t, preview.String(), created.Mrid.String())
+	require.Contains(t, preview.String(), existing.Mrid.String())
+
+	inserter := repository.BunInserter{Db: db}
+	reader := repository.BunVersionReader{Db: db}
+	staging := repository.BunStagingRepository{Db: db}
+	for i := range changes {
+		require.NoError(t, staging.Stage(ctx, &changes[i]))
+	}
+	clear := func(ctx context.Context, inserter repository.Inserter) error {
+		inTx, ok := staging.InTxOf(inserter)
+		require.True(t, ok)
+		return inTx.Discard(ctx, "author", models.MainBranch)
+	}
+
+	t.Run("failing clear rolls back the commit", func(t *testing.T) {
+		failingClear := func(ctx context.Context, inserter repository.Inserter) error {
+			require.NoError(t, clear(ctx, inserter))
+			return errors.New("clear failed")
+		}
+		_, err := CommitStaged(ctx, &inserter, &reader, changes, models.Commit{Message: "failing"}, failingClear)
+		require.ErrorContains(t, err, "clear failed")
+
+		numCommits, err := db.NewSelect().Model((*models.Commit)(nil)).Where("message = ?", "failing").Count(ctx)
+		require.NoError(t, err)
+		require.Zero(t, numCommits)
+
+		staged, err := staging.List(ctx, "author", models.MainBranch)
+		require.NoError(t, err)
+		require.Equal(t, 2, len(staged))
+	})
+
+	commitId, err := CommitStaged(ctx, &inserter, &reader, changes, models.Commit{Message: "new bay", Author: "author"}, clear)
+	require.NoError(t, err)
+	require.NotZero(t, commitId)
+
+	var bvs []models.BaseVoltage
+	require.NoError(t, db.NewSelect().Model(&bvs).Where("commit_id = ?", commitId).Scan(ctx))
+	require.Equal(t, 2, len(bvs))
+
+	staged, err := staging.List(ctx, "author", models.MainBranch)
+	require.NoError(t, err)
+	require.Empty(t, staged)
+
+	t.Run("stale base commit", func(t *testing.T) {
+		_, err := CommitStaged(ctx, &inserter, &reader, changes[1:], models.Commit{Message: "again"}, clear)
+		var conflict *ConflictError
+		require.True(t, errors.As(err, &conflict))
+		require.Equal(t, commitId, conflict.HeadCommitId)
+
+		// The check reads the versions in the transaction of the insert
+		_, err = CommitStaged(ctx, &inserter, nil, changes[1:], models.Commit{Message: "again"}, clear)
+		require.True(t, errors.As(err, &conflict))
+	})
+
+	t.Run("nothing staged", func(t *testing.T) {
+		_, err := CommitStaged(ctx, &inserter, &reader, nil, models.Commit{}, clear)
+		require.Error(t, err)
+	})
+}
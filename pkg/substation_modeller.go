@@ -6,6 +6,7 @@ import (
 	"iter"
 
 	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
 	"github.com/google/uuid"
 	"github.com/uptrace/bun"
 )
@@ -99,6 +100,7 @@ func (s *SubstationModel) Write(ctx context.Context, db *bun.DB, modelId int, ms
 	commit := models.Commit{
 		Message: msg,
 		Author:  "SubstationModeller",
+		Branch:  repository.BranchFromCtx(ctx),
 	}
 	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
 		_, err := ReturnOnFirstError(
@@ -0,0 +1,353 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type UpsertCounts struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Deleted   int `json:"deleted"`
+}
+
+type UpsertReport struct {
+	Classes  map[string]*UpsertCounts `json:"classes"`
+	CommitId int64                    `json:"commit_id,omitempty"`
+}
+
+func (u *UpsertReport) counts(class string) *UpsertCounts {
+	counts, ok := u.Classes[class]
+	if !ok {
+		counts = &UpsertCounts{}
+		u.Classes[class] = counts
+	}
+	return counts
+}
+
+// versionHash hashes the content of an object, leaving out the fields that differ between versions
+func versionHash(item any) string {
+	return MustGetHash(GenericFields(item))
+}
+
+// latestVersionsOf returns the latest version of the passed objects visible in the context, keyed by mrid
+func latestVersionsOf(ctx context.Context, db bun.IDB, items []models.VersionedIdentifiedObject) (map[uuid.UUID]models.VersionedIdentifiedObject, error) {
+	mridsByType := make(map[reflect.Type][]uuid.UUID)
+	for _, item := range items {
+		t := reflect.TypeOf(item)
+		mridsByType[t] = append(mridsByType[t], item.GetMrid())
+	}
+
+	latest := make(map[uuid.UUID]models.VersionedIdentifiedObject)
+	for t, mrids := range mridsByType {
+		itemPtr := reflect.New(t.Elem()).Interface()
+		versions, err := VersionsOf(ctx, db, itemPtr, func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("?TableAlias.mrid IN (?)", bun.In(mrids)).Apply(repository.OnBranch(ctx))
+		})
+		if err != nil {
+			return latest, err
+		}
+		for item := range OnlyLatestVersion(versions) {
+			latest[item.GetMrid()] = item
+		}
+	}
+	return latest, nil
+}
+
+// childReference returns the column and value of the reference from a row without an mrid, such as
+// a position point, to the object owning it
+func childReference(item any) (string, uuid.UUID, bool) {
+	v := reflect.ValueOf(item)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return "", uuid.Nil, false
+	}
+	v = v.Elem()
+
+	var (
+		column string
+		parent uuid.UUID
+		found  int
+	)
+	for i := range v.NumField() {
+		if mrid, ok := v.Field(i).Interface().(uuid.UUID); ok {
+			column, parent = bunColumn(v.Type().Field(i).Tag.Get("bun")), mrid
+			found++
+		}
+	}
+	return column, parent, found == 1
+}
+
+// childrenHash hashes a set of rows without an mrid. Repeated rows count once.
+func childrenHash(children []any) string {
+	hashes := make([]string, 0, len(children))
+	for _, child := range children {
+		hashes = append(hashes, versionHash(child))
+	}
+	slices.Sort(hashes)
+	return MustGetHash(slices.Compact(hashes))
+}
+
+// replaceMrids points every mrid field of an item, including references, to its replacement
+func replaceMrids(v reflect.Value, replacements map[uuid.UUID]uuid.UUID) {
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	for i := range v.NumField() {
+		field := v.Field(i)
+		if v.Type().Field(i).Anonymous {
+			replaceMrids(field, replacements)
+			continue
+		}
+		if mrid, ok := field.Interface().(uuid.UUID); ok && field.CanSet() {
+			if replacement, ok := replacements[mrid]; ok {
+				field.Set(reflect.ValueOf(replacement))
+			}
+		}
+	}
+}
+
+// rekeyChangedParents handles rows without an mrid, which have no versions of their own. When the rows
+// owned by an uploaded object differ from the stored ones, the object gets a new mrid derived from the
+// rows, like a changed geometry in a GeoJSON import. The upload then creates the object with its new
+// rows, and the objects referring to it get a new version.
+func rekeyChangedParents(ctx context.Context, db bun.IDB, items []any) error {
+	uploaded := make(map[uuid.UUID]models.VersionedIdentifiedObject)
+	for _, item := range items {
+		if object, ok := item.(models.VersionedIdentifiedObject); ok {
+			uploaded[object.GetMrid()] = object
+		}
+	}
+
+	type childKind struct {
+		t      reflect.Type
+		column string
+	}
+	children := make(map[uuid.UUID][]any)
+	parentsOf := make(map[childKind][]uuid.UUID)
+	for _, item := range items {
+		switch item.(type) {
+		case *models.Entity, models.MridGetter:
+			continue
+		}
+		column, parent, ok := childReference(item)
+		if _, isUploaded := uploaded[parent]; !ok || !isUploaded {
+			continue
+		}
+		kind := childKind{t: reflect.TypeOf(item), column: column}
+		if _, seen := children[parent]; !seen {
+			parentsOf[kind] = append(parentsOf[kind], parent)
+		}
+		children[parent] = append(children[parent], item)
+	}
+
+	stored := make(map[uuid.UUID][]any)
+	for kind, parents := range parentsOf {
+		rows := reflect.New(reflect.SliceOf(kind.t.Elem()))
+		err := db.NewSelect().
+			Model(rows.Interface()).
+			Where("? IN (?)", bun.Ident(kind.column), bun.In(parents)).
+			Scan(ctx)
+		if err != nil {
+			return fmt.Errorf("Failed to fetch stored %s rows: %w", kind.t.Elem().Name(), err)
+		}
+		for i := range rows.Elem().Len() {
+			row := rows.Elem().Index(i).Addr().Interface()
+			_, parent, _ := childReference(row)
+			stored[parent] = append(stored[parent], row)
+		}
+	}
+
+	replacements := make(map[uuid.UUID]uuid.UUID)
+	for parent, rows := range children {
+		previous, ok := stored[parent]
+		if !ok {
+			continue
+		}
+		hash := childrenHash(rows)
+		if hash != childrenHash(previous) {
+			replacements[parent] = mridFromName(StructName(uploaded[parent]), parent.String()+" "+hash)
+		}
+	}
+	if len(replacements) == 0 {
+		return nil
+	}
+	for _, item := range items {
+		replaceMrids(reflect.ValueOf(item), replacements)
+	}
+	return nil
+}
+
+// UpsertItems keeps the items that must be written to bring a model in line with an upload. Objects
+// without a previous version are created, objects that differ from their latest version get a new
+// version and unchanged objects are dropped. Entities are only kept for created objects. Rows without
+// an mrid, such as position points, are kept when they refer to a created object or to nothing at all.
+// An object whose rows without an mrid changed is created anew, see rekeyChangedParents, and the object
+// it replaces is deleted unless something still refers to it.
+func UpsertItems(ctx context.Context, db bun.IDB, modelId int, items []any) ([]any, UpsertReport, error) {
+	report := UpsertReport{Classes: make(map[string]*UpsertCounts)}
+	ctx = repository.WithModel(ctx, modelId)
+
+	if err := rekeyChangedParents(ctx, db, items); err != nil {
+		return nil, report, err
+	}
+
+	var (
+		versioned []models.VersionedIdentifiedObject
+		seen      = make(map[uuid.UUID]struct{})
+	)
+	for _, item := range items {
+		object, ok := item.(models.VersionedIdentifiedObject)
+		if !ok {
+			continue
+		}
+		if _, duplicate := seen[object.GetMrid()]; !duplicate {
+			seen[object.GetMrid()] = struct{}{}
+			versioned = append(versioned, object)
+		}
+	}
+
+	latest, err := latestVersionsOf(ctx, db, versioned)
+	if err != nil {
+		return nil, report, fmt.Errorf("Failed to read latest versions: %w", err)
+	}
+
+	created := make(map[uuid.UUID]struct{})
+	keep := make(map[uuid.UUID]struct{})
+	for _, object := range versioned {
+		mrid := object.GetMrid()
+		counts := report.counts(StructName(object))
+		previous, exists := latest[mrid]
+		switch {
+		case !exists:
+			counts.Created++
+			created[mrid] = struct{}{}
+			keep[mrid] = struct{}{}
+		case versionHash(previous) != versionHash(object):
+			counts.Updated++
+			keep[mrid] = struct{}{}
+		default:
+			counts.Unchanged++
+		}
+	}
+
+	retired, err := retireReplacedOwners(ctx, db, items, versioned, latest)
+	if err != nil {
+		return nil, report, err
+	}
+	for _, item := range retired {
+		report.counts(StructName(item)).Deleted++
+	}
+
+	var result []any
+	written := make(map[uuid.UUID]struct{})
+	entities := make(map[uuid.UUID]struct{})
+	for _, item := range items {
+		switch v := item.(type) {
+		case *models.Entity:
+			if _, ok := created[v.Mrid]; !ok {
+				continue
+			}
+			if _, ok := entities[v.Mrid]; ok {
+				continue
+			}
+			entities[v.Mrid] = struct{}{}
+		case models.VersionedIdentifiedObject:
+			if _, ok := keep[v.GetMrid()]; !ok {
+				continue
+			}
+			if _, ok := written[v.GetMrid()]; ok {
+				continue
+			}
+			written[v.GetMrid()] = struct{}{}
+		default:
+			if !refersToCreated(item, created) {
+				continue
+			}
+		}
+		result = append(result, item)
+	}
+	return append(result, retired...), report, nil
+}
+
+// retireReplacedOwners returns deleted versions of the objects owning rows without an mrid, such as
+// locations, that an uploaded object referred to before the upload but no longer does. Objects that
+// are referred to by the upload or by any other stored object are kept.
+func retireReplacedOwners(ctx context.Context, db bun.IDB, items []any, versioned []models.VersionedIdentifiedObject, latest map[uuid.UUID]models.VersionedIdentifiedObject) ([]any, error) {
+	uploaded := make(map[uuid.UUID]models.VersionedIdentifiedObject)
+	replaced := make(map[uuid.UUID]struct{})
+	referred := make(map[uuid.UUID]struct{})
+	for _, object := range versioned {
+		uploaded[object.GetMrid()] = object
+		replaced[object.GetMrid()] = struct{}{}
+		for _, mrid := range referencedMrids(object) {
+			referred[mrid] = struct{}{}
+		}
+	}
+
+	owners := make(map[reflect.Type]struct{})
+	for _, item := range items {
+		switch item.(type) {
+		case *models.Entity, models.MridGetter:
+			continue
+		}
+		if _, parent, ok := childReference(item); ok {
+			if owner, ok := uploaded[parent]; ok {
+				owners[reflect.TypeOf(owner)] = struct{}{}
+			}
+		}
+	}
+
+	var dropped []uuid.UUID
+	for _, object := range versioned {
+		previous, ok := latest[object.GetMrid()]
+		if !ok {
+			continue
+		}
+		for _, mrid := range referencedMrids(previous) {
+			_, isUploaded := uploaded[mrid]
+			_, isReferred := referred[mrid]
+			if !isUploaded && !isReferred && !slices.Contains(dropped, mrid) {
+				dropped = append(dropped, mrid)
+			}
+		}
+	}
+	if len(dropped) == 0 {
+		return nil, nil
+	}
+
+	var retired []any
+	for owner := range owners {
+		versions, err := retireUnreferenced(ctx, db, reflect.New(owner.Elem()).Interface(), dropped, replaced)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to retire replaced %s objects: %w", owner.Elem().Name(), err)
+		}
+		retired = append(retired, versions...)
+	}
+	return retired, nil
+}
+
+func refersToCreated(item any, created map[uuid.UUID]struct{}) bool {
+	hasReferences := false
+	for _, field := range FlattenStruct(item) {
+		mrid, ok := field.Value.(uuid.UUID)
+		if !ok {
+			continue
+		}
+		hasReferences = true
+		if _, ok := created[mrid]; ok {
+			return true
+		}
+	}
+	return !hasReferences
+}
@@ -0,0 +1,163 @@
+package pkg
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"com.github/davidkleiven/tripleworks/migrations"
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpsertItems(t *testing.T) {
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	ctx := context.Background()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	generator := GeneratorLight{Kind: "thermal", Substation: "Sub A", Num: 1, MaxP: 100.0, Voltage: 300}
+	items := slices.Collect(generator.CimItems(0))
+
+	t.Run("everything is new", func(t *testing.T) {
+		changed, report, err := UpsertItems(ctx, db, 0, items)
+		require.NoError(t, err)
+		require.Equal(t, len(items), len(changed))
+		require.Equal(t, UpsertCounts{Created: 1}, *report.Classes["SynchronousMachine"])
+	})
+
+	require.NoError(t, InsertAll(ctx, db, models.Commit{}, slices.Values(items), NoOpOnInsert))
+
+	t.Run("unchanged", func(t *testing.T) {
+		changed, report, err := UpsertItems(ctx, db, 0, slices.Collect(generator.CimItems(0)))
+		require.NoError(t, err)
+		require.Empty(t, changed)
+		require.Equal(t, UpsertCounts{Unchanged: 1}, *report.Classes["SynchronousMachine"])
+	})
+
+	t.Run("changed max p", func(t *testing.T) {
+		updated := generator
+		updated.MaxP = 150.0
+		changed, report, err := UpsertItems(ctx, db, 0, slices.Collect(updated.CimItems(0)))
+		require.NoError(t, err)
+
+		require.Equal(t, UpsertCounts{Updated: 1}, *report.Classes["SynchronousMachine"])
+		require.Equal(t, UpsertCounts{Updated: 1}, *report.Classes["ThermalGeneratingUnit"])
+		require.Equal(t, UpsertCounts{Unchanged: 1}, *report.Classes["Terminal"])
+
+		for _, item := range changed {
+			_, isEntity := item.(*models.Entity)
+			require.False(t, isEntity)
+		}
+		require.Equal(t, 2, len(changed))
+	})
+
+	t.Run("duplicates are written once", func(t *testing.T) {
+		other := generator
+		other.Num = 2
+		upload := slices.Concat(slices.Collect(generator.CimItems(0)), slices.Collect(other.CimItems(0)))
+		changed, report, err := UpsertItems(ctx, db, 0, upload)
+		require.NoError(t, err)
+		require.Equal(t, UpsertCounts{Unchanged: 1}, *report.Classes["BaseVoltage"])
+		require.Equal(t, UpsertCounts{Created: 1, Unchanged: 1}, *report.Classes["SynchronousMachine"])
+
+		// Curve data of the shared reactive capability curve is not repeated
+		for _, item := range changed {
+			_, isCurveData := item.(*models.CurveData)
+			require.False(t, isCurveData)
+		}
+	})
+}
+
+func TestUpsertMovedSubstation(t *testing.T) {
+	db := NewTestConfig(WithDbName(t.Name())).DatabaseConnection()
+	ctx := context.Background()
+	_, err := migrations.RunUp(ctx, db)
+	require.NoError(t, err)
+
+	substation := SubstationLight{Name: "Sub A", Region: "NO1", X: 10, Y: 60}
+	require.NoError(t, InsertAll(ctx, db, models.Commit{}, substation.CimItems(0), NoOpOnInsert))
+
+	upsert := func(s SubstationLight) ([]any, UpsertReport) {
+		changed, report, err := UpsertItems(ctx, db, 0, slices.Collect(s.CimItems(0)))
+		require.NoError(t, err)
+		require.NoError(t, InsertAll(ctx, db, models.Commit{}, slices.Values(changed), NoOpOnInsert))
+		return changed, report
+	}
+
+	position := func() (float64, float64) {
+		substations, err := FindAll[models.Substation](db, ctx, 0)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(substations))
+
+		var points []models.PositionPoint
+		require.NoError(t, db.NewSelect().Model(&points).Where("location_mrid = ?", substations[0].LocationMrid).Scan(ctx))
+		require.Equal(t, 1, len(points))
+		return points[0].XPosition, points[0].YPosition
+	}
+
+	activeLocations := func() []uuid.UUID {
+		locations, err := FindAll[models.Location](db, ctx, 0)
+		require.NoError(t, err)
+		var mrids []uuid.UUID
+		for _, location := range OnlyActiveLatest(locations) {
+			mrids = append(mrids, location.Mrid)
+		}
+		return mrids
+	}
+	original := activeLocations()
+	require.Equal(t, 1, len(original))
+
+	moved := substation
+	moved.X, moved.Y = 11, 61
+	changed, report := upsert(moved)
+	require.NotEmpty(t, changed)
+	require.Equal(t, UpsertCounts{Created: 1, Deleted: 1}, *report.Classes["Location"])
+	require.Equal(t, UpsertCounts{Updated: 1}, *report.Classes["Substation"])
+	x, y := position()
+	require.Equal(t, 11.0, x)
+	require.Equal(t, 61.0, y)
+
+	// The replaced location is deleted in the same commit
+	movedLocations := activeLocations()
+	require.Equal(t, 1, len(movedLocations))
+	require.NotEqual(t, original, movedLocations)
+
+	t.Run("same position again is unchanged", func(t *testing.T) {
+		changed, _ := upsert(moved)
+		require.Empty(t, changed)
+	})
+
+	t.Run("moving back reuses the original location", func(t *testing.T) {
+		_, report := upsert(substation)
+		require.Equal(t, UpsertCounts{Updated: 1, Deleted: 1}, *report.Classes["Location"])
+		require.Equal(t, UpsertCounts{Updated: 1}, *report.Classes["Substation"])
+		x, y := position()
+		require.Equal(t, 10.0, x)
+		require.Equal(t, 60.0, y)
+		require.Equal(t, original, activeLocations())
+	})
+
+	t.Run("shared location is kept", func(t *testing.T) {
+		var items []any
+		for item := range (&SubstationLight{Name: "Sub B", Region: "NO1"}).CimItems(0) {
+			switch v := item.(type) {
+			case *models.Location, *models.PositionPoint:
+				continue
+			case *models.Entity:
+				if v.EntityType == "Location" {
+					continue
+				}
+			case *models.Substation:
+				v.LocationMrid = original[0]
+			}
+			items = append(items, item)
+		}
+		require.NoError(t, InsertAll(ctx, db, models.Commit{}, slices.Values(items), NoOpOnInsert))
+
+		_, report := upsert(moved)
+		require.Equal(t, UpsertCounts{Updated: 1}, *report.Classes["Location"])
+		require.ElementsMatch(t, append(slices.Clone(original), movedLocations...), activeLocations())
+	})
+}
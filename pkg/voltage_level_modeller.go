@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"com.github/davidkleiven/tripleworks/models"
+	"com.github/davidkleiven/tripleworks/repository"
 	"github.com/google/uuid"
 	"github.com/uptrace/bun"
 )
@@ -61,6 +62,7 @@ func (v *VoltageLevelModel) Write(ctx context.Context, db *bun.DB, modelId int,
 	commit := models.Commit{
 		Message: commitMsg,
 		Author:  "VoltageLevelModeller",
+		Branch:  repository.BranchFromCtx(ctx),
 	}
 
 	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
@@ -162,7 +164,7 @@ func NewVoltageLevelEquipmentFromDb(ctx context.Context, db *bun.DB, substation
 				lineMrids[i] = line.Mrid
 			}
 			var terminals []models.Terminal
-			ierr := db.NewSelect().Model(&terminals).Where("conducting_equipment_mrid IN (?)", bun.In(lineMrids)).Scan(ctx)
+			ierr := db.NewSelect().Model(&terminals).Apply(repository.OnBranch(ctx)).Where("conducting_equipment_mrid IN (?)", bun.In(lineMrids)).Scan(ctx)
 			terminals = OnlyActiveLatest(terminals)
 
 			// Extract terminal numbers to be created if created. Note that if the terminal already exists
@@ -173,7 +175,7 @@ func NewVoltageLevelEquipmentFromDb(ctx context.Context, db *bun.DB, substation
 			return ierr
 		},
 		func() error {
-			return db.NewSelect().Model(&vls).Where("substation_mrid = ?", substation.Mrid).Scan(ctx)
+			return db.NewSelect().Model(&vls).Apply(repository.OnBranch(ctx)).Where("substation_mrid = ?", substation.Mrid).Scan(ctx)
 		},
 		func() error {
 			bvMrids := map[uuid.UUID]struct{}{}
@@ -188,7 +190,7 @@ func NewVoltageLevelEquipmentFromDb(ctx context.Context, db *bun.DB, substation
 			for mrid := range bvMrids {
 				bvSlice = append(bvSlice, mrid)
 			}
-			return db.NewSelect().Model(&bvs).Where("mrid IN (?)", bun.In(bvSlice)).Scan(ctx)
+			return db.NewSelect().Model(&bvs).Apply(repository.OnBranch(ctx)).Where("mrid IN (?)", bun.In(bvSlice)).Scan(ctx)
 		},
 		func() error {
 			var ierr error
@@ -449,7 +451,7 @@ func LineMrids(lines []models.ACLineSegment) []uuid.UUID {
 func GetTargetTerminalSequenceNumber(ctx context.Context, db *bun.DB, lines []uuid.UUID) (map[uuid.UUID]int, error) {
 	result := make(map[uuid.UUID]int)
 	var terminals []models.Terminal
-	err := db.NewSelect().Model(&terminals).Where("conducting_equipment_mrid IN (?)", bun.In(lines)).Scan(ctx)
+	err := db.NewSelect().Model(&terminals).Apply(repository.OnBranch(ctx)).Where("conducting_equipment_mrid IN (?)", bun.In(lines)).Scan(ctx)
 	if err != nil {
 		return result, fmt.Errorf("could not fetch existing terminals: %w", err)
 	}
@@ -474,7 +476,7 @@ func GetTargetTerminalSequenceNumber(ctx context.Context, db *bun.DB, lines []uu
 }
 
 func EquipmentByContainer[T any](ctx context.Context, db *bun.DB, equipContainerMrid uuid.UUID, result *[]T) error {
-	return db.NewSelect().Model(result).Where("equipment_container_mrid = ?", equipContainerMrid).Scan(ctx)
+	return db.NewSelect().Model(result).Apply(repository.OnBranch(ctx)).Where("equipment_container_mrid = ?", equipContainerMrid).Scan(ctx)
 }
 
 func EquipmentByContainers[T any](ctx context.Context, db *bun.DB, equipContainerMrids iter.Seq[uuid.UUID]) (map[uuid.UUID][]T, error) {
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/uptrace/bun"
+)
+
+type asOfCtxKey string
+
+const asOfKey asOfCtxKey = "asOf"
+
+// WithAsOf returns a context where reads only see commits up to and including the passed commit
+func WithAsOf(ctx context.Context, commitId int64) context.Context {
+	return context.WithValue(ctx, asOfKey, commitId)
+}
+
+// AsOfFromCtx returns the as-of commit stored in the context
+func AsOfFromCtx(ctx context.Context) (int64, bool) {
+	commitId, ok := ctx.Value(asOfKey).(int64)
+	return commitId, ok
+}
+
+var (
+	// ErrInvalidAsOf is returned by ResolveAsOf when the value is neither a commit id nor a timestamp
+	ErrInvalidAsOf = errors.New("Invalid as-of value")
+
+	// ErrUnknownCommit is returned by ResolveAsOf when no commit matches the as-of value
+	ErrUnknownCommit = errors.New("Unknown commit")
+)
+
+// ResolveAsOf translates a commit id or an RFC3339 timestamp into the commit id that was the
+// newest commit at that point in time. ErrUnknownCommit is returned when there is no such commit.
+func ResolveAsOf(ctx context.Context, db bun.IDB, value string) (int64, error) {
+	q := db.NewSelect().Model((*models.Commit)(nil)).Column("id")
+	if commitId, err := strconv.ParseInt(value, 10, 64); err == nil {
+		q = q.Where("id = ?", commitId)
+	} else {
+		timestamp, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return 0, fmt.Errorf("%w: '%s' is neither a commit id nor an RFC3339 timestamp", ErrInvalidAsOf, value)
+		}
+		q = q.Where("created_at <= ?", timestamp).Order("id DESC").Limit(1)
+	}
+
+	var commitId int64
+	err := q.Scan(ctx, &commitId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: no commit matches %s", ErrUnknownCommit, value)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Failed to resolve commit: %w", err)
+	}
+	return commitId, nil
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+)
+
+func dbWithCommits(t *testing.T, createdAt ...time.Time) *bun.DB {
+	dburl := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	sqldb, err := sql.Open("sqlite3", dburl)
+	require.NoError(t, err)
+	db := bun.NewDB(sqldb, sqlitedialect.New())
+	ctx := context.Background()
+	_, err = db.NewCreateTable().Model((*models.Commit)(nil)).Exec(ctx)
+	require.NoError(t, err)
+
+	for _, created := range createdAt {
+		commit := models.Commit{CreatedAt: created, Branch: models.MainBranch}
+		_, err = db.NewInsert().Model(&commit).Exec(ctx)
+		require.NoError(t, err)
+	}
+	return db
+}
+
+func TestAsOfFromCtx(t *testing.T) {
+	_, ok := AsOfFromCtx(context.Background())
+	require.False(t, ok)
+
+	commitId, ok := AsOfFromCtx(WithAsOf(context.Background(), 3))
+	require.True(t, ok)
+	require.Equal(t, int64(3), commitId)
+}
+
+func TestResolveAsOf(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	db := dbWithCommits(t, start, start.Add(time.Hour), start.Add(2*time.Hour))
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		value    string
+		commitId int64
+		wantErr  error
+	}{
+		{value: "2", commitId: 2},
+		{value: "2025-01-01T01:30:00Z", commitId: 2},
+		{value: "2025-01-01T03:30:00+02:00", commitId: 2},
+		{value: "2025-01-01T02:00:00Z", commitId: 3},
+		{value: "2025-01-01T02:00:00.5Z", commitId: 3},
+		{value: "2024-12-31T23:00:00Z", wantErr: ErrUnknownCommit},
+		{value: "4", wantErr: ErrUnknownCommit},
+		{value: "yesterday", wantErr: ErrInvalidAsOf},
+	} {
+		t.Run(test.value, func(t *testing.T) {
+			commitId, err := ResolveAsOf(ctx, db, test.value)
+			if test.wantErr != nil {
+				require.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.commitId, commitId)
+		})
+	}
+}
+
+func TestResolveAsOfStorageError(t *testing.T) {
+	db := dbWithCommits(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ResolveAsOf(ctx, db, "1")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrUnknownCommit)
+}
+
+func TestBunReadRepositoryAsOf(t *testing.T) {
+	db := dbWithCommits(t, time.Now(), time.Now())
+	ctx := context.Background()
+	_, err := db.NewCreateTable().Model((*models.BaseVoltage)(nil)).Exec(ctx)
+	require.NoError(t, err)
+
+	mrid := uuid.New()
+	bvs := make([]models.BaseVoltage, 2)
+	for i := range bvs {
+		bvs[i].Mrid = mrid
+		bvs[i].CommitId = i + 1
+		bvs[i].NominalVoltage = float64(100 * (i + 1))
+	}
+	_, err = db.NewInsert().Model(&bvs).Exec(ctx)
+	require.NoError(t, err)
+
+	repo := BunReadRepository[models.BaseVoltage]{Db: db}
+	versions, err := repo.List(WithAsOf(ctx, 1))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(versions))
+	require.Equal(t, 100.0, versions[0].NominalVoltage)
+
+	versions, err = repo.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(versions))
+}
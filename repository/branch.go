@@ -0,0 +1,238 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/uptrace/bun"
+)
+
+type branchCtxKey string
+
+const branchKey branchCtxKey = "branch"
+
+// WithBranch returns a context where all reads and writes are performed against the passed branch
+func WithBranch(ctx context.Context, branch string) context.Context {
+	return context.WithValue(ctx, branchKey, branch)
+}
+
+// BranchFromCtx returns the branch stored in the context. Main is returned if no branch is set
+func BranchFromCtx(ctx context.Context) string {
+	branch, ok := ctx.Value(branchKey).(string)
+	if !ok || branch == "" {
+		return models.MainBranch
+	}
+	return branch
+}
+
+// OnBranch restricts a select query on a versioned table to the rows that are visible from the
+// branch in the context. On main, rows committed to other branches are hidden. On other branches,
+// rows on the branch itself are visible together with rows on main that were committed before the
+// branch was created. If the context carries an as-of commit, later commits are hidden as well, and
+// if it carries a model, only objects registered in that model are visible.
+// The query must select from a model such that the table is ?TableAlias.
+func OnBranch(ctx context.Context) func(q *bun.SelectQuery) *bun.SelectQuery {
+	return func(q *bun.SelectQuery) *bun.SelectQuery {
+		if !isScoped(ctx) {
+			return q.Where("?TableAlias.commit_id NOT IN (SELECT id FROM commits WHERE branch <> ?)", models.MainBranch)
+		}
+		return visibleInScope(ctx, q, "?TableAlias")
+	}
+}
+
+// isScoped reports whether reads must be resolved against something else than the head of main
+func isScoped(ctx context.Context) bool {
+	_, hasAsOf := AsOfFromCtx(ctx)
+	_, hasModel := ModelFromCtx(ctx)
+	return hasAsOf || hasModel || BranchFromCtx(ctx) != models.MainBranch
+}
+
+func visibleInScope(ctx context.Context, q *bun.SelectQuery, alias string) *bun.SelectQuery {
+	q = q.Join(fmt.Sprintf("JOIN commits AS bc ON bc.id = %s.commit_id", alias))
+	q = visibleCommits(ctx, q, "bc")
+	if modelId, ok := ModelFromCtx(ctx); ok {
+		q = q.Where(alias+".mrid IN (?)", modelMembers(ctx, q.DB(), modelId))
+	}
+	return q
+}
+
+// modelMembers selects the mrids registered in the model by a commit that is visible in the context.
+// Entities registered before commits were recorded on them carry commit id zero and are always members.
+func modelMembers(ctx context.Context, db bun.IDB, modelId int) *bun.SelectQuery {
+	return db.NewSelect().
+		TableExpr("entities AS me").
+		Column("me.mrid").
+		Join("LEFT JOIN commits AS mc ON mc.id = me.commit_id").
+		Where("me.model_id = ?", modelId).
+		WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("me.commit_id = 0").WhereGroup(" OR ", func(q *bun.SelectQuery) *bun.SelectQuery {
+				return visibleCommits(ctx, q, "mc")
+			})
+		})
+}
+
+// visibleCommits restricts the commits with the passed alias to those visible from the branch and as-of commit in the context
+func visibleCommits(ctx context.Context, q *bun.SelectQuery, alias string) *bun.SelectQuery {
+	branch := BranchFromCtx(ctx)
+	if branch == models.MainBranch {
+		q = q.Where("?.branch = ?", bun.Ident(alias), models.MainBranch)
+	} else {
+		q = q.Where(
+			"(?0.branch = ?1 OR (?0.branch = ?2 AND ?0.id <= (SELECT b.fork_commit_id FROM branches AS b WHERE b.name = ?1)))",
+			bun.Ident(alias), branch, models.MainBranch,
+		)
+	}
+
+	if asOf, ok := AsOfFromCtx(ctx); ok {
+		q = q.Where("?.id <= ?", bun.Ident(alias), asOf)
+	}
+	return q
+}
+
+// HeadCommitId returns the newest commit visible from the branch and as-of commit in the context,
+// or zero when there is none
+func HeadCommitId(ctx context.Context, db bun.IDB) (int64, error) {
+	var head int64
+	err := db.NewSelect().
+		TableExpr("commits AS bc").
+		ColumnExpr("COALESCE(MAX(bc.id), 0)").
+		Apply(func(q *bun.SelectQuery) *bun.SelectQuery { return visibleCommits(ctx, q, "bc") }).
+		Scan(ctx, &head)
+	return head, err
+}
+
+// SelectLatest selects the newest active version of every object in the table as seen from the
+// branch, as-of commit and model in the context. The selected relation is aliased as the latest view of the table.
+func SelectLatest(ctx context.Context, db bun.IDB, table string) *bun.SelectQuery {
+	view := fmt.Sprintf("v_%s_latest", table)
+	if !isScoped(ctx) {
+		return db.NewSelect().Table(view)
+	}
+	return db.NewSelect().TableExpr("(?) AS ?", LatestVersions(ctx, db, table), bun.Ident(view))
+}
+
+// LatestVersions returns a query that gives the same result as the v_<table>_latest views, but
+// where branch commits are resolved over main as of the point where the branch was created and
+// commits after the as-of commit in the context are ignored. Objects outside the model in the
+// context are left out.
+func LatestVersions(ctx context.Context, db bun.IDB, table string) *bun.SelectQuery {
+	versions := db.NewSelect().
+		TableExpr("? AS t", bun.Ident(table)).
+		ColumnExpr("t.*").
+		ColumnExpr("ROW_NUMBER() OVER (PARTITION BY t.mrid ORDER BY bc.created_at DESC, bc.id DESC) AS row_num")
+	versions = visibleInScope(ctx, versions, "t")
+
+	return db.NewSelect().
+		TableExpr("(?) AS sub", versions).
+		ColumnExpr("sub.*").
+		Where("sub.row_num = 1 AND NOT sub.deleted")
+}
+
+var latestViewExpr = regexp.MustCompile(`v_([a-z0-9_]+)_latest`)
+
+// ScopeLatestViews prepends common table expressions to a raw query such that every
+// v_<table>_latest view it refers to resolves against the branch, as-of commit and model in the context.
+// On the head of main the query is returned unchanged.
+func ScopeLatestViews(ctx context.Context, db bun.IDB, query string) string {
+	if !isScoped(ctx) {
+		return query
+	}
+
+	var (
+		tables []string
+		ctes   []string
+	)
+	for _, match := range latestViewExpr.FindAllStringSubmatch(query, -1) {
+		if !slices.Contains(tables, match[1]) {
+			tables = append(tables, match[1])
+		}
+	}
+	for _, table := range tables {
+		cte := fmt.Sprintf("v_%s_latest AS (%s)", table, LatestVersions(ctx, db, table).String())
+		ctes = append(ctes, cte)
+	}
+	if len(ctes) == 0 {
+		return query
+	}
+
+	trimmed := strings.TrimSpace(query)
+	if len(trimmed) > 4 && strings.EqualFold(trimmed[:5], "with ") {
+		return "WITH " + strings.Join(ctes, ",\n") + ",\n" + trimmed[5:]
+	}
+	return "WITH " + strings.Join(ctes, ",\n") + "\n" + trimmed
+}
+
+type BranchRepository interface {
+	Create(ctx context.Context, branch *models.Branch) error
+	Get(ctx context.Context, name string) (models.Branch, error)
+	List(ctx context.Context) ([]models.Branch, error)
+}
+
+type InMemBranchRepository struct {
+	Items []models.Branch
+	Err   error
+}
+
+func (i *InMemBranchRepository) Create(ctx context.Context, branch *models.Branch) error {
+	if _, err := i.Get(ctx, branch.Name); err == nil {
+		return fmt.Errorf("Branch %s already exists", branch.Name)
+	}
+	if i.Err != nil {
+		return i.Err
+	}
+	i.Items = append(i.Items, *branch)
+	return nil
+}
+
+func (i *InMemBranchRepository) Get(ctx context.Context, name string) (models.Branch, error) {
+	for _, branch := range i.Items {
+		if branch.Name == name {
+			return branch, i.Err
+		}
+	}
+	return models.Branch{}, fmt.Errorf("No branch named %s", name)
+}
+
+func (i *InMemBranchRepository) List(ctx context.Context) ([]models.Branch, error) {
+	return i.Items, i.Err
+}
+
+type BunBranchRepository struct {
+	Db *bun.DB
+}
+
+// Create inserts a new branch that forks off from the current head of main
+func (b *BunBranchRepository) Create(ctx context.Context, branch *models.Branch) error {
+	return b.Db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model((*models.Commit)(nil)).
+			ColumnExpr("COALESCE(MAX(id), 0)").
+			Where("branch = ?", models.MainBranch).
+			Scan(ctx, &branch.ForkCommitId)
+		if err != nil {
+			return fmt.Errorf("Failed to find head of main: %w", err)
+		}
+
+		_, err = tx.NewInsert().Model(branch).Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("Failed to insert branch: %w", err)
+		}
+		return nil
+	})
+}
+
+func (b *BunBranchRepository) Get(ctx context.Context, name string) (models.Branch, error) {
+	var branch models.Branch
+	err := b.Db.NewSelect().Model(&branch).Where("name = ?", name).Scan(ctx)
+	return branch, err
+}
+
+func (b *BunBranchRepository) List(ctx context.Context) ([]models.Branch, error) {
+	var branches []models.Branch
+	err := b.Db.NewSelect().Model(&branches).Order("created_at").Scan(ctx)
+	return branches, err
+}
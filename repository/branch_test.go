@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+)
+
+func TestBranchFromCtx(t *testing.T) {
+	require.Equal(t, models.MainBranch, BranchFromCtx(context.Background()))
+	require.Equal(t, "project", BranchFromCtx(WithBranch(context.Background(), "project")))
+	require.Equal(t, models.MainBranch, BranchFromCtx(WithBranch(context.Background(), "")))
+}
+
+func TestInMemBranchRepository(t *testing.T) {
+	repo := InMemBranchRepository{}
+	ctx := context.Background()
+	require.NoError(t, repo.Create(ctx, &models.Branch{Name: "project"}))
+	require.Error(t, repo.Create(ctx, &models.Branch{Name: "project"}))
+
+	branch, err := repo.Get(ctx, "project")
+	require.NoError(t, err)
+	require.Equal(t, "project", branch.Name)
+
+	_, err = repo.Get(ctx, "unknown")
+	require.Error(t, err)
+
+	branches, err := repo.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(branches))
+
+	repo.Err = errors.New("something went wrong")
+	_, err = repo.List(ctx)
+	require.Error(t, err)
+
+	require.Error(t, repo.Create(ctx, &models.Branch{Name: "failed"}))
+	require.Equal(t, 1, len(repo.Items))
+}
+
+func TestScopeLatestViews(t *testing.T) {
+	db := bun.NewDB(nil, sqlitedialect.New())
+	query := "SELECT * FROM v_terminals_latest t JOIN v_substations_latest s ON t.mrid = s.mrid JOIN v_terminals_latest t2 ON t2.mrid = t.mrid"
+
+	t.Run("main is unchanged", func(t *testing.T) {
+		require.Equal(t, query, ScopeLatestViews(context.Background(), db, query))
+	})
+
+	t.Run("one cte per view", func(t *testing.T) {
+		ctx := WithBranch(context.Background(), "project")
+		scoped := ScopeLatestViews(ctx, db, query)
+		require.True(t, strings.HasPrefix(scoped, "WITH "))
+		require.Equal(t, 1, strings.Count(scoped, "v_terminals_latest AS ("))
+		require.Equal(t, 1, strings.Count(scoped, "v_substations_latest AS ("))
+		require.Contains(t, scoped, "'project'")
+	})
+
+	t.Run("merged with existing with", func(t *testing.T) {
+		ctx := WithBranch(context.Background(), "project")
+		withQuery := "WITH a AS (SELECT mrid FROM v_terminals_latest) SELECT * FROM a"
+		scoped := ScopeLatestViews(ctx, db, withQuery)
+		require.Equal(t, 1, strings.Count(strings.ToUpper(scoped), "WITH "))
+		require.True(t, strings.HasSuffix(scoped, "a AS (SELECT mrid FROM v_terminals_latest) SELECT * FROM a"))
+	})
+}
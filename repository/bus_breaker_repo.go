@@ -40,6 +40,6 @@ func (b *BunBusBreakerRepo) Fetch(ctx context.Context) ([]BusBreakerConnection,
 	if err != nil {
 		return result, fmt.Errorf("Failed to open query: %w", err)
 	}
-	err = b.Db.NewRaw(string(query)).Scan(ctx, &result)
+	err = b.Db.NewRaw(ScopeLatestViews(ctx, b.Db, string(query))).Scan(ctx, &result)
 	return result, err
 }
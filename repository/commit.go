@@ -29,6 +29,12 @@ func (b *BunInserter) Insert(ctx context.Context, item any) error {
 	return err
 }
 
+// Versions reads versions through the same connection as the inserter, which is the transaction
+// inside InTx
+func (b *BunInserter) Versions() VersionReader {
+	return &BunVersionReader{Db: b.Db}
+}
+
 func (b *BunInserter) InTx(ctx context.Context, fn InsertFunc) error {
 	return b.Db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
 		txInserter := BunInserter{Db: tx}
@@ -38,6 +44,21 @@ func (b *BunInserter) InTx(ctx context.Context, fn InsertFunc) error {
 
 type InsertFunc func(context.Context, Inserter) error
 
+// VersionReaderProvider is implemented by inserters that can read versions of objects in their
+// transaction, such that a check of the stored versions and the insert depending on it see the same state
+type VersionReaderProvider interface {
+	Versions() VersionReader
+}
+
+// TxVersionReader returns the version reader of the inserter's transaction, or fallback when the
+// inserter can not read versions
+func TxVersionReader(inserter Inserter, fallback VersionReader) VersionReader {
+	if provider, ok := inserter.(VersionReaderProvider); ok {
+		return provider.Versions()
+	}
+	return fallback
+}
+
 type TxRunner interface {
 	InTx(ctx context.Context, fn InsertFunc) error
 }
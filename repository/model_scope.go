@@ -0,0 +1,23 @@
+package repository
+
+import "context"
+
+type modelCtxKey string
+
+const modelKey modelCtxKey = "model"
+
+// WithModel returns a context where reads only see objects that are registered as entities in the passed model
+func WithModel(ctx context.Context, modelId int) context.Context {
+	return context.WithValue(ctx, modelKey, modelId)
+}
+
+// WithoutModel returns a context where reads see the objects of all models
+func WithoutModel(ctx context.Context) context.Context {
+	return context.WithValue(ctx, modelKey, nil)
+}
+
+// ModelFromCtx returns the model stored in the context
+func ModelFromCtx(ctx context.Context) (int, bool) {
+	modelId, ok := ctx.Value(modelKey).(int)
+	return modelId, ok
+}
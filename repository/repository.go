@@ -75,22 +75,19 @@ type BunReadRepository[T any] struct {
 
 func (brp *BunReadRepository[T]) GetByMrid(ctx context.Context, mrid string) (T, error) {
 	var result T
-	latestView := brp.TableName()
-	err := brp.Db.NewSelect().Table(latestView).Where("mrid = ?", mrid).OrderBy("commit_id", bun.OrderDesc).Limit(1).Scan(ctx, &result)
+	err := brp.newSelect(ctx).Where("mrid = ?", mrid).OrderBy("commit_id", bun.OrderDesc).Limit(1).Scan(ctx, &result)
 	return result, err
 }
 
 func (brp *BunReadRepository[T]) List(ctx context.Context) ([]T, error) {
 	var result []T
-	latestView := brp.TableName()
-	err := brp.Db.NewSelect().Table(latestView).Scan(ctx, &result)
+	err := brp.newSelect(ctx).Scan(ctx, &result)
 	return result, err
 }
 
 func (brp *BunReadRepository[T]) ListByMrids(ctx context.Context, mrids iter.Seq[string]) ([]T, error) {
 	var result []T
-	latestView := brp.TableName()
-	err := brp.Db.NewSelect().Table(latestView).Where("mrid IN (?)", bun.List(slices.Collect(mrids))).Scan(ctx, &result)
+	err := brp.newSelect(ctx).Where("mrid IN (?)", bun.List(slices.Collect(mrids))).Scan(ctx, &result)
 	return result, err
 }
 
@@ -103,6 +100,23 @@ func (brp *BunReadRepository[T]) TableName() string {
 	return tableName
 }
 
+// newSelect selects from the table or the latest view. Versioned objects are resolved
+// against the branch in the context, when the branch is different from main.
+func (brp *BunReadRepository[T]) newSelect(ctx context.Context) *bun.SelectQuery {
+	var item T
+	_, isVersioned := any(&item).(models.VersionedIdentifiedObject)
+	if !isVersioned || !isScoped(ctx) {
+		return brp.Db.NewSelect().Table(brp.TableName())
+	}
+
+	tableName := brp.Db.Table(reflect.TypeOf(item)).Name
+	if brp.UseLatestView {
+		return SelectLatest(ctx, brp.Db, tableName)
+	}
+	query := brp.Db.NewSelect().TableExpr("? AS ?", bun.Ident(tableName), bun.Ident(tableName)).ColumnExpr("?.*", bun.Ident(tableName))
+	return visibleInScope(ctx, query, tableName)
+}
+
 type FailingReadRepo[T any] struct{}
 
 func (f *FailingReadRepo[T]) GetByMrid(ctx context.Context, mrid string) (T, error) {
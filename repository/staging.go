@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/uptrace/bun"
+)
+
+// StagingRepository keeps the changes an author has staged on a branch. Staging an object that is
+// already staged replaces the payload, but keeps the base commit of the first staged edit.
+type StagingRepository interface {
+	Stage(ctx context.Context, change *models.StagedChange) error
+	List(ctx context.Context, author, branch string) ([]models.StagedChange, error)
+
+	// Discard removes the staged changes with the passed ids. All staged changes of the author
+	// on the branch are removed if no ids are passed.
+	Discard(ctx context.Context, author, branch string, ids ...int64) error
+}
+
+type InMemStagingRepository struct {
+	Items []models.StagedChange
+	Err   error
+}
+
+func (i *InMemStagingRepository) Stage(ctx context.Context, change *models.StagedChange) error {
+	for j, item := range i.Items {
+		if item.Author == change.Author && item.Branch == change.Branch && item.Mrid == change.Mrid {
+			change.Id = item.Id
+			change.BaseCommitId = item.BaseCommitId
+			i.Items[j] = *change
+			return i.Err
+		}
+	}
+	change.Id = int64(len(i.Items) + 1)
+	i.Items = append(i.Items, *change)
+	return i.Err
+}
+
+func (i *InMemStagingRepository) List(ctx context.Context, author, branch string) ([]models.StagedChange, error) {
+	var result []models.StagedChange
+	for _, item := range i.Items {
+		if item.Author == author && item.Branch == branch {
+			result = append(result, item)
+		}
+	}
+	return result, i.Err
+}
+
+func (i *InMemStagingRepository) Discard(ctx context.Context, author, branch string, ids ...int64) error {
+	i.Items = slices.DeleteFunc(i.Items, func(item models.StagedChange) bool {
+		return item.Author == author && item.Branch == branch && (len(ids) == 0 || slices.Contains(ids, item.Id))
+	})
+	return i.Err
+}
+
+// TxStagingRepository is implemented by staging repositories that can work in the transaction of an
+// inserter, such that staged changes are cleared together with the commit that stores them
+type TxStagingRepository interface {
+	InTxOf(inserter Inserter) (StagingRepository, bool)
+}
+
+type BunStagingRepository struct {
+	Db bun.IDB
+}
+
+func (b *BunStagingRepository) InTxOf(inserter Inserter) (StagingRepository, bool) {
+	bunInserter, ok := inserter.(*BunInserter)
+	if !ok {
+		return nil, false
+	}
+	return &BunStagingRepository{Db: bunInserter.Db}, true
+}
+
+func (b *BunStagingRepository) Stage(ctx context.Context, change *models.StagedChange) error {
+	return b.Db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var existing models.StagedChange
+		err := tx.NewSelect().
+			Model(&existing).
+			Where("author = ? AND branch = ? AND mrid = ?", change.Author, change.Branch, change.Mrid).
+			Limit(1).
+			Scan(ctx)
+
+		if errors.Is(err, sql.ErrNoRows) {
+			_, err = tx.NewInsert().Model(change).Exec(ctx)
+			if err != nil {
+				return fmt.Errorf("Failed to insert staged change: %w", err)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to look up staged change: %w", err)
+		}
+
+		change.Id = existing.Id
+		change.BaseCommitId = existing.BaseCommitId
+		change.CreatedAt = existing.CreatedAt
+		_, err = tx.NewUpdate().Model(change).WherePK().Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("Failed to update staged change: %w", err)
+		}
+		return nil
+	})
+}
+
+func (b *BunStagingRepository) List(ctx context.Context, author, branch string) ([]models.StagedChange, error) {
+	var changes []models.StagedChange
+	err := b.Db.NewSelect().Model(&changes).Where("author = ? AND branch = ?", author, branch).Order("id").Scan(ctx)
+	return changes, err
+}
+
+func (b *BunStagingRepository) Discard(ctx context.Context, author, branch string, ids ...int64) error {
+	q := b.Db.NewDelete().Model((*models.StagedChange)(nil)).Where("author = ? AND branch = ?", author, branch)
+	if len(ids) > 0 {
+		q = q.Where("id IN (?)", bun.In(ids))
+	}
+	_, err := q.Exec(ctx)
+	return err
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+)
+
+func testStagingRepository(t *testing.T, repo StagingRepository) {
+	ctx := context.Background()
+	first, second := uuid.New(), uuid.New()
+
+	stage := func(author string, mrid uuid.UUID, baseCommitId int64, payload string) *models.StagedChange {
+		change := models.StagedChange{Author: author, Branch: models.MainBranch, Mrid: mrid, CimType: "BaseVoltage", BaseCommitId: baseCommitId, Payload: payload}
+		require.NoError(t, repo.Stage(ctx, &change))
+		return &change
+	}
+
+	stage("alice", first, 1, "first")
+	stage("alice", second, 1, "second")
+	stage("bob", first, 1, "other user")
+	restaged := stage("alice", first, 2, "first again")
+	require.Equal(t, int64(1), restaged.BaseCommitId)
+
+	changes, err := repo.List(ctx, "alice", models.MainBranch)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(changes))
+	require.Equal(t, "first again", changes[0].Payload)
+
+	changes, err = repo.List(ctx, "alice", "project")
+	require.NoError(t, err)
+	require.Empty(t, changes)
+
+	require.NoError(t, repo.Discard(ctx, "alice", models.MainBranch, restaged.Id))
+	changes, err = repo.List(ctx, "alice", models.MainBranch)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(changes))
+	require.Equal(t, second, changes[0].Mrid)
+
+	require.NoError(t, repo.Discard(ctx, "alice", models.MainBranch))
+	changes, err = repo.List(ctx, "alice", models.MainBranch)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+
+	changes, err = repo.List(ctx, "bob", models.MainBranch)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(changes))
+}
+
+func TestInMemStagingRepository(t *testing.T) {
+	testStagingRepository(t, &InMemStagingRepository{})
+}
+
+func TestBunStagingRepository(t *testing.T) {
+	dburl := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	sqldb, err := sql.Open("sqlite3", dburl)
+	require.NoError(t, err)
+	db := bun.NewDB(sqldb, sqlitedialect.New())
+	_, err = db.NewCreateTable().Model((*models.StagedChange)(nil)).Exec(context.Background())
+	require.NoError(t, err)
+
+	testStagingRepository(t, &BunStagingRepository{Db: db})
+}
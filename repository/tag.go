@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/uptrace/bun"
+)
+
+type TagRepository interface {
+	Create(ctx context.Context, tag *models.Tag) error
+	Get(ctx context.Context, name string) (models.Tag, error)
+	List(ctx context.Context) ([]models.Tag, error)
+	Delete(ctx context.Context, name string) error
+}
+
+type InMemTagRepository struct {
+	Items []models.Tag
+	Err   error
+}
+
+func (i *InMemTagRepository) Create(ctx context.Context, tag *models.Tag) error {
+	if _, err := i.Get(ctx, tag.Name); err == nil {
+		return fmt.Errorf("Tag %s already exists", tag.Name)
+	}
+	i.Items = append(i.Items, *tag)
+	return i.Err
+}
+
+func (i *InMemTagRepository) Get(ctx context.Context, name string) (models.Tag, error) {
+	for _, tag := range i.Items {
+		if tag.Name == name {
+			return tag, i.Err
+		}
+	}
+	return models.Tag{}, fmt.Errorf("No tag named %s", name)
+}
+
+func (i *InMemTagRepository) List(ctx context.Context) ([]models.Tag, error) {
+	return i.Items, i.Err
+}
+
+func (i *InMemTagRepository) Delete(ctx context.Context, name string) error {
+	i.Items = slices.DeleteFunc(i.Items, func(tag models.Tag) bool { return tag.Name == name })
+	return i.Err
+}
+
+type BunTagRepository struct {
+	Db *bun.DB
+}
+
+// Create inserts a new tag. If the tag does not point at a commit, it points at the head of its branch.
+// The branch of the tag is always taken from the commit it points at.
+func (b *BunTagRepository) Create(ctx context.Context, tag *models.Tag) error {
+	return b.Db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if tag.CommitId == 0 {
+			err := tx.NewSelect().
+				Model((*models.Commit)(nil)).
+				ColumnExpr("COALESCE(MAX(id), 0)").
+				Where("branch = ?", tag.Branch).
+				Scan(ctx, &tag.CommitId)
+			if err != nil {
+				return fmt.Errorf("Failed to find head of %s: %w", tag.Branch, err)
+			}
+		}
+
+		var commit models.Commit
+		err := tx.NewSelect().Model(&commit).Where("id = ?", tag.CommitId).Scan(ctx)
+		if err != nil {
+			return fmt.Errorf("Failed to find commit %d: %w", tag.CommitId, err)
+		}
+		tag.Branch = commit.Branch
+
+		_, err = tx.NewInsert().Model(tag).Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("Failed to insert tag: %w", err)
+		}
+		return nil
+	})
+}
+
+func (b *BunTagRepository) Get(ctx context.Context, name string) (models.Tag, error) {
+	var tag models.Tag
+	err := b.Db.NewSelect().Model(&tag).Where("name = ?", name).Scan(ctx)
+	return tag, err
+}
+
+func (b *BunTagRepository) List(ctx context.Context) ([]models.Tag, error) {
+	var tags []models.Tag
+	err := b.Db.NewSelect().Model(&tags).Order("created_at").Scan(ctx)
+	return tags, err
+}
+
+func (b *BunTagRepository) Delete(ctx context.Context, name string) error {
+	_, err := b.Db.NewDelete().Model((*models.Tag)(nil)).Where("name = ?", name).Exec(ctx)
+	return err
+}
@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemTagRepository(t *testing.T) {
+	repo := InMemTagRepository{}
+	ctx := context.Background()
+	require.NoError(t, repo.Create(ctx, &models.Tag{Name: "release", CommitId: 2}))
+	require.Error(t, repo.Create(ctx, &models.Tag{Name: "release", CommitId: 3}))
+
+	tag, err := repo.Get(ctx, "release")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), tag.CommitId)
+
+	require.NoError(t, repo.Delete(ctx, "release"))
+	_, err = repo.Get(ctx, "release")
+	require.Error(t, err)
+
+	repo.Err = errors.New("something went wrong")
+	_, err = repo.List(ctx)
+	require.Error(t, err)
+}
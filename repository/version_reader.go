@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+
+	"com.github/davidkleiven/tripleworks/models"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+)
+
+// VersionReader reads single versions of an object into dst, which must be a pointer to a versioned model
+type VersionReader interface {
+	// Head reads the newest version of the object, including versions that mark it as deleted
+	Head(ctx context.Context, dst any, mrid uuid.UUID) error
+
+	// AtCommit reads the version of the object that was stored by the commit
+	AtCommit(ctx context.Context, dst any, mrid uuid.UUID, commitId int64) error
+}
+
+type InMemVersionReader struct {
+	Items []any
+	Err   error
+}
+
+func (i *InMemVersionReader) find(dst any, mrid uuid.UUID, keep func(commitId int) bool) error {
+	var found models.VersionedIdentifiedObject
+	for _, item := range i.Items {
+		versioned, ok := item.(models.VersionedIdentifiedObject)
+		if !ok || reflect.TypeOf(item) != reflect.TypeOf(dst) || versioned.GetMrid() != mrid || !keep(versioned.GetCommitId()) {
+			continue
+		}
+		if found == nil || versioned.GetCommitId() > found.GetCommitId() {
+			found = versioned
+		}
+	}
+	if found == nil {
+		return sql.ErrNoRows
+	}
+	reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(found).Elem())
+	return i.Err
+}
+
+func (i *InMemVersionReader) Head(ctx context.Context, dst any, mrid uuid.UUID) error {
+	return i.find(dst, mrid, func(int) bool { return true })
+}
+
+func (i *InMemVersionReader) AtCommit(ctx context.Context, dst any, mrid uuid.UUID, commitId int64) error {
+	return i.find(dst, mrid, func(c int) bool { return int64(c) == commitId })
+}
+
+type BunVersionReader struct {
+	Db bun.IDB
+}
+
+// Head reads the newest version. Within a transaction on postgres the entity of the object is locked
+// first, such that concurrent transactions reading the same head wait until this one is done.
+func (b *BunVersionReader) Head(ctx context.Context, dst any, mrid uuid.UUID) error {
+	if _, inTx := b.Db.(bun.Tx); inTx && b.Db.Dialect().Name() == dialect.PG {
+		_, err := b.Db.NewSelect().TableExpr("entities").Column("mrid").Where("mrid = ?", mrid).For("UPDATE").Exec(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return b.Db.NewSelect().
+		Model(dst).
+		Apply(OnBranch(ctx)).
+		Where("?TableAlias.mrid = ?", mrid).
+		OrderExpr("?TableAlias.commit_id DESC").
+		Limit(1).
+		Scan(ctx)
+}
+
+func (b *BunVersionReader) AtCommit(ctx context.Context, dst any, mrid uuid.UUID, commitId int64) error {
+	return b.Db.NewSelect().
+		Model(dst).
+		Where("?TableAlias.mrid = ?", mrid).
+		Where("?TableAlias.commit_id = ?", commitId).
+		Limit(1).
+		Scan(ctx)
+}